@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// accessRefresh is how long the access rules are cached before the table is
+// read again, so rules added through another instance apply within a minute
+const accessRefresh = time.Minute
+
+// maxAccessReasonLength matches access_rules.reason
+const maxAccessReasonLength = 500
+
+// accessBlockedMsg answers blocked clients on the web endpoints
+const accessBlockedMsg = "Access from this address has been blocked"
+
+// callerHashPattern matches a caller as calls.from and audit_log.source
+// show it with LOG_PII off
+var callerHashPattern = regexp.MustCompile(`^h:[0-9a-f]{16}$`)
+
+// callerPattern matches a caller number or Telegram sender as it is entered
+var callerPattern = regexp.MustCompile(`^(whatsapp:)?\+[0-9]{6,15}$|^telegram:[0-9]{1,20}$`)
+
+var accessCache struct {
+	sync.Mutex
+	rules  []store.AccessRule
+	loaded time.Time
+}
+
+// accessAllowedContext marks a request from an allowlisted client
+type accessAllowedContext struct{}
+
+// apiAccessRule is the v1 JSON schema for an access rule
+type apiAccessRule struct {
+	ID        int64      `json:"id"`
+	Action    string     `json:"action"`
+	Kind      string     `json:"kind"`
+	Value     string     `json:"value"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired"`
+}
+
+// apiAccessRuleList is the v1 JSON schema for GET /access_rules
+type apiAccessRuleList struct {
+	Rules []apiAccessRule `json:"rules"`
+}
+
+func toAPIAccessRule(a *store.AccessRule) apiAccessRule {
+	out := apiAccessRule{
+		ID:        a.ID,
+		Action:    a.Action,
+		Kind:      a.Kind,
+		Value:     a.Value,
+		Reason:    a.Reason,
+		CreatedBy: a.CreatedBy,
+		CreatedAt: a.CreatedAt,
+		Expired:   a.Expired(time.Now()),
+	}
+	if !a.ExpiresAt.IsZero() {
+		out.ExpiresAt = &a.ExpiresAt
+	}
+	return out
+}
+
+// callerHash is how a caller is stored in access_rules and keyed in rate
+// limit and lockout counters: the hash maskCaller logs with LOG_PII off,
+// whatever LOG_PII is, so rules, counters and alerts never hold numbers
+func callerHash(caller string) string {
+	return "h:" + piiHash(caller)[:16]
+}
+
+// parseAccessValue checks and normalizes the value of a rule of kind: IPs
+// and CIDR ranges are canonicalized, caller numbers are hashed and hashes
+// copied from the calls or audit log are kept as they are
+func parseAccessValue(kind, raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	switch kind {
+	case store.AccessIP:
+		if addr, err := netip.ParseAddr(raw); err == nil {
+			return addr.Unmap().String(), nil
+		}
+		if prefix, err := netip.ParsePrefix(raw); err == nil {
+			return prefix.Masked().String(), nil
+		}
+		return "", errors.New("value must be an IP address or CIDR range")
+	case store.AccessCaller:
+		if callerHashPattern.MatchString(raw) {
+			return raw, nil
+		}
+		if callerPattern.MatchString(raw) {
+			// WhatsApp senders are blocked with their number
+			return callerHash(strings.TrimPrefix(raw, "whatsapp:")), nil
+		}
+		return "", errors.New("value must be an E.164 number, telegram:<user id> or a caller hash such as h:0123456789abcdef")
+	}
+	return "", errors.New("kind must be caller or ip")
+}
+
+// currentAccessRules returns the cached rules, reading the table again
+// after accessRefresh. A failed reload keeps the last good rules.
+func currentAccessRules(ctx context.Context) []store.AccessRule {
+	accessCache.Lock()
+	defer accessCache.Unlock()
+	if !accessCache.loaded.IsZero() && time.Since(accessCache.loaded) < accessRefresh {
+		return accessCache.rules
+	}
+	rules, err := st.ListAccessRules(ctx)
+	if err != nil {
+		logErrorContext(ctx, "ACCESS_RULES_ERROR", fmt.Sprintf("Failed to load access rules: %v", err))
+		rules = accessCache.rules
+	}
+	accessCache.rules, accessCache.loaded = rules, time.Now()
+	return rules
+}
+
+// resetAccessRules makes the next request read the table again
+func resetAccessRules() {
+	accessCache.Lock()
+	accessCache.loaded = time.Time{}
+	accessCache.Unlock()
+}
+
+// matchAccess returns the rule that applies to a client of kind, or nil.
+// An allow rule wins over a block, so one address can be let through a
+// blocked range.
+func matchAccess(ctx context.Context, kind, client string) *store.AccessRule {
+	var keys []string
+	var addr netip.Addr
+	if kind == store.AccessCaller {
+		keys = []string{callerHash(client)}
+		if number, ok := strings.CutPrefix(client, "whatsapp:"); ok {
+			keys = append(keys, callerHash(number))
+		}
+	} else {
+		var err error
+		if addr, err = netip.ParseAddr(client); err != nil {
+			return nil
+		}
+		addr = addr.Unmap()
+	}
+
+	now := time.Now()
+	rules := currentAccessRules(ctx)
+	var match *store.AccessRule
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Kind != kind || rule.Expired(now) {
+			continue
+		}
+		matched := false
+		if kind == store.AccessCaller {
+			for _, k := range keys {
+				matched = matched || rule.Value == k
+			}
+		} else if prefix, err := netip.ParsePrefix(rule.Value); err == nil {
+			matched = prefix.Contains(addr)
+		} else if a, err := netip.ParseAddr(rule.Value); err == nil {
+			matched = a == addr
+		}
+		if !matched {
+			continue
+		}
+		if rule.Action == store.AccessAllow {
+			return rule
+		}
+		if match == nil {
+			match = rule
+		}
+	}
+	return match
+}
+
+// withAccessRules refuses clients of kind that are blocked, answering them
+// with deny, and marks allowlisted ones so withRateLimit and withLockout
+// let them through. key(r) identifies the client; requests it returns ""
+// for are not checked.
+func withAccessRules(kind string, key func(*http.Request) string, deny func(http.ResponseWriter, *http.Request)) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			client := key(r)
+			if client == "" {
+				next(w, r)
+				return
+			}
+			rule := matchAccess(r.Context(), kind, client)
+			switch {
+			case rule == nil:
+				next(w, r)
+			case rule.Action == store.AccessAllow:
+				next(w, r.WithContext(context.WithValue(r.Context(), accessAllowedContext{}, true)))
+			default:
+				accessBlockedTotal.WithLabelValues(kind).Inc()
+				shown := client
+				if kind == store.AccessCaller {
+					shown = maskCaller(client)
+				}
+				logErrorContext(r.Context(), "ACCESS_BLOCKED", fmt.Sprintf("Blocked %s %s on %s by access rule %d", kind, shown, r.URL.Path, rule.ID))
+				deny(w, r)
+			}
+		}
+	}
+}
+
+// allowlisted reports whether an allow rule matched the request's client
+func allowlisted(ctx context.Context) bool {
+	ok, _ := ctx.Value(accessAllowedContext{}).(bool)
+	return ok
+}
+
+// twilioCaller is the number at the other end of a Twilio webhook: the
+// caller, or the number called on calls placed through /api/v1/calls
+func twilioCaller(r *http.Request) string {
+	if strings.HasPrefix(r.PostFormValue("Direction"), "outbound") {
+		return r.PostFormValue("To")
+	}
+	return r.PostFormValue("From")
+}
+
+// telegramCaller is the sender of a Telegram update
+func telegramCaller(r *http.Request) string {
+	return telegramSender(r.Context())
+}
+
+// denyBlockedWeb answers a blocked browser or JSON client
+func denyBlockedWeb(w http.ResponseWriter, r *http.Request) {
+	verifyError(w, r, accessBlockedMsg, http.StatusForbidden)
+}
+
+// denyBlockedAPI answers a blocked API client
+func denyBlockedAPI(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusForbidden, "blocked", accessBlockedMsg)
+}
+
+// denyBlockedTwilio ends a blocked caller's call, or answers their message,
+// with a 200 TwiML reply as denyTwilioVoice does
+func denyBlockedTwilio(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("MessageSid") != "" {
+		writeTwiML(w, twimlMessage{Body: textMsg("blocked")})
+		return
+	}
+	lang := twilioLang(r)
+	writeTwiML(w, say(lang, twilioMsg(lang, "blocked")), twimlHangup{})
+}
+
+// denyBlockedTelegram answers a blocked Telegram sender
+func denyBlockedTelegram(w http.ResponseWriter, r *http.Request) {
+	writeTelegramReply(w, r, textMsg("telegram_blocked"))
+}
+
+// apiListAccessRulesHandler lists the access rules, oldest first. Optional
+// filters: action (block, allow), kind (caller, ip) and active=true to
+// leave expired rules out.
+func apiListAccessRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := st.ListAccessRules(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "ACCESS_RULES_ERROR", fmt.Sprintf("Failed to list access rules: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	list := apiAccessRuleList{Rules: []apiAccessRule{}}
+	for i := range rules {
+		a := &rules[i]
+		if (q.Get("action") != "" && a.Action != q.Get("action")) || (q.Get("kind") != "" && a.Kind != q.Get("kind")) ||
+			(q.Get("active") == "true" && a.Expired(now)) {
+			continue
+		}
+		list.Rules = append(list.Rules, toAPIAccessRule(a))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// apiCreateAccessRuleHandler adds a rule from {"action": "block"|"allow",
+// "kind": "caller"|"ip", "value": "...", "reason": "...", "ttl": "24h"};
+// action defaults to block and no ttl means the rule lasts until deleted
+func apiCreateAccessRuleHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Action string `json:"action"`
+		Kind   string `json:"kind"`
+		Value  string `json:"value"`
+		Reason string `json:"reason"`
+		TTL    string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"kind": "caller"|"ip", "value": "..."}`)
+		return
+	}
+	if body.Action == "" {
+		body.Action = store.AccessBlock
+	}
+	if body.Action != store.AccessBlock && body.Action != store.AccessAllow {
+		writeAPIError(w, http.StatusBadRequest, "invalid_action", "action must be block or allow")
+		return
+	}
+	value, err := parseAccessValue(body.Kind, body.Value)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_value", err.Error())
+		return
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if len(reason) > maxAccessReasonLength {
+		writeAPIError(w, http.StatusBadRequest, "invalid_reason", fmt.Sprintf("reason may be at most %d characters", maxAccessReasonLength))
+		return
+	}
+	rule := &store.AccessRule{Action: body.Action, Kind: body.Kind, Value: value, Reason: reason, CreatedBy: requestUser(r.Context()).Username}
+	if body.TTL != "" {
+		ttl, err := time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_ttl", "ttl must be a positive duration such as 24h")
+			return
+		}
+		rule.ExpiresAt = time.Now().Add(ttl)
+	}
+
+	a, err := st.CreateAccessRule(r.Context(), rule)
+	if errors.Is(err, store.ErrDuplicate) {
+		writeAPIError(w, http.StatusConflict, "duplicate", "An active rule for this value already exists")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "ACCESS_RULES_ERROR", fmt.Sprintf("Failed to store access rule for %s %s: %v", body.Kind, value, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	resetAccessRules()
+	until := "until deleted"
+	if !a.ExpiresAt.IsZero() {
+		until = "until " + a.ExpiresAt.Format(time.RFC3339)
+	}
+	logErrorContext(r.Context(), "ACCESS_RULE_CREATE_SUCCESS", fmt.Sprintf("%s added rule %d to %s %s %s %s: %s",
+		a.CreatedBy, a.ID, a.Action, a.Kind, a.Value, until, a.Reason))
+	publishChange(r.Context(), eventAccessRuleCreated, "", toAPIAccessRule(a))
+	writeJSON(w, http.StatusCreated, toAPIAccessRule(a))
+}
+
+// apiDeleteAccessRuleHandler removes a rule; other instances stop applying
+// it within accessRefresh
+func apiDeleteAccessRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := st.DeleteAccessRule(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No access rule with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "ACCESS_RULES_ERROR", fmt.Sprintf("Failed to delete access rule %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	resetAccessRules()
+	logErrorContext(r.Context(), "ACCESS_RULE_DELETE_SUCCESS", fmt.Sprintf("%s deleted access rule %d", requestUser(r.Context()).Username, id))
+	publishChange(r.Context(), eventAccessRuleDeleted, "", deletedObject{ID: id})
+	w.WriteHeader(http.StatusNoContent)
+}
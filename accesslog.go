@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Access log formats for ACCESS_LOG_FORMAT
+const (
+	accessLogOff      = "off"
+	accessLogCommon   = "common"
+	accessLogCombined = "combined"
+	accessLogJSON     = "json"
+)
+
+// clfTimeLayout is the timestamp format of the Common Log Format
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogEntry is one request as written in the json format
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	// LatencyMS is the time to serve the request in milliseconds
+	LatencyMS float64 `json:"latency_ms"`
+	SourceIP  string  `json:"source_ip"`
+	UserAgent string  `json:"user_agent"`
+	Referer   string  `json:"referer,omitempty"`
+}
+
+// accessLogger writes one line per request in the configured format
+type accessLogger struct {
+	format string
+	mu     sync.Mutex
+	out    io.Writer
+}
+
+// newAccessLogger opens ACCESS_LOG_FILE (default stdout) for
+// ACCESS_LOG_FORMAT; it returns nil when access logging is off
+func newAccessLogger(c config) (*accessLogger, error) {
+	switch c.AccessLogFormat {
+	case accessLogOff, "":
+		return nil, nil
+	case accessLogCommon, accessLogCombined, accessLogJSON:
+	default:
+		return nil, fmt.Errorf("invalid ACCESS_LOG_FORMAT %q (want off, common, combined or json)", c.AccessLogFormat)
+	}
+	var out io.Writer = os.Stdout
+	if c.AccessLogFile != "" {
+		f, err := os.OpenFile(c.AccessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("open ACCESS_LOG_FILE: %w", err)
+		}
+		out = f
+	}
+	return &accessLogger{format: c.AccessLogFormat, out: out}, nil
+}
+
+// middleware logs every request, including ones no route matched. It
+// must run inside requestIDMiddleware so the ID is available.
+func (l *accessLogger) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		l.write(r, rec, start, time.Since(start))
+	})
+}
+
+func (l *accessLogger) write(r *http.Request, rec *statusRecorder, start time.Time, latency time.Duration) {
+	var line []byte
+	switch l.format {
+	case accessLogJSON:
+		line, _ = json.Marshal(accessLogEntry{
+			Time:      start.UTC(),
+			RequestID: requestID(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Proto:     r.Proto,
+			Status:    rec.status,
+			Bytes:     rec.bytes,
+			LatencyMS: float64(latency.Microseconds()) / 1000,
+			SourceIP:  clientIP(r),
+			UserAgent: r.UserAgent(),
+			Referer:   r.Referer(),
+		})
+	default:
+		size := "-"
+		if rec.bytes > 0 {
+			size = strconv.Itoa(rec.bytes)
+		}
+		s := fmt.Sprintf("%s - - [%s] %q %d %s", clientIP(r), start.Format(clfTimeLayout),
+			r.Method+" "+r.URL.RequestURI()+" "+r.Proto, rec.status, size)
+		if l.format == accessLogCombined {
+			s += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+		}
+		line = []byte(s)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
+
+// orDash returns s, or "-" for an empty field as the Apache formats do
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	pageAdminDashboard = "admin_dashboard.html"
+	pageAdminLogin     = "admin_login.html"
+
+	dashboardDefaultDays = 7
+	dashboardMaxDays     = 90
+	dashboardRecent      = 25
+	dashboardTop         = 10
+)
+
+// dashboardPage is the data for the admin dashboard template
+type dashboardPage struct {
+	Days      int
+	Since     time.Time
+	Generated time.Time
+	// Checks counts audit entries per outcome; Total is their sum
+	Checks []store.Count
+	Total  int
+	Recent []store.AuditEntry
+	TopIDs []store.Count
+	// Errors is empty when LOG_DB_ENABLED is off, which ErrorsStored shows
+	Errors       []store.Count
+	ErrorsStored bool
+	Calls        []dashboardBar
+	// Institution is that of the viewing staff, whose dashboard counts only
+	// the checks and calls that matched its people and leaves out errors
+	Institution string
+	// User is who is viewing; Session is set when they logged in with a
+	// password, to offer a sign-out button
+	User    *store.User
+	Session bool
+}
+
+// dashboardBar is one day of the call volume chart
+type dashboardBar struct {
+	Day   string
+	Count int
+	// Percent is the bar width relative to the busiest day
+	Percent int
+}
+
+// registerAdmin mounts the HTML admin pages
+func registerAdmin(r *mux.Router) {
+	r.Handle("/admin", http.RedirectHandler("/admin/", http.StatusMovedPermanently)).Methods("GET")
+	r.HandleFunc("/admin/", requireRolePage(store.RoleViewer)(adminDashboardHandler)).Methods("GET")
+	r.HandleFunc("/admin/login", adminLoginPageHandler).Methods("GET")
+	r.HandleFunc("/admin/login", withRateLimit(loginRateLimit(), clientIP, denyLogin)(adminLoginHandler)).Methods("POST")
+	r.HandleFunc("/admin/logout", adminLogoutHandler).Methods("POST")
+	r.HandleFunc("/admin/oidc/login", withRateLimit(loginRateLimit(), clientIP, denyLogin)(adminOIDCLoginHandler)).Methods("GET")
+	r.HandleFunc(oidcCallbackPath, withRateLimit(loginRateLimit(), clientIP, denyLogin)(adminOIDCCallbackHandler)).Methods("GET")
+	r.HandleFunc("/admin/2fa", requireRolePage(store.RoleViewer)(admin2FAHandler)).Methods("GET")
+	r.HandleFunc("/admin/2fa", withRateLimit(loginRateLimit(), clientIP, denyLogin)(requireRolePage(store.RoleViewer)(admin2FAEnableHandler))).Methods("POST")
+}
+
+// adminDashboardHandler shows recent verifications, outcome and error
+// counts, the most verified IDs and Twilio call volumes for the last
+// ?days= days (default 7)
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	days := dashboardDefaultDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > dashboardMaxDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", dashboardMaxDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	now := time.Now().UTC()
+	page := dashboardPage{
+		Days:         days,
+		Since:        now.AddDate(0, 0, -days),
+		Generated:    now,
+		ErrorsStored: cfg.LogDBEnabled,
+		User:         requestUser(r.Context()),
+		Session:      hasSession(r),
+		Institution:  staffInstitution(r.Context()),
+	}
+	fail := func(err error) {
+		logErrorContext(r.Context(), "ADMIN_DB_ERROR", fmt.Sprintf("Failed to load dashboard: %v", err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+	ctx := r.Context()
+	var err error
+	if page.Checks, err = st.AuditOutcomeCounts(ctx, page.Institution, page.Since, time.Time{}); err != nil {
+		fail(err)
+		return
+	}
+	if page.Recent, _, err = st.ListAudit(ctx, store.AuditFilter{Institution: page.Institution, Since: page.Since}, dashboardRecent, 0); err != nil {
+		fail(err)
+		return
+	}
+	if page.TopIDs, err = st.TopMatchedIDs(ctx, page.Institution, page.Since, time.Time{}, dashboardTop); err != nil {
+		fail(err)
+		return
+	}
+	if page.Institution == "" {
+		if page.Errors, err = st.ErrorTypeCounts(ctx, errorsZone(page.Since), time.Time{}, dashboardTop); err != nil {
+			fail(err)
+			return
+		}
+	}
+	calls, err := st.DailyCallCounts(ctx, page.Institution, page.Since, time.Time{})
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	for _, c := range page.Checks {
+		page.Total += c.Count
+	}
+	page.Calls = dashboardBars(calls)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	renderPage(w, http.StatusOK, pageAdminDashboard, page)
+}
+
+// dashboardBars scales daily counts against the busiest day
+func dashboardBars(counts []store.Count) []dashboardBar {
+	max := 0
+	for _, c := range counts {
+		if c.Count > max {
+			max = c.Count
+		}
+	}
+	bars := make([]dashboardBar, len(counts))
+	for i, c := range counts {
+		bars[i] = dashboardBar{Day: c.Key, Count: c.Count}
+		if max > 0 {
+			bars[i].Percent = c.Count * 100 / max
+		}
+	}
+	return bars
+}
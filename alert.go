@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// alertClient posts alerts; a short timeout keeps a slow receiver from
+// piling up goroutines
+var alertClient = &http.Client{Timeout: 5 * time.Second}
+
+// sendAlert logs an alert and, when ALERT_WEBHOOK_URL is set, posts it as
+// {"text": "..."} (the format Slack, Mattermost and Teams incoming webhooks
+// accept). Delivery is asynchronous so handlers are never slowed down.
+func sendAlert(alertType, message string) {
+	logError(alertType, message)
+	if cfg.AlertWebhookURL == "" {
+		return
+	}
+
+	body, _ := json.Marshal(map[string]string{"text": "[hogwarts_verify] " + message})
+	go func() {
+		resp, err := alertClient.Post(cfg.AlertWebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			logError("ALERT_SEND_FAILED", fmt.Sprintf("Failed to send %s alert: %v", alertType, err))
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logError("ALERT_SEND_FAILED", fmt.Sprintf("Alert webhook returned %d for %s", resp.StatusCode, alertType))
+		}
+	}()
+}
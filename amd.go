@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MachineDetection modes for TWILIO_MACHINE_DETECTION: DetectMessageEnd
+// waits for the greeting to end so the message is recorded after the beep
+const (
+	machineDetectMessageEnd = "DetectMessageEnd"
+	machineDetectEnable     = "Enable"
+	machineDetectOff        = "off"
+)
+
+// answeredByMachine reports whether Twilio's AnsweredBy says a machine
+// picked up: machine_start, machine_end_beep, machine_end_silence,
+// machine_end_other or fax. human and unknown are treated as a person.
+func answeredByMachine(answeredBy string) bool {
+	return strings.HasPrefix(answeredBy, "machine_") || answeredBy == "fax"
+}
+
+// withAnsweringMachine keeps verification results away from voicemail: on
+// any voice webhook where Twilio reports a machine, or a later webhook of a
+// call it reported one on, the caller is left a short message without
+// personal data and the call ends. A fax is hung up on.
+func withAnsweringMachine(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		answeredBy := r.PostFormValue("AnsweredBy")
+		if !answeredByMachine(answeredBy) && !callState(r).Machine {
+			next(w, r)
+			return
+		}
+		if answeredByMachine(answeredBy) {
+			updateCallState(r, func(s *callSession) { s.Machine = true })
+			logErrorContext(r.Context(), "TWILIO_MACHINE_ANSWERED", fmt.Sprintf("Call with %s answered by %s; leaving no result", maskCaller(twilioCaller(r)), answeredBy))
+		}
+		recordCall(r, "", callResultMachine, "")
+		if answeredBy == "fax" {
+			writeTwiML(w, twimlHangup{})
+			return
+		}
+		lang := twilioLang(r)
+		writeTwiML(w, say(lang, twilioMsg(lang, "voicemail")), twimlHangup{})
+	}
+}
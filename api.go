@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// apiPerson is the stable v1 JSON schema for a person record
+type apiPerson struct {
+	NationalID string `json:"national_id"`
+	FullName   string `json:"full_name"`
+	Category   string `json:"category"`
+	// Institution is the ID of the institution the person belongs to, ""
+	// for the deployment's own people
+	Institution string `json:"institution"`
+	// Remark joins the text of Remarks
+	Remark     string      `json:"remark"`
+	Remarks    []apiRemark `json:"remarks"`
+	Courses    []string    `json:"courses"`
+	IssueDate  string      `json:"issue_date,omitempty"`
+	ExpiryDate string      `json:"expiry_date,omitempty"`
+	Expired    bool        `json:"expired"`
+	Revoked    bool        `json:"revoked"`
+	// PublicLookup is false when the person only wants to be verified by
+	// partners; see registrarOnlyNotice
+	PublicLookup bool   `json:"public_lookup"`
+	PhotoURL     string `json:"photo_url,omitempty"`
+}
+
+// apiVerifyResult is the v1 JSON schema for a verification check
+type apiVerifyResult struct {
+	ID       string     `json:"id"`
+	Verified bool       `json:"verified"`
+	Person   *apiPerson `json:"person,omitempty"`
+	// Reference and CheckedAt identify a successful check; see
+	// GET /api/v1/verifications/{reference}
+	Reference string     `json:"reference,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// apiError is the v1 JSON error envelope
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// registerAPIv1 mounts the v1 JSON API on the given subrouter
+func registerAPIv1(r *mux.Router) {
+	viewer, registrar, admin := requireRole(store.RoleViewer), requireRole(store.RoleRegistrar), requireRole(store.RoleAdmin)
+	// deployment guards the admin endpoints for settings and data that span
+	// every institution
+	deployment := func(h http.HandlerFunc) http.HandlerFunc { return admin(requireDeploymentStaff(h)) }
+
+	// limit guards the endpoints partners may call, by the name partner
+	// permissions list them under
+	limit := func(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+		return chain(withMaintenance(denyMaintenanceAPI), withBreaker(denyBusyAPI), requireAPIKey(endpoint), withAccessRules(store.AccessIP, clientIP, denyBlockedAPI),
+			withRateLimit(verifyRateLimit(), apiClientKey, denyAPI), withLockout(apiClientKey, denyAPI))(h)
+	}
+
+	r.HandleFunc("/verify", limit(endpointVerify, apiVerifyHandler)).Methods("GET")
+	r.HandleFunc("/verify/batch", limit(endpointBatch, apiBatchVerifyHandler)).Methods("POST")
+	r.HandleFunc("/share", limit(endpointShare, apiShareHandler)).Methods("POST")
+	r.HandleFunc("/people/import", registrar(apiImportHandler)).Methods("POST")
+	r.HandleFunc("/people/export", registrar(apiExportHandler)).Methods("GET")
+	r.HandleFunc("/duplicates", admin(apiDuplicatesHandler)).Methods("GET")
+	r.HandleFunc("/people/{id}/courses/{course_id}", registrar(requirePersonInScope(apiPersonCourseHandler))).Methods("PUT", "DELETE")
+	r.HandleFunc("/people/{id}/revoke", registrar(requirePersonInScope(apiRevokeHandler))).Methods("POST")
+	r.HandleFunc("/people/{id}/unrevoke", registrar(requirePersonInScope(apiUnrevokeHandler))).Methods("POST")
+	r.HandleFunc("/people/{id}/restore", admin(requirePersonInScope(apiRestorePersonHandler))).Methods("POST")
+	r.HandleFunc("/people/{id}/merge", admin(requirePersonInScope(apiMergePersonHandler))).Methods("POST")
+	r.HandleFunc("/people/{id}/public_lookup", registrar(requirePersonInScope(apiSetPublicLookupHandler))).Methods("PUT")
+	r.HandleFunc("/people/{id}/institution", registrar(requirePersonInScope(apiSetPersonInstitutionHandler))).Methods("PUT")
+	r.HandleFunc("/people/{id}/history", viewer(requirePersonInScope(apiPersonHistoryHandler))).Methods("GET")
+	r.HandleFunc("/people/{id}/remarks", viewer(requirePersonInScope(apiRemarksHandler))).Methods("GET")
+	r.HandleFunc("/people/{id}/remarks", registrar(requirePersonInScope(apiRemarksHandler))).Methods("POST")
+	r.HandleFunc("/people/{id}/remarks/{remark_id}", registrar(requirePersonInScope(apiDeleteRemarkHandler))).Methods("DELETE")
+	r.HandleFunc("/people/{id}/attachments", viewer(requirePersonInScope(apiListAttachmentsHandler))).Methods("GET")
+	r.HandleFunc("/people/{id}/attachments", registrar(requirePersonInScope(apiUploadAttachmentHandler))).Methods("POST")
+	r.HandleFunc("/people/{id}/attachments/{attachment_id}", viewer(requirePersonInScope(apiDownloadAttachmentHandler))).Methods("GET", "HEAD")
+	r.HandleFunc("/people/{id}/attachments/{attachment_id}", registrar(requirePersonInScope(apiDeleteAttachmentHandler))).Methods("DELETE")
+	r.HandleFunc("/people/{id}/identifiers", viewer(requirePersonInScope(apiIdentifiersHandler))).Methods("GET")
+	r.HandleFunc("/people/{id}/identifiers", registrar(requirePersonInScope(apiIdentifiersHandler))).Methods("POST")
+	r.HandleFunc("/people/{id}/identifiers/{type}/{value}", registrar(requirePersonInScope(apiRemoveIdentifierHandler))).Methods("DELETE")
+	// Photos are linked from the public HTML pages, so they need no API key,
+	// but are otherwise guarded like the lookups above, per client IP
+	photo := chain(withMaintenance(denyMaintenanceAPI), withBreaker(denyBusyAPI), withAccessRules(store.AccessIP, clientIP, denyBlockedAPI),
+		withRateLimit(verifyRateLimit(), clientIP, denyAPI), withLockout(clientIP, denyAPI))
+	r.HandleFunc("/people/{id}/photo", photo(apiPhotoHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/people/{id}/photo", registrar(requirePersonInScope(apiSetPhotoHandler))).Methods("PUT")
+	r.HandleFunc("/people/{id}/photo", registrar(requirePersonInScope(apiDeletePhotoHandler))).Methods("DELETE")
+	r.HandleFunc("/people/{id}", limit(endpointPeople, apiPersonHandler)).Methods("GET")
+	r.HandleFunc("/people/{id}", registrar(requirePersonInScope(apiDeletePersonHandler))).Methods("DELETE")
+	r.HandleFunc("/courses", viewer(apiListCoursesHandler)).Methods("GET")
+	r.HandleFunc("/courses", registrar(apiCreateCourseHandler)).Methods("POST")
+	r.HandleFunc("/categories", viewer(apiCategoriesHandler)).Methods("GET")
+	r.HandleFunc("/categories/{name}", deployment(apiPutCategoryHandler)).Methods("PUT")
+	r.HandleFunc("/categories/{name}", deployment(apiDeleteCategoryHandler)).Methods("DELETE")
+	r.HandleFunc("/partners", deployment(apiPartnersHandler)).Methods("GET")
+	r.HandleFunc("/partners/{id}", deployment(apiPutPartnerHandler)).Methods("PUT")
+	r.HandleFunc("/partners/{id}", deployment(apiDeletePartnerHandler)).Methods("DELETE")
+	r.HandleFunc("/partners/{id}/usage", deployment(apiPartnerUsageHandler)).Methods("GET")
+	r.HandleFunc("/institutions", viewer(apiInstitutionsHandler)).Methods("GET")
+	r.HandleFunc("/institutions/{id}", deployment(apiPutInstitutionHandler)).Methods("PUT")
+	r.HandleFunc("/institutions/{id}", deployment(apiDeleteInstitutionHandler)).Methods("DELETE")
+	r.HandleFunc("/search", limit(endpointSearch, apiSearchHandler)).Methods("GET")
+	r.HandleFunc("/calls", viewer(apiCallsHandler)).Methods("GET")
+	r.HandleFunc("/calls", registrar(apiCreateCallHandler)).Methods("POST")
+	r.HandleFunc("/audit", viewer(apiAuditHandler)).Methods("GET")
+	r.HandleFunc("/errors", viewer(requireDeploymentStaff(apiErrorsHandler))).Methods("GET")
+	r.HandleFunc("/errors", deployment(apiPurgeErrorsHandler)).Methods("DELETE")
+	r.HandleFunc("/stats", viewer(apiStatsHandler)).Methods("GET")
+	r.HandleFunc("/subjects/{id}", deployment(apiSubjectExportHandler)).Methods("GET")
+	r.HandleFunc("/subjects/{id}/erase", deployment(apiSubjectEraseHandler)).Methods("POST")
+	r.HandleFunc("/erasures", deployment(apiListErasuresHandler)).Methods("GET")
+	r.HandleFunc("/verifications/{reference}", limit(endpointVerifications, apiVerificationHandler)).Methods("GET")
+	r.HandleFunc("/branding", viewer(apiBrandingHandler)).Methods("GET")
+	r.HandleFunc("/branding", deployment(apiSetBrandingHandler)).Methods("PUT")
+	r.HandleFunc("/maintenance", viewer(apiMaintenanceHandler)).Methods("GET")
+	r.HandleFunc("/maintenance", deployment(apiSetMaintenanceHandler)).Methods("PUT")
+	r.HandleFunc("/message_templates", viewer(apiListMessageTemplatesHandler)).Methods("GET")
+	r.HandleFunc("/message_templates/{channel}/{lang}/{key}", deployment(apiSetMessageTemplateHandler)).Methods("PUT")
+	r.HandleFunc("/message_templates/{channel}/{lang}/{key}", deployment(apiDeleteMessageTemplateHandler)).Methods("DELETE")
+	r.HandleFunc("/keys", admin(apiListKeysHandler)).Methods("GET")
+	r.HandleFunc("/keys", admin(apiCreateKeyHandler)).Methods("POST")
+	r.HandleFunc("/keys/{id}", admin(requireAPIKeyInScope(apiRevokeKeyHandler))).Methods("DELETE")
+	r.HandleFunc("/keys/{id}/usage", admin(requireAPIKeyInScope(apiKeyUsageHandler))).Methods("GET")
+	r.HandleFunc("/webhooks", deployment(apiListWebhooksHandler)).Methods("GET")
+	r.HandleFunc("/webhooks", deployment(apiCreateWebhookHandler)).Methods("POST")
+	r.HandleFunc("/webhooks/{id}", deployment(apiDeleteWebhookHandler)).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/deliveries", deployment(apiDeliveriesHandler)).Methods("GET")
+	r.HandleFunc("/webhooks/{id}/deliveries/{delivery_id}/retry", deployment(apiRetryDeliveryHandler)).Methods("POST")
+	r.HandleFunc("/access_rules", deployment(apiListAccessRulesHandler)).Methods("GET")
+	r.HandleFunc("/access_rules", deployment(apiCreateAccessRuleHandler)).Methods("POST")
+	r.HandleFunc("/access_rules/{id}", deployment(apiDeleteAccessRuleHandler)).Methods("DELETE")
+	r.HandleFunc("/jobs", deployment(apiJobsHandler)).Methods("GET")
+	r.HandleFunc("/retention", deployment(apiRetentionHandler)).Methods("GET")
+	r.HandleFunc("/jobs/{name}/run", deployment(apiRunJobHandler)).Methods("POST")
+	r.HandleFunc("/users", admin(apiListUsersHandler)).Methods("GET")
+	r.HandleFunc("/users", admin(apiCreateUserHandler)).Methods("POST")
+	r.HandleFunc("/users/{id}", admin(requireUserInScope(apiUpdateUserHandler))).Methods("PUT")
+	r.HandleFunc("/users/{id}", admin(requireUserInScope(apiDeleteUserHandler))).Methods("DELETE")
+	r.HandleFunc("/users/{id}/token", admin(requireUserInScope(apiResetUserTokenHandler))).Methods("POST")
+	r.HandleFunc("/users/{id}/institution", admin(requireUserInScope(apiSetUserInstitutionHandler))).Methods("PUT")
+	r.HandleFunc("/users/{id}/password", admin(requireUserInScope(apiSetUserPasswordHandler))).Methods("PUT")
+	r.HandleFunc("/users/{id}/totp", admin(requireUserInScope(apiResetUserTOTPHandler))).Methods("DELETE")
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Unknown API endpoint")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	})
+}
+
+// writeAPIError writes a v1 error envelope with the given status
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorDetail{Code: code, Message: message}})
+}
+
+// parsePagination reads the page and per_page query parameters, writing a
+// 400 response and returning ok=false when they are malformed
+func parsePagination(w http.ResponseWriter, r *http.Request, defPerPage, maxPerPage int) (page, perPage int, ok bool) {
+	q := r.URL.Query()
+	page, perPage = 1, defPerPage
+
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_page", "page must be a positive integer")
+			return 0, 0, false
+		}
+		page = n
+	}
+	if v := q.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			writeAPIError(w, http.StatusBadRequest, "invalid_per_page",
+				fmt.Sprintf("per_page must be between 1 and %d", maxPerPage))
+			return 0, 0, false
+		}
+		perPage = n
+	}
+	return page, perPage, true
+}
+
+// toAPIPerson converts a person row into the v1 schema. Staff see every
+// remark; API clients only those the API may show.
+func toAPIPerson(r *http.Request, p *store.Person) *apiPerson {
+	remarks := p.Remarks
+	if requestUser(r.Context()) == nil {
+		remarks = remarksFor(p, auditChannelAPI)
+	}
+	courses := []string{}
+	if categoryOf(r.Context(), p.Category).ShowCourses && p.Courses != nil {
+		courses = p.Courses
+	}
+	ap := &apiPerson{
+		NationalID:   p.NationalID,
+		FullName:     p.FullName,
+		Category:     p.Category,
+		Institution:  p.InstitutionID,
+		Remark:       remarkText(remarks),
+		Remarks:      toAPIRemarks(remarks),
+		Courses:      courses,
+		IssueDate:    formatDate(p.IssueDate),
+		ExpiryDate:   formatDate(p.ExpiryDate),
+		Expired:      p.Expired(time.Now()),
+		Revoked:      p.Revoked,
+		PublicLookup: p.PublicLookup,
+	}
+	if p.HasPhoto {
+		ap.PhotoURL = photoURL(r, p.NationalID)
+	}
+	return ap
+}
+
+// apiLookup validates id and fetches the person, writing an error response
+// on failure. It returns nil when a response has already been written, and
+// otherwise the person and the audit row carrying the verification reference.
+func apiLookup(w http.ResponseWriter, r *http.Request, id, logPrefix string) (*store.Person, *store.AuditEntry) {
+	if id == "" {
+		logErrorContext(r.Context(), logPrefix+"_NO_ID", "No ID provided")
+		recordAudit(r, auditChannelAPI, id, callResultInvalid, "")
+		writeAPIError(w, http.StatusBadRequest, "missing_id", "ID is required")
+		return nil, nil
+	}
+	if !validID(id) {
+		logErrorContext(r.Context(), logPrefix+"_INVALID_ID", fmt.Sprintf("Invalid ID format: %s", maskID(id)))
+		recordAudit(r, auditChannelAPI, id, callResultInvalid, "")
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return nil, nil
+	}
+
+	idType, err := idTypeParam(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id_type", err.Error())
+		return nil, nil
+	}
+
+	ids, err := lookupIDs(id, idType)
+	if err != nil {
+		logErrorContext(r.Context(), logPrefix+"_INVALID_NIC", fmt.Sprintf("Invalid NIC %s: %v", maskID(id), err))
+		recordAudit(r, auditChannelAPI, id, callResultInvalid, "")
+		writeAPIError(w, http.StatusBadRequest, "invalid_nic", err.Error())
+		return nil, nil
+	}
+
+	p, err := findPerson(r.Context(), ids, idType)
+	if err == store.ErrNotFound {
+		logErrorContext(r.Context(), logPrefix+"_NOT_FOUND", fmt.Sprintf("Person not found for ID: %s", maskID(id)))
+		noteLookup(r.Context(), false, 1)
+		recordAudit(r, auditChannelAPI, id, callResultNoMatch, "")
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return nil, nil
+	} else if breakerRefused(w, err) {
+		denyBusyAPI(w, r)
+		return nil, nil
+	} else if err != nil {
+		logErrorContext(r.Context(), logPrefix+"_DB_ERROR", fmt.Sprintf("Database error for ID %s: %v", maskID(id), err))
+		recordAudit(r, auditChannelAPI, id, callResultError, "")
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return nil, nil
+	}
+	noteLookup(r.Context(), true, 0)
+	if restricted(r, p) {
+		logErrorContext(r.Context(), logPrefix+"_RESTRICTED", fmt.Sprintf("ID %s opted out of public lookups", maskID(id)))
+		recordAudit(r, auditChannelAPI, id, callResultRestricted, p.NationalID)
+		writeAPIError(w, http.StatusForbidden, "registrar_only", registrarOnlyNotice)
+		return nil, nil
+	}
+	return p, recordMatch(r, auditChannelAPI, id, p)
+}
+
+func apiVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	p, check := apiLookup(w, r, id, "API_VERIFY")
+	if p == nil {
+		return
+	}
+
+	logErrorContext(r.Context(), "API_VERIFY_SUCCESS", fmt.Sprintf("Verified ID: %s, Name: %s, Category: %s, Reference: %s", maskID(id), piiText(p.FullName), p.Category, check.Reference))
+	writeJSON(w, http.StatusOK, apiVerifyResult{
+		ID:        id,
+		Verified:  !p.Revoked,
+		Person:    toAPIPerson(r, p),
+		Reference: check.Reference,
+		CheckedAt: &check.CreatedAt,
+	})
+}
+
+func apiPersonHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	p, _ := apiLookup(w, r, id, "API_PERSON")
+	if p == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toAPIPerson(r, p))
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	// apiKeyPrefix starts every issued key so leaked keys are easy to
+	// recognise in logs and secret scanners
+	apiKeyPrefix = "hvk_"
+	// apiKeyDisplayLength is how much of a key is kept as its prefix
+	apiKeyDisplayLength = len(apiKeyPrefix) + 8
+	// maxAPIKeyNameLength matches api_keys.name
+	maxAPIKeyNameLength = 100
+)
+
+// apiKey is the v1 JSON schema for an API key. Key is only set in the
+// response that issues it.
+type apiKey struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	// Institution is the institution whose people the key may look up,
+	// "" for everyone
+	Institution string `json:"institution"`
+	// Partner is the partner whose quota and endpoints the key uses
+	Partner    string     `json:"partner,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	Requests   int64      `json:"requests"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// apiKeyList is the v1 JSON schema for GET /keys
+type apiKeyList struct {
+	Keys []apiKey `json:"keys"`
+}
+
+// apiKeyUsage is the v1 JSON schema for GET /keys/{id}/usage
+type apiKeyUsage struct {
+	KeyID int64            `json:"key_id"`
+	Total int              `json:"total"`
+	Days  []apiKeyUsageDay `json:"days"`
+}
+
+type apiKeyUsageDay struct {
+	Day      string `json:"day"`
+	Requests int    `json:"requests"`
+}
+
+func toAPIKey(k *store.APIKey) apiKey {
+	out := apiKey{ID: k.ID, Name: k.Name, Prefix: k.Prefix, Institution: k.InstitutionID, Partner: k.PartnerID, Requests: k.Requests, CreatedAt: k.CreatedAt}
+	if !k.LastUsedAt.IsZero() {
+		out.LastUsedAt = &k.LastUsedAt
+	}
+	if k.Revoked() {
+		out.RevokedAt = &k.RevokedAt
+	}
+	return out
+}
+
+// newToken returns a random bearer token starting with prefix; only its
+// hash is stored
+func newToken(prefix string) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// hashToken is the stored hash of an API key or user token. Tokens are
+// random, so a plain SHA-256 is enough; there is nothing to brute-force.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type apiKeyCtxKey struct{}
+
+// requestAPIKey returns the API key that authenticated the request, or nil
+// for the admin token or when keys are not required
+func requestAPIKey(ctx context.Context) *store.APIKey {
+	k, _ := ctx.Value(apiKeyCtxKey{}).(*store.APIKey)
+	return k
+}
+
+// apiClientKey rate limits API requests per API key, so partners behind a
+// shared address do not share a limit, and per client IP otherwise
+func apiClientKey(r *http.Request) string {
+	if k := requestAPIKey(r.Context()); k != nil {
+		return "key:" + strconv.FormatInt(k.ID, 10)
+	}
+	return clientIP(r)
+}
+
+// requireAPIKey protects a public JSON API endpoint with an issued key
+// (or ADMIN_TOKEN) in an "Authorization: Bearer" header, applies the
+// endpoint permissions and quota of the key's partner, and counts the
+// request against the key. It lets every request through when
+// API_KEYS_REQUIRED is off.
+func requireAPIKey(endpoint string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !cfg.APIKeysRequired {
+				next(w, r)
+				return
+			}
+			token, ok := bearerToken(r)
+			if ok && cfg.AdminToken != "" && validAdminToken(token) {
+				next(w, r)
+				return
+			}
+			unauthorized := func() {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				writeAPIError(w, http.StatusUnauthorized, "unauthorized", "A valid API key is required")
+			}
+			if !ok {
+				logErrorContext(r.Context(), "AUTH_FAILED", "Missing API key for "+r.URL.Path+" from "+clientIP(r))
+				unauthorized()
+				return
+			}
+
+			k, err := st.FindAPIKey(r.Context(), hashToken(token))
+			if err == store.ErrNotFound || (err == nil && k.Revoked()) {
+				logErrorContext(r.Context(), "AUTH_FAILED", "Unknown or revoked API key for "+r.URL.Path+" from "+clientIP(r))
+				unauthorized()
+				return
+			} else if err != nil {
+				logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to look up API key: %v", err))
+				writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+				return
+			}
+
+			if !checkPartner(w, r, k, endpoint) {
+				return
+			}
+			// A failure to count the request should not lock the partner out
+			if err := st.RecordAPIKeyUse(r.Context(), k.ID, time.Now()); err != nil {
+				logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to record use of API key %d: %v", k.ID, err))
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey{}, k)))
+		}
+	}
+}
+
+func apiListKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := st.ListAPIKeys(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to list API keys: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	// An institution's staff see only the keys limited to it
+	own := staffInstitution(r.Context())
+	list := apiKeyList{Keys: []apiKey{}}
+	for i := range keys {
+		if own == "" || keys[i].InstitutionID == own {
+			list.Keys = append(list.Keys, toAPIKey(&keys[i]))
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// apiCreateKeyHandler issues a key from a {"name": "..."} body naming the
+// partner, with an optional "institution" limiting its lookups to that
+// institution's people and "partner", the ID of the partner whose quota
+// and endpoints apply. Keys issued by an institution's staff are limited
+// to it and have no partner. The key is in the response only; it cannot
+// be shown again.
+func apiCreateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Name        string `json:"name"`
+		Institution string `json:"institution"`
+		Partner     string `json:"partner"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"name": "...", "institution": "...", "partner": "..."}`)
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		writeAPIError(w, http.StatusBadRequest, "invalid_name",
+			fmt.Sprintf("name is required and may be at most %d characters", maxAPIKeyNameLength))
+		return
+	}
+	institution, ok := userInstitution(w, r, body.Institution)
+	if !ok {
+		return
+	}
+	if _, ok := currentPartners(r.Context())[body.Partner]; body.Partner != "" && !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_partner", "partner must be the ID of a partner, or empty")
+		return
+	}
+	if body.Partner != "" && staffInstitution(r.Context()) != "" {
+		writeAPIError(w, http.StatusForbidden, "other_institution", "Only the deployment's own staff can issue partner keys")
+		return
+	}
+
+	key, err := newToken(apiKeyPrefix)
+	if err != nil {
+		logErrorContext(r.Context(), "APIKEY_CREATE_ERROR", fmt.Sprintf("Failed to generate API key: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	k, err := st.CreateAPIKey(r.Context(), name, key[:apiKeyDisplayLength], hashToken(key), institution, body.Partner)
+	if err != nil {
+		logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to store API key for %q: %v", name, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "APIKEY_CREATE_SUCCESS", fmt.Sprintf("Issued API key %d (%s) for %s", k.ID, k.Prefix, k.Name))
+	publishChange(r.Context(), eventAPIKeyCreated, "", toAPIKey(k))
+	resp := toAPIKey(k)
+	resp.Key = key
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// pathID parses the {id} path variable, writing a 400 when it is invalid
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id < 1 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "id must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// apiRevokeKeyHandler revokes a key at once; requests made with it get 401
+func apiRevokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := st.RevokeAPIKey(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No active API key with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to revoke API key %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "APIKEY_REVOKE_SUCCESS", fmt.Sprintf("Revoked API key %d", id))
+	publishChange(r.Context(), eventAPIKeyRevoked, "", deletedObject{ID: id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiKeyUsageHandler returns a key's requests per UTC day. Optional
+// filters: since and until (RFC 3339 or YYYY-MM-DD, until exclusive).
+func apiKeyUsageHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	since, until, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := st.GetAPIKey(r.Context(), id); errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No API key with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to load API key %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	counts, err := st.APIKeyUsage(r.Context(), id, since, until)
+	if err != nil {
+		logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to load usage of API key %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	resp := apiKeyUsage{KeyID: id, Days: make([]apiKeyUsageDay, len(counts))}
+	for i, c := range counts {
+		resp.Days[i] = apiKeyUsageDay{Day: c.Key, Requests: c.Count}
+		resp.Total += c.Count
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// useTestStore points st at a migrated SQLite store in a temporary
+// directory for the length of the test
+func useTestStore(t *testing.T) *store.SQLStore {
+	t.Helper()
+	s, err := store.Open(store.DriverSQLite, store.SQLiteDSN(filepath.Join(t.TempDir(), "test.db")), store.PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := st
+	st = s
+	t.Cleanup(func() {
+		st = prev
+		s.Close()
+	})
+	return s
+}
+
+func TestNewToken(t *testing.T) {
+	a, err := newToken(apiKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newToken(apiKeyPrefix)
+	if !strings.HasPrefix(a, apiKeyPrefix) || len(a) != len(apiKeyPrefix)+48 {
+		t.Errorf("newToken = %q; want %s and 48 hex digits", a, apiKeyPrefix)
+	}
+	if a == b {
+		t.Error("newToken returned the same token twice")
+	}
+	if h := hashToken(a); len(h) != 64 || h == hashToken(b) {
+		t.Errorf("hashToken(%q) = %q; want its SHA-256 in hex", a, h)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header, want string
+		ok           bool
+	}{
+		{"Bearer hvk_abc", "hvk_abc", true},
+		{"bearer hvk_abc", "hvk_abc", true},
+		{"Bearer  hvk_abc ", "hvk_abc", true},
+		{"Bearer ", "", false},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"hvk_abc", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/verify", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if got, ok := bearerToken(r); got != tt.want || ok != tt.ok {
+			t.Errorf("bearerToken(%q) = %q, %v; want %q, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	s := useTestStore(t)
+	defer func(required bool, admin string) { cfg.APIKeysRequired, cfg.AdminToken = required, admin }(cfg.APIKeysRequired, cfg.AdminToken)
+	cfg.AdminToken = "admin-secret"
+	ctx := context.Background()
+
+	issue := func(name string) (string, *store.APIKey) {
+		key, err := newToken(apiKeyPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := s.CreateAPIKey(ctx, name, key[:apiKeyDisplayLength], hashToken(key), "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key, k
+	}
+	active, activeKey := issue("active")
+	revoked, revokedKey := issue("revoked")
+	if err := s.RevokeAPIKey(ctx, revokedKey.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		required bool
+		header   string
+		status   int
+		keyID    int64
+	}{
+		{name: "keys not required", header: "", status: http.StatusOK},
+		{name: "missing", required: true, status: http.StatusUnauthorized},
+		{name: "not bearer", required: true, header: "Basic " + active, status: http.StatusUnauthorized},
+		{name: "unknown", required: true, header: "Bearer " + apiKeyPrefix + "0000", status: http.StatusUnauthorized},
+		{name: "revoked", required: true, header: "Bearer " + revoked, status: http.StatusUnauthorized},
+		{name: "admin token", required: true, header: "Bearer admin-secret", status: http.StatusOK},
+		{name: "active", required: true, header: "Bearer " + active, status: http.StatusOK, keyID: activeKey.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.APIKeysRequired = tt.required
+			var keyID int64
+			h := requireAPIKey(endpointVerify)(func(w http.ResponseWriter, r *http.Request) {
+				if k := requestAPIKey(r.Context()); k != nil {
+					keyID = k.ID
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/verify", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.status || keyID != tt.keyID {
+				t.Errorf("status %d with key %d; want %d with key %d", w.Code, keyID, tt.status, tt.keyID)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+
+	now := time.Now()
+	usage, err := s.APIKeyUsage(ctx, activeKey.ID, now.AddDate(0, 0, -1), now.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, c := range usage {
+		total += c.Count
+	}
+	if total != 1 {
+		t.Errorf("active key counted %d requests, want 1", total)
+	}
+}
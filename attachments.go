@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// attachmentTypes are the document types accepted for upload, checked
+// against the sniffed content rather than the declared type
+var attachmentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/webp":      true,
+}
+
+// maxAttachmentFilename bounds the stored name of a document
+const maxAttachmentFilename = 255
+
+// errAttachmentMissing is returned when an attachment's file is not in
+// the storage
+var errAttachmentMissing = errors.New("attachment file is missing")
+
+// attachmentStorage keeps the files of attachments by storage key
+type attachmentStorage interface {
+	put(ctx context.Context, key, contentType string, data []byte) error
+	// get returns errAttachmentMissing for a key that is not stored
+	get(ctx context.Context, key string) ([]byte, error)
+	remove(ctx context.Context, key string) error
+}
+
+// diskAttachments stores attachments as files in a directory
+type diskAttachments struct {
+	dir string
+}
+
+func (d diskAttachments) put(ctx context.Context, key, contentType string, data []byte) error {
+	if err := os.MkdirAll(d.dir, 0o700); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a failed upload leaves no
+	// partial document behind
+	f, err := os.CreateTemp(d.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(d.dir, key))
+}
+
+func (d diskAttachments) get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errAttachmentMissing
+	}
+	return data, err
+}
+
+func (d diskAttachments) remove(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(d.dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// s3Attachments stores attachments under a prefix in an S3 bucket
+type s3Attachments struct {
+	bucket s3Bucket
+	prefix string
+}
+
+func (s s3Attachments) put(ctx context.Context, key, contentType string, data []byte) error {
+	return s.bucket.put(ctx, s.prefix+key, contentType, data)
+}
+
+func (s s3Attachments) get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.bucket.get(ctx, s.prefix+key)
+	if err == errS3NotFound {
+		return nil, errAttachmentMissing
+	}
+	return data, err
+}
+
+func (s s3Attachments) remove(ctx context.Context, key string) error {
+	return s.bucket.remove(ctx, s.prefix+key)
+}
+
+// attachments is the configured attachment storage: ATTACHMENT_S3_BUCKET
+// when set, else ATTACHMENT_DIR
+func attachments() attachmentStorage {
+	if cfg.AttachmentS3Bucket == "" {
+		return diskAttachments{dir: cfg.AttachmentDir}
+	}
+	return s3Attachments{
+		bucket: s3Bucket{Name: cfg.AttachmentS3Bucket, Region: cfg.AttachmentS3Region, Endpoint: cfg.AttachmentS3Endpoint,
+			AccessKey: cfg.AttachmentS3AccessKey, SecretKey: cfg.AttachmentS3SecretKey},
+		prefix: cfg.AttachmentS3Prefix,
+	}
+}
+
+// validateAttachments checks the ATTACHMENT_* settings at startup
+func validateAttachments(c config) error {
+	if c.AttachmentMaxBytes < 1 {
+		return errors.New("ATTACHMENT_MAX_BYTES must be positive")
+	}
+	if c.AttachmentS3Bucket == "" {
+		if c.AttachmentDir == "" {
+			return errors.New("ATTACHMENT_DIR or ATTACHMENT_S3_BUCKET is required")
+		}
+		return nil
+	}
+	if c.AttachmentS3AccessKey == "" || c.AttachmentS3SecretKey == "" {
+		return errors.New("ATTACHMENT_S3_ACCESS_KEY_ID and ATTACHMENT_S3_SECRET_ACCESS_KEY are required with ATTACHMENT_S3_BUCKET")
+	}
+	if c.AttachmentS3Endpoint != "" {
+		if u, err := url.Parse(c.AttachmentS3Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("ATTACHMENT_S3_ENDPOINT must be an absolute http(s) URL")
+		}
+	}
+	return nil
+}
+
+// apiAttachment is the v1 JSON schema for an attachment
+type apiAttachment struct {
+	ID          int64     `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	UploadedBy  string    `json:"uploaded_by,omitempty"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func toAPIAttachment(r *http.Request, a *store.Attachment) apiAttachment {
+	return apiAttachment{
+		ID:          a.ID,
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		SHA256:      a.SHA256,
+		UploadedBy:  a.UploadedBy,
+		URL:         publicBaseURL(r) + "/api/v1/people/" + url.PathEscape(a.NationalID) + "/attachments/" + strconv.FormatInt(a.ID, 10),
+		CreatedAt:   a.CreatedAt.UTC(),
+	}
+}
+
+// cleanFilename keeps the last path element of an uploaded file's name,
+// without control characters and at most maxAttachmentFilename long
+func cleanFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	for utf8.RuneCountInString(name) > maxAttachmentFilename {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == ".." {
+		return "document"
+	}
+	return name
+}
+
+// attachmentIDs validates the {id} of an attachment request, writing an
+// error response and returning nil when it is malformed
+func attachmentIDs(w http.ResponseWriter, r *http.Request) []string {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return nil
+	}
+	ids, err := lookupIDs(id, "")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return nil
+	}
+	return ids
+}
+
+// attachmentID parses {attachment_id}, writing an error response and
+// returning 0 when it is malformed
+func attachmentID(w http.ResponseWriter, r *http.Request) int64 {
+	id, err := strconv.ParseInt(mux.Vars(r)["attachment_id"], 10, 64)
+	if err != nil || id < 1 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_attachment_id", "attachment_id must be a positive integer")
+		return 0
+	}
+	return id
+}
+
+// apiListAttachmentsHandler lists the documents attached to a person,
+// deleted or not, oldest first
+func apiListAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	ids := attachmentIDs(w, r)
+	if ids == nil {
+		return
+	}
+	p, err := findPersonOrDeleted(r.Context(), ids)
+	var list []store.Attachment
+	if err == nil {
+		list, err = st.ListAttachments(r.Context(), p.NationalID)
+	}
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "ATTACHMENT_DB_ERROR", fmt.Sprintf("Failed to list attachments for %s: %v", maskID(ids[0]), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	out := make([]apiAttachment, len(list))
+	for i := range list {
+		out[i] = toAPIAttachment(r, &list[i])
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"national_id": p.NationalID, "attachments": out})
+}
+
+// apiUploadAttachmentHandler attaches a document to a person: POST
+// /people/{id}/attachments with a multipart "file" field holding a PDF,
+// JPEG, PNG or WebP file of at most ATTACHMENT_MAX_BYTES
+func apiUploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	ids := attachmentIDs(w, r)
+	if ids == nil {
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.AttachmentMaxBytes)+64<<10)
+	var tooLarge *http.MaxBytesError
+	if err := r.ParseMultipartForm(32 << 20); errors.As(err, &tooLarge) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "attachment_too_large",
+			fmt.Sprintf("Attachments may be at most %d bytes", cfg.AttachmentMaxBytes))
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "Body must be multipart/form-data with a file field")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "missing_file", "The file field is required")
+		return
+	}
+	defer file.Close()
+	if header.Size > int64(cfg.AttachmentMaxBytes) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "attachment_too_large",
+			fmt.Sprintf("Attachments may be at most %d bytes", cfg.AttachmentMaxBytes))
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "Failed to read the file")
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if len(data) == 0 || !attachmentTypes[contentType] {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_attachment", "The file must be a PDF, JPEG, PNG or WebP document")
+		return
+	}
+
+	p, err := findPerson(r.Context(), ids, "")
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "ATTACHMENT_DB_ERROR", fmt.Sprintf("Failed to look up %s for an attachment: %v", maskID(ids[0]), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	// Keys are random so they do not reveal whose document it is
+	key, err := newToken("")
+	if err != nil {
+		logErrorContext(r.Context(), "ATTACHMENT_STORAGE_ERROR", fmt.Sprintf("Failed to generate a storage key: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	sum := sha256.Sum256(data)
+	a := &store.Attachment{
+		NationalID:  p.NationalID,
+		Filename:    cleanFilename(header.Filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		StorageKey:  key,
+	}
+	storage := attachments()
+	if err := storage.put(r.Context(), key, contentType, data); err != nil {
+		logErrorContext(r.Context(), "ATTACHMENT_STORAGE_ERROR", fmt.Sprintf("Failed to store an attachment for %s: %v", maskID(p.NationalID), err))
+		writeAPIError(w, http.StatusBadGateway, "storage_unavailable", "The document could not be stored")
+		return
+	}
+	a, err = st.AddAttachment(r.Context(), a)
+	if err != nil {
+		if rmErr := storage.remove(r.Context(), key); rmErr != nil {
+			logErrorContext(r.Context(), "ATTACHMENT_STORAGE_ERROR", fmt.Sprintf("Failed to remove unrecorded attachment file %s: %v", key, rmErr))
+		}
+		if err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+			return
+		}
+		logErrorContext(r.Context(), "ATTACHMENT_DB_ERROR", fmt.Sprintf("Failed to record an attachment for %s: %v", maskID(p.NationalID), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "ATTACHMENT_ADD_SUCCESS", fmt.Sprintf("%s attached %d byte %s document %d to %s",
+		requestUser(r.Context()).Username, a.Size, a.ContentType, a.ID, maskID(a.NationalID)))
+	writeJSON(w, http.StatusCreated, toAPIAttachment(r, a))
+}
+
+// apiDownloadAttachmentHandler serves the file of one attachment, checked
+// against the digest recorded when it was uploaded
+func apiDownloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	ids := attachmentIDs(w, r)
+	if ids == nil {
+		return
+	}
+	id := attachmentID(w, r)
+	if id == 0 {
+		return
+	}
+
+	p, err := findPersonOrDeleted(r.Context(), ids)
+	var a *store.Attachment
+	if err == nil {
+		a, err = st.GetAttachment(r.Context(), p.NationalID, id)
+	}
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person or attachment not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "ATTACHMENT_DB_ERROR", fmt.Sprintf("Failed to look up attachment %d for %s: %v", id, maskID(ids[0]), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	data, err := attachments().get(r.Context(), a.StorageKey)
+	if err == nil {
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != a.SHA256 {
+			err = errors.New("the file does not match its recorded SHA-256")
+		}
+	}
+	if err != nil {
+		logErrorContext(r.Context(), "ATTACHMENT_STORAGE_ERROR", fmt.Sprintf("Failed to load attachment %d of %s: %v", a.ID, maskID(a.NationalID), err))
+		writeAPIError(w, http.StatusBadGateway, "storage_unavailable", "The document could not be loaded")
+		return
+	}
+
+	logErrorContext(r.Context(), "ATTACHMENT_DOWNLOAD_SUCCESS", fmt.Sprintf("%s downloaded attachment %d of %s",
+		requestUser(r.Context()).Username, a.ID, maskID(a.NationalID)))
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", a.CreatedAt, bytes.NewReader(data))
+}
+
+// apiDeleteAttachmentHandler handles DELETE
+// /people/{id}/attachments/{attachment_id}, removing the record and then
+// its file
+func apiDeleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	ids := attachmentIDs(w, r)
+	if ids == nil {
+		return
+	}
+	id := attachmentID(w, r)
+	if id == 0 {
+		return
+	}
+
+	p, err := findPerson(r.Context(), ids, "")
+	var a *store.Attachment
+	if err == nil {
+		a, err = st.DeleteAttachment(r.Context(), p.NationalID, id)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person or attachment not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "ATTACHMENT_DB_ERROR", fmt.Sprintf("Failed to delete attachment %d for %s: %v", id, maskID(ids[0]), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	removeAttachmentFiles(r.Context(), []store.Attachment{*a})
+	logErrorContext(r.Context(), "ATTACHMENT_DELETE_SUCCESS", fmt.Sprintf("%s deleted attachment %d of %s",
+		requestUser(r.Context()).Username, a.ID, maskID(a.NationalID)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeAttachmentFiles deletes the files of attachments whose records are
+// gone. Failures are logged only: the record is what makes a file
+// reachable, so a leftover file is never served.
+func removeAttachmentFiles(ctx context.Context, list []store.Attachment) {
+	storage := attachments()
+	for _, a := range list {
+		if err := storage.remove(ctx, a.StorageKey); err != nil {
+			logErrorContext(ctx, "ATTACHMENT_STORAGE_ERROR", fmt.Sprintf("Failed to remove the file of attachment %d (%s): %v", a.ID, a.StorageKey, err))
+		}
+	}
+}
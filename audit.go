@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/oklog/ulid/v2"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	auditDefaultPerPage = 50
+	auditMaxPerPage     = 500
+
+	// auditMaxInput bounds the stored input; invalid input can be any length
+	auditMaxInput = 100
+)
+
+// Channels recorded in audit_log.channel
+const (
+	auditChannelWeb      = "web"
+	auditChannelAPI      = "api"
+	auditChannelBatch    = "batch"
+	auditChannelVoice    = "voice"
+	auditChannelSMS      = "sms"
+	auditChannelWhatsApp = "whatsapp"
+	auditChannelShare    = "share"
+	auditChannelTelegram = "telegram"
+)
+
+// apiAuditEntry is the v1 JSON schema for an audit_log row
+type apiAuditEntry struct {
+	ID        int64     `json:"id"`
+	Channel   string    `json:"channel"`
+	Source    string    `json:"source"`
+	Input     string    `json:"input"`
+	Outcome   string    `json:"outcome"`
+	MatchedID string    `json:"matched_id,omitempty"`
+	Reference string    `json:"reference,omitempty"`
+	State     string    `json:"state,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiVerification is the v1 JSON schema for a past check fetched by its
+// reference: the state found then, and the person as they are now
+type apiVerification struct {
+	Reference string     `json:"reference"`
+	CheckedAt time.Time  `json:"checked_at"`
+	Channel   string     `json:"channel"`
+	State     string     `json:"state"`
+	Person    *apiPerson `json:"person,omitempty"`
+}
+
+// apiAuditList is the v1 JSON schema for a page of audit entries
+type apiAuditList struct {
+	Page    int             `json:"page"`
+	PerPage int             `json:"per_page"`
+	Total   int             `json:"total"`
+	Entries []apiAuditEntry `json:"entries"`
+}
+
+// checkedAtLayout formats the time of a check in plain-text responses
+const checkedAtLayout = "2006-01-02 15:04:05 UTC"
+
+// auditEntry builds an audit row for one lookup of input on channel.
+// Outcomes use the call result names (match, no_match, invalid, error);
+// matches get a new verification reference.
+func auditEntry(r *http.Request, channel, input, outcome, matchedID string) *store.AuditEntry {
+	source := clientIP(r)
+	switch channel {
+	case auditChannelVoice, auditChannelSMS, auditChannelWhatsApp:
+		if from := twilioCaller(r); from != "" {
+			source = maskCaller(from)
+		}
+	case auditChannelTelegram:
+		if from := telegramSender(r.Context()); from != "" {
+			source = maskCaller(from)
+		}
+	}
+	if len(input) > auditMaxInput {
+		input = strings.ToValidUTF8(input[:auditMaxInput], "")
+	}
+	e := &store.AuditEntry{
+		Channel:   channel,
+		Source:    source,
+		Input:     maskID(input),
+		Outcome:   outcome,
+		MatchedID: matchedID,
+		RequestID: requestID(r.Context()),
+		CreatedAt: time.Now().UTC(),
+	}
+	if outcome == callResultMatch {
+		e.Reference = newReference(e.CreatedAt)
+	}
+	return e
+}
+
+// newReference returns a ULID for a check made at t. ULIDs sort by time and
+// carry 80 random bits, so references cannot be guessed from one another.
+func newReference(t time.Time) string {
+	return ulid.MustNew(ulid.Timestamp(t), rand.Reader).String()
+}
+
+// matchEntry builds the audit row for a successful lookup of input that
+// found p. Handlers print its Reference and CreatedAt in the response.
+func matchEntry(r *http.Request, channel, input string, p *store.Person) *store.AuditEntry {
+	e := auditEntry(r, channel, input, callResultMatch, p.NationalID)
+	e.State = credentialState(p)
+	return e
+}
+
+// recordMatch records a successful lookup and returns its audit row
+func recordMatch(r *http.Request, channel, input string, p *store.Person) *store.AuditEntry {
+	e := matchEntry(r, channel, input, p)
+	writeAudit(r, e)
+	return e
+}
+
+// recordAudit inserts one verification attempt into audit_log. Like
+// recordCall it fails silently so the caller still gets a response.
+func recordAudit(r *http.Request, channel, input, outcome, matchedID string) {
+	writeAudit(r, auditEntry(r, channel, input, outcome, matchedID))
+}
+
+// writeAudit inserts prepared entries, logging rather than failing on
+// error, and sends their verification events to webhooks
+func writeAudit(r *http.Request, entries ...*store.AuditEntry) {
+	if err := st.RecordAudit(r.Context(), entries...); err != nil {
+		logErrorContext(r.Context(), "AUDIT_LOG_ERROR", fmt.Sprintf("Failed to record %d audit entries: %v", len(entries), err))
+	}
+	publishAudit(r.Context(), entries...)
+}
+
+// apiAuditHandler lists verification attempts, newest first. Optional
+// filters: channel, source, matched_id, outcome, request_id, since and
+// until. A source that is a phone number matches its stored hash.
+// An institution's staff see only the checks that matched its people.
+func apiAuditHandler(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := parsePagination(w, r, auditDefaultPerPage, auditMaxPerPage)
+	if !ok {
+		return
+	}
+	since, until, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	f := store.AuditFilter{
+		Channel:     q.Get("channel"),
+		Source:      callerFilter(q.Get("source")),
+		MatchedID:   q.Get("matched_id"),
+		Outcome:     q.Get("outcome"),
+		RequestID:   q.Get("request_id"),
+		Since:       since,
+		Until:       until,
+		Institution: staffInstitution(r.Context()),
+	}
+
+	entries, total, err := st.ListAudit(r.Context(), f, perPage, (page-1)*perPage)
+	if err != nil {
+		logErrorContext(r.Context(), "AUDIT_DB_ERROR", fmt.Sprintf("Failed to list audit log: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	result := apiAuditList{Page: page, PerPage: perPage, Total: total, Entries: make([]apiAuditEntry, len(entries))}
+	for i := range entries {
+		result.Entries[i] = toAPIAuditEntry(&entries[i])
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func toAPIAuditEntry(e *store.AuditEntry) apiAuditEntry {
+	return apiAuditEntry{
+		ID:        e.ID,
+		Channel:   e.Channel,
+		Source:    e.Source,
+		Input:     e.Input,
+		Outcome:   e.Outcome,
+		MatchedID: e.MatchedID,
+		Reference: e.Reference,
+		State:     e.State,
+		RequestID: e.RequestID,
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+// apiVerificationHandler re-fetches a past check by its reference, so a
+// verifier can cite it later. The person is omitted once deleted.
+func apiVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	ref, err := ulid.ParseStrict(mux.Vars(r)["reference"])
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_reference", "reference must be a 26-character verification reference")
+		return
+	}
+
+	e, err := st.GetAuditByReference(r.Context(), ref.String())
+	if err == store.ErrNotFound {
+		noteLookup(r.Context(), false, 1)
+		writeAPIError(w, http.StatusNotFound, "not_found", "Verification not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "AUDIT_DB_ERROR", fmt.Sprintf("Failed to load verification %s: %v", ref, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	result := apiVerification{Reference: e.Reference, CheckedAt: e.CreatedAt, Channel: e.Channel, State: e.State}
+	p, err := st.GetByNationalID(r.Context(), e.MatchedID)
+	if err != nil && err != store.ErrNotFound {
+		logErrorContext(r.Context(), "AUDIT_DB_ERROR", fmt.Sprintf("Failed to load person for verification %s: %v", ref, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	if p != nil && !restricted(r, p) && inScope(r.Context(), p) {
+		result.Person = toAPIPerson(r, p)
+	}
+	noteLookup(r.Context(), true, 0)
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// adminTokenUser is who a request made with ADMIN_TOKEN acts as. It has no
+// users row; the token is for bootstrapping and break-glass access.
+var adminTokenUser = &store.User{Username: "ADMIN_TOKEN", Role: store.RoleAdmin}
+
+type userCtxKey struct{}
+
+// requestUser returns the user a request to an admin endpoint was
+// authenticated as, or nil outside requireRole
+func requestUser(ctx context.Context) *store.User {
+	u, _ := ctx.Value(userCtxKey{}).(*store.User)
+	return u
+}
+
+// withUser returns ctx authenticated as u, who the changes made to people
+// with it are recorded as made by
+func withUser(ctx context.Context, u *store.User) context.Context {
+	return store.WithActor(context.WithValue(ctx, userCtxKey{}, u), u.Username)
+}
+
+// hasRole reports whether a user with role may act as want; each role
+// includes the less privileged ones
+func hasRole(role, want string) bool {
+	return slices.Index(store.Roles, role) >= slices.Index(store.Roles, want)
+}
+
+// authenticateUser returns the user a bearer token belongs to, or nil when
+// no user or ADMIN_TOKEN matches
+func authenticateUser(ctx context.Context, token string) (*store.User, error) {
+	if token == "" {
+		return nil, nil
+	}
+	if cfg.AdminToken != "" && validAdminToken(token) {
+		return adminTokenUser, nil
+	}
+	u, err := st.FindUserByToken(ctx, hashToken(token))
+	if err == store.ErrNotFound {
+		return nil, nil
+	}
+	return u, err
+}
+
+// requireRole protects an admin API handler: the bearer token must belong
+// to a user with at least role, or be ADMIN_TOKEN
+func requireRole(role string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token, _ := bearerToken(r)
+			u, err := authenticateUser(r.Context(), token)
+			if err != nil {
+				logErrorContext(r.Context(), "AUTH_DB_ERROR", fmt.Sprintf("Failed to look up user token: %v", err))
+				writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+				return
+			}
+			if u == nil {
+				logErrorContext(r.Context(), "AUTH_FAILED", "Invalid or missing admin token for "+r.URL.Path+" from "+clientIP(r))
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeAPIError(w, http.StatusUnauthorized, "unauthorized", "A valid bearer token is required")
+				return
+			}
+			if !hasRole(u.Role, role) {
+				logErrorContext(r.Context(), "AUTH_FORBIDDEN", fmt.Sprintf("User %s (%s) denied %s %s", u.Username, u.Role, r.Method, r.URL.Path))
+				writeAPIError(w, http.StatusForbidden, "forbidden", "This endpoint requires the "+role+" role")
+				return
+			}
+			next(w, r.WithContext(withUser(r.Context(), u)))
+		}
+	}
+}
+
+// requireRolePage protects an HTML admin page like requireRole. Browsers
+// log in at /admin/login and send a session cookie; scripts can still send
+// a bearer token, or HTTP basic auth with the token as the password (any
+// user name). Browsers without credentials are sent to the login form, and
+// with TWO_FACTOR_REQUIRED, users without two-factor login to set it up
+// unless they signed in through the OIDC provider.
+func requireRolePage(role string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			u, method, err := sessionUser(r)
+			if u != nil && cfg.TwoFactorRequired && !u.TOTPEnabled && method != store.SessionOIDC && r.URL.Path != "/admin/2fa" {
+				http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+				return
+			}
+			if err == nil && u == nil {
+				token, ok := bearerToken(r)
+				if !ok {
+					_, token, ok = r.BasicAuth()
+				}
+				if !ok {
+					http.Redirect(w, r, "/admin/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+					return
+				}
+				u, err = authenticateUser(r.Context(), token)
+			}
+			if err != nil {
+				logErrorContext(r.Context(), "AUTH_DB_ERROR", fmt.Sprintf("Failed to look up user: %v", err))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if u == nil {
+				logErrorContext(r.Context(), "AUTH_FAILED", "Invalid admin credentials for "+r.URL.Path+" from "+clientIP(r))
+				http.Error(w, "Admin credentials are required", http.StatusUnauthorized)
+				return
+			}
+			if !hasRole(u.Role, role) {
+				logErrorContext(r.Context(), "AUTH_FORBIDDEN", fmt.Sprintf("User %s (%s) denied %s %s", u.Username, u.Role, r.Method, r.URL.Path))
+				http.Error(w, "This page requires the "+role+" role", http.StatusForbidden)
+				return
+			}
+			next(w, r.WithContext(withUser(r.Context(), u)))
+		}
+	}
+}
+
+// validAdminToken compares token with ADMIN_TOKEN in constant time
+func validAdminToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
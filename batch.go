@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// maxBatchBodyBytes bounds the size of a batch request body
+const maxBatchBodyBytes = 1 << 20
+
+// apiBatchItem is the per-ID result of a batch verification
+type apiBatchItem struct {
+	ID        string     `json:"id"`
+	Verified  bool       `json:"verified"`
+	Person    *apiPerson `json:"person,omitempty"`
+	Reference string     `json:"reference,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Message   string     `json:"message,omitempty"`
+}
+
+// apiBatchResult is the v1 JSON schema for a batch verification
+type apiBatchResult struct {
+	Results []apiBatchItem `json:"results"`
+}
+
+func apiBatchVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)
+
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		logErrorContext(r.Context(), "API_BATCH_INVALID_BODY", fmt.Sprintf("Failed to decode batch body: %v", err))
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "Body must be a JSON array of IDs")
+		return
+	}
+	if len(ids) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "missing_id", "At least one ID is required")
+		return
+	}
+	if len(ids) > cfg.BatchMaxIDs {
+		logErrorContext(r.Context(), "API_BATCH_TOO_LARGE", fmt.Sprintf("Batch of %d IDs exceeds limit of %d", len(ids), cfg.BatchMaxIDs))
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "batch_too_large",
+			fmt.Sprintf("A batch may contain at most %d IDs", cfg.BatchMaxIDs))
+		return
+	}
+
+	idType, err := idTypeParam(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id_type", err.Error())
+		return
+	}
+
+	// Only well-formed IDs are sent to the database, each with its other
+	// NIC formats
+	var lookup []string
+	candidates := make([][]string, len(ids))
+	nicErrs := make([]error, len(ids))
+	for i, id := range ids {
+		if !validID(id) {
+			continue
+		}
+		candidates[i], nicErrs[i] = lookupIDs(id, idType)
+		lookup = append(lookup, candidates[i]...)
+	}
+
+	fail := func(err error) {
+		if breakerRefused(w, err) {
+			denyBusyAPI(w, r)
+			return
+		}
+		logErrorContext(r.Context(), "API_BATCH_DB_ERROR", fmt.Sprintf("Database error for batch of %d IDs: %v", len(lookup), err))
+		audit := make([]*store.AuditEntry, len(lookup))
+		for i, id := range lookup {
+			audit[i] = auditEntry(r, auditChannelBatch, id, callResultError, "")
+		}
+		writeAudit(r, audit...)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+	}
+
+	rows, err := st.GetMany(r.Context(), lookup)
+	if err != nil {
+		fail(err)
+		return
+	}
+	found := make(map[string]*store.Person, len(rows))
+	for _, p := range rows {
+		if inScope(r.Context(), p) {
+			found[strings.ToUpper(p.NationalID)] = p
+		}
+	}
+
+	// Record keys come from the single query above; registered identifiers
+	// are looked up one ID at a time
+	people := make([]*store.Person, len(ids))
+	for i := range ids {
+		if candidates[i] == nil {
+			continue
+		}
+		p, err := batchResolve(r.Context(), found, candidates[i], idType)
+		if err != nil && err != store.ErrNotFound {
+			fail(err)
+			return
+		}
+		if p != nil && !inScope(r.Context(), p) {
+			p = nil
+		}
+		people[i] = p
+	}
+
+	results := make([]apiBatchItem, len(ids))
+	audit := make([]*store.AuditEntry, len(ids))
+	matched, trailingMisses := 0, 0
+	partner := partnerRequest(r)
+	for i, id := range ids {
+		item := apiBatchItem{ID: id}
+		if !validID(id) {
+			item.Error = "invalid_id"
+			audit[i] = auditEntry(r, auditChannelBatch, id, callResultInvalid, "")
+		} else if nicErrs[i] != nil {
+			item.Error = "invalid_nic"
+			item.Message = nicErrs[i].Error()
+			audit[i] = auditEntry(r, auditChannelBatch, id, callResultInvalid, "")
+		} else if p := people[i]; p != nil && !p.PublicLookup && !partner {
+			item.Error = "registrar_only"
+			item.Message = registrarOnlyNotice
+			audit[i] = auditEntry(r, auditChannelBatch, id, callResultRestricted, p.NationalID)
+			matched++
+			trailingMisses = 0
+		} else if p != nil {
+			item.Verified = !p.Revoked
+			item.Person = toAPIPerson(r, p)
+			audit[i] = matchEntry(r, auditChannelBatch, id, p)
+			item.Reference, item.CheckedAt = audit[i].Reference, &audit[i].CreatedAt
+			matched++
+			trailingMisses = 0
+		} else {
+			item.Error = "not_found"
+			audit[i] = auditEntry(r, auditChannelBatch, id, callResultNoMatch, "")
+			trailingMisses++
+		}
+		results[i] = item
+	}
+	// Only the misses after the last match are consecutive
+	noteLookup(r.Context(), matched > 0, trailingMisses)
+	writeAudit(r, audit...)
+
+	logErrorContext(r.Context(), "API_BATCH_SUCCESS", fmt.Sprintf("Batch verified %d of %d IDs", matched, len(ids)))
+	writeJSON(w, http.StatusOK, apiBatchResult{Results: results})
+}
+
+// batchResolve finds the person for one batch ID among the record keys in
+// found and the registered identifiers, in the order findPerson uses
+func batchResolve(ctx context.Context, found map[string]*store.Person, ids []string, idType string) (*store.Person, error) {
+	if keyFirst(idType) {
+		if p := firstFound(found, ids); p != nil {
+			return p, nil
+		}
+		return findByIdentifier(ctx, ids, idType)
+	}
+	if p, err := findByIdentifier(ctx, ids, idType); err != store.ErrNotFound {
+		return p, err
+	}
+	if p := firstFound(found, ids); p != nil {
+		return p, nil
+	}
+	return nil, store.ErrNotFound
+}
+
+// firstFound returns the person for the first of ids present in found,
+// which is keyed by upper-cased national ID
+func firstFound(found map[string]*store.Person, ids []string) *store.Person {
+	for _, id := range ids {
+		if p, ok := found[strings.ToUpper(id)]; ok {
+			return p
+		}
+	}
+	return nil
+}
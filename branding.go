@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// branding is the institution shown on the HTML pages. Defaults come from
+// BRAND_* in the environment; each field can be overridden at runtime with
+// a "branding.*" row in the settings table. Empty fields keep the plain
+// built-in look.
+type branding struct {
+	Name    string `json:"name"`
+	LogoURL string `json:"logo_url"`
+	// PrimaryColor is used for the institution name and header rule,
+	// AccentColor for the verified status; both are hex colors
+	PrimaryColor string `json:"primary_color"`
+	AccentColor  string `json:"accent_color"`
+	FooterText   string `json:"footer_text"`
+}
+
+// Settings table names of the branding fields
+const (
+	settingBrandPrefix       = "branding."
+	settingBrandName         = "branding.name"
+	settingBrandLogoURL      = "branding.logo_url"
+	settingBrandPrimaryColor = "branding.primary_color"
+	settingBrandAccentColor  = "branding.accent_color"
+	settingBrandFooterText   = "branding.footer_text"
+)
+
+// brandingRefresh is how long the merged branding is cached before the
+// settings table is read again, so changes made through another instance
+// show up without a restart
+const brandingRefresh = time.Minute
+
+var hexColorRegex = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+var brandCache struct {
+	sync.Mutex
+	brand  branding
+	loaded time.Time
+}
+
+// validate rejects colors that are not hex and logo URLs that are not
+// absolute http(s) URLs, since both end up in style and src attributes
+func (b branding) validate() error {
+	for name, color := range map[string]string{"primary_color": b.PrimaryColor, "accent_color": b.AccentColor} {
+		if color != "" && !hexColorRegex.MatchString(color) {
+			return fmt.Errorf("%s must be a hex color such as #1a3c6e", name)
+		}
+	}
+	if b.LogoURL != "" {
+		u, err := url.Parse(b.LogoURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("logo_url must be an absolute http or https URL")
+		}
+	}
+	if len(b.Name) > 200 || len(b.FooterText) > 1000 {
+		return fmt.Errorf("name must be at most 200 and footer_text at most 1000 characters")
+	}
+	return nil
+}
+
+// settings returns b as settings table rows; empty fields delete the row
+func (b branding) settings() map[string]string {
+	return map[string]string{
+		settingBrandName:         b.Name,
+		settingBrandLogoURL:      b.LogoURL,
+		settingBrandPrimaryColor: b.PrimaryColor,
+		settingBrandAccentColor:  b.AccentColor,
+		settingBrandFooterText:   b.FooterText,
+	}
+}
+
+// LogoSrc and the color helpers are for the templates: the values are
+// validated before they are stored, so they are safe in attributes
+func (b branding) LogoSrc() template.URL      { return template.URL(b.LogoURL) }
+func (b branding) PrimaryStyle() template.CSS { return colorStyle(b.PrimaryColor) }
+func (b branding) AccentStyle() template.CSS  { return colorStyle(b.AccentColor) }
+func (b branding) HeaderBorder() template.CSS {
+	if b.PrimaryColor == "" {
+		return ""
+	}
+	return template.CSS("border-top: 4px solid " + b.PrimaryColor + "; padding-top: 6px;")
+}
+
+func colorStyle(color string) template.CSS {
+	if color == "" {
+		return ""
+	}
+	return template.CSS("color: " + color + ";")
+}
+
+// currentBranding returns cfg.Brand with the settings table overrides
+// applied. A failed reload keeps the last good branding.
+func currentBranding(ctx context.Context) branding {
+	brandCache.Lock()
+	defer brandCache.Unlock()
+	if !brandCache.loaded.IsZero() && time.Since(brandCache.loaded) < brandingRefresh {
+		return brandCache.brand
+	}
+	b, err := loadBranding(ctx)
+	if err != nil {
+		logErrorContext(ctx, "BRANDING_ERROR", fmt.Sprintf("Failed to load branding settings: %v", err))
+		if brandCache.loaded.IsZero() {
+			return cfg.Brand
+		}
+		b = brandCache.brand
+	}
+	brandCache.brand, brandCache.loaded = b, time.Now()
+	return b
+}
+
+// loadBranding reads the overrides from the settings table. Stored values
+// that no longer validate are ignored rather than breaking every page.
+func loadBranding(ctx context.Context) (branding, error) {
+	b := cfg.Brand
+	values, err := st.GetSettings(ctx, settingBrandPrefix)
+	if err != nil {
+		return b, err
+	}
+	merged := b
+	for name, field := range map[string]*string{
+		settingBrandName:         &merged.Name,
+		settingBrandLogoURL:      &merged.LogoURL,
+		settingBrandPrimaryColor: &merged.PrimaryColor,
+		settingBrandAccentColor:  &merged.AccentColor,
+		settingBrandFooterText:   &merged.FooterText,
+	} {
+		if v, ok := values[name]; ok {
+			*field = v
+		}
+	}
+	if err := merged.validate(); err != nil {
+		return b, fmt.Errorf("stored branding ignored: %w", err)
+	}
+	return merged, nil
+}
+
+// resetBranding makes the next page load read the settings table again
+func resetBranding() {
+	brandCache.Lock()
+	brandCache.loaded = time.Time{}
+	brandCache.Unlock()
+}
+
+// apiBrandingHandler returns the branding currently applied to the pages
+func apiBrandingHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := loadBranding(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "BRANDING_ERROR", fmt.Sprintf("Failed to load branding settings: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// apiSetBrandingHandler replaces the branding overrides with the request
+// body. Fields left empty or omitted fall back to the BRAND_* defaults.
+func apiSetBrandingHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var b branding
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil && err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "Body must be a JSON branding object")
+		return
+	}
+	if err := b.validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_branding", err.Error())
+		return
+	}
+
+	if err := st.SetSettings(r.Context(), b.settings()); err != nil {
+		logErrorContext(r.Context(), "BRANDING_ERROR", fmt.Sprintf("Failed to save branding settings: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	resetBranding()
+	logErrorContext(r.Context(), "BRANDING_SUCCESS", "Updated branding settings")
+	apiBrandingHandler(w, r)
+}
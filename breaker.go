@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// busyMsg answers lookups on the web endpoints while the database circuit
+// breaker is open
+const busyMsg = "The service is busy, please try again in a few minutes"
+
+// dbBreaker fails queries at once while the database is down; nil when
+// DB_BREAKER_THRESHOLD is 0
+var dbBreaker *store.Breaker
+
+// observeBreaker logs and exports the breaker opening and closing
+func observeBreaker(open bool) {
+	if open {
+		dbBreakerOpen.Set(1)
+		logError("DB_BREAKER_OPEN", fmt.Sprintf("Database failing, refusing queries for %s at a time", cfg.DBBreakerCooldown))
+		return
+	}
+	dbBreakerOpen.Set(0)
+	logError("DB_BREAKER_CLOSED", "Database answering again, breaker closed")
+}
+
+// withBreaker sheds requests with deny while the breaker is open, so they
+// are answered at once instead of queueing for a database connection. Once
+// the cooldown is over requests go through again and the first query is
+// the breaker's trial.
+func withBreaker(deny func(http.ResponseWriter, *http.Request)) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if dbBreaker == nil {
+				next(w, r)
+				return
+			}
+			left := dbBreaker.OpenFor()
+			if left <= 0 {
+				next(w, r)
+				return
+			}
+			busyDeniedTotal.Inc()
+			setRetryAfter(w, left)
+			deny(w, r)
+		}
+	}
+}
+
+// breakerRefused reports whether a lookup failed because the breaker
+// refused its query, as it does to every other call while the trial after
+// a cooldown is running, and if so sets Retry-After. The caller then
+// answers with its channel's busy reply, as withBreaker would have.
+func breakerRefused(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, store.ErrUnavailable) {
+		return false
+	}
+	busyDeniedTotal.Inc()
+	var left time.Duration
+	if dbBreaker != nil {
+		left = dbBreaker.OpenFor()
+	}
+	setRetryAfter(w, left)
+	return true
+}
+
+// setRetryAfter asks the client to come back in left, rounded up to a
+// whole second and at least one
+func setRetryAfter(w http.ResponseWriter, left time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(max(1, int(math.Ceil(left.Seconds())))))
+}
+
+// denyBusyWeb answers a browser or JSON client with a 503
+func denyBusyWeb(w http.ResponseWriter, r *http.Request) {
+	verifyError(w, r, busyMsg, http.StatusServiceUnavailable)
+}
+
+// denyBusyAPI answers an API client with a 503
+func denyBusyAPI(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusServiceUnavailable, "service_busy", busyMsg)
+}
+
+// denyBusyTwilio ends a call, or answers a message, with a 200 TwiML reply
+// as denyTwilioVoice does
+func denyBusyTwilio(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("MessageSid") != "" {
+		writeTwiML(w, twimlMessage{Body: textMsg("busy")})
+		return
+	}
+	lang := twilioLang(r)
+	writeTwiML(w, say(lang, twilioMsg(lang, "busy")), twimlHangup{})
+}
+
+// denyBusyTelegram answers a Telegram sender
+func denyBusyTelegram(w http.ResponseWriter, r *http.Request) {
+	writeTelegramReply(w, r, textMsg("busy"))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+func TestBreakerRefused(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"unavailable", store.ErrUnavailable, true},
+		{"wrapped", fmt.Errorf("lookup: %w", store.ErrUnavailable), true},
+		{"not found", store.ErrNotFound, false},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if got := breakerRefused(w, tt.err); got != tt.want {
+			t.Errorf("breakerRefused(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+		if retry := w.Header().Get("Retry-After"); (retry == "1") != tt.want {
+			t.Errorf("breakerRefused(%s) set Retry-After %q", tt.name, retry)
+		}
+	}
+}
+
+func TestSetRetryAfter(t *testing.T) {
+	tests := []struct {
+		left time.Duration
+		want string
+	}{
+		{0, "1"},
+		{-time.Second, "1"},
+		{300 * time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{30 * time.Second, "30"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		setRetryAfter(w, tt.left)
+		if got := w.Header().Get("Retry-After"); got != tt.want {
+			t.Errorf("setRetryAfter(%s) = %q, want %q", tt.left, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// Supported EVENTS_BROKER values
+const (
+	brokerNATS  = "nats"
+	brokerKafka = "kafka"
+)
+
+const (
+	// brokerQueueSize bounds the events waiting to be published
+	brokerQueueSize = 1000
+	// brokerBatch is how many queued events are published in one call
+	brokerBatch = 100
+	// brokerPublishTimeout bounds one call, so a broker that stopped
+	// answering holds up the queue for at most this long
+	brokerPublishTimeout = 10 * time.Second
+)
+
+// broker publishes events to the message broker; nil when EVENTS_BROKER
+// is not set
+var broker *eventBroker
+
+// brokerMessage is an event as it is published: Body is the same JSON as
+// a webhook receives, Key the national ID it is about, if any
+type brokerMessage struct {
+	ID   string
+	Type string
+	Key  string
+	Body []byte
+}
+
+// brokerPublisher sends a batch of events to one kind of broker
+type brokerPublisher interface {
+	publish(ctx context.Context, batch []brokerMessage) error
+}
+
+// eventBroker publishes events in the background. As with logWriter,
+// events wait on a bounded channel so handlers never wait for the broker.
+// Unlike webhook deliveries they are not stored, so events queued when the
+// process exits, or that the broker rejects, are lost; consumers that
+// cannot miss any should use a webhook.
+type eventBroker struct {
+	queue chan brokerMessage
+	types []string
+	pub   brokerPublisher
+}
+
+// validateBroker checks the EVENTS_* settings at startup
+func validateBroker(c config) error {
+	if c.EventsBroker == "" {
+		return nil
+	}
+	if c.EventsBroker != brokerNATS && c.EventsBroker != brokerKafka {
+		return fmt.Errorf("EVENTS_BROKER must be %s or %s", brokerNATS, brokerKafka)
+	}
+	if len(c.EventsBrokerURLs) == 0 {
+		return errors.New("EVENTS_BROKER_URL is required with EVENTS_BROKER")
+	}
+	if c.EventsTopic == "" {
+		return errors.New("EVENTS_TOPIC must not be empty")
+	}
+	for _, t := range c.EventsTypes {
+		if !slices.Contains(eventTypes, t) {
+			return fmt.Errorf("EVENTS_TYPES entry %q must be among %s", t, strings.Join(eventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// startBroker connects to the broker configured by the EVENTS_* settings
+// and starts publishing. A broker that is down does not stop startup; NATS
+// keeps trying to connect and Kafka connects on each publish.
+func startBroker(c config) *eventBroker {
+	if c.EventsBroker == "" {
+		return nil
+	}
+	b := &eventBroker{queue: make(chan brokerMessage, brokerQueueSize), types: c.EventsTypes}
+	switch c.EventsBroker {
+	case brokerNATS:
+		pub, err := newNATSPublisher(c)
+		if err != nil {
+			logError("BROKER_CONNECT_ERROR", fmt.Sprintf("Failed to set up NATS, events will not be published: %v", err))
+			return nil
+		}
+		b.pub = pub
+	case brokerKafka:
+		b.pub = newKafkaPublisher(c)
+	}
+	go b.run()
+	return b
+}
+
+// publish queues an event without blocking, dropping it if the queue is
+// full or EVENTS_TYPES leaves its type out
+func (b *eventBroker) publish(m brokerMessage) {
+	if b == nil || (len(b.types) > 0 && !slices.Contains(b.types, m.Type)) {
+		return
+	}
+	select {
+	case b.queue <- m:
+	default:
+		brokerEventsTotal.WithLabelValues("dropped").Inc()
+		logger.Warn("Dropped broker event, the queue is full", "event", m.Type, "event_id", m.ID)
+	}
+}
+
+// run publishes queued events, taking up to brokerBatch at a time
+func (b *eventBroker) run() {
+	batch := make([]brokerMessage, 0, brokerBatch)
+	for m := range b.queue {
+		batch = append(batch[:0], m)
+	fill:
+		for len(batch) < brokerBatch {
+			select {
+			case m := <-b.queue:
+				batch = append(batch, m)
+			default:
+				break fill
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), brokerPublishTimeout)
+		err := b.pub.publish(ctx, batch)
+		cancel()
+		if err != nil {
+			brokerEventsTotal.WithLabelValues("failed").Add(float64(len(batch)))
+			logError("BROKER_PUBLISH_ERROR", fmt.Sprintf("Failed to publish %d events: %v", len(batch), err))
+			continue
+		}
+		brokerEventsTotal.WithLabelValues("published").Add(float64(len(batch)))
+	}
+}
+
+// natsPublisher publishes each event on the subject EVENTS_TOPIC.<type>,
+// e.g. hogwarts_verify.person.revoked
+type natsPublisher struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func newNATSPublisher(c config) (*natsPublisher, error) {
+	opts := []nats.Option{
+		nats.Name("hogwarts_verify"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logError("BROKER_DISCONNECTED", fmt.Sprintf("Lost the NATS connection: %v", err))
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logError("BROKER_RECONNECT_SUCCESS", "Reconnected to NATS at "+nc.ConnectedUrlRedacted())
+		}),
+	}
+	if c.EventsBrokerUser != "" {
+		opts = append(opts, nats.UserInfo(c.EventsBrokerUser, c.EventsBrokerPassword))
+	}
+	conn, err := nats.Connect(strings.Join(c.EventsBrokerURLs, ","), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{conn: conn, prefix: c.EventsTopic}, nil
+}
+
+// publish sends the batch. While the connection is down NATS buffers
+// messages and sends them once it reconnects, failing only when that
+// buffer is full. Nats-Msg-Id lets JetStream streams drop duplicates.
+func (p *natsPublisher) publish(_ context.Context, batch []brokerMessage) error {
+	for _, m := range batch {
+		msg := nats.NewMsg(p.prefix + "." + m.Type)
+		msg.Header.Set("Nats-Msg-Id", m.ID)
+		msg.Data = m.Body
+		if err := p.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kafkaPublisher writes every event to the topic EVENTS_TOPIC, keyed by
+// the national ID it is about (or its type), so each person's events stay
+// in order on one partition
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func newKafkaPublisher(c config) *kafkaPublisher {
+	transport := &kafka.Transport{ClientID: "hogwarts_verify"}
+	if c.EventsBrokerUser != "" {
+		transport.SASL = plain.Mechanism{Username: c.EventsBrokerUser, Password: c.EventsBrokerPassword}
+	}
+	if c.EventsBrokerTLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return &kafkaPublisher{writer: &kafka.Writer{
+		Addr:         kafka.TCP(c.EventsBrokerURLs...),
+		Topic:        c.EventsTopic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    brokerBatch,
+		// run already batches; don't wait for more messages
+		BatchTimeout: 10 * time.Millisecond,
+		Transport:    transport,
+	}}
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, batch []brokerMessage) error {
+	msgs := make([]kafka.Message, len(batch))
+	for i, m := range batch {
+		key := m.Key
+		if key == "" {
+			key = m.Type
+		}
+		msgs[i] = kafka.Message{
+			Key:   []byte(key),
+			Value: m.Body,
+			Headers: []kafka.Header{
+				{Key: "event_id", Value: []byte(m.ID)},
+				{Key: "event_type", Value: []byte(m.Type)},
+			},
+		}
+	}
+	return p.writer.WriteMessages(ctx, msgs...)
+}
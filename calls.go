@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	callsDefaultPerPage = 50
+	callsMaxPerPage     = 500
+)
+
+// Call outcomes recorded in calls.result
+const (
+	callResultMatch    = "match"
+	callResultNoMatch  = "no_match"
+	callResultInvalid  = "invalid"
+	callResultConfirm  = "confirm"
+	callResultReenter  = "reenter"
+	callResultLanguage = "language"
+	callResultError    = "error"
+	// callResultRestricted is a match on a person who opted out of the
+	// public channels, answered with registrarOnlyNotice
+	callResultRestricted = "restricted"
+	// callResultNameMismatch is a /verify match whose ?name= did not match
+	// the person, answered like callResultNoMatch
+	callResultNameMismatch = "name_mismatch"
+	// callResultFollowUp is a voice result texted to the caller
+	callResultFollowUp = "sms_followup"
+	// callResultRepeat is a voice result read again and callResultAnother
+	// a caller asking to check another ID after a result
+	callResultRepeat  = "repeat"
+	callResultAnother = "another"
+	// Main menu choices: verification, office hours and a transfer to the
+	// registrar
+	callResultMenu        = "menu_verify"
+	callResultOfficeHours = "office_hours"
+	callResultTransfer    = "transfer"
+	// callResultOutbound is a call placed through /api/v1/calls and
+	// callResultOutboundRead its result read to the person who answered
+	callResultOutbound     = "outbound"
+	callResultOutboundRead = "outbound_read"
+	// callResultMachine is a call answered by a voicemail or fax machine,
+	// which is left a message without the result
+	callResultMachine = "machine"
+)
+
+// twilioCallStatuses maps the final CallStatus values of Twilio's status
+// callback to the result recorded for them
+var twilioCallStatuses = map[string]string{
+	"completed": "completed",
+	"busy":      "busy",
+	"failed":    "failed",
+	"no-answer": "no_answer",
+	"canceled":  "canceled",
+}
+
+// apiCall is the v1 JSON schema for a calls table row
+type apiCall struct {
+	ID        int64     `json:"id"`
+	CallSid   string    `json:"call_sid"`
+	From      string    `json:"from"`
+	Endpoint  string    `json:"endpoint"`
+	Input     string    `json:"input"`
+	Result    string    `json:"result"`
+	MatchedID string    `json:"matched_id,omitempty"`
+	Duration  int       `json:"duration,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiCallList is the v1 JSON schema for a page of calls
+type apiCallList struct {
+	Page    int       `json:"page"`
+	PerPage int       `json:"per_page"`
+	Total   int       `json:"total"`
+	Calls   []apiCall `json:"calls"`
+}
+
+// recordCall inserts one Twilio webhook interaction (voice or SMS) into the calls table.
+// Like logError it fails silently so the caller still gets a response.
+func recordCall(r *http.Request, input, result, matchedID string) {
+	// Messaging webhooks carry a MessageSid instead of a CallSid
+	sid := r.PostFormValue("CallSid")
+	if sid == "" {
+		sid = r.PostFormValue("MessageSid")
+	}
+	err := st.RecordCall(r.Context(), &store.Call{
+		CallSid:   sid,
+		From:      maskCaller(twilioCaller(r)),
+		Endpoint:  r.URL.Path,
+		Input:     maskID(input),
+		Result:    result,
+		MatchedID: matchedID,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		logErrorContext(r.Context(), "CALL_LOG_ERROR", fmt.Sprintf("Failed to record call %s: %v", sid, err))
+	}
+}
+
+// twilioStatusHandler receives the status callback Twilio sends when a
+// voice call ends and records its outcome and duration as a calls row of
+// its own. Intermediate statuses (ringing, in-progress) are acknowledged
+// but not recorded, and a repeated callback for the same call is ignored.
+func twilioStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+	sid := r.PostFormValue("CallSid")
+	result, final := twilioCallStatuses[r.PostFormValue("CallStatus")]
+	if sid == "" || !final || !underSendLimit(r, "call_status:"+sid, 1, callSessionTTL) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := callSessions.Delete(r.Context(), sid); err != nil {
+		logErrorContext(r.Context(), "CALL_SESSION_ERROR", fmt.Sprintf("Failed to delete session of call %s: %v", sid, err))
+	}
+
+	duration, _ := strconv.Atoi(r.PostFormValue("CallDuration"))
+	if duration < 0 {
+		duration = 0
+	}
+	twilioCallsTotal.WithLabelValues(result).Inc()
+	if result == "completed" {
+		twilioCallDuration.Observe(float64(duration))
+	}
+	err := st.RecordCall(r.Context(), &store.Call{
+		CallSid:   sid,
+		From:      maskCaller(twilioCaller(r)),
+		Endpoint:  r.URL.Path,
+		Result:    result,
+		Duration:  duration,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		logErrorContext(r.Context(), "CALL_LOG_ERROR", fmt.Sprintf("Failed to record status of call %s: %v", sid, err))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or plain YYYY-MM-DD dates
+func parseTimeParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// parseTimeRange reads the since and until query parameters, writing a 400
+// response and returning ok=false when either is malformed
+func parseTimeRange(w http.ResponseWriter, r *http.Request) (since, until time.Time, ok bool) {
+	q := r.URL.Query()
+	if v := q.Get("since"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_since", "since must be RFC 3339 or YYYY-MM-DD")
+			return since, until, false
+		}
+		since = t
+	}
+	if v := q.Get("until"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_until", "until must be RFC 3339 or YYYY-MM-DD")
+			return since, until, false
+		}
+		until = t
+	}
+	return since, until, true
+}
+
+// apiCallsHandler lists recorded calls, newest first. Optional filters:
+// call_sid, from (a number or its stored hash), result, since and until
+// (RFC 3339 or YYYY-MM-DD). An institution's staff see only the calls that
+// matched its people.
+func apiCallsHandler(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := parsePagination(w, r, callsDefaultPerPage, callsMaxPerPage)
+	if !ok {
+		return
+	}
+
+	since, until, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	f := store.CallFilter{CallSid: q.Get("call_sid"), From: callerFilter(q.Get("from")), Result: q.Get("result"), Since: since, Until: until}
+	f.Institution = staffInstitution(r.Context())
+
+	calls, total, err := st.ListCalls(r.Context(), f, perPage, (page-1)*perPage)
+	if err != nil {
+		logErrorContext(r.Context(), "CALLS_DB_ERROR", fmt.Sprintf("Failed to list calls: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	result := apiCallList{Page: page, PerPage: perPage, Total: total, Calls: make([]apiCall, len(calls))}
+	for i := range calls {
+		result.Calls[i] = toAPICall(&calls[i])
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func toAPICall(c *store.Call) apiCall {
+	return apiCall{
+		ID:        c.ID,
+		CallSid:   c.CallSid,
+		From:      c.From,
+		Endpoint:  c.Endpoint,
+		Input:     c.Input,
+		Result:    c.Result,
+		MatchedID: c.MatchedID,
+		Duration:  c.Duration,
+		CreatedAt: c.CreatedAt,
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// callSessionTTL is how long a call's session outlives its last webhook;
+// it is renewed on every save
+const callSessionTTL = time.Hour
+
+// callSession is what a voice call carries from one webhook to the next:
+// the caller's language, how many IDs and menu choices they have tried,
+// the ID waiting to be confirmed (or last read out) and the reference of
+// the last result. Outbound is the reference a call placed through
+// /api/v1/calls was placed to read out, and Machine is set once Twilio has
+// reported an answering machine on the call.
+type callSession struct {
+	Lang        string `json:"lang,omitempty"`
+	Attempt     int    `json:"attempt,omitempty"`
+	MenuAttempt int    `json:"menu_attempt,omitempty"`
+	Input       string `json:"input,omitempty"`
+	Reference   string `json:"reference,omitempty"`
+	Outbound    string `json:"outbound,omitempty"`
+	Machine     bool   `json:"machine,omitempty"`
+}
+
+// callSessionStore keeps call sessions by CallSid: in memory for a single
+// instance, or in Redis so a call's webhooks can reach any instance
+type callSessionStore interface {
+	// Load returns the session of sid, or nil if there is none
+	Load(ctx context.Context, sid string) (*callSession, error)
+	// Save stores the session of sid for ttl
+	Save(ctx context.Context, sid string, s *callSession, ttl time.Duration) error
+	// Delete drops the session of sid once the call is over
+	Delete(ctx context.Context, sid string) error
+}
+
+// memoryCallSessions is a process-local callSessionStore
+type memoryCallSessions struct {
+	mu       sync.Mutex
+	sessions map[string]memoryCallSession
+}
+
+type memoryCallSession struct {
+	session callSession
+	expires time.Time
+}
+
+func newMemoryCallSessions() *memoryCallSessions {
+	return &memoryCallSessions{sessions: make(map[string]memoryCallSession)}
+}
+
+func (m *memoryCallSessions) Load(ctx context.Context, sid string) (*callSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sid]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(s.expires) {
+		delete(m.sessions, sid)
+		return nil, nil
+	}
+	session := s.session
+	return &session, nil
+}
+
+func (m *memoryCallSessions) Save(ctx context.Context, sid string, s *callSession, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	// Drop expired sessions now and then so the map does not grow without bound
+	if _, ok := m.sessions[sid]; !ok && len(m.sessions) > 10000 {
+		for k, old := range m.sessions {
+			if now.After(old.expires) {
+				delete(m.sessions, k)
+			}
+		}
+	}
+	m.sessions[sid] = memoryCallSession{session: *s, expires: now.Add(ttl)}
+	return nil
+}
+
+func (m *memoryCallSessions) Delete(ctx context.Context, sid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, sid)
+	return nil
+}
+
+// redisCallSessions is a callSessionStore shared through Redis
+type redisCallSessions struct {
+	client *redis.Client
+}
+
+// redisCallSessionPrefix namespaces call sessions in a shared Redis
+const redisCallSessionPrefix = "hogwarts:call:"
+
+func (c *redisCallSessions) Load(ctx context.Context, sid string) (*callSession, error) {
+	data, err := c.client.Get(ctx, redisCallSessionPrefix+sid).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var s callSession
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (c *redisCallSessions) Save(ctx context.Context, sid string, s *callSession, ttl time.Duration) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, redisCallSessionPrefix+sid, data, ttl).Err()
+}
+
+func (c *redisCallSessions) Delete(ctx context.Context, sid string) error {
+	return c.client.Del(ctx, redisCallSessionPrefix+sid).Err()
+}
+
+// callSessions is where call sessions are kept
+var callSessions callSessionStore = newMemoryCallSessions()
+
+// callSessionContext carries the session loaded by withCallSession
+type callSessionContext struct{}
+
+// withCallSession loads the session of the call a voice webhook belongs to
+// into the request context. Webhooks without a CallSid (SMS, WhatsApp) get
+// none. A failing store is logged and the call goes on without its state,
+// as if it had just started.
+func withCallSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sid := r.PostFormValue("CallSid")
+		if sid == "" {
+			next(w, r)
+			return
+		}
+		s, err := callSessions.Load(r.Context(), sid)
+		if err != nil {
+			logErrorContext(r.Context(), "CALL_SESSION_ERROR", fmt.Sprintf("Failed to load session of call %s: %v", sid, err))
+		}
+		if s == nil {
+			s = &callSession{}
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), callSessionContext{}, s)))
+	}
+}
+
+// callState returns the session of the call r belongs to; it is empty for
+// a new call and for webhooks that are not part of a call
+func callState(r *http.Request) callSession {
+	if s, ok := r.Context().Value(callSessionContext{}).(*callSession); ok {
+		return *s
+	}
+	return callSession{}
+}
+
+// updateCallState applies change to the call's session and saves it before
+// the response goes out, so the next webhook of the call sees it
+func updateCallState(r *http.Request, change func(*callSession)) {
+	s, ok := r.Context().Value(callSessionContext{}).(*callSession)
+	if !ok {
+		return
+	}
+	change(s)
+	sid := r.PostFormValue("CallSid")
+	if err := callSessions.Save(r.Context(), sid, s, callSessionTTL); err != nil {
+		logErrorContext(r.Context(), "CALL_SESSION_ERROR", fmt.Sprintf("Failed to save session of call %s: %v", sid, err))
+	}
+}
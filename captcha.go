@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Supported CAPTCHA_PROVIDER values
+const (
+	captchaTurnstile = "turnstile"
+	captchaReCAPTCHA = "recaptcha"
+)
+
+// captchaProviders describes each provider: where tokens are checked, the
+// widget script and the form field the widget fills in
+var captchaProviders = map[string]struct {
+	VerifyURL    string
+	Script       string
+	ScriptOrigin string
+	WidgetClass  string
+	Field        string
+}{
+	captchaTurnstile: {
+		VerifyURL:    "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		Script:       "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		ScriptOrigin: "https://challenges.cloudflare.com",
+		WidgetClass:  "cf-turnstile",
+		Field:        "cf-turnstile-response",
+	},
+	captchaReCAPTCHA: {
+		VerifyURL:    "https://www.google.com/recaptcha/api/siteverify",
+		Script:       "https://www.google.com/recaptcha/api.js",
+		ScriptOrigin: "https://www.google.com https://www.gstatic.com",
+		WidgetClass:  "g-recaptcha",
+		Field:        "g-recaptcha-response",
+	},
+}
+
+// captchaHeader carries the challenge token on requests from the frontend
+const captchaHeader = "X-Captcha-Token"
+
+// Messages for a missing or rejected token and for a provider outage
+const (
+	captchaRequiredMsg    = "Please complete the challenge to continue"
+	captchaUnavailableMsg = "The challenge could not be checked, please try again later"
+)
+
+// captchaClient checks tokens with the provider; the timeout bounds how long
+// a browser waits when the provider is slow
+var captchaClient = &http.Client{Timeout: 5 * time.Second}
+
+// validateCaptcha checks the CAPTCHA_* settings at startup
+func validateCaptcha(c config) error {
+	if c.CaptchaProvider == "" {
+		return nil
+	}
+	if _, ok := captchaProviders[c.CaptchaProvider]; !ok {
+		return fmt.Errorf("CAPTCHA_PROVIDER must be %s or %s", captchaTurnstile, captchaReCAPTCHA)
+	}
+	if c.CaptchaSiteKey == "" || c.CaptchaSecretKey == "" {
+		return errors.New("CAPTCHA_SITE_KEY and CAPTCHA_SECRET_KEY are required with CAPTCHA_PROVIDER")
+	}
+	return nil
+}
+
+// requireCaptcha protects the browser-facing /verify with the configured
+// challenge. The token comes from the X-Captcha-Token header (the frontend)
+// or the widget's own form field (the challenge page). Requests made with
+// an API key or ADMIN_TOKEN skip it, as do Twilio, which never uses /verify.
+func requireCaptcha(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := captchaProviders[cfg.CaptchaProvider]
+		if !ok || partnerRequest(r) {
+			next(w, r)
+			return
+		}
+
+		token := r.Header.Get(captchaHeader)
+		if token == "" {
+			token = r.URL.Query().Get(provider.Field)
+		}
+		if token == "" {
+			captchaChecksTotal.WithLabelValues("missing").Inc()
+			writeChallenge(w, r, "", http.StatusForbidden)
+			return
+		}
+
+		passed, err := checkCaptcha(r, provider.VerifyURL, token)
+		if err != nil {
+			captchaChecksTotal.WithLabelValues("error").Inc()
+			logErrorContext(r.Context(), "CAPTCHA_ERROR", fmt.Sprintf("Failed to check %s token: %v", cfg.CaptchaProvider, err))
+			verifyError(w, r, captchaUnavailableMsg, http.StatusServiceUnavailable)
+			return
+		}
+		if !passed {
+			captchaChecksTotal.WithLabelValues("failed").Inc()
+			logErrorContext(r.Context(), "CAPTCHA_FAILED", "Rejected "+cfg.CaptchaProvider+" token from "+clientIP(r))
+			writeChallenge(w, r, captchaRequiredMsg, http.StatusForbidden)
+			return
+		}
+		captchaChecksTotal.WithLabelValues("passed").Inc()
+		next(w, r)
+	}
+}
+
+// checkCaptcha asks the provider whether token was solved by the client.
+// Both providers take the same form and answer {"success": ...}.
+func checkCaptcha(r *http.Request, defaultURL, token string) (bool, error) {
+	verifyURL := cfg.CaptchaVerifyURL
+	if verifyURL == "" {
+		verifyURL = defaultURL
+	}
+	form := url.Values{"secret": {cfg.CaptchaSecretKey}, "response": {token}, "remoteip": {clientIP(r)}}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := captchaClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("siteverify returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(nil, resp.Body, 64<<10)).Decode(&result); err != nil {
+		return false, err
+	}
+	// A wrong secret is our fault, not the client's
+	for _, code := range result.ErrorCodes {
+		if code == "invalid-input-secret" || code == "missing-input-secret" {
+			return false, errors.New("siteverify rejected CAPTCHA_SECRET_KEY")
+		}
+	}
+	return result.Success, nil
+}
+
+// writeChallenge answers a request without a valid token: JSON clients get
+// an error, browsers the challenge page, which sends them back to /verify
+// with the same parameters once it is solved
+func writeChallenge(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if wantsJSON(r) {
+		if message == "" {
+			message = captchaRequiredMsg
+		}
+		writeJSON(w, status, errorResponse{Error: message})
+		return
+	}
+
+	provider := captchaProviders[cfg.CaptchaProvider]
+	params := url.Values{}
+	for _, k := range []string{"id", "id_type", "name", "lang"} {
+		if v := r.URL.Query().Get(k); v != "" {
+			params.Set(k, v)
+		}
+	}
+	page := challengePage{
+		pageContext: newPageContext(r),
+		Script:      provider.Script,
+		WidgetClass: provider.WidgetClass,
+		SiteKey:     cfg.CaptchaSiteKey,
+		Params:      params,
+	}
+	if message != "" {
+		page.Message = pageMsg(page.Lang, message)
+	}
+	// The default policy allows no scripts or frames; the widget needs both
+	// from the provider. Browsers ignore repeated directives, so a custom
+	// CONTENT_SECURITY_POLICY with its own script-src or frame-src wins.
+	if csp := w.Header().Get("Content-Security-Policy"); csp != "" {
+		w.Header().Set("Content-Security-Policy", csp+"; script-src "+provider.ScriptOrigin+"; frame-src "+provider.ScriptOrigin)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderPage(w, status, pageChallenge, page)
+}
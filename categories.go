@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// categoryRefresh is how long the categories are cached before the table is
+// read again, so other instances pick up changes
+const categoryRefresh = time.Minute
+
+// maxCategoryLabelLength bounds a category's label and spoken labels
+const maxCategoryLabelLength = 100
+
+// categoryNamePattern is what a people.category value may look like
+var categoryNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,30}$`)
+
+// categoryCache holds the categories by name
+var categoryCache struct {
+	sync.Mutex
+	byName map[string]store.Category
+	loaded time.Time
+}
+
+// apiCategory is the v1 JSON schema for a category
+type apiCategory struct {
+	Name         string            `json:"name"`
+	Label        string            `json:"label"`
+	SpokenLabels map[string]string `json:"spoken_labels"`
+	Template     string            `json:"template"`
+	ShowCourses  bool              `json:"show_courses"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+func toAPICategory(c *store.Category) apiCategory {
+	spoken := c.SpokenLabels
+	if spoken == nil {
+		spoken = map[string]string{}
+	}
+	return apiCategory{Name: c.Name, Label: c.Label, SpokenLabels: spoken, Template: c.Template, ShowCourses: c.ShowCourses, CreatedAt: c.CreatedAt.UTC()}
+}
+
+// currentCategories returns the cached categories, reading the table again
+// after categoryRefresh. A failed reload keeps the last good ones.
+func currentCategories(ctx context.Context) map[string]store.Category {
+	categoryCache.Lock()
+	defer categoryCache.Unlock()
+	if !categoryCache.loaded.IsZero() && time.Since(categoryCache.loaded) < categoryRefresh {
+		return categoryCache.byName
+	}
+	list, err := st.ListCategories(ctx)
+	if err != nil {
+		logErrorContext(ctx, "CATEGORIES_ERROR", fmt.Sprintf("Failed to load categories: %v", err))
+		categoryCache.loaded = time.Now()
+		return categoryCache.byName
+	}
+	byName := make(map[string]store.Category, len(list))
+	for _, c := range list {
+		byName[c.Name] = c
+	}
+	categoryCache.byName, categoryCache.loaded = byName, time.Now()
+	return byName
+}
+
+// resetCategories makes the next request read the table again
+func resetCategories() {
+	categoryCache.Lock()
+	categoryCache.loaded = time.Time{}
+	categoryCache.Unlock()
+}
+
+// categoryOf returns the category called name. One missing from the table,
+// which only happens while it cannot be read, is shown by its name on the
+// staff page.
+func categoryOf(ctx context.Context, name string) store.Category {
+	if c, ok := currentCategories(ctx)[name]; ok {
+		return c
+	}
+	return store.Category{Name: name, Label: name, Template: pageStaff}
+}
+
+// validCategory reports whether c is an allowed people.category value
+func validCategory(ctx context.Context, c string) bool {
+	_, ok := currentCategories(ctx)[c]
+	return ok
+}
+
+// categoryList names the categories for error messages
+func categoryList(ctx context.Context) string {
+	var names []string
+	for name := range currentCategories(ctx) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// spokenLabel is how calls in lang say the category of c: its spoken label
+// in lang, then in English, then its label
+func spokenLabel(c store.Category, lang string) string {
+	for _, l := range []string{lang, langEnglish} {
+		if s := c.SpokenLabels[l]; s != "" {
+			return s
+		}
+	}
+	return c.Label
+}
+
+// apiCategoriesHandler lists the categories by name
+func apiCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := st.ListCategories(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "CATEGORIES_ERROR", fmt.Sprintf("Failed to list categories: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	out := make([]apiCategory, len(list))
+	for i := range list {
+		out[i] = toAPICategory(&list[i])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"categories": out})
+}
+
+// apiPutCategoryHandler adds or replaces a category: PUT /categories/{name}
+// with {"label", "spoken_labels", "template", "show_courses"}. template
+// defaults to student.html when results show courses and staff.html when
+// they show the remark.
+func apiPutCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !categoryNamePattern.MatchString(name) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_name", "name must be 1 to 30 lower-case letters, digits or underscores")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Label        string            `json:"label"`
+		SpokenLabels map[string]string `json:"spoken_labels"`
+		Template     string            `json:"template"`
+		ShowCourses  bool              `json:"show_courses"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"label": "...", "spoken_labels": {"en": "..."}, "template": "...", "show_courses": false}`)
+		return
+	}
+	c := &store.Category{Name: name, Label: strings.TrimSpace(body.Label), SpokenLabels: map[string]string{}, Template: body.Template, ShowCourses: body.ShowCourses}
+	if c.Label == "" || utf8.RuneCountInString(c.Label) > maxCategoryLabelLength {
+		writeAPIError(w, http.StatusBadRequest, "invalid_label", fmt.Sprintf("label must be 1 to %d characters", maxCategoryLabelLength))
+		return
+	}
+	for lang, label := range body.SpokenLabels {
+		label = strings.TrimSpace(label)
+		if _, ok := twilioMessages[lang]; !ok {
+			writeAPIError(w, http.StatusBadRequest, "invalid_lang", "spoken_labels are keyed by "+strings.Join(twilioMenuOrder, ", "))
+			return
+		}
+		if label == "" || utf8.RuneCountInString(label) > maxCategoryLabelLength {
+			writeAPIError(w, http.StatusBadRequest, "invalid_label", fmt.Sprintf("spoken labels must be 1 to %d characters", maxCategoryLabelLength))
+			return
+		}
+		c.SpokenLabels[lang] = label
+	}
+	if c.Template == "" {
+		c.Template = pageStaff
+		if c.ShowCourses {
+			c.Template = pageStudent
+		}
+	}
+	if !strings.HasSuffix(c.Template, ".html") || pages.Lookup(c.Template) == nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_template", "template must be an HTML template in TEMPLATES_DIR or built in, such as "+pageStudent+" or "+pageStaff)
+		return
+	}
+
+	saved, err := st.PutCategory(r.Context(), c)
+	if err != nil {
+		logErrorContext(r.Context(), "CATEGORIES_ERROR", fmt.Sprintf("Failed to save category %s: %v", name, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	resetCategories()
+	logErrorContext(r.Context(), "CATEGORY_UPDATE_SUCCESS", fmt.Sprintf("%s saved category %s", requestUser(r.Context()).Username, name))
+	out := toAPICategory(saved)
+	publishChange(r.Context(), eventCategoryUpdated, "", out)
+	writeJSON(w, http.StatusOK, out)
+}
+
+// apiDeleteCategoryHandler removes a category no one is in
+func apiDeleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	err := st.DeleteCategory(r.Context(), name)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No category with this name")
+		return
+	} else if errors.Is(err, store.ErrInUse) {
+		writeAPIError(w, http.StatusConflict, "category_in_use", "People are still in this category")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "CATEGORIES_ERROR", fmt.Sprintf("Failed to delete category %s: %v", name, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	resetCategories()
+	logErrorContext(r.Context(), "CATEGORY_DELETE_SUCCESS", fmt.Sprintf("%s deleted category %s", requestUser(r.Context()).Username, name))
+	publishChange(r.Context(), eventCategoryDeleted, "", map[string]string{"name": name})
+	w.WriteHeader(http.StatusNoContent)
+}
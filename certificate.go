@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	// certificateQRSize is the QR code's size in pixels, certificateQRWidth
+	// its width on the page in mm
+	certificateQRSize  = 256
+	certificateQRWidth = 40
+	// certificateFontName is the family CERTIFICATE_FONT is registered as
+	certificateFontName = "certificate"
+)
+
+// pdfFonts registers CERTIFICATE_FONT, when set, and returns the font
+// family and bold style to use and the translation text needs for them.
+// Core fonts only cover cp1252, so text is translated to it; a UTF-8 font
+// takes text as it is but has no bold style.
+func pdfFonts(pdf *fpdf.Fpdf) (face, bold string, tr func(string) string) {
+	if cfg.CertificateFont != "" {
+		pdf.AddUTF8Font(certificateFontName, "", cfg.CertificateFont)
+		return certificateFontName, "", func(s string) string { return s }
+	}
+	return "Helvetica", "B", pdf.UnicodeTranslatorFromDescriptor("")
+}
+
+// renderCertificate returns a one-page A4 PDF certifying the check: the
+// person's record as the verification page shows it, the reference and a
+// QR code linking back to the record. Certificates are in English; names
+// outside Latin-1 need CERTIFICATE_FONT.
+func renderCertificate(r *http.Request, p *store.Person, check *store.AuditEntry) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Certificate of verification "+check.Reference, true)
+	pdf.SetCreator("hogwarts_verify", true)
+	pdf.SetCreationDate(check.CreatedAt)
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetAutoPageBreak(true, 20)
+
+	face, bold, tr := pdfFonts(pdf)
+	pdf.AddPage()
+
+	brand := institutionBranding(r.Context(), p.InstitutionID)
+	if brand.Name != "" {
+		pdf.SetFont(face, bold, 16)
+		pdf.CellFormat(0, 10, tr(brand.Name), "", 1, "C", false, 0, "")
+	}
+	pdf.SetFont(face, bold, 22)
+	pdf.CellFormat(0, 14, tr("Certificate of Verification"), "", 1, "C", false, 0, "")
+	pdf.SetDrawColor(120, 120, 120)
+	pdf.Line(20, pdf.GetY()+2, 190, pdf.GetY()+2)
+	pdf.Ln(8)
+
+	pdf.SetFont(face, "", 11)
+	pdf.MultiCell(0, 6, tr("This certifies that the record below was verified against the registry at the time shown."), "", "L", false)
+	pdf.Ln(4)
+
+	state := credentialState(p)
+	c := categoryOf(r.Context(), p.Category)
+	rows := [][2]string{
+		{"ID", p.NationalID},
+		{"Full name", p.FullName},
+		{"Category", c.Label},
+	}
+	if d := formatDate(p.IssueDate); d != "" {
+		rows = append(rows, [2]string{"Issued", d})
+	}
+	if d := formatDate(p.ExpiryDate); d != "" {
+		rows = append(rows, [2]string{"Valid until", d})
+	}
+	if c.ShowCourses && len(p.Courses) > 0 {
+		rows = append(rows, [2]string{"Courses completed", strings.Join(p.Courses, "\n")})
+	}
+	rows = append(rows,
+		[2]string{"Status", verificationStatus(p)},
+		[2]string{"Reference", check.Reference},
+		[2]string{"Checked at", check.CreatedAt.Format(checkedAtLayout)},
+	)
+	for _, row := range rows {
+		pdf.SetFont(face, bold, 11)
+		pdf.CellFormat(50, 7, tr(row[0]), "", 0, "L", false, 0, "")
+		pdf.SetFont(face, "", 11)
+		pdf.MultiCell(0, 7, tr(row[1]), "", "L", false)
+	}
+	if state == stateRevoked {
+		pdf.Ln(2)
+		pdf.SetTextColor(204, 0, 0)
+		pdf.MultiCell(0, 7, tr(revokedNotice), "", "L", false)
+		pdf.SetTextColor(0, 0, 0)
+	}
+
+	pdf.Ln(8)
+	if png, err := qrPNG(r, p.NationalID, certificateQRSize); err == nil {
+		opts := fpdf.ImageOptions{ImageType: "PNG"}
+		pdf.RegisterImageOptionsReader("qr", opts, bytes.NewReader(png))
+		pdf.ImageOptions("qr", 20, pdf.GetY(), certificateQRWidth, certificateQRWidth, true, opts, 0, "")
+	}
+	pdf.SetFont(face, "", 9)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.MultiCell(0, 5, tr("Scan the code or visit "+verifyURL(r, p.NationalID)+" to check the current status of this record."), "", "L", false)
+	if brand.FooterText != "" {
+		pdf.Ln(2)
+		pdf.MultiCell(0, 5, tr(brand.FooterText), "", "L", false)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloader serves CERT_FILE and KEY_FILE and picks up renewed files
+// without a restart: on SIGHUP, and when either file's modification time
+// changes (checked every CERT_RELOAD_INTERVAL). A reload that fails keeps
+// the current certificate.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader loads the key pair, failing if it cannot be used
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// load reads the key pair and swaps it in
+func (cr *certReloader) load() error {
+	modTime, err := cr.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.mu.Lock()
+	cr.cert, cr.modTime = &cert, modTime
+	cr.mu.Unlock()
+	return nil
+}
+
+// filesModTime is the later modification time of the two files
+func (cr *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the files again and logs the outcome
+func (cr *certReloader) reload(reason string) {
+	if err := cr.load(); err != nil {
+		logError("CERT_RELOAD_ERROR", fmt.Sprintf("Failed to reload certificate (%s), keeping the current one: %v", reason, err))
+		return
+	}
+	logError("CERT_RELOAD_SUCCESS", fmt.Sprintf("Reloaded certificate from %s (%s)", cr.certFile, reason))
+}
+
+// watch reloads on SIGHUP and when the files change. It runs until the
+// process exits.
+func (cr *certReloader) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	// tried is the modification time of the last files tried, so a bad
+	// pair is reported once rather than on every tick. A pair caught
+	// half-written is retried once the second file lands and changes it.
+	cr.mu.RLock()
+	tried := cr.modTime
+	cr.mu.RUnlock()
+	for {
+		select {
+		case <-hup:
+			cr.reload("SIGHUP")
+		case <-tick:
+			modTime, err := cr.filesModTime()
+			if err == nil && !modTime.Equal(tried) {
+				tried = modTime
+				cr.reload("files changed")
+			}
+		}
+	}
+}
+
+// GetCertificate is the tls.Config hook, returning the current certificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
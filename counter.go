@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// counterStore counts events per key in fixed time windows. It backs the
+// enumeration lockout: in memory for a single instance, or in Redis so every
+// instance behind the load balancer shares the same counts.
+type counterStore interface {
+	// Add adds n to key's count in the current window, starting a new window
+	// if there is none, and returns the new count
+	Add(ctx context.Context, key string, n int64, window time.Duration) (int64, error)
+	// Get returns key's count and how long its window has left (0, 0 if none)
+	Get(ctx context.Context, key string) (int64, time.Duration, error)
+	// Reset deletes key's count
+	Reset(ctx context.Context, key string) error
+}
+
+// memoryCounter is a process-local counterStore
+type memoryCounter struct {
+	mu      sync.Mutex
+	windows map[string]counterWindow
+}
+
+type counterWindow struct {
+	count   int64
+	expires time.Time
+}
+
+func newMemoryCounter() *memoryCounter {
+	return &memoryCounter{windows: make(map[string]counterWindow)}
+}
+
+func (m *memoryCounter) Add(ctx context.Context, key string, n int64, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	w, ok := m.windows[key]
+	if !ok || now.After(w.expires) {
+		w = counterWindow{expires: now.Add(window)}
+		// Drop expired windows now and then so the map does not grow without bound
+		if len(m.windows) > 10000 {
+			for k, old := range m.windows {
+				if now.After(old.expires) {
+					delete(m.windows, k)
+				}
+			}
+		}
+	}
+	w.count += n
+	m.windows[key] = w
+	return w.count, nil
+}
+
+func (m *memoryCounter) Get(ctx context.Context, key string) (int64, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.windows[key]
+	if !ok {
+		return 0, 0, nil
+	}
+	left := time.Until(w.expires)
+	if left <= 0 {
+		delete(m.windows, key)
+		return 0, 0, nil
+	}
+	return w.count, left, nil
+}
+
+func (m *memoryCounter) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.windows, key)
+	return nil
+}
+
+// redisCounter is a counterStore shared through Redis
+type redisCounter struct {
+	client *redis.Client
+}
+
+// redisCounterPrefix namespaces counters in a shared Redis
+const redisCounterPrefix = "hogwarts:count:"
+
+func (c *redisCounter) Add(ctx context.Context, key string, n int64, window time.Duration) (int64, error) {
+	key = redisCounterPrefix + key
+	count, err := c.client.IncrBy(ctx, key, n).Result()
+	if err != nil {
+		return 0, err
+	}
+	// The first increment starts the window; later ones must not extend it
+	if count == n {
+		if err := c.client.PExpire(ctx, key, window).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (c *redisCounter) Get(ctx context.Context, key string) (int64, time.Duration, error) {
+	key = redisCounterPrefix + key
+	pipe := c.client.Pipeline()
+	get := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+	count, err := get.Int64()
+	if err == redis.Nil {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	return count, ttl.Val(), nil
+}
+
+func (c *redisCounter) Reset(ctx context.Context, key string) error {
+	return c.client.Del(ctx, redisCounterPrefix+key).Err()
+}
+
+// redisClient is the shared Redis connection, nil when REDIS_URL is unset
+var redisClient *redis.Client
+
+// counters is where lockout counts are kept
+var counters counterStore = newMemoryCounter()
+
+// openRedis connects to REDIS_URL and checks it with a ping
+func openRedis(url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// maxCourseNameLength matches courses.name
+const maxCourseNameLength = 200
+
+// apiCourse is the v1 JSON schema for a course
+type apiCourse struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiCourseList is the v1 JSON schema for GET /courses
+type apiCourseList struct {
+	Courses []apiCourse `json:"courses"`
+}
+
+func toAPICourse(c *store.Course) apiCourse {
+	return apiCourse{ID: c.ID, Name: c.Name, CreatedAt: c.CreatedAt}
+}
+
+func apiListCoursesHandler(w http.ResponseWriter, r *http.Request) {
+	courses, err := st.ListCourses(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "COURSES_DB_ERROR", fmt.Sprintf("Failed to list courses: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	list := apiCourseList{Courses: make([]apiCourse, len(courses))}
+	for i := range courses {
+		list.Courses[i] = toAPICourse(&courses[i])
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// apiCreateCourseHandler adds a course from a {"name": "..."} body
+func apiCreateCourseHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"name": "..."}`)
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > maxCourseNameLength {
+		writeAPIError(w, http.StatusBadRequest, "invalid_name",
+			fmt.Sprintf("name is required and may be at most %d characters", maxCourseNameLength))
+		return
+	}
+
+	c, err := st.CreateCourse(r.Context(), name)
+	if errors.Is(err, store.ErrDuplicate) {
+		writeAPIError(w, http.StatusConflict, "duplicate_course", "A course with this name already exists")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "COURSES_DB_ERROR", fmt.Sprintf("Failed to create course %q: %v", name, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "COURSE_CREATE_SUCCESS", fmt.Sprintf("Created course %d: %s", c.ID, c.Name))
+	writeJSON(w, http.StatusCreated, toAPICourse(c))
+}
+
+// apiPersonCourseHandler assigns (PUT) or removes (DELETE) a completed
+// course for /people/{id}/courses/{course_id}
+func apiPersonCourseHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+	courseID, err := strconv.ParseInt(vars["course_id"], 10, 64)
+	if err != nil || courseID < 1 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_course_id", "course_id must be a positive integer")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err = st.UnassignCourse(r.Context(), id, courseID)
+	} else {
+		err = st.AssignCourse(r.Context(), id, courseID)
+	}
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person, course or assignment not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "COURSES_DB_ERROR", fmt.Sprintf("Failed to update course %d for %s: %v", courseID, maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	action := "Assigned"
+	if r.Method == http.MethodDelete {
+		action = "Removed"
+	}
+	logErrorContext(r.Context(), "COURSE_ASSIGN_SUCCESS", fmt.Sprintf("%s course %d for %s", action, courseID, maskID(id)))
+	w.WriteHeader(http.StatusNoContent)
+}
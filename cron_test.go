@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded; want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	colombo := time.FixedZone("Asia/Colombo", 5*3600+30*60)
+	// A Wednesday
+	from := time.Date(2026, 3, 4, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2026, 3, 4, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2026, 3, 4, 10, 30, 0, 0, time.UTC)},
+		{"5/20 10 * * *", from, time.Date(2026, 3, 4, 10, 25, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", from, time.Date(2026, 3, 4, 13, 0, 0, 0, time.UTC)},
+		{"0,45 10 * * *", from, time.Date(2026, 3, 4, 10, 45, 0, 0, time.UTC)},
+		// The current minute has started, so it is tomorrow's
+		{"17 10 * * *", from, time.Date(2026, 3, 5, 10, 17, 0, 0, time.UTC)},
+		{"0 6 * * 1", from, time.Date(2026, 3, 9, 6, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", from, time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"30 3 1 * *", from, time.Date(2026, 4, 1, 3, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", from, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 13th or any Friday
+		{"0 12 13 * 5", from, time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)},
+		// Only the day of week restricted: any Friday in March
+		{"0 12 * 3 5", from, time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", from, time.Time{}},
+		// Hours step in the schedule's zone, whatever its offset
+		{"0 6 * * *", from.In(colombo), time.Date(2026, 3, 5, 6, 0, 0, 0, colombo)},
+		{"30 * * * *", time.Date(2026, 3, 4, 10, 17, 0, 0, colombo), time.Date(2026, 3, 4, 10, 30, 0, 0, colombo)},
+		{"0 * * * *", time.Date(2026, 3, 4, 10, 17, 0, 0, colombo), time.Date(2026, 3, 4, 11, 0, 0, 0, colombo)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q) failed: %v", tt.expr, err)
+			}
+			if got := s.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// apiDeletion is the v1 JSON schema for whether a person is deleted
+type apiDeletion struct {
+	NationalID string     `json:"national_id"`
+	Deleted    bool       `json:"deleted"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy  string     `json:"deleted_by,omitempty"`
+}
+
+func toAPIDeletion(p *store.Person) apiDeletion {
+	d := apiDeletion{NationalID: p.NationalID, Deleted: !p.DeletedAt.IsZero(), DeletedBy: p.DeletedBy}
+	if d.Deleted {
+		d.DeletedAt = &p.DeletedAt
+	}
+	return d
+}
+
+// findPersonOrDeleted is findPerson by national ID or identifier, falling
+// back to the deleted people under ids
+func findPersonOrDeleted(ctx context.Context, ids []string) (*store.Person, error) {
+	p, err := findPerson(ctx, ids, "")
+	if err != store.ErrNotFound {
+		return p, err
+	}
+	deleted, err := st.GetDeleted(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if d, ok := deleted[id]; ok && inScope(ctx, d) {
+			return d, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// apiDeletePersonHandler deletes a person: DELETE /people/{id}. The record
+// is kept, but no channel verifies it until an admin restores it.
+func apiDeletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+
+	p, err := st.DeletePerson(r.Context(), id, requestUser(r.Context()).Username)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "PERSON_DB_ERROR", fmt.Sprintf("Failed to delete %s: %v", maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	d := toAPIDeletion(p)
+	logErrorContext(r.Context(), "PERSON_DELETE_SUCCESS", fmt.Sprintf("%s deleted %s", p.DeletedBy, maskID(p.NationalID)))
+	publishEvent(r.Context(), eventPersonDeleted, p.NationalID, d)
+	writeJSON(w, http.StatusOK, d)
+}
+
+// apiRestorePersonHandler undoes a deletion: POST /people/{id}/restore
+func apiRestorePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+
+	p, err := st.RestorePerson(r.Context(), id)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No deleted person with this ID")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "PERSON_DB_ERROR", fmt.Sprintf("Failed to restore %s: %v", maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	d := toAPIDeletion(p)
+	logErrorContext(r.Context(), "PERSON_RESTORE_SUCCESS", fmt.Sprintf("%s restored %s", requestUser(r.Context()).Username, maskID(p.NationalID)))
+	publishEvent(r.Context(), eventPersonRestored, p.NationalID, d)
+	writeJSON(w, http.StatusOK, d)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// Reasons people are flagged as likely duplicates
+const (
+	duplicateNationalID = "national_id"
+	duplicateName       = "name"
+)
+
+// duplicateWordMax bounds the people compared by name for sharing a word:
+// a word more common than this, such as a frequent surname, pairs no one
+// on its own
+const duplicateWordMax = 200
+
+// duplicateGroup is a set of people that are likely the same person. Key
+// is the 12-digit NIC they share, for duplicateNationalID.
+type duplicateGroup struct {
+	Reason string
+	Key    string
+	People []*store.Person
+}
+
+// apiDuplicateGroup is the v1 JSON schema for a group of likely duplicates
+type apiDuplicateGroup struct {
+	Reason string               `json:"reason"`
+	Key    string               `json:"key,omitempty"`
+	People []apiDuplicatePerson `json:"people"`
+}
+
+// apiDuplicatePerson is a person in an apiDuplicateGroup
+type apiDuplicatePerson struct {
+	NationalID string    `json:"national_id"`
+	FullName   string    `json:"full_name"`
+	Category   string    `json:"category"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func toAPIDuplicateGroup(g *duplicateGroup) apiDuplicateGroup {
+	out := apiDuplicateGroup{Reason: g.Reason, Key: g.Key, People: make([]apiDuplicatePerson, len(g.People))}
+	for i, p := range g.People {
+		out.People[i] = apiDuplicatePerson{NationalID: p.NationalID, FullName: p.FullName, Category: p.Category, CreatedAt: p.CreatedAt.UTC()}
+	}
+	return out
+}
+
+// findDuplicates flags the people, deleted ones aside, that are likely
+// stored twice: records whose national ID, or a registered NIC identifier,
+// is the same NIC in either format, and records with near-identical names.
+// People already grouped by NIC are not grouped again by name. A non-empty
+// institution compares only its people.
+func findDuplicates(ctx context.Context, institution string) ([]duplicateGroup, error) {
+	var people []*store.Person
+	if err := st.ExportPeople(ctx, store.PersonFilter{Institution: institution}, func(p *store.Person) error {
+		people = append(people, p)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	idents, err := st.ListIdentifiersOfType(ctx, store.IdentifierNIC)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(people))
+	for i, p := range people {
+		index[strings.ToUpper(p.NationalID)] = i
+	}
+	byNIC := map[string][]int{}
+	add := func(key string, i int) {
+		for _, j := range byNIC[key] {
+			if j == i {
+				return
+			}
+		}
+		byNIC[key] = append(byNIC[key], i)
+	}
+	for i, p := range people {
+		add(nicKey(p.NationalID), i)
+	}
+	for _, ident := range idents {
+		if i, ok := index[strings.ToUpper(ident.NationalID)]; ok {
+			add(nicKey(ident.Value), i)
+		}
+	}
+
+	var groups []duplicateGroup
+	nicGroup := make([]int, len(people))
+	for i := range nicGroup {
+		nicGroup[i] = -1
+	}
+	for key, members := range byNIC {
+		if len(members) < 2 {
+			continue
+		}
+		g := duplicateGroup{Reason: duplicateNationalID, Key: key}
+		for _, i := range members {
+			nicGroup[i] = len(groups)
+			g.People = append(g.People, people[i])
+		}
+		groups = append(groups, g)
+	}
+
+	// People are compared by name when their keys share a word
+	parent := make([]int, len(people))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	keys := make([]string, len(people))
+	byWord := map[string][]int{}
+	for i, p := range people {
+		keys[i] = store.NameKey(p.FullName)
+		for _, w := range dedupeIDs(strings.Fields(keys[i])) {
+			byWord[w] = append(byWord[w], i)
+		}
+	}
+	for _, members := range byWord {
+		if len(members) > duplicateWordMax {
+			continue
+		}
+		for a := 0; a < len(members); a++ {
+			for b := a + 1; b < len(members); b++ {
+				i, j := members[a], members[b]
+				if nicGroup[i] != -1 && nicGroup[i] == nicGroup[j] {
+					continue
+				}
+				if root(i) != root(j) && store.SimilarNameKeys(keys[i], keys[j]) {
+					parent[root(i)] = root(j)
+				}
+			}
+		}
+	}
+	byRoot := map[int][]*store.Person{}
+	for i := range people {
+		byRoot[root(i)] = append(byRoot[root(i)], people[i])
+	}
+	for _, members := range byRoot {
+		if len(members) > 1 {
+			groups = append(groups, duplicateGroup{Reason: duplicateName, People: members})
+		}
+	}
+
+	for _, g := range groups {
+		sort.Slice(g.People, func(i, j int) bool { return g.People[i].NationalID < g.People[j].NationalID })
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Reason != groups[j].Reason {
+			return groups[i].Reason == duplicateNationalID
+		}
+		return groups[i].People[0].NationalID < groups[j].People[0].NationalID
+	})
+	return groups, nil
+}
+
+// findDuplicatesJob reports how many groups of likely duplicates there are,
+// for the admins to review with GET /api/v1/duplicates
+func findDuplicatesJob(ctx context.Context, at time.Time) (string, error) {
+	groups, err := findDuplicates(ctx, "")
+	if err != nil || len(groups) == 0 {
+		return "", err
+	}
+	byNIC := 0
+	for _, g := range groups {
+		if g.Reason == duplicateNationalID {
+			byNIC++
+		}
+	}
+	return fmt.Sprintf("found %d groups of likely duplicate people, %d by national ID and %d by name", len(groups), byNIC, len(groups)-byNIC), nil
+}
+
+// apiDuplicatesHandler lists the groups of likely duplicate people,
+// those sharing an NIC first; an institution's staff see only its people
+func apiDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := findDuplicates(r.Context(), staffInstitution(r.Context()))
+	if err != nil {
+		logErrorContext(r.Context(), "DUPLICATES_DB_ERROR", fmt.Sprintf("Failed to look for duplicate people: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	list := make([]apiDuplicateGroup, len(groups))
+	for i := range groups {
+		list[i] = toAPIDuplicateGroup(&groups[i])
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"groups": list, "total": len(list)})
+}
+
+// mergeTypeNone merges without registering the merged ID as an identifier
+const mergeTypeNone = "none"
+
+// apiMerge is the v1 JSON schema for a merge; Person, the kept record, is
+// left out of the event
+type apiMerge struct {
+	NationalID string     `json:"national_id"`
+	MergedFrom string     `json:"merged_from"`
+	Person     *apiPerson `json:"person,omitempty"`
+}
+
+// apiMergePersonHandler merges a duplicate into a person: POST
+// /people/{id}/merge with {"from": "...", "type": "..."}. The duplicate's
+// identifiers, courses, photo and history move to {id}, its ID is
+// registered as an identifier of type (nic when it is one, none to skip)
+// and it is deleted for good. Two formats of the same NIC need no
+// identifier, since lookups already try both.
+func apiMergePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		From string `json:"from"`
+		Type string `json:"type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !validID(strings.TrimSpace(body.From)) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"from": "<ID>", "type": "..."}`)
+		return
+	}
+	from := strings.TrimSpace(body.From)
+	if strings.EqualFold(from, id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "A person cannot be merged into themselves")
+		return
+	}
+	idType := strings.ToLower(strings.TrimSpace(body.Type))
+	if idType == "" {
+		if n, err := parseNIC(from); err != nil || n == nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_id_type", "type is required when from is not an NIC number: one of "+identifierTypeList()+" or "+mergeTypeNone)
+			return
+		}
+		idType = store.IdentifierNIC
+	}
+	if idType != mergeTypeNone && !identifierTypes[idType] {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id_type", "type must be one of "+identifierTypeList()+" or "+mergeTypeNone)
+		return
+	}
+	if idType == mergeTypeNone || nicKey(from) == nicKey(id) {
+		idType = ""
+	}
+
+	// requirePersonInScope checked id; an institution's staff may only
+	// merge in their own people too
+	if staffInstitution(r.Context()) != "" {
+		if _, err := findPerson(r.Context(), []string{from}, ""); err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Both people must exist and not be deleted")
+			return
+		}
+	}
+
+	p, err := st.MergePeople(r.Context(), id, from, idType)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Both people must exist and not be deleted")
+		return
+	} else if errors.Is(err, store.ErrDuplicate) {
+		writeAPIError(w, http.StatusConflict, "duplicate_identifier", "The merged ID is already registered as an identifier")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "PERSON_DB_ERROR", fmt.Sprintf("Failed to merge %s into %s: %v", maskID(from), maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	m := apiMerge{NationalID: p.NationalID, MergedFrom: strings.ToUpper(normalizeNIC(from))}
+	logErrorContext(r.Context(), "PERSON_MERGE_SUCCESS", fmt.Sprintf("%s merged %s into %s", requestUser(r.Context()).Username, maskID(m.MergedFrom), maskID(p.NationalID)))
+	publishEvent(r.Context(), eventPersonMerged, p.NationalID, m)
+	m.Person = toAPIPerson(r, p)
+	writeJSON(w, http.StatusOK, m)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// Formats of an emailed verification
+const (
+	emailSummary     = "summary"
+	emailCertificate = "certificate"
+)
+
+// Messages for the email endpoint, translated on the HTML pages
+const (
+	emailDisabledMsg = "Email is not enabled"
+	emailInvalidMsg  = "Please enter a valid email address"
+	emailNotFoundMsg = "Verification not found"
+	emailExpiredMsg  = "This verification is too old to email, please verify again"
+	emailLimitMsg    = "Too many emails, please try again later"
+	emailFailedMsg   = "The email could not be sent, please try again later"
+	emailSentMsg     = "The verification result has been sent to your email address."
+)
+
+// emailRecipientWindow is the window EMAIL_MAX_PER_RECIPIENT counts over
+const emailRecipientWindow = 24 * time.Hour
+
+// emailResult is the JSON response of a sent email
+type emailResult struct {
+	Status    string `json:"status"`
+	Reference string `json:"reference"`
+	Format    string `json:"format"`
+}
+
+// emailPage is the data for the email body template: the verification
+// page's fields plus the link to check the record again
+type emailPage struct {
+	verifyPage
+	VerifyURL   string
+	Certificate bool
+}
+
+// noticePage is the data for the email_sent template
+type noticePage struct {
+	pageContext
+	Message string
+}
+
+// emailRateLimit bounds emails per client IP
+func emailRateLimit() rateLimit {
+	return rateLimit{Name: "email", Rate: cfg.RateLimitEmail, Burst: cfg.RateLimitEmailBurst}
+}
+
+// verifyEmailHandler serves POST /verify/email: it emails the result of a
+// recent check, identified by its reference, to a requester-supplied
+// address, optionally with a PDF certificate. The body is a form (the
+// verification page) or JSON: {"reference": ..., "email": ...,
+// "format": "summary"|"certificate", "lang": ...}. Since only a successful
+// check has a reference, the endpoint cannot be used to look anyone up.
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if cfg.SMTPHost == "" {
+		verifyError(w, r, emailDisabledMsg, http.StatusNotFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Reference string `json:"reference"`
+		Email     string `json:"email"`
+		Format    string `json:"format"`
+		Lang      string `json:"lang"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			verifyError(w, r, `Body must be {"reference": "...", "email": "..."}`, http.StatusBadRequest)
+			return
+		}
+	} else {
+		body.Reference, body.Email = r.PostFormValue("reference"), r.PostFormValue("email")
+		body.Format, body.Lang = r.PostFormValue("format"), r.PostFormValue("lang")
+	}
+	lang := strings.ToLower(body.Lang)
+	if pageMessages[lang] == nil {
+		lang = pageLang(r)
+	}
+	if body.Format == "" {
+		body.Format = emailSummary
+	}
+	if body.Format != emailSummary && body.Format != emailCertificate {
+		verifyError(w, r, "format must be summary or certificate", http.StatusBadRequest)
+		return
+	}
+	to, err := parseEmail(body.Email)
+	if err != nil {
+		verifyError(w, r, emailInvalidMsg, http.StatusBadRequest)
+		return
+	}
+	ref, err := ulid.ParseStrict(body.Reference)
+	if err != nil {
+		verifyError(w, r, emailNotFoundMsg, http.StatusNotFound)
+		return
+	}
+
+	check, err := st.GetAuditByReference(r.Context(), ref.String())
+	if err == store.ErrNotFound {
+		verifyError(w, r, emailNotFoundMsg, http.StatusNotFound)
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "EMAIL_DB_ERROR", fmt.Sprintf("Failed to load verification %s: %v", ref, err))
+		verifyError(w, r, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if time.Since(check.CreatedAt) > cfg.EmailReferenceMaxAge {
+		verifyError(w, r, emailExpiredMsg, http.StatusGone)
+		return
+	}
+	p, err := st.GetByNationalID(r.Context(), check.MatchedID)
+	if err == nil && !inScope(r.Context(), p) {
+		err = store.ErrNotFound
+	}
+	if err == store.ErrNotFound {
+		// Deleted since the check, or checked at another institution
+		verifyError(w, r, emailNotFoundMsg, http.StatusNotFound)
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "EMAIL_DB_ERROR", fmt.Sprintf("Failed to load person for verification %s: %v", ref, err))
+		verifyError(w, r, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if restricted(r, p) {
+		verifyError(w, r, registrarOnlyNotice, http.StatusForbidden)
+		return
+	}
+
+	// A reference or an address used too often is refused before anything
+	// is rendered. The address is counted by its hash, like caller numbers.
+	if !underSendLimit(r, "email_ref:"+check.Reference, cfg.EmailMaxPerReference, cfg.EmailReferenceMaxAge) ||
+		!underSendLimit(r, "email_to:"+piiHash(strings.ToLower(to)), cfg.EmailMaxPerRecipient, emailRecipientWindow) {
+		emailsTotal.WithLabelValues("limited").Inc()
+		logErrorContext(r.Context(), "EMAIL_LIMITED", fmt.Sprintf("Refused to email verification %s to %s again", check.Reference, maskCaller(to)))
+		verifyError(w, r, emailLimitMsg, http.StatusTooManyRequests)
+		return
+	}
+
+	m, err := verificationEmail(r, lang, to, p, check, body.Format == emailCertificate)
+	if err != nil {
+		logErrorContext(r.Context(), "EMAIL_RENDER_ERROR", fmt.Sprintf("Failed to render email for verification %s: %v", check.Reference, err))
+		verifyError(w, r, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := sendMail(r.Context(), m); err != nil {
+		emailsTotal.WithLabelValues("failed").Inc()
+		logErrorContext(r.Context(), "EMAIL_SEND_ERROR", fmt.Sprintf("Failed to email verification %s to %s: %v", check.Reference, maskCaller(to), err))
+		verifyError(w, r, emailFailedMsg, http.StatusServiceUnavailable)
+		return
+	}
+	emailsTotal.WithLabelValues("sent").Inc()
+	logErrorContext(r.Context(), "EMAIL_SEND_SUCCESS", fmt.Sprintf("Emailed the %s of verification %s to %s", body.Format, check.Reference, maskCaller(to)))
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, emailResult{Status: "sent", Reference: check.Reference, Format: body.Format})
+		return
+	}
+	page := noticePage{pageContext: newPageContext(r)}
+	page.Lang = lang
+	page.Message = pageMsg(lang, emailSentMsg)
+	renderPage(w, http.StatusOK, pageEmailSent, page)
+}
+
+// underSendLimit counts one message against key and reports whether it is
+// within limit. A failing counter store lets the message through, as
+// withRateLimit does.
+func underSendLimit(r *http.Request, key string, limit int, window time.Duration) bool {
+	if limit <= 0 {
+		return true
+	}
+	n, err := counters.Add(r.Context(), key, 1, window)
+	if err != nil {
+		logErrorContext(r.Context(), "RATE_LIMIT_ERROR", fmt.Sprintf("Send limit check failed for %s: %v", key, err))
+		return true
+	}
+	return n <= int64(limit)
+}
+
+// verificationEmail renders the email for a check in lang, attaching the
+// PDF certificate when certificate is set
+func verificationEmail(r *http.Request, lang, to string, p *store.Person, check *store.AuditEntry, certificate bool) (*mailMessage, error) {
+	page := emailPage{
+		verifyPage:  newVerifyPage(r, p.NationalID, p, check),
+		VerifyURL:   verifyURL(r, p.NationalID),
+		Certificate: certificate,
+	}
+	page.Lang = lang
+	// Mail clients block data: images and links to the API, and the
+	// recipient may not be entitled to the photo
+	page.QRCode, page.PhotoURL, page.EmailAction = "", "", ""
+
+	var html bytes.Buffer
+	if err := pages.ExecuteTemplate(&html, tmplEmailVerification, page); err != nil {
+		return nil, err
+	}
+
+	t := page.T
+	var text strings.Builder
+	if page.Brand.Name != "" {
+		text.WriteString(page.Brand.Name + "\n\n")
+	}
+	fmt.Fprintf(&text, "%s\n\n", t("email_intro"))
+	fmt.Fprintf(&text, "%s: %s\n%s: %s\n", t("id"), p.NationalID, t("full_name"), p.FullName)
+	fmt.Fprintf(&text, "%s: %s\n", t("status"), t(plainState(page.State)))
+	fmt.Fprintf(&text, "%s: %s\n%s: %s\n\n", t("reference"), page.Reference, t("checked_at"), page.CheckedAt)
+	if certificate {
+		fmt.Fprintf(&text, "%s\n\n", t("email_certificate"))
+	}
+	fmt.Fprintf(&text, "%s\n%s\n\n%s\n", t("email_recheck"), page.VerifyURL, t("email_ignore"))
+
+	m := &mailMessage{
+		To:        to,
+		Subject:   t("email_subject") + " " + check.Reference,
+		Text:      text.String(),
+		HTML:      html.String(),
+		MessageID: check.Reference + "." + newReference(time.Now()),
+	}
+	if certificate {
+		pdf, err := renderCertificate(r, p, check)
+		if err != nil {
+			return nil, err
+		}
+		m.Attachments = append(m.Attachments, mailAttachment{
+			Name:        "verification-" + check.Reference + ".pdf",
+			ContentType: "application/pdf",
+			Data:        pdf,
+		})
+	}
+	return m, nil
+}
+
+// plainState is the page text key of a credential state
+func plainState(state string) string {
+	switch state {
+	case stateRevoked:
+		return "revoked"
+	case stateExpired:
+		return "expired"
+	default:
+		return "verified"
+	}
+}
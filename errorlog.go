@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	errorsDefaultPerPage = 50
+	errorsMaxPerPage     = 500
+)
+
+// apiErrorEntry is the v1 JSON schema for an errors table row
+type apiErrorEntry struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Severity  string    `json:"severity"`
+	Category  string    `json:"category"`
+	ErrorType string    `json:"error_type"`
+	Remark    string    `json:"remark"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// apiErrorList is the v1 JSON schema for a page of logged events
+type apiErrorList struct {
+	Page    int             `json:"page"`
+	PerPage int             `json:"per_page"`
+	Total   int             `json:"total"`
+	Entries []apiErrorEntry `json:"entries"`
+}
+
+// apiPurgeResult is the v1 JSON schema for a purge of the errors table
+type apiPurgeResult struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// parseErrorFilter reads the error_type, category, severity (the minimum:
+// debug, info, warn or error), request_id, since and until query parameters, writing a
+// 400 response and returning ok=false when they are malformed
+func parseErrorFilter(w http.ResponseWriter, r *http.Request) (store.ErrorFilter, bool) {
+	since, until, ok := parseTimeRange(w, r)
+	if !ok {
+		return store.ErrorFilter{}, false
+	}
+	q := r.URL.Query()
+	f := store.ErrorFilter{
+		ErrorType: strings.ToUpper(q.Get("error_type")),
+		Category:  strings.ToLower(q.Get("category")),
+		RequestID: q.Get("request_id"),
+		Since:     errorsZone(since),
+		Until:     errorsZone(until),
+	}
+	if v := q.Get("severity"); v != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(v)); err != nil || !slices.Contains(store.Severities, level.String()) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_severity", "severity must be debug, info, warn or error")
+			return f, false
+		}
+		f.MinSeverity = level.String()
+	}
+	return f, true
+}
+
+// errorsZone converts a filter bound to the zone logErrorToDB writes
+// errors.timestamp in (London), since SQLite compares the stored text and
+// PostgreSQL drops the offset. The zero time stays zero.
+func errorsZone(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	if london, err := time.LoadLocation("Europe/London"); err == nil {
+		return t.In(london)
+	}
+	return t
+}
+
+// apiErrorsHandler lists logged events, newest first. Optional filters:
+// error_type, category, severity, request_id, since and until. format=csv streams every matching event as
+// CSV instead of returning one JSON page.
+func apiErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := parseErrorFilter(w, r)
+	if !ok {
+		return
+	}
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "", "json":
+	case "csv":
+		exportErrorsCSV(w, r, f)
+		return
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_format", "format must be 'csv' or 'json'")
+		return
+	}
+
+	page, perPage, ok := parsePagination(w, r, errorsDefaultPerPage, errorsMaxPerPage)
+	if !ok {
+		return
+	}
+	entries, total, err := st.ListErrors(r.Context(), f, perPage, (page-1)*perPage)
+	if err != nil {
+		logErrorContext(r.Context(), "ERRORS_DB_ERROR", fmt.Sprintf("Failed to list errors: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	result := apiErrorList{Page: page, PerPage: perPage, Total: total, Entries: make([]apiErrorEntry, len(entries))}
+	for i := range entries {
+		result.Entries[i] = toAPIErrorEntry(&entries[i])
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func toAPIErrorEntry(e *store.ErrorEntry) apiErrorEntry {
+	return apiErrorEntry{
+		ID:        e.ID,
+		Timestamp: e.Timestamp,
+		Severity:  e.Severity,
+		Category:  e.Category,
+		ErrorType: e.ErrorType,
+		Remark:    e.Remark,
+		RequestID: e.RequestID,
+	}
+}
+
+// exportErrorsCSV streams the events matching f. As in apiExportHandler,
+// headers go out with the first row so an up-front query failure still
+// gets a JSON error.
+func exportErrorsCSV(w http.ResponseWriter, r *http.Request, f store.ErrorFilter) {
+	count := 0
+	var cw *csv.Writer
+	start := func() {
+		extendWriteDeadline(w)
+		filename := "errors-" + time.Now().UTC().Format("20060102") + ".csv"
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw = csv.NewWriter(w)
+		cw.Write([]string{"id", "timestamp", "severity", "category", "error_type", "remark", "request_id"})
+	}
+
+	err := st.ExportErrors(r.Context(), f, func(e *store.ErrorEntry) error {
+		if cw == nil {
+			start()
+		}
+		cw.Write([]string{strconv.FormatInt(e.ID, 10), e.Timestamp.UTC().Format(time.RFC3339), e.Severity, e.Category, e.ErrorType, e.Remark, e.RequestID})
+		if count%500 == 499 {
+			cw.Flush()
+		}
+		count++
+		return nil
+	})
+	if err != nil && cw == nil {
+		logErrorContext(r.Context(), "ERRORS_DB_ERROR", fmt.Sprintf("Errors export query failed: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	if cw == nil {
+		start()
+	}
+	cw.Flush()
+	if err != nil {
+		logErrorContext(r.Context(), "ERRORS_DB_ERROR", fmt.Sprintf("Errors export aborted after %d rows: %v", count, err))
+	}
+}
+
+// apiPurgeErrorsHandler deletes the events matching the same filters as
+// apiErrorsHandler. At least one filter is required unless all=true, so a bare DELETE
+// cannot empty the table by accident.
+func apiPurgeErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := parseErrorFilter(w, r)
+	if !ok {
+		return
+	}
+	if f == (store.ErrorFilter{}) && r.URL.Query().Get("all") != "true" {
+		writeAPIError(w, http.StatusBadRequest, "missing_filter", "Give a filter, or all=true to purge every event")
+		return
+	}
+
+	n, err := st.PurgeErrors(r.Context(), f)
+	if err != nil {
+		logErrorContext(r.Context(), "ERRORS_DB_ERROR", fmt.Sprintf("Failed to purge errors: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "ERRORS_PURGE_SUCCESS", fmt.Sprintf("Purged %d logged events", n))
+	writeJSON(w, http.StatusOK, apiPurgeResult{Deleted: n})
+}
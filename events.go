@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// Event types sent to webhooks and the message broker
+const (
+	eventVerificationSucceeded  = "verification.succeeded"
+	eventVerificationFailed     = "verification.failed"
+	eventPersonRevoked          = "person.revoked"
+	eventPersonReinstated       = "person.reinstated"
+	eventPersonOptedOut         = "person.opted_out"
+	eventPersonOptedIn          = "person.opted_in"
+	eventPersonErased           = "person.erased"
+	eventPersonDeleted          = "person.deleted"
+	eventPersonRestored         = "person.restored"
+	eventPersonMerged           = "person.merged"
+	eventImportCompleted        = "import.completed"
+	eventAPIKeyCreated          = "apikey.created"
+	eventAPIKeyRevoked          = "apikey.revoked"
+	eventPartnerUpdated         = "partner.updated"
+	eventPartnerDeleted         = "partner.deleted"
+	eventUserCreated            = "user.created"
+	eventUserUpdated            = "user.updated"
+	eventUserDeleted            = "user.deleted"
+	eventWebhookCreated         = "webhook.created"
+	eventWebhookDeleted         = "webhook.deleted"
+	eventAccessRuleCreated      = "access_rule.created"
+	eventAccessRuleDeleted      = "access_rule.deleted"
+	eventMessageTemplateUpdated = "message_template.updated"
+	eventMessageTemplateDeleted = "message_template.deleted"
+	eventCategoryUpdated        = "category.updated"
+	eventCategoryDeleted        = "category.deleted"
+	eventInstitutionUpdated     = "institution.updated"
+	eventInstitutionDeleted     = "institution.deleted"
+	eventMaintenanceUpdated     = "maintenance.updated"
+)
+
+// eventTypes lists every event type, for webhook subscriptions and
+// EVENTS_TYPES
+var eventTypes = []string{
+	eventVerificationSucceeded,
+	eventVerificationFailed,
+	eventPersonRevoked,
+	eventPersonReinstated,
+	eventPersonOptedOut,
+	eventPersonOptedIn,
+	eventPersonErased,
+	eventPersonDeleted,
+	eventPersonRestored,
+	eventPersonMerged,
+	eventImportCompleted,
+	eventAPIKeyCreated,
+	eventAPIKeyRevoked,
+	eventPartnerUpdated,
+	eventPartnerDeleted,
+	eventUserCreated,
+	eventUserUpdated,
+	eventUserDeleted,
+	eventWebhookCreated,
+	eventWebhookDeleted,
+	eventAccessRuleCreated,
+	eventAccessRuleDeleted,
+	eventMessageTemplateUpdated,
+	eventMessageTemplateDeleted,
+	eventCategoryUpdated,
+	eventCategoryDeleted,
+	eventInstitutionUpdated,
+	eventInstitutionDeleted,
+	eventMaintenanceUpdated,
+}
+
+// eventPayload is the JSON body of an event, POSTed to webhooks and
+// published to the broker. ID is the same for every webhook, every attempt
+// and the broker, so consumers can drop duplicates.
+type eventPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// verificationEventData is the data of the verification events. NationalID
+// is set when the lookup found someone: on success, and when the name did
+// not match or the person opted out of public lookups.
+type verificationEventData struct {
+	Reference  string    `json:"reference,omitempty"`
+	Channel    string    `json:"channel"`
+	Outcome    string    `json:"outcome"`
+	NationalID string    `json:"national_id,omitempty"`
+	State      string    `json:"state,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// importEventData is the data of import.completed
+type importEventData struct {
+	Total      int    `json:"total"`
+	ImportedBy string `json:"imported_by"`
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+// changeEventData is the data of the admin change events: who made the
+// change and the changed object as the API returns it, without secrets.
+// Deleted objects are just their ID.
+type changeEventData struct {
+	Actor     string      `json:"actor"`
+	Object    interface{} `json:"object"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// deletedObject is the object of a deletion event
+type deletedObject struct {
+	ID int64 `json:"id"`
+}
+
+// publishChange sends an admin change event made by the request's user
+func publishChange(ctx context.Context, event, subjectID string, object interface{}) {
+	publishEvent(ctx, event, subjectID, changeEventData{
+		Actor:     requestUser(ctx).Username,
+		Object:    object,
+		RequestID: requestID(ctx),
+	})
+}
+
+// publishEvent hands an event to every webhook subscribed to its type and
+// to the message broker. subjectID is the national ID the event is about,
+// if any. It never blocks; see webhookWorker.publish and
+// eventBroker.publish.
+func publishEvent(ctx context.Context, event, subjectID string, data interface{}) {
+	if hooks == nil && broker == nil {
+		return
+	}
+	now := time.Now().UTC()
+	p := eventPayload{ID: newReference(now), Type: event, CreatedAt: now, Data: data}
+	body, err := json.Marshal(p)
+	if err != nil {
+		logErrorContext(ctx, "EVENT_ENCODE_ERROR", fmt.Sprintf("Failed to encode %s event: %v", event, err))
+		return
+	}
+	if hooks != nil {
+		hooks.publish(&store.WebhookDelivery{
+			EventID:   p.ID,
+			Event:     event,
+			SubjectID: subjectID,
+			Payload:   string(body),
+			CreatedAt: now,
+		})
+	}
+	broker.publish(brokerMessage{ID: p.ID, Type: event, Key: subjectID, Body: body})
+}
+
+// publishAudit sends the verification event of each audit entry
+func publishAudit(ctx context.Context, entries ...*store.AuditEntry) {
+	for _, e := range entries {
+		event := eventVerificationFailed
+		if e.Outcome == callResultMatch {
+			event = eventVerificationSucceeded
+		}
+		publishEvent(ctx, event, e.MatchedID, verificationEventData{
+			Reference:  e.Reference,
+			Channel:    e.Channel,
+			Outcome:    e.Outcome,
+			NationalID: e.MatchedID,
+			State:      e.State,
+			RequestID:  e.RequestID,
+			CheckedAt:  e.CreatedAt,
+		})
+	}
+}
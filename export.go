@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// exportDateLayout is the format of the from/to export filters
+const exportDateLayout = "2006-01-02"
+
+// apiExportPerson is a person row as written by the export endpoint
+type apiExportPerson struct {
+	NationalID  string `json:"national_id"`
+	FullName    string `json:"full_name"`
+	Category    string `json:"category"`
+	Institution string `json:"institution"`
+	// Remarks include private ones, since exports are for staff
+	Remarks    []apiRemark `json:"remarks"`
+	CreatedAt  time.Time   `json:"created_at"`
+	IssueDate  string      `json:"issue_date,omitempty"`
+	ExpiryDate string      `json:"expiry_date,omitempty"`
+	Revoked    bool        `json:"revoked"`
+	// RevocationReason is included because exports are admin-only
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+	PublicLookup     bool       `json:"public_lookup"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy        string     `json:"deleted_by,omitempty"`
+}
+
+// apiExportHandler streams the people table as CSV or JSON.
+// Optional filters: category, institution, from and to (YYYY-MM-DD, inclusive, on
+// created_at), status (expired or valid) and deleted (include or only;
+// deleted people are left out by default).
+func apiExportHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	format := strings.ToLower(q.Get("format"))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_format", "format must be 'csv' or 'json'")
+		return
+	}
+
+	var f store.PersonFilter
+	if category := q.Get("category"); category != "" {
+		if !validCategory(r.Context(), category) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_category", "category must be one of "+categoryList(r.Context()))
+			return
+		}
+		f.Category = category
+	}
+	if institution := q.Get("institution"); institution != "" {
+		if !validInstitution(r.Context(), institution) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_institution", "institution must be the ID of an institution")
+			return
+		}
+		f.Institution = institution
+	}
+	// An institution's staff export only its people
+	if own := staffInstitution(r.Context()); own != "" {
+		if f.Institution != "" && f.Institution != own {
+			writeAPIError(w, http.StatusForbidden, "other_institution", "You can only act within your own institution")
+			return
+		}
+		f.Institution = own
+	}
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse(exportDateLayout, from)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_from", "from must be a date in YYYY-MM-DD format")
+			return
+		}
+		f.CreatedFrom = t
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse(exportDateLayout, to)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_to", "to must be a date in YYYY-MM-DD format")
+			return
+		}
+		f.CreatedTo = t.AddDate(0, 0, 1)
+	}
+
+	switch status := q.Get("status"); status {
+	case "":
+	case store.ValidityExpired, store.ValidityCurrent:
+		f.Validity = status
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_status", "status must be 'expired' or 'valid'")
+		return
+	}
+
+	switch deleted := q.Get("deleted"); deleted {
+	case "":
+	case store.DeletedInclude, store.DeletedOnly:
+		f.Deleted = deleted
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_deleted", "deleted must be 'include' or 'only'")
+		return
+	}
+
+	// Headers are written with the first row, so a query that fails
+	// up front still gets a proper error response. After that, errors can
+	// only be logged; the client sees a truncated body.
+	count := 0
+	started := false
+	var cw *csv.Writer
+	enc := json.NewEncoder(w)
+	start := func() {
+		started = true
+		extendWriteDeadline(w)
+		filename := "people-" + time.Now().UTC().Format("20060102") + "." + format
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			cw = csv.NewWriter(w)
+			cw.Write([]string{"national_id", "full_name", "category", "remarks", "created_at", "issue_date", "expiry_date", "revoked", "revocation_reason", "public_lookup", "deleted_at", "deleted_by", "institution"})
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("["))
+		}
+	}
+
+	err := st.ExportPeople(r.Context(), f, func(p *store.Person) error {
+		if !started {
+			start()
+		}
+		var deletedAt *time.Time
+		deleted := ""
+		if !p.DeletedAt.IsZero() {
+			deletedAt, deleted = &p.DeletedAt, p.DeletedAt.Format(time.RFC3339)
+		}
+		if format == "csv" {
+			remarks, _ := json.Marshal(toAPIRemarks(p.Remarks))
+			cw.Write([]string{p.NationalID, p.FullName, p.Category, string(remarks), p.CreatedAt.Format(time.RFC3339),
+				formatDate(p.IssueDate), formatDate(p.ExpiryDate), strconv.FormatBool(p.Revoked), p.RevocationReason,
+				strconv.FormatBool(p.PublicLookup), deleted, p.DeletedBy, p.InstitutionID})
+			if count%500 == 499 {
+				cw.Flush()
+			}
+		} else {
+			if count > 0 {
+				w.Write([]byte(","))
+			}
+			enc.Encode(apiExportPerson{
+				NationalID:       p.NationalID,
+				FullName:         p.FullName,
+				Category:         p.Category,
+				Institution:      p.InstitutionID,
+				Remarks:          toAPIRemarks(p.Remarks),
+				CreatedAt:        p.CreatedAt,
+				IssueDate:        formatDate(p.IssueDate),
+				ExpiryDate:       formatDate(p.ExpiryDate),
+				Revoked:          p.Revoked,
+				RevocationReason: p.RevocationReason,
+				PublicLookup:     p.PublicLookup,
+				DeletedAt:        deletedAt,
+				DeletedBy:        p.DeletedBy,
+			})
+		}
+		count++
+		return nil
+	})
+	if err != nil && !started {
+		logErrorContext(r.Context(), "EXPORT_DB_ERROR", fmt.Sprintf("Export query failed: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	if !started {
+		start()
+	}
+	if format == "csv" {
+		cw.Flush()
+	} else {
+		w.Write([]byte("]\n"))
+	}
+	if err != nil {
+		logErrorContext(r.Context(), "EXPORT_DB_ERROR", fmt.Sprintf("Export aborted after %d rows: %v", count, err))
+		return
+	}
+
+	logErrorContext(r.Context(), "EXPORT_SUCCESS", fmt.Sprintf("Exported %d people as %s", count, format))
+}
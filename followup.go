@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// followUpDigit is the key that asks for the result by SMS; 1 and 2
+// repeat the result or check another ID
+const followUpDigit = "3"
+
+// smsFollowUpEnabled reports whether callers are offered the result by SMS
+func smsFollowUpEnabled() bool {
+	return cfg.TwilioSMSFrom != ""
+}
+
+// twilioFollowUpHandler answers 3 after a voice result by texting the
+// summary and a link to the caller's own number. The reference must pass
+// callerCheck, and each check is texted at most once.
+func twilioFollowUpHandler(w http.ResponseWriter, r *http.Request) {
+	lang := twilioLang(r)
+	if r.PostFormValue("Digits") != followUpDigit || !smsFollowUpEnabled() {
+		writeTwiML(w, twimlHangup{})
+		return
+	}
+
+	ctx := r.Context()
+	from := twilioCaller(r)
+	ref := callReference(r)
+	fail := func(result, message string) {
+		twilioFollowUpsTotal.WithLabelValues(result).Inc()
+		logErrorContext(ctx, "TWILIO_FOLLOWUP_REFUSED", fmt.Sprintf("Refused to text verification %s to %s: %s", ref, maskCaller(from), message))
+		recordCall(r, "", callResultError, "")
+		writeTwiML(w, say(lang, twilioMsg(lang, "followup_failed")), twimlHangup{})
+	}
+
+	if !strings.HasPrefix(from, "+") {
+		fail("refused", "caller has no phone number")
+		return
+	}
+	check, p, refusal, err := callerCheck(r, ref)
+	if err != nil {
+		logErrorContext(ctx, "TWILIO_DB_ERROR", fmt.Sprintf("Failed to load verification %s: %v", ref, err))
+		fail("failed", "database error")
+		return
+	}
+	if refusal != "" {
+		fail("refused", refusal)
+		return
+	}
+	// A second press, or Twilio retrying the webhook, must not text again
+	if !underSendLimit(r, "sms_followup:"+check.Reference, 1, callerCheckMaxAge) {
+		writeTwiML(w, say(lang, twilioMsg(lang, "followup_sent")), twimlHangup{})
+		return
+	}
+
+	sid, err := sendSMS(ctx, from, smsText(ctx, p, check)+"\n"+verifyURL(r, p.NationalID))
+	if err != nil {
+		twilioFollowUpsTotal.WithLabelValues("failed").Inc()
+		logErrorContext(ctx, "TWILIO_FOLLOWUP_ERROR", fmt.Sprintf("Failed to text verification %s to %s: %v", check.Reference, maskCaller(from), err))
+		recordCall(r, "", callResultError, p.NationalID)
+		writeTwiML(w, say(lang, twilioMsg(lang, "followup_failed")), twimlHangup{})
+		return
+	}
+	twilioFollowUpsTotal.WithLabelValues("sent").Inc()
+	logErrorContext(ctx, "TWILIO_FOLLOWUP_SUCCESS", fmt.Sprintf("Texted verification %s to %s as %s", check.Reference, maskCaller(from), sid))
+	recordCall(r, "", callResultFollowUp, p.NationalID)
+	writeTwiML(w, say(lang, twilioMsg(lang, "followup_sent")), twimlHangup{})
+}
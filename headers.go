@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultContentSecurityPolicy fits the HTML pages: no scripts, inline
+// style attributes and the dashboard <style> block, images from this
+// origin (QR codes, photos) plus https logos and data: URIs, and no
+// framing
+const defaultContentSecurityPolicy = "default-src 'none'; img-src 'self' https: data:; style-src 'unsafe-inline'; " +
+	"base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+
+// securityHeaders sets the headers browsers use to harden responses:
+// X-Content-Type-Options always, and Content-Security-Policy,
+// X-Frame-Options and Referrer-Policy unless set to "off". HSTS is only sent
+// over HTTPS, see hstsValue.
+func securityHeaders(c config, next http.Handler) http.Handler {
+	static := map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": c.ContentSecurityPolicy,
+		"X-Frame-Options":         c.FrameOptions,
+		"Referrer-Policy":         c.ReferrerPolicy,
+	}
+	for k, v := range static {
+		if v == "" || v == "off" {
+			delete(static, k)
+		}
+	}
+	hsts := hstsValue(c.HSTSMaxAge, c.HSTSIncludeSubdomains)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		for k, v := range static {
+			h.Set(k, v)
+		}
+		if hsts != "" && isHTTPS(r) {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hstsValue is the Strict-Transport-Security header telling browsers to
+// use HTTPS only for the next maxAge; "" when maxAge is not positive
+func hstsValue(maxAge time.Duration, includeSubdomains bool) string {
+	if maxAge <= 0 {
+		return ""
+	}
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return value
+}
+
+// isHTTPS reports whether the client connected over HTTPS: directly, or to
+// the reverse proxy when TRUST_PROXY_HEADERS is set
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return cfg.TrustProxyHeaders && r.Header.Get("X-Forwarded-Proto") == "https"
+}
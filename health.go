@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// healthCheckTimeout bounds how long the DB and Redis pings may take
+const healthCheckTimeout = 2 * time.Second
+
+// componentStatus describes the health of one dependency
+type componentStatus struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// healthResponse is the JSON body returned by /healthz
+type healthResponse struct {
+	Status     string                     `json:"status"`
+	Time       time.Time                  `json:"time"`
+	Components map[string]componentStatus `json:"components"`
+	// Maintenance is set while lookups are turned away; it does not change
+	// the status, so load balancers keep the instance in rotation
+	Maintenance bool `json:"maintenance,omitempty"`
+}
+
+// healthHandler reports service health for load balancers and Docker.
+// It returns 200 when every component is up and 503 otherwise.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	resp := healthResponse{
+		Status:      "ok",
+		Time:        time.Now().UTC(),
+		Components:  map[string]componentStatus{},
+		Maintenance: inMaintenance(ctx),
+	}
+
+	start := time.Now()
+	database := componentStatus{Status: "up"}
+	if err := st.Ping(ctx); err != nil {
+		database.Status = "down"
+		database.Error = err.Error()
+		resp.Status = "unavailable"
+	}
+	database.LatencyMS = time.Since(start).Milliseconds()
+	resp.Components["database"] = database
+
+	// Lookups fall back to the primary, so a replica outage does not make
+	// the service unavailable
+	start = time.Now()
+	if err := st.PingReplica(ctx); !errors.Is(err, store.ErrNoReplica) {
+		replica := componentStatus{Status: "up"}
+		if err != nil {
+			replica.Status = "down"
+			replica.Error = err.Error()
+		}
+		replica.LatencyMS = time.Since(start).Milliseconds()
+		resp.Components["replica"] = replica
+	}
+
+	if redisClient != nil {
+		start = time.Now()
+		cache := componentStatus{Status: "up"}
+		if err := redisClient.Ping(ctx).Err(); err != nil {
+			cache.Status = "down"
+			cache.Error = err.Error()
+			resp.Status = "unavailable"
+		}
+		cache.LatencyMS = time.Since(start).Milliseconds()
+		resp.Components["redis"] = cache
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// apiPersonChange is the v1 JSON schema for an entry in a person's history.
+// Old and New hold only the fields that changed; Old is omitted for the
+// created entry. MergedFrom is the record the entry was made on, for the
+// history of a duplicate merged into this one.
+type apiPersonChange struct {
+	ID         int64                  `json:"id"`
+	Action     string                 `json:"action"`
+	Actor      string                 `json:"actor,omitempty"`
+	MergedFrom string                 `json:"merged_from,omitempty"`
+	ChangedAt  time.Time              `json:"changed_at"`
+	Old        map[string]interface{} `json:"old,omitempty"`
+	New        map[string]interface{} `json:"new"`
+}
+
+func toAPIPersonChange(c *store.PersonChange) apiPersonChange {
+	return apiPersonChange{ID: c.ID, Action: c.Action, Actor: c.Actor, MergedFrom: c.MergedFrom, ChangedAt: c.CreatedAt.UTC(), Old: c.Old, New: c.New}
+}
+
+// recordAt rebuilds what p's tracked fields were at at by undoing, newest
+// first, the changes made after it; nil when the record did not exist yet.
+// Entries merged from a duplicate describe that record and are skipped.
+func recordAt(p *store.Person, changes []store.PersonChange, at time.Time) map[string]interface{} {
+	if p.CreatedAt.After(at) {
+		return nil
+	}
+	record := store.HistoryFields(p)
+	for i := len(changes) - 1; i >= 0 && changes[i].CreatedAt.After(at); i-- {
+		c := &changes[i]
+		if c.MergedFrom != "" {
+			continue
+		}
+		if c.Action == store.HistoryCreated {
+			return nil
+		}
+		for k := range c.New {
+			if v, ok := c.Old[k]; ok {
+				record[k] = v
+			} else {
+				delete(record, k)
+			}
+		}
+	}
+	return record
+}
+
+// apiPersonHistoryHandler lists the changes made to a person's record,
+// deleted or not, oldest first: GET /people/{id}/history. With at (RFC 3339
+// or YYYY-MM-DD) it also returns the record as it was then, null when it
+// did not exist yet.
+func apiPersonHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+	var at time.Time
+	if v := r.URL.Query().Get("at"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_at", "at must be RFC 3339 or YYYY-MM-DD")
+			return
+		}
+		at = t
+	}
+	ids, err := lookupIDs(id, "")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return
+	}
+
+	p, err := findPersonOrDeleted(r.Context(), ids)
+	var changes []store.PersonChange
+	if err == nil {
+		changes, err = st.ListPersonHistory(r.Context(), p.NationalID)
+	}
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "HISTORY_DB_ERROR", fmt.Sprintf("Failed to list history of %s: %v", maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	list := make([]apiPersonChange, len(changes))
+	for i := range changes {
+		list[i] = toAPIPersonChange(&changes[i])
+	}
+	out := map[string]interface{}{"national_id": p.NationalID, "changes": list}
+	if !at.IsZero() {
+		out["at"] = at.UTC()
+		out["record"] = recordAt(p, changes, at)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// identifierTypes are the accepted id_type values
+var identifierTypes = map[string]bool{
+	store.IdentifierNIC:           true,
+	store.IdentifierPassport:      true,
+	store.IdentifierStudentNumber: true,
+}
+
+// apiIdentifier is the v1 JSON schema for a registered identifier
+type apiIdentifier struct {
+	Type      string    `json:"type"`
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiIdentifierList is the v1 JSON schema for a person's identifiers
+type apiIdentifierList struct {
+	NationalID  string          `json:"national_id"`
+	Identifiers []apiIdentifier `json:"identifiers"`
+}
+
+func toAPIIdentifier(i *store.Identifier) apiIdentifier {
+	return apiIdentifier{Type: i.Type, Value: i.Value, CreatedAt: i.CreatedAt}
+}
+
+// idTypeParam reads the optional id_type hint; an unknown type is an error
+func idTypeParam(r *http.Request) (string, error) {
+	t := strings.ToLower(r.URL.Query().Get("id_type"))
+	if t != "" && !identifierTypes[t] {
+		return "", fmt.Errorf("id_type must be one of %s", identifierTypeList())
+	}
+	return t, nil
+}
+
+func identifierTypeList() string {
+	return strings.Join([]string{store.IdentifierNIC, store.IdentifierPassport, store.IdentifierStudentNumber}, ", ")
+}
+
+// keyFirst reports whether lookups of idType try people.national_id before
+// person_identifiers: the record key is an NIC for most people
+func keyFirst(idType string) bool {
+	return idType == "" || idType == store.IdentifierNIC
+}
+
+// findPerson returns the person stored under the first of ids that exists,
+// either as their record key or as a registered identifier of idType (any
+// type when empty). Every lookup is exact; there is no prefix matching.
+// People of other institutions than the request's are not found.
+func findPerson(ctx context.Context, ids []string, idType string) (*store.Person, error) {
+	p, err := findAnyPerson(ctx, ids, idType)
+	if err == nil && !inScope(ctx, p) {
+		return nil, store.ErrNotFound
+	}
+	return p, err
+}
+
+// findAnyPerson is findPerson in every institution
+func findAnyPerson(ctx context.Context, ids []string, idType string) (*store.Person, error) {
+	if !keyFirst(idType) {
+		if p, err := findByIdentifier(ctx, ids, idType); err != store.ErrNotFound {
+			return p, err
+		}
+	}
+	for _, id := range ids {
+		p, err := st.GetByNationalID(ctx, id)
+		if err != store.ErrNotFound {
+			return p, err
+		}
+	}
+	if keyFirst(idType) {
+		return findByIdentifier(ctx, ids, idType)
+	}
+	return nil, store.ErrNotFound
+}
+
+// findByIdentifier looks ids up in person_identifiers, where values are
+// stored upper-cased
+func findByIdentifier(ctx context.Context, ids []string, idType string) (*store.Person, error) {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strings.ToUpper(id)
+	}
+	return st.FindByIdentifier(ctx, idType, dedupeIDs(values))
+}
+
+// normalizeIdentifier validates value for idType and returns its stored
+// form: NICs in their canonical format, everything else upper-cased
+func normalizeIdentifier(idType, value string) (string, error) {
+	if !validID(value) {
+		return "", errors.New("value must be alphanumeric and at most 50 characters")
+	}
+	if idType == store.IdentifierNIC {
+		n, err := parseNIC(value)
+		if err != nil {
+			return "", err
+		}
+		if n == nil {
+			return "", errors.New("value is not an NIC number")
+		}
+	}
+	return strings.ToUpper(normalizeNIC(value)), nil
+}
+
+// apiIdentifiersHandler lists (GET) or registers (POST {"type", "value"})
+// identifiers for /people/{id}/identifiers
+func apiIdentifiersHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		ids, err := st.ListIdentifiers(r.Context(), id)
+		if err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+			return
+		} else if err != nil {
+			logErrorContext(r.Context(), "IDENTIFIERS_DB_ERROR", fmt.Sprintf("Failed to list identifiers for %s: %v", maskID(id), err))
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+		list := apiIdentifierList{NationalID: id, Identifiers: make([]apiIdentifier, len(ids))}
+		for i := range ids {
+			list.Identifiers[i] = toAPIIdentifier(&ids[i])
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"type": "...", "value": "..."}`)
+		return
+	}
+	idType := strings.ToLower(strings.TrimSpace(body.Type))
+	if !identifierTypes[idType] {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id_type", "type must be one of "+identifierTypeList())
+		return
+	}
+	value, err := normalizeIdentifier(idType, strings.TrimSpace(body.Value))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_value", err.Error())
+		return
+	}
+
+	ident, err := st.AddIdentifier(r.Context(), id, idType, value)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if errors.Is(err, store.ErrDuplicate) {
+		writeAPIError(w, http.StatusConflict, "duplicate_identifier", "This identifier is already registered")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "IDENTIFIERS_DB_ERROR", fmt.Sprintf("Failed to add %s identifier for %s: %v", idType, maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "IDENTIFIER_ADD_SUCCESS", fmt.Sprintf("Registered %s %s for %s", idType, maskID(value), maskID(ident.NationalID)))
+	writeJSON(w, http.StatusCreated, toAPIIdentifier(ident))
+}
+
+// apiRemoveIdentifierHandler handles DELETE /people/{id}/identifiers/{type}/{value}
+func apiRemoveIdentifierHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, idType := vars["id"], strings.ToLower(vars["type"])
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+	if !identifierTypes[idType] {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id_type", "type must be one of "+identifierTypeList())
+		return
+	}
+	value, err := normalizeIdentifier(idType, vars["value"])
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_value", err.Error())
+		return
+	}
+
+	err = st.RemoveIdentifier(r.Context(), id, idType, value)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Identifier not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "IDENTIFIERS_DB_ERROR", fmt.Sprintf("Failed to remove %s identifier for %s: %v", idType, maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "IDENTIFIER_REMOVE_SUCCESS", fmt.Sprintf("Removed %s %s for %s", idType, maskID(value), maskID(id)))
+	w.WriteHeader(http.StatusNoContent)
+}
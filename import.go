@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	// maxImportBytes bounds the size of an uploaded CSV file
+	maxImportBytes = 10 << 20
+	// maxImportRows bounds the number of data rows in a single import
+	maxImportRows = 10000
+)
+
+// importColumns are the required CSV header columns, in any order
+var importColumns = []string{"national_id", "full_name", "category", "remark"}
+
+// importDateColumns are optional YYYY-MM-DD columns
+var importDateColumns = []string{"issue_date", "expiry_date"}
+
+// importPublicLookupColumn is an optional true/false column; people are
+// publicly verifiable when it is missing or empty
+const importPublicLookupColumn = "public_lookup"
+
+// importInstitutionColumn is an optional column with the ID of the
+// institution each person belongs to; the deployment's own when missing or
+// empty
+const importInstitutionColumn = "institution"
+
+// The optional remark_type and remark_visibility columns describe the
+// remark column; it is a public general remark when they are missing or
+// empty
+const (
+	importRemarkTypeColumn       = "remark_type"
+	importRemarkVisibilityColumn = "remark_visibility"
+)
+
+// apiImportRow is the per-row outcome of a CSV import
+type apiImportRow struct {
+	Line       int      `json:"line"`
+	NationalID string   `json:"national_id"`
+	OK         bool     `json:"ok"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// apiImportResult is the v1 JSON schema for a CSV import report
+type apiImportResult struct {
+	Imported bool           `json:"imported"`
+	Total    int            `json:"total"`
+	Valid    int            `json:"valid"`
+	Invalid  int            `json:"invalid"`
+	Rows     []apiImportRow `json:"rows"`
+}
+
+// apiImportHandler loads people from a multipart CSV upload (field "file").
+// All rows are validated first; the import is all-or-nothing, so if any row
+// is invalid nothing is inserted and the report explains what to fix.
+func apiImportHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	if err := r.ParseMultipartForm(maxImportBytes); err != nil {
+		logErrorContext(r.Context(), "IMPORT_INVALID_FORM", fmt.Sprintf("Failed to parse multipart form: %v", err))
+		writeAPIError(w, http.StatusBadRequest, "invalid_form", "Expected a multipart upload with a 'file' field")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "missing_file", "Expected a multipart upload with a 'file' field")
+		return
+	}
+	defer file.Close()
+
+	people, report, err := parseImportCSV(r.Context(), file)
+	if err != nil {
+		logErrorContext(r.Context(), "IMPORT_INVALID_CSV", fmt.Sprintf("Failed to read CSV: %v", err))
+		writeAPIError(w, http.StatusBadRequest, "invalid_csv", err.Error())
+		return
+	}
+
+	// Reject IDs that already exist in the registry, deleted or not, in
+	// either NIC format
+	ids := make([]string, 0, len(people))
+	for _, p := range people {
+		if p != nil {
+			forms, _ := lookupIDs(p.NationalID, "")
+			ids = append(ids, forms...)
+		}
+	}
+	rows, err := st.GetMany(r.Context(), ids)
+	if err != nil {
+		logErrorContext(r.Context(), "IMPORT_DB_ERROR", fmt.Sprintf("Database error checking existing IDs: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	deletedRows, err := st.GetDeleted(r.Context(), ids)
+	if err != nil {
+		logErrorContext(r.Context(), "IMPORT_DB_ERROR", fmt.Sprintf("Database error checking deleted IDs: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	existing := make(map[string]string, len(rows))
+	for _, p := range rows {
+		existing[nicKey(p.NationalID)] = p.NationalID
+	}
+	deleted := make(map[string]string, len(deletedRows))
+	for _, p := range deletedRows {
+		deleted[nicKey(p.NationalID)] = p.NationalID
+	}
+	for i, p := range people {
+		if p == nil {
+			continue
+		}
+		if id, ok := existing[nicKey(p.NationalID)]; ok {
+			msg := "national_id already exists"
+			if !strings.EqualFold(id, p.NationalID) {
+				msg = fmt.Sprintf("national_id already exists as %s", id)
+			}
+			report.Rows[i].Errors = append(report.Rows[i].Errors, msg)
+		}
+		if id, ok := deleted[nicKey(p.NationalID)]; ok {
+			report.Rows[i].Errors = append(report.Rows[i].Errors, fmt.Sprintf("national_id %s was deleted; restore it instead", id))
+		}
+	}
+
+	for i := range report.Rows {
+		report.Rows[i].OK = len(report.Rows[i].Errors) == 0
+		if report.Rows[i].OK {
+			report.Valid++
+		} else {
+			report.Invalid++
+		}
+	}
+	if report.Invalid > 0 || report.Total == 0 {
+		logErrorContext(r.Context(), "IMPORT_REJECTED", fmt.Sprintf("Import rejected: %d of %d rows invalid", report.Invalid, report.Total))
+		writeJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	if err := st.CreateMany(r.Context(), people); errors.Is(err, store.ErrDuplicate) {
+		// Another import inserted one of these IDs since validation ran
+		logErrorContext(r.Context(), "IMPORT_CONFLICT", fmt.Sprintf("Import transaction hit a duplicate ID: %v", err))
+		writeAPIError(w, http.StatusConflict, "duplicate_id", "An ID in this file was added concurrently; no rows were inserted")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "IMPORT_DB_ERROR", fmt.Sprintf("Import transaction failed: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Import failed; no rows were inserted")
+		return
+	}
+
+	report.Imported = true
+	logErrorContext(r.Context(), "IMPORT_SUCCESS", fmt.Sprintf("Imported %d people", report.Total))
+	publishEvent(r.Context(), eventImportCompleted, "", importEventData{
+		Total:      report.Total,
+		ImportedBy: requestUser(r.Context()).Username,
+		RequestID:  requestID(r.Context()),
+	})
+	writeJSON(w, http.StatusCreated, report)
+}
+
+// parseImportCSV reads and validates every data row. The returned slice is
+// parallel to report.Rows and holds nil for rows that failed validation.
+func parseImportCSV(ctx context.Context, rd io.Reader) ([]*store.Person, *apiImportResult, error) {
+	cr := csv.NewReader(rd)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("CSV file is empty")
+	} else if err != nil {
+		return nil, nil, err
+	}
+	col := make(map[string]int)
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	for _, c := range importColumns {
+		if _, ok := col[c]; !ok {
+			return nil, nil, fmt.Errorf("missing required column %q", c)
+		}
+	}
+
+	report := &apiImportResult{Rows: []apiImportRow{}}
+	var people []*store.Person
+	seen := make(map[string]int)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if len(people) >= maxImportRows {
+			return nil, nil, fmt.Errorf("too many rows; at most %d are allowed", maxImportRows)
+		}
+		line, _ := cr.FieldPos(0)
+
+		p := &store.Person{
+			NationalID:   normalizeNIC(strings.TrimSpace(record[col["national_id"]])),
+			FullName:     strings.TrimSpace(record[col["full_name"]]),
+			Category:     strings.ToLower(strings.TrimSpace(record[col["category"]])),
+			PublicLookup: true,
+		}
+		row := apiImportRow{Line: line, NationalID: p.NationalID}
+		dates := make(map[string]time.Time)
+		for _, c := range importDateColumns {
+			i, ok := col[c]
+			if !ok || strings.TrimSpace(record[i]) == "" {
+				continue
+			}
+			t, err := time.Parse(exportDateLayout, strings.TrimSpace(record[i]))
+			if err != nil {
+				row.Errors = append(row.Errors, c+" must be a date in YYYY-MM-DD format")
+				continue
+			}
+			dates[c] = t
+		}
+		p.IssueDate, p.ExpiryDate = dates["issue_date"], dates["expiry_date"]
+		if i, ok := col[importPublicLookupColumn]; ok && strings.TrimSpace(record[i]) != "" {
+			if public, err := strconv.ParseBool(strings.TrimSpace(record[i])); err != nil {
+				row.Errors = append(row.Errors, importPublicLookupColumn+" must be true or false")
+			} else {
+				p.PublicLookup = public
+			}
+		}
+		if i, ok := col[importInstitutionColumn]; ok {
+			p.InstitutionID = strings.TrimSpace(record[i])
+			if p.InstitutionID != "" && !validInstitution(ctx, p.InstitutionID) {
+				row.Errors = append(row.Errors, importInstitutionColumn+" must be the ID of an institution")
+			}
+		}
+		// An institution's staff import only its people
+		if own := staffInstitution(ctx); own != "" {
+			if p.InstitutionID == "" {
+				p.InstitutionID = own
+			} else if p.InstitutionID != own {
+				row.Errors = append(row.Errors, importInstitutionColumn+" must be "+own+", your institution")
+			}
+		}
+		if text := strings.TrimSpace(record[col["remark"]]); text != "" {
+			rm := store.Remark{Type: store.RemarkGeneral, Visibility: store.RemarkPublic, Text: text}
+			if i, ok := col[importRemarkTypeColumn]; ok && strings.TrimSpace(record[i]) != "" {
+				rm.Type = strings.ToLower(strings.TrimSpace(record[i]))
+			}
+			if i, ok := col[importRemarkVisibilityColumn]; ok && strings.TrimSpace(record[i]) != "" {
+				rm.Visibility = strings.ToLower(strings.TrimSpace(record[i]))
+			}
+			if !remarkTypes[rm.Type] {
+				row.Errors = append(row.Errors, importRemarkTypeColumn+" must be one of "+remarkTypeList())
+			}
+			if !remarkVisibilities[rm.Visibility] {
+				row.Errors = append(row.Errors, importRemarkVisibilityColumn+" must be one of "+remarkVisibilityList())
+			}
+			if utf8.RuneCountInString(text) > maxRemarkLength {
+				row.Errors = append(row.Errors, fmt.Sprintf("remark must be at most %d characters", maxRemarkLength))
+			}
+			p.Remarks = []store.Remark{rm}
+		}
+		if !p.IssueDate.IsZero() && !p.ExpiryDate.IsZero() && p.ExpiryDate.Before(p.IssueDate) {
+			row.Errors = append(row.Errors, "expiry_date must not be before issue_date")
+		}
+		if !validID(p.NationalID) {
+			row.Errors = append(row.Errors, "national_id must be alphanumeric and at most 50 characters")
+		} else if _, err := lookupIDs(p.NationalID, ""); err != nil {
+			row.Errors = append(row.Errors, "national_id: "+err.Error())
+		} else if first, dup := seen[nicKey(p.NationalID)]; dup {
+			row.Errors = append(row.Errors, fmt.Sprintf("duplicate national_id (first seen on line %d)", first))
+		} else {
+			seen[nicKey(p.NationalID)] = line
+		}
+		if p.FullName == "" || len(p.FullName) > 100 {
+			row.Errors = append(row.Errors, "full_name is required and must be at most 100 characters")
+		}
+		if !validCategory(ctx, p.Category) {
+			row.Errors = append(row.Errors, "category must be one of "+categoryList(ctx))
+		}
+
+		if len(row.Errors) > 0 {
+			p = nil
+		}
+		people = append(people, p)
+		report.Rows = append(report.Rows, row)
+		report.Total++
+	}
+	return people, report, nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// institutionRefresh is how long the institutions are cached before the
+// table is read again, so other instances pick up changes
+const institutionRefresh = time.Minute
+
+// maxInstitutionHosts bounds the host names of one institution, and
+// maxInstitutionHostsLength their comma-separated length, the width of
+// institutions.hosts
+const (
+	maxInstitutionHosts       = 10
+	maxInstitutionHostsLength = 1000
+)
+
+// institutionIDPattern is what an institution ID may look like
+var institutionIDPattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// hostnamePattern is a lower-case host name without a port
+var hostnamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// institutionCache holds the institutions by ID and by host name
+var institutionCache struct {
+	sync.Mutex
+	byID   map[string]store.Institution
+	byHost map[string]string
+	loaded time.Time
+}
+
+// apiInstitution is the v1 JSON schema for an institution
+type apiInstitution struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Hosts        []string  `json:"hosts"`
+	LogoURL      string    `json:"logo_url"`
+	PrimaryColor string    `json:"primary_color"`
+	AccentColor  string    `json:"accent_color"`
+	FooterText   string    `json:"footer_text"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func toAPIInstitution(i *store.Institution) apiInstitution {
+	hosts := i.Hosts
+	if hosts == nil {
+		hosts = []string{}
+	}
+	return apiInstitution{ID: i.ID, Name: i.Name, Hosts: hosts, LogoURL: i.LogoURL, PrimaryColor: i.PrimaryColor,
+		AccentColor: i.AccentColor, FooterText: i.FooterText, CreatedAt: i.CreatedAt.UTC()}
+}
+
+// currentInstitutions returns the cached institutions by ID and the ID of
+// each host name's institution, reading the table again after
+// institutionRefresh. A failed reload keeps the last good ones.
+func currentInstitutions(ctx context.Context) (map[string]store.Institution, map[string]string) {
+	institutionCache.Lock()
+	defer institutionCache.Unlock()
+	if !institutionCache.loaded.IsZero() && time.Since(institutionCache.loaded) < institutionRefresh {
+		return institutionCache.byID, institutionCache.byHost
+	}
+	list, err := st.ListInstitutions(ctx)
+	if err != nil {
+		logErrorContext(ctx, "INSTITUTIONS_ERROR", fmt.Sprintf("Failed to load institutions: %v", err))
+		institutionCache.loaded = time.Now()
+		return institutionCache.byID, institutionCache.byHost
+	}
+	byID := make(map[string]store.Institution, len(list))
+	byHost := make(map[string]string)
+	for _, i := range list {
+		byID[i.ID] = i
+		for _, h := range i.Hosts {
+			byHost[h] = i.ID
+		}
+	}
+	institutionCache.byID, institutionCache.byHost, institutionCache.loaded = byID, byHost, time.Now()
+	return byID, byHost
+}
+
+// resetInstitutions makes the next request read the table again
+func resetInstitutions() {
+	institutionCache.Lock()
+	institutionCache.loaded = time.Time{}
+	institutionCache.Unlock()
+}
+
+// validInstitution reports whether id names an institution
+func validInstitution(ctx context.Context, id string) bool {
+	byID, _ := currentInstitutions(ctx)
+	_, ok := byID[id]
+	return ok
+}
+
+type institutionCtxKey struct{}
+
+// withInstitution returns ctx limiting person lookups to the people of the
+// institution id
+func withInstitution(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, institutionCtxKey{}, id)
+}
+
+// requestInstitution is the institution lookups made with ctx are limited
+// to: that of the API key or signed-in user, else that of the host name
+// the request was made to; "" when they see everyone. The deployment's own
+// staff see everyone whatever the host.
+func requestInstitution(ctx context.Context) string {
+	if k := requestAPIKey(ctx); k != nil && k.InstitutionID != "" {
+		return k.InstitutionID
+	}
+	if u := requestUser(ctx); u != nil {
+		return u.InstitutionID
+	}
+	id, _ := ctx.Value(institutionCtxKey{}).(string)
+	return id
+}
+
+// staffInstitution is the institution of the signed-in user, "" for the
+// deployment's own staff and for requests no user made
+func staffInstitution(ctx context.Context) string {
+	if u := requestUser(ctx); u != nil {
+		return u.InstitutionID
+	}
+	return ""
+}
+
+// assignableInstitution reports whether the signed-in user may put people
+// or users in institution ("" for the deployment): the deployment's own
+// staff anywhere, an institution's staff only in theirs
+func assignableInstitution(ctx context.Context, institution string) bool {
+	own := staffInstitution(ctx)
+	return own == "" || own == institution
+}
+
+// requirePersonInScope answers a /people/{id} request of an institution's
+// staff with a 404 when the person, deleted or not, belongs to another
+// institution, so they cannot read or change people they would not find
+func requirePersonInScope(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if staffInstitution(r.Context()) == "" || !validID(id) {
+			next(w, r)
+			return
+		}
+		_, err := findPersonOrDeleted(r.Context(), []string{id})
+		if err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+			return
+		} else if err != nil {
+			logErrorContext(r.Context(), "INSTITUTIONS_ERROR", fmt.Sprintf("Failed to look up the institution of %s: %v", maskID(id), err))
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireUserInScope answers a /users/{id} request of an institution's
+// staff with a 404 unless the user belongs to the same institution
+func requireUserInScope(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		own := staffInstitution(r.Context())
+		if own == "" {
+			next(w, r)
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		u, err := st.GetUser(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) || (err == nil && u.InstitutionID != own) {
+			writeAPIError(w, http.StatusNotFound, "not_found", "No user with this id")
+			return
+		} else if err != nil {
+			logErrorContext(r.Context(), "USERS_DB_ERROR", fmt.Sprintf("Failed to load user %d: %v", id, err))
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAPIKeyInScope answers a /keys/{id} request of an institution's
+// staff with a 404 unless the key is limited to the same institution
+func requireAPIKeyInScope(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		own := staffInstitution(r.Context())
+		if own == "" {
+			next(w, r)
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		k, err := st.GetAPIKey(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) || (err == nil && k.InstitutionID != own) {
+			writeAPIError(w, http.StatusNotFound, "not_found", "No API key with this id")
+			return
+		} else if err != nil {
+			logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to load API key %d: %v", id, err))
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireDeploymentStaff refuses an institution's staff the endpoints
+// that read or change the whole deployment rather than their people
+func requireDeploymentStaff(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if own := staffInstitution(r.Context()); own != "" {
+			logErrorContext(r.Context(), "AUTH_FORBIDDEN", fmt.Sprintf("User %s of institution %s denied %s %s",
+				requestUser(r.Context()).Username, own, r.Method, r.URL.Path))
+			writeAPIError(w, http.StatusForbidden, "other_institution", "This endpoint is for the deployment's own staff")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// inScope reports whether p may be found by lookups made with ctx
+func inScope(ctx context.Context, p *store.Person) bool {
+	scope := requestInstitution(ctx)
+	return scope == "" || p.InstitutionID == scope
+}
+
+// institutionMiddleware limits requests made to an institution's host
+// name to its people and shows them its branding
+func institutionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		_, byHost := currentInstitutions(r.Context())
+		if id, ok := byHost[strings.ToLower(host)]; ok {
+			r = r.WithContext(withInstitution(r.Context(), id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// institutionBranding is the branding of the institution id: the
+// deployment's, with the institution's name and whichever of its logo,
+// colors and footer are set. Unknown IDs and "" get the deployment's.
+func institutionBranding(ctx context.Context, id string) branding {
+	b := currentBranding(ctx)
+	if id == "" {
+		return b
+	}
+	byID, _ := currentInstitutions(ctx)
+	i, ok := byID[id]
+	if !ok {
+		return b
+	}
+	b.Name = i.Name
+	for field, v := range map[*string]string{&b.LogoURL: i.LogoURL, &b.PrimaryColor: i.PrimaryColor, &b.AccentColor: i.AccentColor, &b.FooterText: i.FooterText} {
+		if v != "" {
+			*field = v
+		}
+	}
+	return b
+}
+
+// apiInstitutionsHandler lists the institutions by ID
+func apiInstitutionsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := st.ListInstitutions(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "INSTITUTIONS_ERROR", fmt.Sprintf("Failed to list institutions: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	out := make([]apiInstitution, len(list))
+	for i := range list {
+		out[i] = toAPIInstitution(&list[i])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"institutions": out})
+}
+
+// apiPutInstitutionHandler adds or replaces an institution: PUT
+// /institutions/{id} with {"name", "hosts", "logo_url", "primary_color",
+// "accent_color", "footer_text"}. Only the name is required.
+func apiPutInstitutionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !institutionIDPattern.MatchString(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "id must be 1 to 50 lower-case letters, digits, hyphens or underscores")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Name         string   `json:"name"`
+		Hosts        []string `json:"hosts"`
+		LogoURL      string   `json:"logo_url"`
+		PrimaryColor string   `json:"primary_color"`
+		AccentColor  string   `json:"accent_color"`
+		FooterText   string   `json:"footer_text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"name": "...", "hosts": ["..."], "logo_url": "...", "primary_color": "...", "accent_color": "...", "footer_text": "..."}`)
+		return
+	}
+	inst := &store.Institution{ID: id, Name: strings.TrimSpace(body.Name), LogoURL: body.LogoURL,
+		PrimaryColor: body.PrimaryColor, AccentColor: body.AccentColor, FooterText: body.FooterText}
+	if inst.Name == "" || utf8.RuneCountInString(inst.Name) > 200 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_name", "name must be 1 to 200 characters")
+		return
+	}
+	// The branding fields end up in style and src attributes like the
+	// deployment's, so they are checked the same way
+	if err := (branding{Name: inst.Name, LogoURL: inst.LogoURL, PrimaryColor: inst.PrimaryColor, AccentColor: inst.AccentColor, FooterText: inst.FooterText}).validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_branding", err.Error())
+		return
+	}
+	if len(body.Hosts) > maxInstitutionHosts {
+		writeAPIError(w, http.StatusBadRequest, "invalid_hosts", fmt.Sprintf("hosts may list at most %d host names", maxInstitutionHosts))
+		return
+	}
+	list, err := st.ListInstitutions(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "INSTITUTIONS_ERROR", fmt.Sprintf("Failed to list institutions: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	taken := make(map[string]string)
+	for _, other := range list {
+		for _, h := range other.Hosts {
+			taken[h] = other.ID
+		}
+	}
+	for _, h := range body.Hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if !hostnamePattern.MatchString(h) || len(h) > 253 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_hosts", fmt.Sprintf("%q is not a host name; give it without a scheme or port", h))
+			return
+		}
+		if owner, ok := taken[h]; ok && owner != id {
+			writeAPIError(w, http.StatusConflict, "host_in_use", fmt.Sprintf("%s already belongs to institution %s", h, owner))
+			return
+		}
+		if !slices.Contains(inst.Hosts, h) {
+			inst.Hosts = append(inst.Hosts, h)
+		}
+	}
+	if len(strings.Join(inst.Hosts, ",")) > maxInstitutionHostsLength {
+		writeAPIError(w, http.StatusBadRequest, "invalid_hosts", fmt.Sprintf("hosts may be at most %d characters together, separated by commas", maxInstitutionHostsLength))
+		return
+	}
+
+	saved, err := st.PutInstitution(r.Context(), inst)
+	if err != nil {
+		logErrorContext(r.Context(), "INSTITUTIONS_ERROR", fmt.Sprintf("Failed to save institution %s: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	resetInstitutions()
+	logErrorContext(r.Context(), "INSTITUTION_UPDATE_SUCCESS", fmt.Sprintf("%s saved institution %s", requestUser(r.Context()).Username, id))
+	out := toAPIInstitution(saved)
+	publishChange(r.Context(), eventInstitutionUpdated, "", out)
+	writeJSON(w, http.StatusOK, out)
+}
+
+// apiDeleteInstitutionHandler removes an institution no one belongs to
+func apiDeleteInstitutionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := st.DeleteInstitution(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No institution with this ID")
+		return
+	} else if errors.Is(err, store.ErrInUse) {
+		writeAPIError(w, http.StatusConflict, "institution_in_use", "People, users or active API keys still belong to this institution")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "INSTITUTIONS_ERROR", fmt.Sprintf("Failed to delete institution %s: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	resetInstitutions()
+	loadMessageTemplates(r.Context())
+	logErrorContext(r.Context(), "INSTITUTION_DELETE_SUCCESS", fmt.Sprintf("%s deleted institution %s", requestUser(r.Context()).Username, id))
+	publishChange(r.Context(), eventInstitutionDeleted, "", map[string]string{"id": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiSetPersonInstitutionHandler moves a person to another institution:
+// PUT /people/{id}/institution with {"institution": "..."}, "" for the
+// deployment's own people
+func apiSetPersonInstitutionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Institution *string `json:"institution"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Institution == nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"institution": "..."}`)
+		return
+	}
+	institution := *body.Institution
+	if institution != "" && !validInstitution(r.Context(), institution) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_institution", "institution must be the ID of an institution, or empty")
+		return
+	}
+	if !assignableInstitution(r.Context(), institution) {
+		writeAPIError(w, http.StatusForbidden, "other_institution", "You can only act within your own institution")
+		return
+	}
+
+	p, err := st.SetInstitution(r.Context(), id, institution)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "INSTITUTIONS_ERROR", fmt.Sprintf("Failed to move %s to institution %q: %v", maskID(id), institution, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "INSTITUTION_MOVE_SUCCESS", fmt.Sprintf("%s moved %s to institution %q", requestUser(r.Context()).Username, maskID(p.NationalID), institution))
+	writeJSON(w, http.StatusOK, map[string]string{"national_id": p.NationalID, "institution": p.InstitutionID})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// institutionAPI serves the v1 API from a test store holding the
+// institutions beauxbatons and durmstrang, and returns it with the token
+// of an admin of beauxbatons
+func institutionAPI(t *testing.T) (*store.SQLStore, http.Handler, string) {
+	t.Helper()
+	s := useTestStore(t)
+	resetInstitutions()
+	t.Cleanup(resetInstitutions)
+	ctx := context.Background()
+	for _, id := range []string{"beauxbatons", "durmstrang"} {
+		if _, err := s.PutInstitution(ctx, &store.Institution{ID: id, Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := newToken(userTokenPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.CreateUser(ctx, "maxime", store.RoleAdmin, hashToken(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetUserInstitution(ctx, u.ID, "beauxbatons"); err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	registerAPIv1(r.PathPrefix("/api/v1").Subrouter())
+	return s, r, token
+}
+
+// staffRequest makes an API request with token and returns the response
+func staffRequest(h http.Handler, token, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestInstitutionAdminKeys(t *testing.T) {
+	s, h, token := institutionAPI(t)
+	other, err := s.CreateAPIKey(context.Background(), "deployment", "hvk_000", hashToken("hvk_000"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, body  string
+		status      int
+		institution string
+	}{
+		{name: "own by default", body: `{"name": "portal"}`, status: http.StatusCreated, institution: "beauxbatons"},
+		{name: "own", body: `{"name": "portal", "institution": "beauxbatons"}`, status: http.StatusCreated, institution: "beauxbatons"},
+		{name: "other", body: `{"name": "portal", "institution": "durmstrang"}`, status: http.StatusForbidden},
+		{name: "unknown", body: `{"name": "portal", "institution": "hogwarts"}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := staffRequest(h, token, http.MethodPost, "/api/v1/keys", tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("POST /keys %s = %d %s, want %d", tt.body, w.Code, w.Body, tt.status)
+			}
+			var k apiKey
+			if json.Unmarshal(w.Body.Bytes(), &k); k.Institution != tt.institution {
+				t.Errorf("key institution = %q, want %q", k.Institution, tt.institution)
+			}
+		})
+	}
+
+	var list apiKeyList
+	if w := staffRequest(h, token, http.MethodGet, "/api/v1/keys", ""); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil {
+		t.Fatalf("GET /keys = %d %s", w.Code, w.Body)
+	}
+	for _, k := range list.Keys {
+		if k.Institution != "beauxbatons" {
+			t.Errorf("listed key %d of institution %q", k.ID, k.Institution)
+		}
+	}
+	if len(list.Keys) != 2 {
+		t.Errorf("listed %d keys, want the 2 issued", len(list.Keys))
+	}
+	for _, path := range []string{"/api/v1/keys/%d", "/api/v1/keys/%d/usage"} {
+		method := http.MethodGet
+		if !strings.HasSuffix(path, "usage") {
+			method = http.MethodDelete
+		}
+		if w := staffRequest(h, token, method, fmt.Sprintf(path, other.ID), ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s of a deployment key = %d, want 404", method, path, w.Code)
+		}
+	}
+}
+
+func TestInstitutionAdminDeploymentEndpoints(t *testing.T) {
+	_, h, token := institutionAPI(t)
+	tests := []struct{ method, path, body string }{
+		{http.MethodPut, "/api/v1/institutions/durmstrang", `{"name": "Durmstrang", "hosts": ["verify.beauxbatons.example"]}`},
+		{http.MethodDelete, "/api/v1/institutions/durmstrang", ""},
+		{http.MethodPut, "/api/v1/branding", `{"name": "Beauxbatons"}`},
+		{http.MethodPut, "/api/v1/maintenance", `{"enabled": true}`},
+		{http.MethodPut, "/api/v1/message_templates/text/en/match", `{"text": "Yes"}`},
+		{http.MethodGet, "/api/v1/partners", ""},
+		{http.MethodGet, "/api/v1/webhooks", ""},
+		{http.MethodPost, "/api/v1/access_rules", `{"action": "block", "kind": "ip", "value": "192.0.2.1"}`},
+		{http.MethodGet, "/api/v1/subjects/200012345679", ""},
+		{http.MethodPost, "/api/v1/subjects/200012345679/erase", `{"reason": "request"}`},
+		{http.MethodPost, "/api/v1/jobs/refresh_caches/run", ""},
+	}
+	for _, tt := range tests {
+		w := staffRequest(h, token, tt.method, tt.path, tt.body)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "other_institution") {
+			t.Errorf("%s %s = %d %s, want 403 other_institution", tt.method, tt.path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestInstitutionStaffRecords(t *testing.T) {
+	s, h, token := institutionAPI(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for id, institution := range map[string]string{"200012345679": "beauxbatons", "200098765432": "durmstrang"} {
+		if err := s.Create(ctx, &store.Person{NationalID: id, FullName: "Student " + id, Category: "student", InstitutionID: institution}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RecordAudit(ctx, &store.AuditEntry{Channel: "web", Source: "192.0.2.1", Input: id, Outcome: callResultMatch, MatchedID: id, CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RecordCall(ctx, &store.Call{CallSid: "CA" + id, From: "h:0000", Endpoint: "/voice", Input: id, Result: callResultMatch, MatchedID: id, CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.RecordAudit(ctx, &store.AuditEntry{Channel: "web", Source: "192.0.2.1", Input: "2000****0000", Outcome: "not_found", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	var audit apiAuditList
+	if w := staffRequest(h, token, http.MethodGet, "/api/v1/audit", ""); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &audit) != nil {
+		t.Fatalf("GET /audit = %d %s", w.Code, w.Body)
+	}
+	if audit.Total != 1 || len(audit.Entries) != 1 || audit.Entries[0].MatchedID != "200012345679" {
+		t.Errorf("GET /audit = %+v, want only the check of the beauxbatons student", audit)
+	}
+
+	var calls apiCallList
+	if w := staffRequest(h, token, http.MethodGet, "/api/v1/calls", ""); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &calls) != nil {
+		t.Fatalf("GET /calls = %d %s", w.Code, w.Body)
+	}
+	if calls.Total != 1 || len(calls.Calls) != 1 || calls.Calls[0].MatchedID != "200012345679" {
+		t.Errorf("GET /calls = %+v, want only the call about the beauxbatons student", calls)
+	}
+
+	var stats apiStats
+	if w := staffRequest(h, token, http.MethodGet, "/api/v1/stats", ""); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &stats) != nil {
+		t.Fatalf("GET /stats = %d %s", w.Code, w.Body)
+	}
+	if stats.Checks != 1 || stats.Matches != 1 || stats.DistinctIDs != 1 || len(stats.TopErrors) != 0 {
+		t.Errorf("GET /stats = %+v, want the one beauxbatons check and no errors", stats)
+	}
+
+	if w := staffRequest(h, token, http.MethodGet, "/api/v1/errors", ""); w.Code != http.StatusForbidden {
+		t.Errorf("GET /errors = %d, want 403", w.Code)
+	}
+}
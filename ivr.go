@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// registrarDialTimeout is how many seconds a transfer to the registrar rings
+const registrarDialTimeout = 30
+
+// e164Pattern matches an E.164 phone number such as TWILIO_REGISTRAR_NUMBER
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// twilioMenuOption is one choice of the main menu. The digits stay the
+// same whichever options are enabled, so callers and written instructions
+// can rely on them.
+type twilioMenuOption struct {
+	Digit   string
+	Offer   string // message key announcing the option
+	Enabled func() bool
+	Handle  func(w http.ResponseWriter, r *http.Request, lang string)
+}
+
+// twilioMenuOptions lists the main menu in the order it is read
+func twilioMenuOptions() []twilioMenuOption {
+	return []twilioMenuOption{
+		{Digit: "1", Offer: "menu_verify", Enabled: func() bool { return true }, Handle: twilioMenuVerify},
+		{Digit: "2", Offer: "menu_hours", Enabled: func() bool { return cfg.TwilioOfficeHours != "" }, Handle: twilioMenuHours},
+		{Digit: "3", Offer: "menu_registrar", Enabled: func() bool { return cfg.TwilioRegistrarNumber != "" }, Handle: twilioMenuRegistrar},
+	}
+}
+
+// twilioMenuEnabled reports whether callers get the main menu after
+// choosing a language; with only verification on offer they go straight to
+// the ID prompt
+func twilioMenuEnabled() bool {
+	for _, option := range twilioMenuOptions()[1:] {
+		if option.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// officeHours is the TWILIO_OFFICE_HOURS text for lang, falling back to the
+// English text
+func officeHours(lang string) string {
+	switch lang {
+	case langSinhala:
+		if cfg.TwilioOfficeHoursSinhala != "" {
+			return cfg.TwilioOfficeHoursSinhala
+		}
+	case langTamil:
+		if cfg.TwilioOfficeHoursTamil != "" {
+			return cfg.TwilioOfficeHoursTamil
+		}
+	}
+	return cfg.TwilioOfficeHours
+}
+
+// validateTwilioMenu checks the main menu settings at startup
+func validateTwilioMenu(c config) error {
+	if c.TwilioRegistrarNumber != "" && !e164Pattern.MatchString(c.TwilioRegistrarNumber) {
+		return errors.New("TWILIO_REGISTRAR_NUMBER must be an E.164 number such as +94112345678")
+	}
+	return nil
+}
+
+// writeTwilioMenu offers the enabled menu options. attempt counts the
+// times the menu has been offered on this call, so a caller who keeps
+// pressing the wrong key is not looped forever.
+func writeTwilioMenu(w http.ResponseWriter, r *http.Request, lang string, attempt int, message string) {
+	if attempt > cfg.TwilioMaxAttempts {
+		writeTwiML(w, say(lang, twilioMsg(lang, "max_attempts")), twimlHangup{})
+		return
+	}
+	var verbs []interface{}
+	if message != "" {
+		verbs = append(verbs, say(lang, message))
+	}
+	for _, option := range twilioMenuOptions() {
+		if option.Enabled() {
+			verbs = append(verbs, say(lang, twilioMsg(lang, option.Offer)))
+		}
+	}
+	updateCallState(r, func(s *callSession) { s.MenuAttempt = attempt })
+	writeTwiML(w,
+		twimlGather{
+			Input:     "dtmf",
+			NumDigits: 1,
+			Action:    twilioURL("/twilio/menu", url.Values{"lang": {lang}}),
+			Method:    "POST",
+			Timeout:   cfg.TwilioGatherTimeout,
+			Verbs:     verbs,
+		},
+		say(lang, twilioMsg(lang, "no_input")),
+	)
+}
+
+// twilioMenuHandler dispatches the caller's choice from the main menu to
+// the option's handler. Without a choice, or with a key that is not on
+// offer, the menu is played again.
+func twilioMenuHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+	lang := twilioLang(r)
+	attempt := attemptNumber(r.URL.Query().Get("attempt"), callState(r).MenuAttempt)
+	digits := r.PostFormValue("Digits")
+	for _, option := range twilioMenuOptions() {
+		if option.Digit == digits && option.Enabled() {
+			option.Handle(w, r, lang)
+			return
+		}
+	}
+	message := ""
+	if digits != "" {
+		message = twilioMsg(lang, "menu_invalid")
+	}
+	writeTwilioMenu(w, r, lang, attempt+1, message)
+}
+
+// twilioMenuVerify starts the verification flow
+func twilioMenuVerify(w http.ResponseWriter, r *http.Request, lang string) {
+	recordCall(r, "", callResultMenu, "")
+	writeTwilioPrompt(w, r, lang, "")
+}
+
+// twilioMenuHours reads the office hours and returns to the menu
+func twilioMenuHours(w http.ResponseWriter, r *http.Request, lang string) {
+	recordCall(r, "", callResultOfficeHours, "")
+	writeTwilioMenu(w, r, lang, 1, fmt.Sprintf(twilioMsg(lang, "office_hours"), officeHours(lang)))
+}
+
+// twilioMenuRegistrar transfers the caller to TWILIO_REGISTRAR_NUMBER. When
+// the transfer ends Twilio posts the outcome to /twilio/menu/dialed.
+func twilioMenuRegistrar(w http.ResponseWriter, r *http.Request, lang string) {
+	logErrorContext(r.Context(), "TWILIO_TRANSFER", fmt.Sprintf("Transferring %s to the registrar", maskCaller(twilioCaller(r))))
+	recordCall(r, "", callResultTransfer, "")
+	writeTwiML(w,
+		say(lang, twilioMsg(lang, "transfer")),
+		twimlDial{
+			Action:  twilioURL("/twilio/menu/dialed", url.Values{"lang": {lang}}),
+			Method:  "POST",
+			Timeout: registrarDialTimeout,
+			Number:  cfg.TwilioRegistrarNumber,
+		},
+	)
+}
+
+// twilioDialedHandler runs when a transfer to the registrar ends: a
+// completed call hangs up, anything else (busy, no answer) apologises and
+// offers the menu again
+func twilioDialedHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+	lang := twilioLang(r)
+	status := r.PostFormValue("DialCallStatus")
+	if status == "completed" {
+		writeTwiML(w, twimlHangup{})
+		return
+	}
+	logErrorContext(r.Context(), "TWILIO_TRANSFER_FAILED", fmt.Sprintf("Transfer of %s to the registrar ended with %q", maskCaller(twilioCaller(r)), status))
+	recordCall(r, "", callResultError, "")
+	writeTwilioMenu(w, r, lang, 1, twilioMsg(lang, "registrar_away"))
+}
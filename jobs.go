@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// jobScheduleOff disables a job
+const jobScheduleOff = "off"
+
+// jobTimeout bounds one run of a job
+const jobTimeout = 5 * time.Minute
+
+// accessRuleKeep is how long expired access rules stay listed before the
+// expire_access_rules job deletes them
+const accessRuleKeep = 7 * 24 * time.Hour
+
+// jobDefaults are the default JOB_<NAME>_SCHEDULE of the maintenance jobs,
+// in UTC
+var jobDefaults = map[string]string{
+	"retention":           "15 3 * * *",
+	"expire_sessions":     "*/15 * * * *",
+	"expire_access_rules": "45 * * * *",
+	"prune_api_key_usage": "30 3 * * *",
+	"refresh_caches":      "* * * * *",
+	"find_duplicates":     "0 4 * * 1",
+}
+
+// jobSchedules reads JOB_<NAME>_SCHEDULE for each maintenance job
+func jobSchedules() map[string]string {
+	schedules := make(map[string]string, len(jobDefaults))
+	for name, schedule := range jobDefaults {
+		schedules[name] = envString("JOB_"+strings.ToUpper(name)+"_SCHEDULE", schedule)
+	}
+	return schedules
+}
+
+// job is a periodic task. Run gets the time the run was scheduled for and
+// returns a summary of what it did, "" when there was nothing to do.
+type job struct {
+	Name     string
+	Schedule string
+	Location *time.Location
+	// Local jobs maintain per-process state and run on every instance;
+	// others run on one instance per scheduled time
+	Local bool
+	Run   func(ctx context.Context, at time.Time) (string, error)
+
+	mu      sync.Mutex
+	parsed  *cronSchedule
+	next    time.Time
+	running bool
+	last    *jobRun
+}
+
+// jobRun is the outcome of a job's last run on this instance
+type jobRun struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	Summary   string
+	Err       error
+}
+
+// jobs are the registered jobs, by name
+var jobs = map[string]*job{}
+
+// apiJob is the v1 JSON schema for a job. LastRun is the last run on the
+// instance answering the request.
+type apiJob struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Timezone string     `json:"timezone"`
+	Local    bool       `json:"local"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	Running  bool       `json:"running"`
+	LastRun  *apiJobRun `json:"last_run,omitempty"`
+}
+
+// apiJobRun is the v1 JSON schema for a job's run
+type apiJobRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Result     string    `json:"result"`
+	Summary    string    `json:"summary,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// maintenanceJobs returns the built-in jobs, unscheduled
+func maintenanceJobs() []*job {
+	return []*job{
+		{Name: "retention", Run: retentionJob},
+		{Name: "expire_sessions", Run: expireSessionsJob},
+		{Name: "expire_access_rules", Run: expireAccessRulesJob},
+		{Name: "prune_api_key_usage", Run: pruneAPIKeyUsageJob},
+		{Name: "refresh_caches", Local: true, Run: refreshCachesJob},
+		{Name: "find_duplicates", Run: findDuplicatesJob},
+	}
+}
+
+// validateJobs checks the JOB_*_SCHEDULE settings at startup
+func validateJobs(c config) error {
+	for name, schedule := range c.JobSchedules {
+		if schedule == jobScheduleOff {
+			continue
+		}
+		if _, err := parseCron(schedule); err != nil {
+			return fmt.Errorf("JOB_%s_SCHEDULE: %v", strings.ToUpper(name), err)
+		}
+	}
+	return nil
+}
+
+// startJobs registers the maintenance jobs and the report and runs each on
+// its schedule in the background
+func startJobs(c config) {
+	for _, j := range maintenanceJobs() {
+		j.Schedule, j.Location = c.JobSchedules[j.Name], time.UTC
+		registerJob(j)
+	}
+	if c.ReportSchedule != "" {
+		loc, _ := time.LoadLocation(c.ReportTimezone)
+		registerJob(&job{Name: "report", Schedule: c.ReportSchedule, Location: loc, Run: reportJob})
+	}
+	for _, j := range jobs {
+		if j.parsed != nil {
+			go j.loop()
+		}
+	}
+}
+
+// registerJob adds j, parsing its schedule; an off job can still be run
+// through the API
+func registerJob(j *job) {
+	if j.Schedule != jobScheduleOff {
+		j.parsed, _ = parseCron(j.Schedule)
+	}
+	jobs[j.Name] = j
+}
+
+// loop runs j at each time its schedule matches
+func (j *job) loop() {
+	for {
+		next := j.parsed.next(time.Now().In(j.Location))
+		if next.IsZero() {
+			logError("JOB_ERROR", fmt.Sprintf("Schedule %q of job %s never matches", j.Schedule, j.Name))
+			return
+		}
+		j.mu.Lock()
+		j.next = next
+		j.mu.Unlock()
+		time.Sleep(time.Until(next))
+
+		if !j.Local && !j.claim(next) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+		j.execute(ctx, next)
+		cancel()
+	}
+}
+
+// claim reports whether this instance runs the job's run at at. With
+// Redis the first instance to count the run gets it; a failing counter
+// store lets every instance run it rather than none.
+func (j *job) claim(at time.Time) bool {
+	key := "job:" + j.Name + ":" + at.UTC().Format(time.RFC3339)
+	n, err := counters.Add(context.Background(), key, 1, time.Hour)
+	if err != nil {
+		logError("JOB_ERROR", fmt.Sprintf("Failed to claim the %s run of job %s, running it anyway: %v", at.Format(time.RFC3339), j.Name, err))
+		return true
+	}
+	return n == 1
+}
+
+// errJobRunning is returned for a job that is still running
+var errJobRunning = errors.New("job is already running")
+
+// execute runs the job once, unless it is still running, and records and
+// logs the outcome
+func (j *job) execute(ctx context.Context, at time.Time) (*jobRun, error) {
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		logErrorContext(ctx, "JOB_SKIPPED", fmt.Sprintf("Skipped the %s run of job %s: the previous run has not finished", at.Format(time.RFC3339), j.Name))
+		return nil, errJobRunning
+	}
+	j.running = true
+	j.mu.Unlock()
+
+	run := &jobRun{StartedAt: time.Now()}
+	run.Summary, run.Err = j.Run(ctx, at)
+	run.Duration = time.Since(run.StartedAt)
+	jobDuration.WithLabelValues(j.Name).Observe(run.Duration.Seconds())
+
+	j.mu.Lock()
+	j.running, j.last = false, run
+	j.mu.Unlock()
+
+	if run.Err != nil {
+		jobRunsTotal.WithLabelValues(j.Name, "failed").Inc()
+		sendAlert("JOB_FAILED", fmt.Sprintf("Job %s failed: %v", j.Name, run.Err))
+		return run, nil
+	}
+	jobRunsTotal.WithLabelValues(j.Name, "ok").Inc()
+	if run.Summary != "" {
+		logErrorContext(ctx, "JOB_SUCCESS", fmt.Sprintf("Job %s: %s", j.Name, run.Summary))
+	}
+	return run, nil
+}
+
+// expireSessionsJob deletes dashboard sessions past SESSION_MAX_AGE or
+// SESSION_IDLE_TIMEOUT
+func expireSessionsJob(ctx context.Context, at time.Time) (string, error) {
+	n, err := st.DeleteExpiredSessions(ctx, at.Add(-cfg.SessionMaxAge), at.Add(-cfg.SessionIdleTimeout))
+	if err != nil || n == 0 {
+		return "", err
+	}
+	return fmt.Sprintf("deleted %d expired sessions", n), nil
+}
+
+// expireAccessRulesJob deletes access rules that expired more than
+// accessRuleKeep ago
+func expireAccessRulesJob(ctx context.Context, at time.Time) (string, error) {
+	n, err := st.DeleteExpiredAccessRules(ctx, at.Add(-accessRuleKeep))
+	if err != nil || n == 0 {
+		return "", err
+	}
+	resetAccessRules()
+	return fmt.Sprintf("deleted %d expired access rules", n), nil
+}
+
+// pruneAPIKeyUsageJob deletes daily API key request counts older than
+// API_KEY_USAGE_RETENTION, keeping the current month's, which partner
+// quotas are counted from
+func pruneAPIKeyUsageJob(ctx context.Context, at time.Time) (string, error) {
+	if cfg.APIKeyUsageRetention <= 0 {
+		return "", nil
+	}
+	before := at.Add(-cfg.APIKeyUsageRetention)
+	if start := monthStart(at); before.After(start) {
+		before = start
+	}
+	n, err := st.PruneAPIKeyUsage(ctx, before)
+	if err != nil || n == 0 {
+		return "", err
+	}
+	return fmt.Sprintf("deleted %d daily API key usage counts", n), nil
+}
+
+// refreshCachesJob reloads the branding, access rules, categories,
+// institutions, partners, maintenance setting and message templates so
+// requests do not wait on the reload, and drops
+// expired people from the in-memory lookup cache
+func refreshCachesJob(ctx context.Context, at time.Time) (string, error) {
+	resetBranding()
+	currentBranding(ctx)
+	resetAccessRules()
+	currentAccessRules(ctx)
+	resetCategories()
+	currentCategories(ctx)
+	resetInstitutions()
+	currentInstitutions(ctx)
+	resetPartners()
+	currentPartners(ctx)
+	resetMaintenance()
+	maintenanceSetting(ctx)
+	loadMessageTemplates(ctx)
+	if lookupCache != nil {
+		if n := lookupCache.Prune(); n > 0 {
+			return fmt.Sprintf("dropped %d expired people from the lookup cache", n), nil
+		}
+	}
+	return "", nil
+}
+
+// lookupCache is the in-memory lookup cache, when CACHE_TTL enables one
+// without Redis
+var lookupCache *store.MemoryCache
+
+func toAPIJob(j *job) apiJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := apiJob{Name: j.Name, Schedule: j.Schedule, Timezone: j.Location.String(), Local: j.Local, Running: j.running}
+	if !j.next.IsZero() {
+		next := j.next
+		out.NextRun = &next
+	}
+	if j.last != nil {
+		out.LastRun = toAPIJobRun(j.last)
+	}
+	return out
+}
+
+func toAPIJobRun(r *jobRun) *apiJobRun {
+	out := &apiJobRun{StartedAt: r.StartedAt.UTC(), DurationMS: r.Duration.Milliseconds(), Result: "ok", Summary: r.Summary}
+	if r.Err != nil {
+		out.Result, out.Error = "failed", r.Err.Error()
+	}
+	return out
+}
+
+// apiJobsHandler lists the jobs by name
+func apiJobsHandler(w http.ResponseWriter, r *http.Request) {
+	list := make([]apiJob, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, toAPIJob(j))
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].Name < list[k].Name })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": list})
+}
+
+// apiRunJobHandler runs a job now, on this instance, and returns how it
+// went. It runs even when its schedule is off.
+func apiRunJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := jobs[mux.Vars(r)["name"]]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Unknown job")
+		return
+	}
+	logErrorContext(r.Context(), "JOB_RUN", fmt.Sprintf("%s ran job %s", requestUser(r.Context()).Username, j.Name))
+	// The run goes on if the client disconnects
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), jobTimeout)
+	defer cancel()
+	run, err := j.execute(ctx, time.Now().In(j.Location))
+	if err == errJobRunning {
+		writeAPIError(w, http.StatusConflict, "job_running", "The job is already running")
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIJobRun(run))
+}
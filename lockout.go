@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// lockoutKeyContext carries the client key chosen by withLockout so
+// handlers can report lookup outcomes without knowing how it was derived
+type lockoutKeyContext struct{}
+
+// withLockout blocks clients that are locked out for repeated not-found
+// lookups, and makes the client key available to noteLookup. Blocked
+// requests get a Retry-After header and are answered by deny. Allowlisted
+// clients are neither blocked nor counted.
+func withLockout(key func(*http.Request) string, deny func(http.ResponseWriter, *http.Request)) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if cfg.LockoutThreshold <= 0 {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			if allowlisted(r.Context()) {
+				next(w, r)
+				return
+			}
+			k := key(r)
+			locked, left, err := counters.Get(r.Context(), "lock:"+k)
+			if err != nil {
+				logErrorContext(r.Context(), "LOCKOUT_ERROR", fmt.Sprintf("Lockout check failed for %s: %v", k, err))
+			} else if locked > 0 {
+				logErrorContext(r.Context(), "LOCKOUT_BLOCKED", fmt.Sprintf("Blocked locked-out client %s on %s", k, r.URL.Path))
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+				deny(w, r)
+				return
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), lockoutKeyContext{}, k)))
+		}
+	}
+}
+
+// noteLookup records the outcome of lookups made for the client in ctx:
+// misses consecutive not-found results, after found results if any were
+// found. A found result clears the run of misses before it. Once
+// LOCKOUT_THRESHOLD misses accumulate the client is locked out for
+// LOCKOUT_DURATION and an alert is sent.
+func noteLookup(ctx context.Context, found bool, misses int) {
+	k, ok := ctx.Value(lockoutKeyContext{}).(string)
+	if !ok {
+		return
+	}
+
+	if found {
+		if err := counters.Reset(ctx, "miss:"+k); err != nil {
+			logErrorContext(ctx, "LOCKOUT_ERROR", fmt.Sprintf("Failed to reset miss count for %s: %v", k, err))
+		}
+	}
+	if misses == 0 {
+		return
+	}
+
+	count, err := counters.Add(ctx, "miss:"+k, int64(misses), cfg.LockoutWindow)
+	if err != nil {
+		logErrorContext(ctx, "LOCKOUT_ERROR", fmt.Sprintf("Failed to count misses for %s: %v", k, err))
+		return
+	}
+	if count < int64(cfg.LockoutThreshold) {
+		return
+	}
+
+	if _, err := counters.Add(ctx, "lock:"+k, 1, cfg.LockoutDuration); err != nil {
+		logErrorContext(ctx, "LOCKOUT_ERROR", fmt.Sprintf("Failed to lock out %s: %v", k, err))
+		return
+	}
+	counters.Reset(ctx, "miss:"+k)
+	lockoutsTotal.Inc()
+	sendAlert("LOCKOUT_TRIGGERED", fmt.Sprintf("Client %s locked out for %s after %d consecutive not-found lookups",
+		k, cfg.LockoutDuration, count))
+}
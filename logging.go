@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// logger is the structured logger. Until initLogging runs (e.g. while
+// .env is being loaded) it writes text to stderr, so startup failures are
+// always visible.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// dbLogLevel is the least severe level stored in the errors table, so
+// routine successes stay in the log output only
+var dbLogLevel = slog.LevelWarn
+
+// eventLevels overrides the level logLevelForType derives from the suffix
+// for routine events that would otherwise count as warnings
+var eventLevels = map[string]slog.Level{
+	"TWILIO_LANGUAGE": slog.LevelDebug,
+	"TWILIO_REENTER":  slog.LevelDebug,
+	"TWILIO_NO_INPUT": slog.LevelInfo,
+	"JOB_RUN":         slog.LevelInfo,
+}
+
+// initLogging configures the logger from LOG_FORMAT (text|json),
+// LOG_LEVEL (debug|info|warn|error) and LOG_FILE (default stdout), and the
+// errors table threshold from LOG_DB_LEVEL
+func initLogging(c config) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL %q: %w", c.LogLevel, err)
+	}
+	if err := dbLogLevel.UnmarshalText([]byte(c.LogDBLevel)); err != nil {
+		return fmt.Errorf("invalid LOG_DB_LEVEL %q: %w", c.LogDBLevel, err)
+	}
+	if c.LogDBQueueSize < 1 || c.LogDBBatchSize < 1 || c.LogDBFlushInterval <= 0 {
+		return fmt.Errorf("LOG_DB_QUEUE_SIZE, LOG_DB_BATCH_SIZE and LOG_DB_FLUSH_INTERVAL must be positive")
+	}
+
+	var out io.Writer = os.Stdout
+	if c.LogFile != "" {
+		f, err := os.OpenFile(c.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return fmt.Errorf("open LOG_FILE: %w", err)
+		}
+		out = f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(c.LogFormat) {
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(out, opts))
+	case "text", "":
+		logger = slog.New(slog.NewTextHandler(out, opts))
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q (want text or json)", c.LogFormat)
+	}
+	return nil
+}
+
+// logLevelForType derives a level from the event type naming convention:
+// *_SUCCESS events are informational, *_ERROR / *_FAILED are errors and
+// everything else (not found, invalid input, ...) is a warning.
+func logLevelForType(errorType string) slog.Level {
+	if level, ok := eventLevels[errorType]; ok {
+		return level
+	}
+	switch {
+	case strings.HasSuffix(errorType, "_SUCCESS"):
+		return slog.LevelInfo
+	case strings.HasSuffix(errorType, "_ERROR"), strings.HasSuffix(errorType, "_FAILED"):
+		return slog.LevelError
+	default:
+		return slog.LevelWarn
+	}
+}
+
+// logCategory is the lower-cased event type prefix: "VERIFY_NOT_FOUND"
+// belongs to "verify", "TWILIO_DB_ERROR" to "twilio"
+func logCategory(errorType string) string {
+	prefix, _, _ := strings.Cut(errorType, "_")
+	return strings.ToLower(prefix)
+}
+
+// logError records an event with the level and category derived from its
+// type. It is always written to the structured logger; when LOG_DB_ENABLED
+// is set and the level is at least LOG_DB_LEVEL it is also queued for the
+// errors table, or written to LOG_DB_FALLBACK_FILE while the database is
+// not open. Code handling a request uses logErrorContext instead.
+func logError(errorType, remark string) {
+	logErrorContext(context.Background(), errorType, remark)
+}
+
+// logErrorContext is logError for an event during a request: the request
+// ID carried by ctx is added to the log line and the errors table row
+func logErrorContext(ctx context.Context, errorType, remark string) {
+	level := logLevelForType(errorType)
+	category := logCategory(errorType)
+	attrs := []any{"type", errorType, "category", category}
+	reqID := requestID(ctx)
+	if reqID != "" {
+		attrs = append(attrs, "request_id", reqID)
+	}
+	logger.Log(ctx, level, remark, attrs...)
+
+	if !cfg.LogDBEnabled || level < dbLogLevel {
+		return
+	}
+	logErrorToDB(level, category, errorType, remark, reqID)
+}
+
+// logErrorToDB queues an entry for the errors table. It never blocks:
+// dbLog drops entries when its queue is full. Before the database is open
+// (including when opening it failed) the entry goes to the fallback file.
+func logErrorToDB(level slog.Level, category, errorType, remark, reqID string) {
+	// Use London timezone (UTC+1 for BST in June)
+	timestamp := time.Now().UTC()
+	if london, err := time.LoadLocation("Europe/London"); err == nil {
+		timestamp = time.Now().In(london)
+	} else {
+		remark = fmt.Sprintf("Timezone error: %v; %s", err, remark)
+	}
+
+	e := &store.ErrorEntry{
+		Timestamp: timestamp,
+		Severity:  level.String(),
+		Category:  category,
+		ErrorType: errorType,
+		Remark:    remark,
+		RequestID: reqID,
+	}
+	if dbLog == nil {
+		writeLogFallback(e)
+		return
+	}
+	dbLog.enqueue(e)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// logFlushTimeout bounds how long exiting waits for queued log events
+const logFlushTimeout = 5 * time.Second
+
+// dbLog writes errors table entries in the background; nil when
+// LOG_DB_ENABLED is off or before the database is open
+var dbLog *logWriter
+
+// logWriter batches errors table inserts off the request path. Entries
+// are queued on a bounded channel and written by one goroutine when a batch
+// fills up or the flush interval passes. When the queue is full, entries
+// are dropped rather than blocking the request that logged them.
+type logWriter struct {
+	queue    chan *store.ErrorEntry
+	batch    int
+	interval time.Duration
+	// dropped counts entries lost since the last report
+	dropped atomic.Int64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// startLogWriter starts the background writer sized by LOG_DB_QUEUE_SIZE,
+// LOG_DB_BATCH_SIZE and LOG_DB_FLUSH_INTERVAL
+func startLogWriter(c config) *logWriter {
+	w := &logWriter{
+		queue:    make(chan *store.ErrorEntry, c.LogDBQueueSize),
+		batch:    c.LogDBBatchSize,
+		interval: c.LogDBFlushInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// enqueue queues e without blocking, counting it as dropped if the queue
+// is full
+func (w *logWriter) enqueue(e *store.ErrorEntry) {
+	select {
+	case w.queue <- e:
+	default:
+		w.drop(1, "queue_full")
+	}
+}
+
+func (w *logWriter) drop(n int, reason string) {
+	w.dropped.Add(int64(n))
+	logDroppedTotal.WithLabelValues(reason).Add(float64(n))
+}
+
+func (w *logWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	pending := make([]*store.ErrorEntry, 0, w.batch)
+	flush := func() {
+		if len(pending) > 0 {
+			w.write(pending)
+			pending = pending[:0]
+		}
+		if n := w.dropped.Swap(0); n > 0 {
+			logger.Warn("Dropped log events instead of storing them in the errors table", "count", n)
+		}
+	}
+	for {
+		select {
+		case e := <-w.queue:
+			pending = append(pending, e)
+			if len(pending) >= w.batch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-w.stop:
+			// Drain what was queued before the stop, then exit
+			for {
+				select {
+				case e := <-w.queue:
+					pending = append(pending, e)
+					if len(pending) >= w.batch {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write inserts one batch, appending it to the fallback file when the
+// insert fails. Failures go to the structured logger only, since logging
+// them through logError would queue more entries for the same failing
+// table.
+func (w *logWriter) write(entries []*store.ErrorEntry) {
+	if err := st.LogError(context.Background(), entries...); err != nil {
+		logger.Error("Failed to write to errors table", "count", len(entries), "err", err)
+		if !writeLogFallback(entries...) {
+			w.drop(len(entries), "write_failed")
+		}
+	}
+}
+
+// logFallback is the file errors table entries are appended to when they
+// cannot be inserted, opened on first use
+var logFallback struct {
+	sync.Mutex
+	file *os.File
+}
+
+// fallbackEntry is an errors table row as written to the fallback file,
+// one JSON object per line
+type fallbackEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Severity  string    `json:"severity"`
+	Category  string    `json:"category"`
+	ErrorType string    `json:"error_type"`
+	Remark    string    `json:"remark"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// writeLogFallback appends entries to LOG_DB_FALLBACK_FILE and reports
+// whether they were written
+func writeLogFallback(entries ...*store.ErrorEntry) bool {
+	if cfg.LogDBFallbackFile == "" {
+		return false
+	}
+	logFallback.Lock()
+	defer logFallback.Unlock()
+
+	if logFallback.file == nil {
+		f, err := os.OpenFile(cfg.LogDBFallbackFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			logger.Error("Failed to open log fallback file", "path", cfg.LogDBFallbackFile, "err", err)
+			return false
+		}
+		logFallback.file = f
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		enc.Encode(fallbackEntry{
+			Timestamp: e.Timestamp,
+			Severity:  e.Severity,
+			Category:  e.Category,
+			ErrorType: e.ErrorType,
+			Remark:    e.Remark,
+			RequestID: e.RequestID,
+		})
+	}
+	if _, err := logFallback.file.Write(buf.Bytes()); err != nil {
+		logger.Error("Failed to write log fallback file", "path", cfg.LogDBFallbackFile, "err", err)
+		return false
+	}
+	logFallbackTotal.Add(float64(len(entries)))
+	return true
+}
+
+// flushLogs writes out queued entries, waiting at most timeout. Call it
+// before exiting; entries logged afterwards stay queued and are lost.
+func flushLogs(timeout time.Duration) {
+	if dbLog == nil {
+		return
+	}
+	select {
+	case <-dbLog.stop:
+	default:
+		close(dbLog.stop)
+	}
+	select {
+	case <-dbLog.done:
+	case <-time.After(timeout):
+		logger.Warn("Timed out flushing log events to the errors table")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Supported SMTP_TLS values
+const (
+	smtpStartTLS = "starttls"
+	smtpTLS      = "tls"
+	smtpNoTLS    = "none"
+)
+
+// maxEmailLength is the longest address SMTP allows
+const maxEmailLength = 254
+
+// mailAttachment is a file attached to an email
+type mailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// mailMessage is an email with a plain text and an HTML body
+type mailMessage struct {
+	To          string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []mailAttachment
+	// MessageID is the part of the Message-ID before the @
+	MessageID string
+}
+
+// validateMail checks the SMTP_* settings at startup
+func validateMail(c config) error {
+	if c.SMTPHost == "" {
+		return nil
+	}
+	if c.SMTPTLS != smtpStartTLS && c.SMTPTLS != smtpTLS && c.SMTPTLS != smtpNoTLS {
+		return fmt.Errorf("SMTP_TLS must be %s, %s or %s", smtpStartTLS, smtpTLS, smtpNoTLS)
+	}
+	if c.SMTPFrom == "" {
+		return errors.New("SMTP_FROM is required with SMTP_HOST")
+	}
+	if _, err := mail.ParseAddress(c.SMTPFrom); err != nil {
+		return fmt.Errorf("SMTP_FROM: %v", err)
+	}
+	return nil
+}
+
+// parseEmail checks a requester-supplied address and returns it bare: one
+// address, no display name, nothing that could add headers
+func parseEmail(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > maxEmailLength || strings.ContainsAny(raw, "\r\n") {
+		return "", errors.New("email must be a single address")
+	}
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Name != "" || addr.Address != raw || !strings.Contains(addr.Address, "@") {
+		return "", errors.New("email must be a single address")
+	}
+	return addr.Address, nil
+}
+
+// sendMail delivers m through SMTP_HOST, bounded by SMTP_TIMEOUT
+func sendMail(ctx context.Context, m *mailMessage) error {
+	from, err := mail.ParseAddress(cfg.SMTPFrom)
+	if err != nil {
+		return err
+	}
+	body, err := buildMail(from, m)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.SMTPTimeout)
+	defer cancel()
+	addr := net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort)
+	tlsConfig := &tls.Config{ServerName: cfg.SMTPHost, MinVersion: tls.VersionTLS12}
+	var conn net.Conn
+	if cfg.SMTPTLS == smtpTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if cfg.SMTPTLS == smtpStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if cfg.SMTPUsername != "" {
+		// PlainAuth refuses to send the password without TLS, except to
+		// localhost
+		if err := c.Auth(smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(body); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMail renders m as a MIME message: a multipart/alternative of the
+// text and HTML bodies, wrapped in multipart/mixed when there are
+// attachments
+func buildMail(from *mail.Address, m *mailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	header := textproto.MIMEHeader{}
+	header.Set("From", from.String())
+	header.Set("To", m.To)
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("Message-ID", "<"+m.MessageID+"@"+domain+">")
+	header.Set("MIME-Version", "1.0")
+	// Asks auto-responders not to reply to a message nobody reads
+	header.Set("Auto-Submitted", "auto-generated")
+
+	top := multipart.NewWriter(&buf)
+	if len(m.Attachments) == 0 {
+		header.Set("Content-Type", "multipart/alternative; boundary="+top.Boundary())
+		writeMailHeader(&buf, header)
+		if err := writeAlternatives(top, m); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	header.Set("Content-Type", "multipart/mixed; boundary="+top.Boundary())
+	writeMailHeader(&buf, header)
+	var altBuf bytes.Buffer
+	alt := multipart.NewWriter(&altBuf)
+	if err := writeAlternatives(alt, m); err != nil {
+		return nil, err
+	}
+	part, err := top.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + alt.Boundary()}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write(altBuf.Bytes())
+	for _, a := range m.Attachments {
+		part, err := top.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, a.Data)
+	}
+	if err := top.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeAlternatives writes the text and HTML bodies, quoted-printable, and
+// closes w
+func writeAlternatives(w *multipart.Writer, m *mailMessage) error {
+	for _, body := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qp := quotedprintable.NewWriter(part)
+		qp.Write([]byte(body.content))
+		if err := qp.Close(); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// writeMailHeader writes the top-level header in a stable order
+func writeMailHeader(buf *bytes.Buffer, h textproto.MIMEHeader) {
+	for _, k := range []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Auto-Submitted", "Content-Type"} {
+		fmt.Fprintf(buf, "%s: %s\r\n", k, h.Get(k))
+	}
+	buf.WriteString("\r\n")
+}
+
+// writeBase64Lines writes data as base64 in lines of 76 characters
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
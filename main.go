@@ -1,237 +1,304 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
-	"html"
 	"net/http"
-	"net/url"
 	"os"
 	"regexp"
-	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/Sathimantha/getVerification/store"
 )
 
-var db *sql.DB
+// st is the data layer; all SQL lives in the store package
+var st store.Store
 var digitRegex = regexp.MustCompile(`^\d+$`)
+var idRegex = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
 
-// charToWord maps characters to their spoken form for digit-by-digit reading
-var charToWord = map[rune]string{
-	'0': "zero",
-	'1': "one",
-	'2': "two",
-	'3': "three",
-	'4': "four",
-	'5': "five",
-	'6': "six",
-	'7': "seven",
-	'8': "eight",
-	'9': "nine",
-	'v': "vee",
-	'V': "vee",
-}
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Error loading .env file: %v", err))
+		os.Exit(1)
+	}
 
-// stripHTML removes HTML tags and converts <br> to periods for natural speech
-func stripHTML(input string) string {
-	// Replace <br> with periods
-	input = strings.ReplaceAll(input, "<br>", ". ")
-	// Simple regex to remove HTML tags
-	re := regexp.MustCompile(`<[^>]+>`)
-	clean := re.ReplaceAllString(input, "")
-	// Remove extra spaces and normalize
-	clean = strings.TrimSpace(clean)
-	clean = regexp.MustCompile(`\s+`).ReplaceAllString(clean, " ")
-	return clean
-}
+	cfg = loadConfig()
+	if err := initLogging(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Failed to initialise logging: %v", err))
+		os.Exit(1)
+	}
 
-// logError inserts an entry into the errors table
-func logError(errorType, remark string) {
-	// Use London timezone (UTC+1 for BST in June)
-	london, err := time.LoadLocation("Europe/London")
+	var templateDir string
+	pages, templateDir, err = loadTemplates(cfg.TemplatesDir)
 	if err != nil {
-		// Fallback to UTC if timezone loading fails
-		timestamp := time.Now().UTC()
-		_, dbErr := db.Exec("INSERT INTO errors (timestamp, error_type, remark) VALUES (?, ?, ?)", timestamp, errorType, fmt.Sprintf("Timezone error: %v; %s", err, remark))
-		if dbErr != nil {
-			// Silent fail to avoid disrupting response
-		}
-		return
+		logError("STARTUP_ERROR", fmt.Sprintf("Failed to load templates: %v", err))
+		os.Exit(1)
+	}
+	if templateDir != "" {
+		logError("TEMPLATE_LOAD_SUCCESS", "Loaded page templates from "+templateDir)
 	}
-	timestamp := time.Now().In(london)
 
-	query := `INSERT INTO errors (timestamp, error_type, remark) VALUES (?, ?, ?)`
-	_, err = db.Exec(query, timestamp, errorType, remark)
-	if err != nil {
-		// Silent fail to avoid disrupting response
+	if err := cfg.Brand.validate(); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid BRAND_* setting: %v", err))
+		os.Exit(1)
 	}
-}
 
-func main() {
-	err := godotenv.Load()
-	if err != nil {
-		logError("STARTUP_ERROR", fmt.Sprintf("Error loading .env file: %v", err))
+	if err := validateOIDC(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid OIDC_* setting: %v", err))
 		os.Exit(1)
 	}
 
-	dbUser := os.Getenv("DB_USERNAME")
-	dbPass := os.Getenv("DB_PASSWORD")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
+	if err := validateCaptcha(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid CAPTCHA_* setting: %v", err))
+		os.Exit(1)
+	}
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", dbUser, dbPass, dbHost, dbPort, dbName)
-	db, err = sql.Open("mysql", dsn)
-	if err != nil {
-		logError("DB_CONNECTION_ERROR", fmt.Sprintf("Failed to connect to DB: %v", err))
+	if err := validateMail(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid SMTP_* setting: %v", err))
 		os.Exit(1)
 	}
-	defer db.Close()
 
-	r := mux.NewRouter()
+	if err := validateReports(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid REPORT_* setting: %v", err))
+		os.Exit(1)
+	}
 
-	// Define routes
-	r.HandleFunc("/verify", verifyHandler).Methods("GET")
-	r.HandleFunc("/twilio/verify", twilioVerifyHandler).Methods("POST")
+	if err := validateAttachments(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid ATTACHMENT_* setting: %v", err))
+		os.Exit(1)
+	}
 
-	// Apply CORS only to /verify for frontend
-	corsHandler := handlers.CORS(
-		handlers.AllowedOrigins([]string{"https://hogwarts-legacy.info"}),
-		handlers.AllowedMethods([]string{"GET", "POST"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Accept"}),
-	)
+	if err := validateJobs(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid JOB_* setting: %v", err))
+		os.Exit(1)
+	}
 
-	// Wrap the entire router with CORS handler
-	http.Handle("/", corsHandler(r))
+	if err := validateTwilioAPI(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid TWILIO_* setting: %v", err))
+		os.Exit(1)
+	}
 
-	certFile := os.Getenv("CERT_FILE")
-	keyFile := os.Getenv("KEY_FILE")
-	if certFile == "" || keyFile == "" {
-		logError("CONFIG_ERROR", "CERT_FILE or KEY_FILE not defined in .env")
+	if err := validateTwilioVoice(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid TWILIO_* setting: %v", err))
 		os.Exit(1)
 	}
 
-	err = http.ListenAndServeTLS(":5001", certFile, keyFile, nil)
-	if err != nil {
-		logError("SERVER_ERROR", fmt.Sprintf("Server failed: %v", err))
+	if err := validateTwilioMenu(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid TWILIO_* setting: %v", err))
 		os.Exit(1)
 	}
-}
 
-func twilioVerifyHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse form data
-	if err := r.ParseForm(); err != nil {
-		logError("TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
-		return
+	if err := validateTwilioIPs(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid TWILIO_* setting: %v", err))
+		os.Exit(1)
 	}
 
-	// Extract input from direct form fields (case-insensitive)
-	input := r.PostFormValue("Digits")
-	if input == "" {
-		input = r.PostFormValue("digits")
+	if err := validateTelegram(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid TELEGRAM_* setting: %v", err))
+		os.Exit(1)
 	}
-	if input == "" {
-		input = r.PostFormValue("SpeechResult")
+
+	if err := validateBroker(cfg); err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid EVENTS_* setting: %v", err))
+		os.Exit(1)
 	}
-	if input == "" {
-		input = r.PostFormValue("speechresult")
+
+	cipher, err := loadCipher(cfg)
+	if err != nil {
+		logError("STARTUP_ERROR", fmt.Sprintf("Invalid ENCRYPTION_KEYS setting: %v", err))
+		os.Exit(1)
 	}
 
-	// Fallback: Check if 'body' parameter contains Digits and SpeechResult
-	if input == "" {
-		body := r.PostFormValue("body")
-		if body != "" {
-			// Remove leading '?' if present
-			body = strings.TrimPrefix(body, "?")
-			// Decode URL-encoded body
-			parsed, err := url.ParseQuery(body)
-			if err != nil {
-				logError("TWILIO_INVALID_BODY", fmt.Sprintf("Failed to parse body parameter: %v", err))
-				http.Error(w, "Invalid body parameter", http.StatusBadRequest)
-				return
-			}
-			input = parsed.Get("Digits")
-			if input == "" {
-				input = parsed.Get("digits")
-			}
-			if input == "" {
-				input = parsed.Get("SpeechResult")
-			}
-			if input == "" {
-				input = parsed.Get("speechresult")
+	sqlStore, err := store.Open(cfg.DBDriver, cfg.dsn(), cfg.pool())
+	if err != nil {
+		logError("DB_CONNECTION_ERROR", fmt.Sprintf("Failed to connect to DB: %v", err))
+		os.Exit(1)
+	}
+	sqlStore.Observe = observeQuery
+	sqlStore.QueryTimeout = cfg.DBQueryTimeout
+	sqlStore.Cipher = cipher
+	sqlStore.Retries = cfg.DBRetries
+	sqlStore.RetryBackoff = cfg.DBRetryBackoff
+	sqlStore.OnRetry = observeRetry
+	sqlStore.ReplicaRetry = cfg.DBReplicaRetry
+	sqlStore.OnReplica = observeReplica
+	sqlStore.SearchLimit = cfg.SearchMaxCandidates
+	if dsn := cfg.replicaDSN(); dsn != "" {
+		// An unreachable replica is not fatal: lookups use the primary
+		// until it answers
+		if err := sqlStore.OpenReplica(dsn, cfg.pool()); err != nil {
+			logError("DB_REPLICA_ERROR", fmt.Sprintf("Read replica unavailable, reading from the primary: %v", err))
+		} else {
+			dbReplicaUp.Set(1)
+		}
+	}
+	if cfg.DBBreakerThreshold > 0 {
+		dbBreaker = store.NewBreaker(cfg.DBBreakerThreshold, cfg.DBBreakerCooldown)
+		dbBreaker.OnChange = observeBreaker
+		sqlStore.Breaker = dbBreaker
+	}
+	shared := store.NewSharedStore(sqlStore)
+	shared.OnShared = observeSharedLookup
+	st = shared
+
+	if cfg.RedisURL != "" {
+		redisClient, err = openRedis(cfg.RedisURL)
+		if err != nil {
+			logError("REDIS_CONNECTION_ERROR", fmt.Sprintf("Failed to connect to Redis: %v", err))
+			os.Exit(1)
+		}
+		defer redisClient.Close()
+		counters = &redisCounter{client: redisClient}
+		buckets = &redisBuckets{client: redisClient}
+		callSessions = &redisCallSessions{client: redisClient}
+	}
+
+	if cfg.CacheTTL > 0 && cfg.CacheSize > 0 {
+		lookupCache = store.NewMemoryCache(cfg.CacheSize, cfg.CacheTTL)
+		var cache store.Cache = lookupCache
+		if redisClient != nil {
+			rc := store.NewRedisCache(redisClient, cfg.CacheTTL)
+			rc.OnError = func(op string, err error) {
+				logError("CACHE_ERROR", fmt.Sprintf("Redis cache %s failed: %v", op, err))
 			}
+			cache = rc
 		}
+		cached := store.NewCachedStore(shared, cache)
+		cached.OnLookup = observeCacheLookup
+		st = cached
 	}
+	defer st.Close()
 
-	if input == "" {
-		logError("TWILIO_NO_INPUT", "No input provided in Digits or SpeechResult")
-		http.Error(w, "No input provided", http.StatusBadRequest)
-		return
+	if cfg.LogDBEnabled {
+		dbLog = startLogWriter(cfg)
 	}
 
-	// Remove spaces and normalize input
-	input = strings.ReplaceAll(input, " ", "")
+	// "getVerification migrate" applies schema migrations and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		code := runMigrateCommand(sqlStore, os.Args[2:])
+		flushLogs(logFlushTimeout)
+		os.Exit(code)
+	}
+	if cfg.DBAutoMigrate {
+		ran, err := st.Migrate(context.Background())
+		if err != nil {
+			logError("MIGRATION_ERROR", fmt.Sprintf("Failed to migrate database: %v", err))
+			flushLogs(logFlushTimeout)
+			os.Exit(1)
+		}
+		for _, name := range ran {
+			logError("MIGRATION_SUCCESS", "Applied migration "+name)
+		}
+	}
 
-	// Validate input (alphanumeric, max 50 chars)
-	if len(input) > 50 || !regexp.MustCompile(`^[a-zA-Z0-9]+$`).MatchString(input) {
-		w.Header().Set("Content-Type", "application/xml")
-		twiml := `<?xml version="1.0" encoding="UTF-8"?>
-<Response>
-	<Say>Invalid input format. Please use only numbers or letters.</Say>
-</Response>`
-		logError("TWILIO_INVALID_INPUT", fmt.Sprintf("Invalid input format: %s", input))
-		w.Write([]byte(twiml))
-		return
+	// "getVerification rotate-keys" re-encrypts stored names and remarks
+	// with the current ENCRYPTION_KEYS key and exits
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		code := runRotateKeysCommand(sqlStore, os.Args[2:])
+		flushLogs(logFlushTimeout)
+		os.Exit(code)
 	}
 
-	// Convert input to digit-by-digit spoken form
-	var spokenInput []string
-	for _, char := range input {
-		if word, exists := charToWord[char]; exists {
-			spokenInput = append(spokenInput, word)
-		} else {
-			spokenInput = append(spokenInput, string(char))
-		}
+	hooks = startWebhooks(cfg)
+	broker = startBroker(cfg)
+	startTwilioIPRanges(cfg)
+	startMessageTemplates()
+	startJobs(cfg)
+
+	r := mux.NewRouter()
+	r.Use(metricsMiddleware)
+	r.Use(institutionMiddleware)
+
+	// Access rules, rate limits and enumeration lockout: /verify per client
+	// IP, Twilio lookups per caller number.
+	// Twilio limits run after signature validation so forged requests
+	// cannot use up a real caller's allowance. Maintenance mode and the
+	// database circuit breaker turn lookups away before any of them.
+	twilioLimit := rateLimit{Name: "twilio", Rate: cfg.RateLimitTwilio, Burst: cfg.RateLimitTwilioBurst}
+	webAccess := withAccessRules(store.AccessIP, clientIP, denyBlockedWeb)
+	webAvailable := chain(withMaintenance(denyMaintenanceWeb), withBreaker(denyBusyWeb))
+	limitVerify := chain(webAvailable, webAccess, withRateLimit(verifyRateLimit(), clientIP, denyVerify), withLockout(clientIP, denyVerify))
+	limitWeb := chain(webAccess, withRateLimit(verifyRateLimit(), clientIP, denyVerify))
+	limitVoice := chain(withRateLimit(twilioLimit, twilioClientKey, denyTwilioVoice), withLockout(twilioClientKey, denyTwilioVoice))
+	limitMessage := chain(withRateLimit(twilioLimit, twilioClientKey, denyTwilioMessage), withLockout(twilioClientKey, denyTwilioMessage))
+	// Telegram senders get the same allowance as SMS senders
+	telegramLimit := rateLimit{Name: "telegram", Rate: cfg.RateLimitTwilio, Burst: cfg.RateLimitTwilioBurst}
+	limitTelegram := chain(requireTelegramUpdate, withMaintenance(denyMaintenanceTelegram), withBreaker(denyBusyTelegram), withAccessRules(store.AccessCaller, telegramCaller, denyBlockedTelegram), withRateLimit(telegramLimit, telegramClientKey, denyTelegram), withLockout(telegramClientKey, denyTelegram))
+
+	// Twilio webhooks are small forms; bound them before anything parses
+	// them, and refuse addresses outside Twilio's ranges before that. Status
+	// callbacks report on calls already answered, so access rules do not
+	// apply to them.
+	twilioCallback := chain(requireTwilioIP, limitForm(int64(cfg.MaxFormBytes)), requireTwilioSignature)
+	twilio := chain(twilioCallback, withCallSession, withMaintenance(denyMaintenanceTwilio), withBreaker(denyBusyTwilio), withAnsweringMachine, withAccessRules(store.AccessCaller, twilioCaller, denyBlockedTwilio))
+
+	// Define routes
+	r.HandleFunc("/verify", limitVerify(requireCaptcha(verifyHandler))).Methods("GET")
+	r.HandleFunc("/verify/shared", webAvailable(limitWeb(sharedVerifyHandler))).Methods("GET")
+	r.HandleFunc("/verify/email", chain(webAvailable, webAccess, withRateLimit(emailRateLimit(), clientIP, denyVerify))(verifyEmailHandler)).Methods("POST")
+	r.HandleFunc("/twilio/verify", twilio(limitVoice(twilioVerifyHandler))).Methods("POST")
+	r.HandleFunc("/twilio/language", twilio(twilioLanguageHandler)).Methods("POST")
+	r.HandleFunc("/twilio/menu", twilio(twilioMenuHandler)).Methods("POST")
+	r.HandleFunc("/twilio/menu/dialed", twilio(twilioDialedHandler)).Methods("POST")
+	r.HandleFunc("/twilio/confirm", twilio(limitVoice(twilioConfirmHandler))).Methods("POST")
+	r.HandleFunc("/twilio/result", twilio(limitVoice(twilioResultHandler))).Methods("POST")
+	r.HandleFunc("/twilio/outbound", twilio(twilioOutboundHandler)).Methods("POST")
+	r.HandleFunc("/twilio/status", twilioCallback(twilioStatusHandler)).Methods("POST")
+	r.HandleFunc("/twilio/sms", twilio(limitMessage(twilioSMSHandler))).Methods("POST")
+	r.HandleFunc("/twilio/whatsapp", twilio(limitMessage(twilioWhatsAppHandler))).Methods("POST")
+	r.HandleFunc("/telegram/webhook", limitTelegram(telegramHandler)).Methods("POST")
+	r.HandleFunc("/qr/{id}.png", limitWeb(qrHandler)).Methods("GET")
+	r.HandleFunc("/healthz", healthHandler).Methods("GET", "HEAD")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	// Versioned JSON API
+	registerAPIv1(r.PathPrefix("/api/v1").Subrouter())
+
+	// HTML admin pages
+	registerAdmin(r)
+
+	// Apply CORS only to /verify for frontend
+	corsHandler := handlers.CORS(
+		handlers.AllowedOrigins([]string{"https://hogwarts-legacy.info"}),
+		handlers.AllowedMethods([]string{"GET", "POST"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Accept", "Authorization", captchaHeader}),
+		handlers.ExposedHeaders([]string{requestIDHeader}),
+	)
+
+	if cfg.TLSEnabled && len(cfg.AutocertDomains) == 0 && (cfg.CertFile == "" || cfg.KeyFile == "") {
+		logError("CONFIG_ERROR", "CERT_FILE or KEY_FILE not defined in .env (set AUTOCERT_DOMAINS, or TLS_ENABLED=false to serve plain HTTP)")
+		flushLogs(logFlushTimeout)
+		os.Exit(1)
 	}
-	spokenInputStr := strings.Join(spokenInput, " ")
 
-	var fullName, category, remark string
-	// Use LIKE to match input with or without trailing 'v'
-	queryStr := `SELECT full_name, category, remark FROM people WHERE national_id LIKE ? LIMIT 1`
-	err := db.QueryRow(queryStr, input+"%").Scan(&fullName, &category, &remark)
+	accessLog, err := newAccessLogger(cfg)
 	if err != nil {
-		logError("TWILIO_DB_ERROR", fmt.Sprintf("Database error for input %s: %v", input, err))
+		logError("CONFIG_ERROR", err.Error())
+		flushLogs(logFlushTimeout)
+		os.Exit(1)
+	}
+
+	// Wrap the entire router with CORS handler
+	if err := serve(cfg, requestIDMiddleware(accessLog.middleware(securityHeaders(cfg, corsHandler(r))))); err != nil {
+		logError("SERVER_ERROR", fmt.Sprintf("Server failed: %v", err))
+		flushLogs(logFlushTimeout)
+		os.Exit(1)
 	}
+}
 
-	w.Header().Set("Content-Type", "application/xml")
-	if err == nil {
-		// Adjust category text for natural speech
-		categoryText := "student"
-		if category == "staff" {
-			categoryText = "staff member"
+// chain composes handler wrappers; the first runs outermost
+func chain(wrappers ...func(http.HandlerFunc) http.HandlerFunc) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		for i := len(wrappers) - 1; i >= 0; i-- {
+			h = wrappers[i](h)
 		}
-		// Clean remark by removing HTML tags
-		cleanRemark := stripHTML(remark)
-		// Generate TwiML with digit-by-digit input, name, category, and remark
-		twiml := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<Response>
-	<Say>You entered %s. The name is %s. The category is %s. Remark: %s.</Say>
-</Response>`, spokenInputStr, fullName, categoryText, cleanRemark)
-		logError("TWILIO_SUCCESS", fmt.Sprintf("Verified input: %s, Name: %s, Category: %s, Remark: %s", input, fullName, categoryText, cleanRemark))
-		w.Write([]byte(twiml))
-	} else {
-		// Generate TwiML for no match, including digit-by-digit input
-		twiml := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<Response>
-	<Say>Sorry, no match found for %s.</Say>
-</Response>`, spokenInputStr)
-		logError("TWILIO_NO_MATCH", fmt.Sprintf("No match found for input: %s", input))
-		w.Write([]byte(twiml))
+		return h
 	}
 }
 
@@ -239,72 +306,120 @@ func isDigits(s string) bool {
 	return digitRegex.MatchString(s)
 }
 
+// validID reports whether id is alphanumeric and at most 50 characters
+func validID(id string) bool {
+	return len(id) <= 50 && idRegex.MatchString(id)
+}
+
 func verifyHandler(w http.ResponseWriter, r *http.Request) {
+	// Response format depends on the Accept header
+	w.Header().Add("Vary", "Accept")
+
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		logError("VERIFY_NO_ID", "No ID provided in query parameter")
-		http.Error(w, "ID is required", http.StatusBadRequest)
+		logErrorContext(r.Context(), "VERIFY_NO_ID", "No ID provided in query parameter")
+		recordAudit(r, auditChannelWeb, id, callResultInvalid, "")
+		verifyError(w, r, "ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Validate ID format (alphanumeric, max 50 chars)
-	if len(id) > 50 || !regexp.MustCompile(`^[a-zA-Z0-9]+$`).MatchString(id) {
-		logError("VERIFY_INVALID_ID", fmt.Sprintf("Invalid ID format: %s", id))
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+	if !validID(id) {
+		logErrorContext(r.Context(), "VERIFY_INVALID_ID", fmt.Sprintf("Invalid ID format: %s", maskID(id)))
+		recordAudit(r, auditChannelWeb, id, callResultInvalid, "")
+		verifyError(w, r, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+
+	idType, err := idTypeParam(r)
+	if err != nil {
+		verifyError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name, ok := verifyNameParam(r)
+	if !ok {
+		recordAudit(r, auditChannelWeb, id, callResultInvalid, "")
+		verifyError(w, r, nameRequiredMsg, http.StatusBadRequest)
+		return
+	}
+
+	ids, err := lookupIDs(id, idType)
+	if err != nil {
+		logErrorContext(r.Context(), "VERIFY_INVALID_NIC", fmt.Sprintf("Invalid NIC %s: %v", maskID(id), err))
+		recordAudit(r, auditChannelWeb, id, callResultInvalid, "")
+		verifyError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var fullName, category, remark string
-	query := `SELECT full_name, category, remark FROM people WHERE national_id = ? LIMIT 1`
-	err := db.QueryRow(query, id).Scan(&fullName, &category, &remark)
-	if err == sql.ErrNoRows {
-		logError("VERIFY_NOT_FOUND", fmt.Sprintf("Person not found for ID: %s", id))
-		http.Error(w, "Person not found", http.StatusNotFound)
+	p, err := findPerson(r.Context(), ids, idType)
+	if err == store.ErrNotFound {
+		logErrorContext(r.Context(), "VERIFY_NOT_FOUND", fmt.Sprintf("Person not found for ID: %s", maskID(id)))
+		noteLookup(r.Context(), false, 1)
+		recordAudit(r, auditChannelWeb, id, callResultNoMatch, "")
+		verifyError(w, r, "Person not found", http.StatusNotFound)
+		return
+	} else if breakerRefused(w, err) {
+		denyBusyWeb(w, r)
 		return
 	} else if err != nil {
-		logError("VERIFY_DB_ERROR", fmt.Sprintf("Database error for ID %s: %v", id, err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		logErrorContext(r.Context(), "VERIFY_DB_ERROR", fmt.Sprintf("Database error for ID %s: %v", maskID(id), err))
+		recordAudit(r, auditChannelWeb, id, callResultError, "")
+		verifyError(w, r, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// A wrong name gets exactly the not-found response, and counts towards
+	// the lockout like one
+	if cfg.VerifyRequireName && !nameMatches(p.FullName, name) {
+		logErrorContext(r.Context(), "VERIFY_NAME_MISMATCH", fmt.Sprintf("Name did not match for ID: %s", maskID(id)))
+		noteLookup(r.Context(), false, 1)
+		recordAudit(r, auditChannelWeb, id, callResultNameMismatch, p.NationalID)
+		verifyError(w, r, "Person not found", http.StatusNotFound)
+		return
+	}
+
+	noteLookup(r.Context(), true, 0)
+	if restricted(r, p) {
+		logErrorContext(r.Context(), "VERIFY_RESTRICTED", fmt.Sprintf("ID %s opted out of public lookups", maskID(id)))
+		recordAudit(r, auditChannelWeb, id, callResultRestricted, p.NationalID)
+		verifyError(w, r, registrarOnlyNotice, http.StatusForbidden)
+		return
+	}
+	check := recordMatch(r, auditChannelWeb, id, p)
+	logErrorContext(r.Context(), "VERIFY_SUCCESS", fmt.Sprintf("Verified ID: %s, Name: %s, Category: %s, Remark: %s, Reference: %s", maskID(id), piiText(p.FullName), p.Category, piiText(remarkText(remarksFor(p, check.Channel))), check.Reference))
+	writeVerification(w, r, id, p, check)
+}
+
+// writeVerification renders a found person as HTML or, when the client
+// asks for it, JSON. id is echoed back as the requested ID and check is the
+// audit row carrying the verification reference.
+func writeVerification(w http.ResponseWriter, r *http.Request, id string, p *store.Person, check *store.AuditEntry) {
+	c, remarks := categoryOf(r.Context(), p.Category), remarksFor(p, check.Channel)
+	if wantsJSON(r) {
+		resp := verifyResponse{
+			ID:            id,
+			FullName:      p.FullName,
+			Category:      p.Category,
+			CategoryLabel: c.Label,
+			Remark:        remarkText(remarks),
+			Remarks:       toAPIRemarks(remarks),
+			IssueDate:     formatDate(p.IssueDate),
+			ExpiryDate:    formatDate(p.ExpiryDate),
+			Expired:       p.Expired(time.Now()),
+			Revoked:       p.Revoked,
+			Verified:      !p.Revoked,
+			Status:        verificationStatus(p),
+			Reference:     check.Reference,
+			CheckedAt:     check.CreatedAt,
+		}
+		if c.ShowCourses {
+			resp.Courses = p.Courses
+		}
+		writeJSON(w, http.StatusOK, resp)
 		return
 	}
 
-	// Escape values to prevent XSS
-	safeID := html.EscapeString(id)
-	safeName := html.EscapeString(fullName)
-
-	var htmlResponse string
-	if category == "student" {
-		htmlResponse = fmt.Sprintf(`<div style="font-family: Arial, sans-serif; line-height: 1.6; padding: 10px;">
-			<strong>ID:</strong> %s<br>
-			<strong>FULL NAME:</strong> %s<br>
-			<strong>COURSES COMPLETED:</strong><br>
-			<ul>
-				<li>Introduction to Basic Psychology (One Hour Workshop)</li>
-				<li>Introduction to Career Guidance (One Hour Workshop)</li>
-				<li>Introduction to Basic Counselling (One Hour Workshop)</li>
-				<li>Introduction to Basic IT (One Hour Workshop)</li>
-				<li>Introduction to Basic Business Management (One Hour Workshop)</li>
-				<li>Introduction to Basic Spoken English (One Hour Workshop)</li>
-				<li>Introduction to Memory Boosting (One Hour Workshop)</li>
-				<li>Introduction to Basic Personality Development (One Hour Workshop)</li>
-				<li>Introduction to Entrepreneurship (One Hour Workshop)</li>
-				<li>Introduction to Basic Body Language (One Hour Workshop)</li>
-				<li>Introduction to Basic Counselling Skills (One Hour Workshop)</li>
-				<li>Introduction to Basic Human Resource Management (One Hour Workshop)</li>
-				<li>Introduction to Basic Teaching Methodologies (One Hour Workshop)</li>
-				<li>Introduction to Basic Marketing Management (One Hour Workshop)</li>
-			</ul>
-			<strong>APPROVED AND VERIFIED:</strong> YES
-		</div>`, safeID, safeName)
-	} else {
-		htmlResponse = fmt.Sprintf(`<div style="font-family: Arial, sans-serif; line-height: 1.6; padding: 10px;">
-			<strong>ID:</strong> %s<br>
-			<strong>FULL NAME:</strong> %s<br>
-			<strong>REMARKS:</strong><br>
-			%s
-		</div>`, safeID, safeName, remark)
-	}
-
-	logError("VERIFY_SUCCESS", fmt.Sprintf("Verified ID: %s, Name: %s, Category: %s, Remark: %s", id, fullName, category, remark))
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(htmlResponse))
+	// html/template escapes every field except the admin-entered remark
+	renderPage(w, http.StatusOK, c.Template, newVerifyPage(r, id, p, check))
 }
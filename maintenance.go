@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// settingMaintenance is the settings table row that turns maintenance mode
+// on for every instance
+const settingMaintenance = "maintenance.enabled"
+
+// maintenanceRefresh is how long the maintenance setting is cached, so a
+// switch made through another instance applies within seconds
+const maintenanceRefresh = 10 * time.Second
+
+// maintenanceTimeout bounds the settings read, which is likely to hang
+// while the database is being worked on
+const maintenanceTimeout = time.Second
+
+// maintenanceMsg answers lookups on the web endpoints in maintenance mode
+const maintenanceMsg = "The verification service is down for maintenance. Please try again shortly."
+
+var maintenanceCache struct {
+	sync.Mutex
+	enabled bool
+	loaded  time.Time
+}
+
+// apiMaintenance is the v1 JSON schema for /maintenance. Enabled is true
+// when either the setting or MAINTENANCE_FILE turns maintenance mode on.
+type apiMaintenance struct {
+	Enabled     bool   `json:"enabled"`
+	Setting     bool   `json:"setting"`
+	File        string `json:"file,omitempty"`
+	FilePresent bool   `json:"file_present"`
+}
+
+// inMaintenance reports whether lookups should be turned away: the setting
+// is on or MAINTENANCE_FILE exists
+func inMaintenance(ctx context.Context) bool {
+	return maintenanceFilePresent() || maintenanceSetting(ctx)
+}
+
+// maintenanceFilePresent reports whether MAINTENANCE_FILE exists. It is
+// checked on every request so creating or removing the file applies at
+// once, even when the database is unreachable.
+func maintenanceFilePresent() bool {
+	if cfg.MaintenanceFile == "" {
+		return false
+	}
+	_, err := os.Stat(cfg.MaintenanceFile)
+	return err == nil
+}
+
+// maintenanceSetting returns the cached maintenance setting. A failed
+// reload keeps the last value, so an outage does not switch it off.
+func maintenanceSetting(ctx context.Context) bool {
+	maintenanceCache.Lock()
+	defer maintenanceCache.Unlock()
+	if !maintenanceCache.loaded.IsZero() && time.Since(maintenanceCache.loaded) < maintenanceRefresh {
+		return maintenanceCache.enabled
+	}
+	enabled, err := loadMaintenance(ctx)
+	if err != nil {
+		logErrorContext(ctx, "MAINTENANCE_ERROR", fmt.Sprintf("Failed to load maintenance setting: %v", err))
+		enabled = maintenanceCache.enabled
+	}
+	maintenanceCache.enabled, maintenanceCache.loaded = enabled, time.Now()
+	return enabled
+}
+
+// loadMaintenance reads the maintenance setting from the settings table
+func loadMaintenance(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, maintenanceTimeout)
+	defer cancel()
+	values, err := st.GetSettings(ctx, settingMaintenance)
+	if err != nil {
+		return false, err
+	}
+	return values[settingMaintenance] == "true", nil
+}
+
+// resetMaintenance makes the next request read the settings table again
+func resetMaintenance() {
+	maintenanceCache.Lock()
+	maintenanceCache.loaded = time.Time{}
+	maintenanceCache.Unlock()
+}
+
+// withMaintenance answers requests with deny instead of next while in
+// maintenance mode
+func withMaintenance(deny func(http.ResponseWriter, *http.Request)) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !inMaintenance(r.Context()) {
+				next(w, r)
+				return
+			}
+			maintenanceDeniedTotal.Inc()
+			deny(w, r)
+		}
+	}
+}
+
+// denyMaintenanceWeb shows the maintenance page, or a JSON error to JSON
+// clients, with a 503
+func denyMaintenanceWeb(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: maintenanceMsg})
+		return
+	}
+	page := errorPage{pageContext: newPageContext(r), Status: http.StatusServiceUnavailable}
+	page.Message = pageErrorMsg(page.Lang, maintenanceMsg)
+	renderPage(w, http.StatusServiceUnavailable, pageMaintenance, page)
+}
+
+// denyMaintenanceAPI answers an API lookup in maintenance mode
+func denyMaintenanceAPI(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusServiceUnavailable, "maintenance", maintenanceMsg)
+}
+
+// denyMaintenanceTwilio apologises to a caller and hangs up, or answers a
+// message, with a 200 TwiML reply as denyTwilioVoice does
+func denyMaintenanceTwilio(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("MessageSid") != "" {
+		writeTwiML(w, twimlMessage{Body: textMsg("maintenance")})
+		return
+	}
+	lang := twilioLang(r)
+	writeTwiML(w, say(lang, twilioMsg(lang, "maintenance")), twimlHangup{})
+}
+
+// denyMaintenanceTelegram answers a Telegram sender in maintenance mode
+func denyMaintenanceTelegram(w http.ResponseWriter, r *http.Request) {
+	writeTelegramReply(w, r, textMsg("maintenance"))
+}
+
+// apiMaintenanceHandler reports whether maintenance mode is on and why
+func apiMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	enabled, err := loadMaintenance(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "MAINTENANCE_ERROR", fmt.Sprintf("Failed to load maintenance setting: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	writeJSON(w, http.StatusOK, currentMaintenance(enabled))
+}
+
+// currentMaintenance combines the setting with MAINTENANCE_FILE
+func currentMaintenance(setting bool) apiMaintenance {
+	m := apiMaintenance{Setting: setting, File: cfg.MaintenanceFile, FilePresent: maintenanceFilePresent()}
+	m.Enabled = m.Setting || m.FilePresent
+	return m
+}
+
+// apiSetMaintenanceHandler turns the maintenance setting on or off with
+// {"enabled": true|false}. It cannot override MAINTENANCE_FILE, which has
+// to be removed to leave maintenance mode.
+func apiSetMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); (err != nil && err != io.EOF) || body.Enabled == nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"enabled": true|false}`)
+		return
+	}
+
+	value := ""
+	if *body.Enabled {
+		value = "true"
+	}
+	if err := st.SetSettings(r.Context(), map[string]string{settingMaintenance: value}); err != nil {
+		logErrorContext(r.Context(), "MAINTENANCE_ERROR", fmt.Sprintf("Failed to save maintenance setting: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	maintenanceCache.Lock()
+	maintenanceCache.enabled, maintenanceCache.loaded = *body.Enabled, time.Now()
+	maintenanceCache.Unlock()
+
+	m := currentMaintenance(*body.Enabled)
+	state := "off"
+	if m.Setting {
+		state = "on"
+	}
+	logErrorContext(r.Context(), "MAINTENANCE_SUCCESS", "Turned maintenance mode "+state)
+	publishChange(r.Context(), eventMaintenanceUpdated, "", m)
+	writeJSON(w, http.StatusOK, m)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// Channels of the message catalogs: spoken voice prompts, HTML page (and
+// email) text, and plain-text SMS, WhatsApp and Telegram replies
+const (
+	messageChannelVoice = "voice"
+	messageChannelWeb   = "web"
+	messageChannelText  = "text"
+)
+
+// maxMessageTemplateLength bounds a template, in characters
+const maxMessageTemplateLength = 1000
+
+// messageCatalogs are the built-in messages of each channel, by language
+// and key. Templates stored in message_templates override them.
+var messageCatalogs = map[string]map[string]map[string]string{
+	messageChannelVoice: twilioMessages,
+	messageChannelWeb:   pageMessages,
+	messageChannelText:  textMessages,
+}
+
+// messageVerbPattern matches the fmt verbs a message is formatted with
+var messageVerbPattern = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+// messageTemplateKey identifies a template; Institution is "" for the
+// deployment's own wording
+type messageTemplateKey struct {
+	Institution, Channel, Lang, Key string
+}
+
+// messageTemplates holds the stored templates last read from the table
+var messageTemplates struct {
+	sync.RWMutex
+	templates map[messageTemplateKey]store.MessageTemplate
+}
+
+// apiMessageTemplate is the v1 JSON schema for one message: the wording in
+// use, the built-in default and, when it is overridden, who changed it
+type apiMessageTemplate struct {
+	Institution string     `json:"institution"`
+	Channel     string     `json:"channel"`
+	Lang        string     `json:"lang"`
+	Key         string     `json:"key"`
+	Text        string     `json:"text"`
+	Default     string     `json:"default"`
+	Overridden  bool       `json:"overridden"`
+	UpdatedBy   string     `json:"updated_by,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+// templateMsg returns the text for key on channel in lang: the template
+// stored for the institution, then the deployment's, then the built-in
+// message, then the same in English
+func templateMsg(institution, channel, lang, key string) (string, bool) {
+	messageTemplates.RLock()
+	defer messageTemplates.RUnlock()
+	for _, l := range []string{lang, langEnglish} {
+		if institution != "" {
+			if t, ok := messageTemplates.templates[messageTemplateKey{institution, channel, l, key}]; ok {
+				return t.Text, true
+			}
+		}
+		if t, ok := messageTemplates.templates[messageTemplateKey{"", channel, l, key}]; ok {
+			return t.Text, true
+		}
+		if m, ok := messageCatalogs[channel][l][key]; ok {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+// builtinMsg returns the built-in text of a message, or "" with ok=false
+// when key is not one of the channel's messages. Web error messages are
+// keyed by their English text, which is their English default.
+func builtinMsg(channel, lang, key string) (string, bool) {
+	catalog := messageCatalogs[channel]
+	if _, ok := catalog[lang]; !ok {
+		return "", false
+	}
+	if m, ok := catalog[lang][key]; ok {
+		return m, true
+	}
+	for _, msgs := range catalog {
+		if _, ok := msgs[key]; ok {
+			if m, ok := catalog[langEnglish][key]; ok {
+				return m, true
+			}
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// validateMessageTemplate rejects a template for an unknown message, and
+// text that is empty, too long or formatted with other fmt verbs than the
+// built-in message, which would garble the output
+func validateMessageTemplate(channel, lang, key, text string) error {
+	def, ok := builtinMsg(channel, lang, key)
+	if !ok {
+		return fmt.Errorf("no %s message %q in language %q", channel, key, lang)
+	}
+	if strings.TrimSpace(text) == "" || utf8.RuneCountInString(text) > maxMessageTemplateLength {
+		return fmt.Errorf("text must be 1 to %d characters", maxMessageTemplateLength)
+	}
+	if want := messageVerbPattern.FindAllString(def, -1); !slices.Equal(messageVerbPattern.FindAllString(text, -1), want) {
+		if len(want) == 0 {
+			return errors.New("text must not contain % placeholders")
+		}
+		return fmt.Errorf("text must contain the placeholders %s in that order", strings.Join(want, " "))
+	}
+	return nil
+}
+
+// startMessageTemplates loads the stored templates; the refresh_caches job
+// reloads them
+func startMessageTemplates() {
+	loadMessageTemplates(context.Background())
+}
+
+// loadMessageTemplates reads the table, keeping the current templates if it
+// fails. Stored templates that no longer validate, say after an upgrade
+// changed a built-in message, are skipped so the built-in wording applies.
+func loadMessageTemplates(ctx context.Context) {
+	rows, err := st.ListMessageTemplates(ctx)
+	if err != nil {
+		logErrorContext(ctx, "MESSAGE_TEMPLATES_ERROR", fmt.Sprintf("Failed to load message templates: %v", err))
+		return
+	}
+	templates := make(map[messageTemplateKey]store.MessageTemplate, len(rows))
+	for _, t := range rows {
+		if validateMessageTemplate(t.Channel, t.Lang, t.Key, t.Text) == nil {
+			templates[messageTemplateKey{t.InstitutionID, t.Channel, t.Lang, t.Key}] = t
+		}
+	}
+	messageTemplates.Lock()
+	messageTemplates.templates = templates
+	messageTemplates.Unlock()
+}
+
+// toAPIMessageTemplate describes a message as the institution sees it. Its
+// default is the deployment's wording, which an institution's template
+// overrides.
+func toAPIMessageTemplate(institution, channel, lang, key string) apiMessageTemplate {
+	def, _ := builtinMsg(channel, lang, key)
+	messageTemplates.RLock()
+	if institution != "" {
+		if t, ok := messageTemplates.templates[messageTemplateKey{"", channel, lang, key}]; ok {
+			def = t.Text
+		}
+	}
+	t, ok := messageTemplates.templates[messageTemplateKey{institution, channel, lang, key}]
+	messageTemplates.RUnlock()
+	m := apiMessageTemplate{Institution: institution, Channel: channel, Lang: lang, Key: key, Text: def, Default: def}
+	if ok {
+		m.Text, m.Overridden, m.UpdatedBy = t.Text, true, t.UpdatedBy
+		updated := t.UpdatedAt
+		m.UpdatedAt = &updated
+	}
+	return m
+}
+
+// templateInstitution reads the ?institution= the message_templates
+// endpoints act on, writing a 400 when it names no institution. Without
+// it they act on the deployment's own wording.
+func templateInstitution(w http.ResponseWriter, r *http.Request) (string, bool) {
+	institution := r.URL.Query().Get("institution")
+	if institution != "" && !validInstitution(r.Context(), institution) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_institution", "institution must be the ID of an institution")
+		return "", false
+	}
+	return institution, true
+}
+
+// apiListMessageTemplatesHandler lists every message with the wording in
+// use. Optional filters: institution, channel, lang and overridden=true.
+func apiListMessageTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	channel, lang := q.Get("channel"), q.Get("lang")
+	if _, ok := messageCatalogs[channel]; channel != "" && !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_channel", "channel must be voice, web or text")
+		return
+	}
+	institution, ok := templateInstitution(w, r)
+	if !ok {
+		return
+	}
+
+	list := []apiMessageTemplate{}
+	for ch, catalog := range messageCatalogs {
+		if channel != "" && ch != channel {
+			continue
+		}
+		keys := make(map[string]bool)
+		for _, msgs := range catalog {
+			for key := range msgs {
+				keys[key] = true
+			}
+		}
+		for l := range catalog {
+			if lang != "" && l != lang {
+				continue
+			}
+			for key := range keys {
+				m := toAPIMessageTemplate(institution, ch, l, key)
+				if q.Get("overridden") == "true" && !m.Overridden {
+					continue
+				}
+				list = append(list, m)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		if a.Lang != b.Lang {
+			return a.Lang < b.Lang
+		}
+		return a.Key < b.Key
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"templates": list})
+}
+
+// apiSetMessageTemplateHandler replaces the wording of one message:
+// PUT /message_templates/{channel}/{lang}/{key} with {"text": "..."}. The
+// text must use the same % placeholders as the built-in message. With
+// ?institution= it changes the wording for that institution's people only.
+func apiSetMessageTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	channel, lang, key := vars["channel"], vars["lang"], vars["key"]
+	institution, ok := templateInstitution(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"text": "..."}`)
+		return
+	}
+	if _, ok := builtinMsg(channel, lang, key); !ok {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Unknown message")
+		return
+	}
+	if err := validateMessageTemplate(channel, lang, key, body.Text); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_text", err.Error())
+		return
+	}
+
+	t := &store.MessageTemplate{InstitutionID: institution, Channel: channel, Lang: lang, Key: key, Text: body.Text, UpdatedBy: requestUser(r.Context()).Username}
+	if err := st.SetMessageTemplate(r.Context(), t); err != nil {
+		logErrorContext(r.Context(), "MESSAGE_TEMPLATES_ERROR", fmt.Sprintf("Failed to save message %s/%s/%s%s: %v", channel, lang, key, forInstitution(institution), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	loadMessageTemplates(r.Context())
+	logErrorContext(r.Context(), "MESSAGE_TEMPLATE_UPDATE_SUCCESS", fmt.Sprintf("%s changed message %s/%s/%s%s", t.UpdatedBy, channel, lang, key, forInstitution(institution)))
+	m := toAPIMessageTemplate(institution, channel, lang, key)
+	publishChange(r.Context(), eventMessageTemplateUpdated, "", m)
+	writeJSON(w, http.StatusOK, m)
+}
+
+// apiDeleteMessageTemplateHandler reverts a message to its built-in
+// wording, or with ?institution= that institution's to the deployment's
+func apiDeleteMessageTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	channel, lang, key := vars["channel"], vars["lang"], vars["key"]
+	institution, ok := templateInstitution(w, r)
+	if !ok {
+		return
+	}
+	err := st.DeleteMessageTemplate(r.Context(), institution, channel, lang, key)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Message is not overridden")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "MESSAGE_TEMPLATES_ERROR", fmt.Sprintf("Failed to delete message %s/%s/%s%s: %v", channel, lang, key, forInstitution(institution), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	loadMessageTemplates(r.Context())
+	logErrorContext(r.Context(), "MESSAGE_TEMPLATE_DELETE_SUCCESS", fmt.Sprintf("%s reverted message %s/%s/%s%s", requestUser(r.Context()).Username, channel, lang, key, forInstitution(institution)))
+	publishChange(r.Context(), eventMessageTemplateDeleted, "", toAPIMessageTemplate(institution, channel, lang, key))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forInstitution is the " for institution ..." log messages about a
+// template end with, "" for the deployment's own
+func forInstitution(institution string) string {
+	if institution == "" {
+		return ""
+	}
+	return " for institution " + institution
+}
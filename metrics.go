@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hogwarts_http_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hogwarts_db_query_duration_seconds",
+		Help:    "Database query latency by query name.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"query"})
+
+	dbReplicaUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "hogwarts_db_replica_up",
+		Help: "1 while person lookups are read from the replica, 0 while they fall back to the primary.",
+	})
+
+	sharedLookupsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hogwarts_shared_lookups_total",
+		Help: "Person lookups answered by a query a concurrent identical lookup also waited on.",
+	})
+
+	dbRetriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hogwarts_db_retries_total",
+		Help: "Database queries run again after a deadlock or a dropped connection.",
+	})
+
+	captchaChecksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_captcha_checks_total",
+		Help: "Challenge checks on /verify by result (passed, failed, missing, error).",
+	}, []string{"result"})
+
+	twilioLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_twilio_lookups_total",
+		Help: "Twilio verification lookups by result (match, no_match, invalid, restricted, error).",
+	}, []string{"result"})
+
+	rateLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_rate_limited_total",
+		Help: "Requests rejected by rate limiting, by limit name.",
+	}, []string{"limit"})
+
+	quotaExceededTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hogwarts_quota_exceeded_total",
+		Help: "API requests refused because the partner's monthly quota was used up.",
+	})
+
+	lockoutsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hogwarts_lockouts_total",
+		Help: "Clients locked out for repeated not-found lookups.",
+	})
+
+	cacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_cache_lookups_total",
+		Help: "Person lookup cache requests by result (hit, miss).",
+	}, []string{"result"})
+
+	webhookDeliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_webhook_deliveries_total",
+		Help: "Webhook delivery attempts by result (delivered, retry, failed).",
+	}, []string{"result"})
+
+	webhookEventsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hogwarts_webhook_events_dropped_total",
+		Help: "Webhook events dropped because the queue was full or could not be stored.",
+	})
+
+	brokerEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_broker_events_total",
+		Help: "Events sent to the message broker by result (published, failed, dropped).",
+	}, []string{"result"})
+
+	emailsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_emails_total",
+		Help: "Emailed verification results by result (sent, failed, limited).",
+	}, []string{"result"})
+
+	twilioFollowUpsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_twilio_followups_total",
+		Help: "Voice results texted to callers by result (sent, failed, refused).",
+	}, []string{"result"})
+
+	twilioCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_twilio_calls_total",
+		Help: "Finished voice calls by final status (completed, busy, failed, no_answer, canceled).",
+	}, []string{"status"})
+
+	twilioCallDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "hogwarts_twilio_call_duration_seconds",
+		Help:    "Length of completed voice calls.",
+		Buckets: []float64{10, 30, 60, 120, 180, 300, 600, 1200},
+	})
+
+	outboundCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_outbound_calls_total",
+		Help: "Outbound verification calls by result (placed, failed, limited, blocked).",
+	}, []string{"result"})
+
+	jobRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_job_runs_total",
+		Help: "Background job runs by job and result (ok, failed).",
+	}, []string{"job", "result"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hogwarts_job_duration_seconds",
+		Help:    "Background job run time by job.",
+		Buckets: []float64{.01, .1, 1, 10, 60, 300},
+	}, []string{"job"})
+
+	accessBlockedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_access_blocked_total",
+		Help: "Requests refused by a block rule, by kind (caller, ip).",
+	}, []string{"kind"})
+
+	dbBreakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "hogwarts_db_breaker_open",
+		Help: "1 while the database circuit breaker is failing queries at once.",
+	})
+
+	busyDeniedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hogwarts_busy_denied_total",
+		Help: "Lookups answered with the service busy message while the database circuit breaker was open.",
+	})
+
+	maintenanceDeniedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hogwarts_maintenance_denied_total",
+		Help: "Lookups answered with the maintenance message instead of being served.",
+	})
+
+	twilioIPRejectedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hogwarts_twilio_ip_rejected_total",
+		Help: "Twilio webhooks refused because they came from outside the allowed IP ranges.",
+	})
+
+	logDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hogwarts_log_dropped_total",
+		Help: "Log events not stored in the errors table, by reason (queue_full, write_failed).",
+	}, []string{"reason"})
+
+	logFallbackTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hogwarts_log_fallback_total",
+		Help: "Log events written to the fallback file instead of the errors table.",
+	})
+)
+
+// statusRecorder captures the status code and body size written by a
+// handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g.
+// to lift the write deadline for an export
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Flush lets streaming handlers (export) flush through the recorder
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// metricsMiddleware records request counts and latency per route template,
+// so /api/v1/people/{id} is one series rather than one per ID.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tpl, err := cur.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		httpRequestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// observeQuery records the duration of a named DB query; it is installed
+// as the store's Observe hook
+func observeQuery(name string, d time.Duration) {
+	dbQueryDuration.WithLabelValues(name).Observe(d.Seconds())
+}
+
+// observeRetry counts a query run again; it is installed as the store's
+// OnRetry hook
+func observeRetry(err error) {
+	dbRetriesTotal.Inc()
+}
+
+// observeReplica logs and exports the read replica going down or coming
+// back; it is installed as the store's OnReplica hook
+func observeReplica(up bool, err error) {
+	if up {
+		dbReplicaUp.Set(1)
+		logError("DB_REPLICA_UP", "Read replica answering again, lookups use it")
+		return
+	}
+	dbReplicaUp.Set(0)
+	logError("DB_REPLICA_ERROR", fmt.Sprintf("Read replica failed, reading from the primary for %s: %v", cfg.DBReplicaRetry, err))
+}
+
+// observeSharedLookup counts a lookup that shared its query; it is
+// installed as the shared store's OnShared hook
+func observeSharedLookup() {
+	sharedLookupsTotal.Inc()
+}
+
+// observeCacheLookup counts lookup cache hits and misses; it is installed
+// as the cached store's OnLookup hook
+func observeCacheLookup(hit bool) {
+	if hit {
+		cacheLookupsTotal.WithLabelValues("hit").Inc()
+	} else {
+		cacheLookupsTotal.WithLabelValues("miss").Inc()
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// runMigrateCommand implements "getVerification migrate [status]" and
+// returns the process exit code
+func runMigrateCommand(s *store.SQLStore, args []string) int {
+	ctx := context.Background()
+
+	if len(args) > 0 && args[0] == "status" {
+		list, err := s.Migrations(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate status: %v\n", err)
+			return 1
+		}
+		for _, m := range list {
+			state := "pending"
+			if m.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%-8s %s\n", state, m.Name)
+		}
+		return 0
+	}
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s migrate [status]\n", os.Args[0])
+		return 2
+	}
+
+	ran, err := s.Migrate(ctx)
+	for _, name := range ran {
+		fmt.Println("applied", name)
+	}
+	if err != nil {
+		logError("MIGRATION_ERROR", fmt.Sprintf("Failed to migrate database: %v", err))
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	if len(ran) == 0 {
+		fmt.Println("database is up to date")
+	}
+	return 0
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sri Lankan NIC numbers come in two formats for the same person:
+//
+//	old: YY DDD SSS C + V|X   e.g. 853400939V
+//	new: YYYY DDD 0SSS C      e.g. 198534000939
+//
+// where DDD is the day of the birth year (plus 500 for women) and C is a
+// mod-11 check digit. Old numbers convert to new by prefixing the century
+// and padding the serial with a zero, which keeps the check digit valid.
+var (
+	oldNICRegex = regexp.MustCompile(`^(\d{9})([VvXx]?)$`)
+	newNICRegex = regexp.MustCompile(`^\d{12}$`)
+)
+
+// Check digit weights for the first 8 (old) or 11 (new) digits. The new
+// weights on "19" sum to 0 mod 11, so converted numbers keep their digit.
+var (
+	oldNICWeights = []int{3, 2, 7, 6, 5, 4, 3, 2}
+	newNICWeights = []int{8, 4, 3, 2, 7, 6, 5, 8, 4, 3, 2}
+)
+
+// nic is a parsed NIC number
+type nic struct {
+	// Old is the 9 digits of the old format without the letter; empty when
+	// the number has no old equivalent (born 2000 or later, 4-digit serial)
+	Old string
+	// Letter is V or X when the input was in the old format with a letter
+	Letter string
+	// New is the 12-digit format
+	New       string
+	BirthYear int
+	// BirthDay is the day of the year, 1-366 (NICs treat every year as leap)
+	BirthDay int
+	Female   bool
+}
+
+// nicErrorPrefix starts every nicError message
+const nicErrorPrefix = "Invalid NIC number: "
+
+// nicError explains why an NIC-shaped ID is not a valid NIC
+type nicError struct {
+	reason string
+}
+
+func (e *nicError) Error() string {
+	return nicErrorPrefix + e.reason
+}
+
+// parseNIC parses an old (9 digits, optionally followed by V or X) or new
+// (12 digits) NIC number. It returns nil and no error when s does not have
+// either shape, and a *nicError when it does but is not a valid NIC.
+func parseNIC(s string) (*nic, error) {
+	var n nic
+	var digits string
+	if m := oldNICRegex.FindStringSubmatch(s); m != nil {
+		digits = m[1]
+		n.Old = digits
+		n.Letter = strings.ToUpper(m[2])
+		n.New = "19" + digits[:5] + "0" + digits[5:]
+		n.BirthYear = 1900 + atoi(digits[:2])
+	} else if newNICRegex.MatchString(s) {
+		digits = s
+		n.New = digits
+		n.BirthYear = atoi(digits[:4])
+		if digits[:2] == "19" && digits[7] == '0' {
+			n.Old = digits[2:7] + digits[8:]
+		}
+	} else {
+		return nil, nil
+	}
+
+	if n.BirthYear < 1900 || n.BirthYear > time.Now().Year() {
+		return nil, &nicError{fmt.Sprintf("birth year %d is not valid", n.BirthYear)}
+	}
+
+	day := atoi(n.New[4:7])
+	switch {
+	case day >= 1 && day <= 366:
+		n.BirthDay = day
+	case day >= 501 && day <= 866:
+		n.BirthDay, n.Female = day-500, true
+	default:
+		return nil, &nicError{fmt.Sprintf("birth day code %03d is not valid; it must be 001-366, or 501-866 for women", day)}
+	}
+
+	if cfg.NICCheckDigit {
+		weights := newNICWeights
+		if len(digits) == 9 {
+			weights = oldNICWeights
+		}
+		if nicCheckDigit(digits, weights) != int(digits[len(digits)-1]-'0') {
+			return nil, &nicError{"the check digit does not match; please check the number"}
+		}
+	}
+	return &n, nil
+}
+
+// nicCheckDigit computes the mod-11 check digit over the digits before it
+func nicCheckDigit(digits string, weights []int) int {
+	sum := 0
+	for i, w := range weights {
+		sum += int(digits[i]-'0') * w
+	}
+	// A remainder of 1 would need a check digit of 10; such numbers use 0
+	return (11 - sum%11) % 11 % 10
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// lookupIDs returns the IDs input may be stored under, most likely first:
+// the input itself, then its other NIC formats. An old number typed without
+// its letter (as on a phone keypad) matches both V and X. Input hinted as
+// another identifier type is used as it is.
+//
+// Input in either NIC format that fails validation is rejected with a
+// *nicError. Nine bare digits are only treated as an NIC when they validate,
+// since other identifiers can be nine digits long too.
+func lookupIDs(input, idType string) ([]string, error) {
+	if !keyFirst(idType) {
+		return []string{input}, nil
+	}
+	n, err := parseNIC(input)
+	if err != nil {
+		if m := oldNICRegex.FindStringSubmatch(input); m != nil && m[2] == "" {
+			return []string{input}, nil
+		}
+		return nil, err
+	}
+	if n == nil {
+		return []string{input}, nil
+	}
+
+	ids := []string{input}
+	if n.Old != "" {
+		if n.Letter != "" {
+			ids = append(ids, n.Old+n.Letter)
+		} else {
+			ids = append(ids, n.Old+"V", n.Old+"X")
+		}
+	}
+	ids = append(ids, n.New)
+	return dedupeIDs(ids), nil
+}
+
+// dedupeIDs drops repeated IDs, ignoring case, keeping the first of each
+func dedupeIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := ids[:0]
+	for _, id := range ids {
+		if k := strings.ToUpper(id); !seen[k] {
+			seen[k] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// normalizeNIC upper-cases the letter of an old-format NIC; other IDs are
+// returned unchanged
+func normalizeNIC(id string) string {
+	if n, err := parseNIC(id); err == nil && n != nil && n.Letter != "" {
+		return n.Old + n.Letter
+	}
+	return id
+}
+
+// nicKey is the canonical form of id for duplicate detection: the 12-digit
+// format for valid NICs and the upper-cased ID otherwise
+func nicKey(id string) string {
+	if n, err := parseNIC(id); err == nil && n != nil {
+		return n.New
+	}
+	return strings.ToUpper(id)
+}
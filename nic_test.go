@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+func TestParseNIC(t *testing.T) {
+	defer func(check bool) { cfg.NICCheckDigit = check }(cfg.NICCheckDigit)
+	cfg.NICCheckDigit = true
+
+	tests := []struct {
+		in      string
+		want    *nic
+		invalid bool
+	}{
+		{in: "853400939V", want: &nic{Old: "853400939", Letter: "V", New: "198534000939", BirthYear: 1985, BirthDay: 340}},
+		{in: "853400939v", want: &nic{Old: "853400939", Letter: "V", New: "198534000939", BirthYear: 1985, BirthDay: 340}},
+		{in: "853400939", want: &nic{Old: "853400939", New: "198534000939", BirthYear: 1985, BirthDay: 340}},
+		{in: "925671231X", want: &nic{Old: "925671231", Letter: "X", New: "199256701231", BirthYear: 1992, BirthDay: 67, Female: true}},
+		{in: "198534000939", want: &nic{Old: "853400939", New: "198534000939", BirthYear: 1985, BirthDay: 340}},
+		{in: "199256701231", want: &nic{Old: "925671231", New: "199256701231", BirthYear: 1992, BirthDay: 67, Female: true}},
+		// Born in 2000, so there is no old format
+		{in: "200012345679", want: &nic{New: "200012345679", BirthYear: 2000, BirthDay: 123}},
+		// A four-digit serial does not fit the old format either
+		{in: "199534012346", want: &nic{New: "199534012346", BirthYear: 1995, BirthDay: 340}},
+		{in: "853400938V", invalid: true},
+		{in: "198534000938", invalid: true},
+		{in: "853670939V", invalid: true},
+		{in: "200000012345", invalid: true},
+		{in: "209912345678", invalid: true},
+		{in: "189912345678", invalid: true},
+		{in: "ABC12345"},
+		{in: "85340093V"},
+		{in: "8534009399V"},
+		{in: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseNIC(tt.in)
+			if tt.invalid {
+				var nicErr *nicError
+				if !errors.As(err, &nicErr) {
+					t.Fatalf("parseNIC(%q) = %+v, %v; want a *nicError", tt.in, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseNIC(%q) failed: %v", tt.in, err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("parseNIC(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNICWithoutCheckDigit(t *testing.T) {
+	defer func(check bool) { cfg.NICCheckDigit = check }(cfg.NICCheckDigit)
+	cfg.NICCheckDigit = false
+
+	if n, err := parseNIC("853400938V"); err != nil || n == nil {
+		t.Errorf("parseNIC with a wrong check digit = %+v, %v; want it accepted", n, err)
+	}
+	if _, err := parseNIC("853670939V"); err == nil {
+		t.Error("parseNIC accepted birth day code 367 without check digits")
+	}
+}
+
+func TestLookupIDs(t *testing.T) {
+	defer func(check bool) { cfg.NICCheckDigit = check }(cfg.NICCheckDigit)
+	cfg.NICCheckDigit = true
+
+	tests := []struct {
+		in, idType string
+		want       []string
+		invalid    bool
+	}{
+		{in: "853400939V", want: []string{"853400939V", "198534000939"}},
+		{in: "853400939x", want: []string{"853400939x", "198534000939"}},
+		{in: "853400939", want: []string{"853400939", "853400939V", "853400939X", "198534000939"}},
+		{in: "198534000939", want: []string{"198534000939", "853400939V", "853400939X"}},
+		{in: "200012345679", want: []string{"200012345679"}},
+		{in: "198534000939", idType: store.IdentifierNIC, want: []string{"198534000939", "853400939V", "853400939X"}},
+		// Nine digits that are not a valid NIC may be another identifier
+		{in: "853400938", want: []string{"853400938"}},
+		{in: "N1234567", want: []string{"N1234567"}},
+		{in: "853400938V", idType: store.IdentifierPassport, want: []string{"853400938V"}},
+		{in: "853400938V", invalid: true},
+		{in: "198534000938", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in+"/"+tt.idType, func(t *testing.T) {
+			got, err := lookupIDs(tt.in, tt.idType)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("lookupIDs(%q) = %v; want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookupIDs(%q) failed: %v", tt.in, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("lookupIDs(%q, %q) = %v, want %v", tt.in, tt.idType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNICKey(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"853400939V", "198534000939"},
+		{"853400939v", "853400939X"},
+		{"n1234567", "N1234567"},
+	}
+	for _, tt := range tests {
+		if nicKey(tt.a) != nicKey(tt.b) {
+			t.Errorf("nicKey(%q) = %q, nicKey(%q) = %q; want them equal", tt.a, nicKey(tt.a), tt.b, nicKey(tt.b))
+		}
+	}
+}
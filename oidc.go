@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	pageAdminSSO = "admin_sso.html"
+
+	// oidcCookie carries the state, nonce and PKCE verifier of a sign-in
+	// from /admin/oidc/login to the callback
+	oidcCookie = "hv_oidc"
+	// oidcLoginTTL is how long a sign-in may take at the provider
+	oidcLoginTTL = 10 * time.Minute
+	// oidcCallbackPath is where the provider sends the browser back
+	oidcCallbackPath = "/admin/oidc/callback"
+)
+
+// oidcClient is the discovered provider, set on first use so a provider
+// that is down at startup does not stop the service from starting
+var oidcClient struct {
+	sync.Mutex
+	provider *oidc.Provider
+}
+
+// ssoPage is the data for the page that continues to the dashboard after
+// single sign-on
+type ssoPage struct {
+	Next string
+}
+
+// oidcEnabled reports whether OIDC_ISSUER_URL is set
+func oidcEnabled() bool {
+	return cfg.OIDCIssuerURL != ""
+}
+
+// oidcProviderName is the OIDC_PROVIDER_NAME shown on the login form, or
+// "" when single sign-on is off
+func oidcProviderName() string {
+	if !oidcEnabled() {
+		return ""
+	}
+	return cfg.OIDCProviderName
+}
+
+// validateOIDC checks the OIDC_* settings at startup
+func validateOIDC(c config) error {
+	if c.OIDCIssuerURL == "" {
+		return nil
+	}
+	if c.OIDCClientID == "" {
+		return errors.New("OIDC_CLIENT_ID is required with OIDC_ISSUER_URL")
+	}
+	if c.OIDCRedirectURL == "" && c.PublicBaseURL == "" {
+		return errors.New("OIDC_REDIRECT_URL or PUBLIC_BASE_URL is required with OIDC_ISSUER_URL")
+	}
+	if c.OIDCDefaultRole != "" && !validRole(c.OIDCDefaultRole) {
+		return fmt.Errorf("OIDC_DEFAULT_ROLE must be one of %s", strings.Join(store.Roles, ", "))
+	}
+	if c.OIDCDefaultRole == "" && len(c.OIDCAdminGroups)+len(c.OIDCRegistrarGroups)+len(c.OIDCViewerGroups) == 0 {
+		return errors.New("set OIDC_ADMIN_GROUPS, OIDC_REGISTRAR_GROUPS, OIDC_VIEWER_GROUPS or OIDC_DEFAULT_ROLE, or no one can sign in")
+	}
+	return nil
+}
+
+// oidcConfig discovers the provider on first use and returns the OAuth2
+// client settings and ID token verifier for it
+func oidcConfig(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	oidcClient.Lock()
+	defer oidcClient.Unlock()
+	if oidcClient.provider == nil {
+		p, err := oidc.NewProvider(ctx, cfg.OIDCIssuerURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		oidcClient.provider = p
+	}
+	p := oidcClient.provider
+
+	redirect := cfg.OIDCRedirectURL
+	if redirect == "" {
+		redirect = strings.TrimSuffix(cfg.PublicBaseURL, "/") + oidcCallbackPath
+	}
+	oc := &oauth2.Config{
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  redirect,
+		Endpoint:     p.Endpoint(),
+		Scopes:       cfg.OIDCScopes,
+	}
+	return oc, p.Verifier(&oidc.Config{ClientID: cfg.OIDCClientID}), nil
+}
+
+// setOIDCCookie stores a sign-in in progress, or clears it when value is "".
+// It is Lax rather than Strict because the provider sends the browser back
+// with a cross-site redirect.
+func setOIDCCookie(w http.ResponseWriter, r *http.Request, value string) {
+	c := &http.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     "/admin/oidc",
+		MaxAge:   int(oidcLoginTTL / time.Second),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// adminOIDCLoginHandler sends the browser to the provider to sign in,
+// returning to ?next= afterwards
+func adminOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	oc, _, err := oidcConfig(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "AUTH_OIDC_ERROR", fmt.Sprintf("Failed to discover OIDC provider %s: %v", cfg.OIDCIssuerURL, err))
+		renderLogin(w, r, http.StatusBadGateway, "Single sign-on is unavailable, please try again later")
+		return
+	}
+	state, err := newToken("")
+	var nonce string
+	if err == nil {
+		nonce, err = newToken("")
+	}
+	if err != nil {
+		logErrorContext(r.Context(), "AUTH_OIDC_ERROR", fmt.Sprintf("Failed to start single sign-on: %v", err))
+		renderLogin(w, r, http.StatusInternalServerError, "Single sign-on is unavailable, please try again later")
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	setOIDCCookie(w, r, url.Values{
+		"state":    {state},
+		"nonce":    {nonce},
+		"verifier": {verifier},
+		"next":     {loginNext(r.URL.Query().Get("next"))},
+	}.Encode())
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, oc.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+}
+
+// adminOIDCCallbackHandler finishes single sign-on: it exchanges the code
+// for an ID token, maps the user's groups to a role, creates or updates
+// their users row and starts a session
+func adminOIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	fail := func(status int, message, logType, logMsg string) {
+		logErrorContext(r.Context(), logType, logMsg)
+		setOIDCCookie(w, r, "")
+		renderLogin(w, r, status, message)
+	}
+
+	var login url.Values
+	if c, err := r.Cookie(oidcCookie); err == nil {
+		login, _ = url.ParseQuery(c.Value)
+	}
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		fail(http.StatusUnauthorized, "Single sign-on was cancelled or refused", "AUTH_FAILED",
+			fmt.Sprintf("OIDC provider returned %s (%s) to %s", q.Get("error"), q.Get("error_description"), clientIP(r)))
+		return
+	}
+	if login.Get("state") == "" || q.Get("state") != login.Get("state") {
+		fail(http.StatusBadRequest, "Single sign-on expired, please try again", "AUTH_FAILED",
+			"OIDC callback with an unknown state from "+clientIP(r))
+		return
+	}
+
+	oc, verifier, err := oidcConfig(r.Context())
+	if err != nil {
+		fail(http.StatusBadGateway, "Single sign-on is unavailable, please try again later", "AUTH_OIDC_ERROR",
+			fmt.Sprintf("Failed to discover OIDC provider %s: %v", cfg.OIDCIssuerURL, err))
+		return
+	}
+	tok, err := oc.Exchange(r.Context(), q.Get("code"), oauth2.VerifierOption(login.Get("verifier")))
+	if err != nil {
+		fail(http.StatusBadGateway, "Single sign-on failed, please try again", "AUTH_OIDC_ERROR",
+			fmt.Sprintf("Failed to exchange OIDC code: %v", err))
+		return
+	}
+	raw, _ := tok.Extra("id_token").(string)
+	idToken, err := verifier.Verify(r.Context(), raw)
+	if err == nil && idToken.Nonce != login.Get("nonce") {
+		err = errors.New("nonce does not match")
+	}
+	if err != nil {
+		fail(http.StatusUnauthorized, "Single sign-on failed, please try again", "AUTH_FAILED",
+			fmt.Sprintf("Invalid OIDC ID token from %s: %v", clientIP(r), err))
+		return
+	}
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		fail(http.StatusUnauthorized, "Single sign-on failed, please try again", "AUTH_FAILED",
+			fmt.Sprintf("Unreadable OIDC claims from %s: %v", clientIP(r), err))
+		return
+	}
+
+	username, err := oidcUsername(claims)
+	if err != nil {
+		fail(http.StatusForbidden, "Your account may not use this service", "AUTH_FORBIDDEN",
+			fmt.Sprintf("OIDC subject %s refused: %v", idToken.Subject, err))
+		return
+	}
+	role := oidcRole(claimStrings(claims[cfg.OIDCGroupsClaim]))
+	if role == "" {
+		fail(http.StatusForbidden, "Your account may not use this service", "AUTH_FORBIDDEN",
+			fmt.Sprintf("OIDC user %s is in no group with a role", username))
+		return
+	}
+
+	u, err := oidcUser(r.Context(), username, role)
+	var token string
+	if err == nil {
+		token, err = startSession(r, u, store.SessionOIDC)
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, "Sign-in is unavailable, please try again later", "AUTH_DB_ERROR",
+			fmt.Sprintf("Failed to sign in OIDC user %s: %v", username, err))
+		return
+	}
+
+	logErrorContext(r.Context(), "AUTH_LOGIN_SUCCESS", fmt.Sprintf("User %s (%s) logged in with single sign-on from %s", u.Username, u.Role, clientIP(r)))
+	setOIDCCookie(w, r, "")
+	setSessionCookie(w, r, token)
+	// The session cookie is SameSite=Strict, so browsers would not send it
+	// on a redirect that started at the provider; a page on this site that
+	// moves on by itself makes the next request same-site
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	renderPage(w, http.StatusOK, pageAdminSSO, ssoPage{Next: loginNext(login.Get("next"))})
+}
+
+// oidcUsername returns the lower-cased OIDC_USERNAME_CLAIM, checking that
+// its address is verified and in OIDC_ALLOWED_DOMAINS when that is set
+func oidcUsername(claims map[string]interface{}) (string, error) {
+	name, _ := claims[cfg.OIDCUsernameClaim].(string)
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !usernameRegex.MatchString(name) {
+		return "", fmt.Errorf("claim %s %q is not a valid username", cfg.OIDCUsernameClaim, name)
+	}
+	if cfg.OIDCUsernameClaim == "email" {
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return "", fmt.Errorf("email %s is not verified", name)
+		}
+	}
+	if len(cfg.OIDCAllowedDomains) > 0 {
+		_, domain, _ := strings.Cut(name, "@")
+		if !slices.ContainsFunc(cfg.OIDCAllowedDomains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+			return "", fmt.Errorf("%s is not in OIDC_ALLOWED_DOMAINS", name)
+		}
+	}
+	return name, nil
+}
+
+// oidcRole returns the highest role any of groups maps to, else
+// OIDC_DEFAULT_ROLE
+func oidcRole(groups []string) string {
+	mapped := []struct {
+		role   string
+		groups []string
+	}{
+		{store.RoleAdmin, cfg.OIDCAdminGroups},
+		{store.RoleRegistrar, cfg.OIDCRegistrarGroups},
+		{store.RoleViewer, cfg.OIDCViewerGroups},
+	}
+	for _, m := range mapped {
+		for _, g := range groups {
+			if slices.Contains(m.groups, g) {
+				return m.role
+			}
+		}
+	}
+	return cfg.OIDCDefaultRole
+}
+
+// claimStrings reads a claim that is a list of strings or a single string
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// oidcUser returns the users row for an SSO login, adding it on first
+// sign-in. The role follows the provider's groups on every sign-in. New
+// users get a random bearer token that no one knows; an admin can issue a
+// usable one with POST /api/v1/users/{id}/token.
+func oidcUser(ctx context.Context, username, role string) (*store.User, error) {
+	u, err := st.FindUserByName(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
+		token, err := newToken(userTokenPrefix)
+		if err != nil {
+			return nil, err
+		}
+		logErrorContext(ctx, "USERS_CREATE_SUCCESS", fmt.Sprintf("Single sign-on created user %s (%s)", username, role))
+		return st.CreateUser(ctx, username, role, hashToken(token))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Role != role {
+		logErrorContext(ctx, "USERS_UPDATE_SUCCESS", fmt.Sprintf("Single sign-on set the role of user %s to %s (was %s)", username, role, u.Role))
+		return st.SetUserRole(ctx, u.ID, role)
+	}
+	return u, nil
+}
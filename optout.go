@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// registrarOnlyNotice is the reply on every public channel for a person who
+// opted out of public lookups
+const registrarOnlyNotice = "Verification of this person requires contacting the registrar."
+
+// apiPublicLookup is the v1 JSON schema for a person's public lookup opt-out
+type apiPublicLookup struct {
+	NationalID   string `json:"national_id"`
+	PublicLookup bool   `json:"public_lookup"`
+}
+
+// partnerRequest reports whether r carries a valid API key or ADMIN_TOKEN.
+// requireAPIKey has already checked the key on the endpoints it guards; on
+// the others, such as photos, and when API_KEYS_REQUIRED is off, the bearer
+// token is looked up here.
+func partnerRequest(r *http.Request) bool {
+	if requestAPIKey(r.Context()) != nil {
+		return true
+	}
+	token, ok := bearerToken(r)
+	if !ok {
+		return false
+	}
+	if cfg.AdminToken != "" && validAdminToken(token) {
+		return true
+	}
+	k, err := st.FindAPIKey(r.Context(), hashToken(token))
+	if err != nil && err != store.ErrNotFound {
+		logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to look up API key: %v", err))
+	}
+	return err == nil && !k.Revoked()
+}
+
+// restricted reports whether p opted out of public lookups and r is not
+// from an API partner, so r only gets registrarOnlyNotice
+func restricted(r *http.Request, p *store.Person) bool {
+	return !p.PublicLookup && !partnerRequest(r)
+}
+
+// apiSetPublicLookupHandler opts a person in to or out of the public
+// channels: PUT /people/{id}/public_lookup with {"public_lookup": false}
+func apiSetPublicLookupHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		PublicLookup *bool `json:"public_lookup"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.PublicLookup == nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"public_lookup": true|false}`)
+		return
+	}
+
+	p, err := st.SetPublicLookup(r.Context(), id, *body.PublicLookup)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "OPTOUT_DB_ERROR", fmt.Sprintf("Failed to update public lookup for %s: %v", maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	result := apiPublicLookup{NationalID: p.NationalID, PublicLookup: p.PublicLookup}
+	if p.PublicLookup {
+		logErrorContext(r.Context(), "OPTOUT_REMOVE_SUCCESS", fmt.Sprintf("%s opted %s back in to public lookups", requestUser(r.Context()).Username, maskID(p.NationalID)))
+		publishChange(r.Context(), eventPersonOptedIn, p.NationalID, result)
+	} else {
+		logErrorContext(r.Context(), "OPTOUT_SUCCESS", fmt.Sprintf("%s opted %s out of public lookups", requestUser(r.Context()).Username, maskID(p.NationalID)))
+		publishChange(r.Context(), eventPersonOptedOut, p.NationalID, result)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// outboundRingTimeout is how many seconds an outbound call rings before
+// Twilio gives up
+const outboundRingTimeout = 30
+
+// outboundNumberWindow is the period TWILIO_CALL_MAX_PER_NUMBER applies to
+const outboundNumberWindow = 24 * time.Hour
+
+// apiOutboundCall is the v1 JSON schema for a call placed through
+// POST /api/v1/calls
+type apiOutboundCall struct {
+	CallSid   string    `json:"call_sid"`
+	To        string    `json:"to"`
+	Reference string    `json:"reference"`
+	Status    string    `json:"status"`
+	Lang      string    `json:"lang"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiCreateCallHandler calls a number and reads out a person's verification
+// result, for registrar staff helping employers who cannot use the web:
+// POST /calls with {"to": "+94771234567", "id": "...", "lang": "en"}. The
+// lookup is recorded in audit_log like any API check, and its reference is
+// what the call reads out once answered.
+func apiCreateCallHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.TwilioCallFrom == "" {
+		writeAPIError(w, http.StatusNotFound, "outbound_calls_disabled", "Outbound calls are not enabled")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		To   string `json:"to"`
+		ID   string `json:"id"`
+		Lang string `json:"lang"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"to": "+94771234567", "id": "..."}`)
+		return
+	}
+	if !e164Pattern.MatchString(body.To) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_to", "to must be an E.164 number such as +94771234567")
+		return
+	}
+	lang := body.Lang
+	if lang == "" {
+		lang = cfg.TwilioLanguage
+	}
+	if _, ok := twilioMessages[lang]; !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_lang", "lang must be en, si or ta")
+		return
+	}
+	ctx := r.Context()
+	if rule := matchAccess(ctx, store.AccessCaller, body.To); rule != nil && rule.Action == store.AccessBlock {
+		outboundCallsTotal.WithLabelValues("blocked").Inc()
+		logErrorContext(ctx, "OUTBOUND_CALL_REFUSED", fmt.Sprintf("Refused to call %s: blocked by access rule %d", maskCaller(body.To), rule.ID))
+		writeAPIError(w, http.StatusForbidden, "blocked", "This number is blocked from the verification service")
+		return
+	}
+
+	p, check := apiLookup(w, r, body.ID, "OUTBOUND_CALL")
+	if p == nil {
+		return
+	}
+	if !underSendLimit(r, "outbound_to:"+piiHash(body.To), cfg.TwilioCallMaxPerNumber, outboundNumberWindow) {
+		outboundCallsTotal.WithLabelValues("limited").Inc()
+		logErrorContext(ctx, "OUTBOUND_CALL_REFUSED", fmt.Sprintf("Refused to call %s: called %d times today", maskCaller(body.To), cfg.TwilioCallMaxPerNumber))
+		writeAPIError(w, http.StatusTooManyRequests, "call_limited", "This number has been called too often today")
+		return
+	}
+
+	answerURL := twilioWebhookURL(r, twilioURL("/twilio/outbound", url.Values{"lang": {lang}, "ref": {check.Reference}}))
+	sid, status, err := placeCall(ctx, body.To, answerURL, twilioWebhookURL(r, "/twilio/status"))
+	if err != nil {
+		outboundCallsTotal.WithLabelValues("failed").Inc()
+		logErrorContext(ctx, "OUTBOUND_CALL_ERROR", fmt.Sprintf("Failed to call %s for verification %s: %v", maskCaller(body.To), check.Reference, err))
+		writeAPIError(w, http.StatusBadGateway, "call_failed", "The call could not be placed")
+		return
+	}
+	outboundCallsTotal.WithLabelValues("placed").Inc()
+	logErrorContext(ctx, "OUTBOUND_CALL_SUCCESS", fmt.Sprintf("%s called %s for verification %s as %s",
+		requestUser(ctx).Username, maskCaller(body.To), check.Reference, sid))
+
+	now := time.Now().UTC()
+	err = st.RecordCall(ctx, &store.Call{
+		CallSid:   sid,
+		From:      maskCaller(body.To),
+		Endpoint:  r.URL.Path,
+		Input:     maskID(body.ID),
+		Result:    callResultOutbound,
+		MatchedID: p.NationalID,
+		CreatedAt: now,
+	})
+	if err != nil {
+		logErrorContext(ctx, "CALL_LOG_ERROR", fmt.Sprintf("Failed to record call %s: %v", sid, err))
+	}
+	writeJSON(w, http.StatusCreated, apiOutboundCall{CallSid: sid, To: body.To, Reference: check.Reference, Status: status, Lang: lang, CreatedAt: now})
+}
+
+// twilioOutboundHandler runs when a call placed by apiCreateCallHandler is
+// answered: it introduces the call and reads the result of the check in
+// ?ref=, with the same options to repeat it, verify another ID or have it
+// texted as an inbound call gets
+func twilioOutboundHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	lang := twilioLang(r)
+	ref := r.URL.Query().Get("ref")
+	updateCallState(r, func(s *callSession) { s.Lang, s.Outbound, s.Reference = lang, ref, ref })
+
+	check, p, refusal, err := callerCheck(r, ref)
+	if breakerRefused(w, err) {
+		denyBusyTwilio(w, r)
+		return
+	}
+	if err != nil {
+		logErrorContext(ctx, "TWILIO_DB_ERROR", fmt.Sprintf("Failed to load verification %s: %v", ref, err))
+		recordCall(r, "", callResultError, "")
+		writeTwiML(w, say(lang, twilioMsg(lang, "unavailable")), twimlHangup{})
+		return
+	}
+	if refusal != "" {
+		logErrorContext(ctx, "TWILIO_OUTBOUND_REFUSED", fmt.Sprintf("Refused to read verification %s to %s: %s", ref, maskCaller(twilioCaller(r)), refusal))
+		recordCall(r, "", callResultError, "")
+		writeTwiML(w, say(lang, twilioMsg(lang, "unavailable")), twimlHangup{})
+		return
+	}
+
+	updateCallState(r, func(s *callSession) { s.Input = p.NationalID })
+	recordCall(r, p.NationalID, callResultOutboundRead, p.NationalID)
+	verbs := []interface{}{say(lang, twilioMsg(lang, "outbound_intro")), twimlPause{Length: 1}}
+	writeTwiML(w, append(verbs, twilioResultVerbs(r, lang, p.NationalID, p, check)...)...)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pageMessages holds the HTML page text per language, keyed like
+// twilioMessages. Error messages are keyed by their English text, since
+// handlers pass verifyError the English message.
+var pageMessages = map[string]map[string]string{
+	langEnglish: {
+		"id":                "ID",
+		"full_name":         "FULL NAME",
+		"issued":            "ISSUED",
+		"valid_until":       "VALID UNTIL",
+		"courses_completed": "COURSES COMPLETED",
+		"remarks":           "REMARKS",
+		"status":            "STATUS",
+		"revoked":           "REVOKED",
+		"expired":           "EXPIRED",
+		"verified":          "APPROVED AND VERIFIED",
+		"yes":               "YES",
+		"revoked_notice":    revokedNotice,
+		"not_found":         "NOT FOUND",
+		"not_found_hint":    "No record matches this ID. Please check the number and try again.",
+		"error":             "ERROR",
+		"maintenance":       "MAINTENANCE",
+		"qr_alt":            "QR code linking to this verification",
+		"photo_alt":         "Photograph of the certificate holder",
+		"reference":         "Verification reference",
+		"checked_at":        "Checked at",
+		"invalid_nic":       "Invalid NIC number. Please check the number and try again.",
+		"challenge":         "Please confirm you are not a robot to see this verification.",
+		"continue":          "Continue",
+		"email_subject":     "Verification result",
+		"email_intro":       "As requested, here is the result of this verification.",
+		"email_certificate": "The certificate of verification is attached as a PDF.",
+		"email_recheck":     "Check the current status at any time:",
+		"email_ignore":      "If you did not ask for this email, you can ignore it.",
+		"email_label":       "Email this result",
+		"email_attach":      "Attach PDF certificate",
+		"email_send":        "Send",
+	},
+	langSinhala: {
+		"id":                "හැඳුනුම්පත් අංකය",
+		"full_name":         "සම්පූර්ණ නම",
+		"issued":            "නිකුත් කළ දිනය",
+		"valid_until":       "වලංගු අවසන් දිනය",
+		"courses_completed": "සම්පූර්ණ කළ පාඨමාලා",
+		"remarks":           "සටහන්",
+		"status":            "තත්ත්වය",
+		"revoked":           "අවලංගු කර ඇත",
+		"expired":           "කල් ඉකුත් වී ඇත",
+		"verified":          "අනුමත කර සත්‍යාපනය කර ඇත",
+		"yes":               "ඔව්",
+		"revoked_notice":    "මෙම සහතිකය අවලංගු කර ඇත.",
+		"not_found":         "හමු නොවීය",
+		"not_found_hint":    "මෙම අංකයට ගැළපෙන වාර්තාවක් නොමැත. කරුණාකර අංකය පරීක්ෂා කර නැවත උත්සාහ කරන්න.",
+		"error":             "දෝෂයකි",
+		"maintenance":       "නඩත්තු කටයුතු සිදුවෙමින් පවතී",
+		"qr_alt":            "මෙම සත්‍යාපනයට යොමු වන QR කේතය",
+		"photo_alt":         "සහතිකය හිමි පුද්ගලයාගේ ඡායාරූපය",
+		"reference":         "සත්‍යාපන යොමු අංකය",
+		"checked_at":        "පරීක්ෂා කළ වේලාව",
+		"invalid_nic":       "වලංගු නොවන ජාතික හැඳුනුම්පත් අංකයකි. කරුණාකර අංකය පරීක්ෂා කර නැවත උත්සාහ කරන්න.",
+		"challenge":         "මෙම සත්‍යාපනය බැලීමට ඔබ රොබෝවරයෙකු නොවන බව තහවුරු කරන්න.",
+		"continue":          "ඉදිරියට",
+		"email_subject":     "සත්‍යාපන ප්‍රතිඵලය",
+		"email_intro":       "ඔබ ඉල්ලූ පරිදි, මෙම සත්‍යාපනයේ ප්‍රතිඵලය මෙසේය.",
+		"email_certificate": "සත්‍යාපන සහතිකය PDF ලෙස අමුණා ඇත.",
+		"email_recheck":     "වත්මන් තත්ත්වය ඕනෑම වේලාවක පරීක්ෂා කරන්න:",
+		"email_ignore":      "ඔබ මෙම විද්‍යුත් තැපෑල ඉල්ලා නොසිටියේ නම්, එය නොසලකා හරින්න.",
+		"email_label":       "මෙම ප්‍රතිඵලය විද්‍යුත් තැපෑලෙන් යවන්න",
+		"email_attach":      "PDF සහතිකය අමුණන්න",
+		"email_send":        "යවන්න",
+
+		"ID is required":                            "හැඳුනුම්පත් අංකය අවශ්‍යයි",
+		"Invalid ID format":                         "වැරදි හැඳුනුම්පත් අංක ආකෘතියකි",
+		"Person not found":                          "පුද්ගලයා හමු නොවීය",
+		"Internal server error":                     "අභ්‍යන්තර සේවාදායක දෝෂයකි",
+		"Too many requests, please try again later": "ඉල්ලීම් වැඩියි, කරුණාකර පසුව නැවත උත්සාහ කරන්න",
+		"Share links are not enabled":               "බෙදාගැනීමේ සබැඳි සක්‍රිය කර නැත",
+		"token is required":                         "ටෝකනය අවශ්‍යයි",
+		"Invalid share link":                        "වලංගු නොවන බෙදාගැනීමේ සබැඳියකි",
+		"This share link has expired":               "මෙම බෙදාගැනීමේ සබැඳිය කල් ඉකුත් වී ඇත",
+		registrarOnlyNotice:                         "මෙම පුද්ගලයා සත්‍යාපනය කිරීමට ලේඛකාධිකාරී අමතන්න.",
+		nameRequiredMsg:                             "වාසගමේ කොටසක් අවශ්‍යයි",
+		captchaRequiredMsg:                          "ඉදිරියට යාමට කරුණාකර අභියෝගය සම්පූර්ණ කරන්න",
+		captchaUnavailableMsg:                       "අභියෝගය පරීක්ෂා කළ නොහැකි විය, කරුණාකර පසුව නැවත උත්සාහ කරන්න",
+		emailDisabledMsg:                            "විද්‍යුත් තැපෑල සක්‍රිය කර නැත",
+		emailInvalidMsg:                             "කරුණාකර වලංගු විද්‍යුත් තැපැල් ලිපිනයක් ඇතුළත් කරන්න",
+		emailNotFoundMsg:                            "සත්‍යාපනය හමු නොවීය",
+		emailExpiredMsg:                             "මෙම සත්‍යාපනය විද්‍යුත් තැපෑලෙන් යැවීමට පැරණි වැඩියි, කරුණාකර නැවත සත්‍යාපනය කරන්න",
+		emailLimitMsg:                               "විද්‍යුත් තැපැල් වැඩියි, කරුණාකර පසුව නැවත උත්සාහ කරන්න",
+		emailFailedMsg:                              "විද්‍යුත් තැපෑල යැවිය නොහැකි විය, කරුණාකර පසුව නැවත උත්සාහ කරන්න",
+		emailSentMsg:                                "සත්‍යාපන ප්‍රතිඵලය ඔබගේ විද්‍යුත් තැපැල් ලිපිනයට යවා ඇත.",
+		accessBlockedMsg:                            "මෙම ලිපිනයෙන් ප්‍රවේශය අවහිර කර ඇත",
+		maintenanceMsg:                              "සත්‍යාපන සේවාව නඩත්තු කටයුතු සඳහා තාවකාලිකව නවතා ඇත. කරුණාකර ටික වේලාවකින් නැවත උත්සාහ කරන්න.",
+		busyMsg:                                     "සේවාව මේ මොහොතේ කාර්යබහුලයි, කරුණාකර මිනිත්තු කිහිපයකින් නැවත උත්සාහ කරන්න",
+	},
+	langTamil: {
+		"id":                "அடையாள எண்",
+		"full_name":         "முழுப் பெயர்",
+		"issued":            "வழங்கப்பட்ட தேதி",
+		"valid_until":       "செல்லுபடி முடிவு தேதி",
+		"courses_completed": "முடித்த பாடநெறிகள்",
+		"remarks":           "குறிப்புகள்",
+		"status":            "நிலை",
+		"revoked":           "ரத்து செய்யப்பட்டது",
+		"expired":           "காலாவதியானது",
+		"verified":          "அங்கீகரிக்கப்பட்டு சரிபார்க்கப்பட்டது",
+		"yes":               "ஆம்",
+		"revoked_notice":    "இந்தச் சான்றிதழ் ரத்து செய்யப்பட்டுள்ளது.",
+		"not_found":         "கிடைக்கவில்லை",
+		"not_found_hint":    "இந்த எண்ணுக்குப் பொருந்தும் பதிவு எதுவும் இல்லை. எண்ணைச் சரிபார்த்து மீண்டும் முயற்சிக்கவும்.",
+		"error":             "பிழை",
+		"maintenance":       "பராமரிப்பு நடைபெறுகிறது",
+		"qr_alt":            "இந்தச் சரிபார்ப்புக்கான QR குறியீடு",
+		"photo_alt":         "சான்றிதழ் வைத்திருப்பவரின் புகைப்படம்",
+		"reference":         "சரிபார்ப்புக் குறிப்பு எண்",
+		"checked_at":        "சரிபார்த்த நேரம்",
+		"invalid_nic":       "தவறான தேசிய அடையாள அட்டை எண். எண்ணைச் சரிபார்த்து மீண்டும் முயற்சிக்கவும்.",
+		"challenge":         "இந்தச் சரிபார்ப்பைக் காண நீங்கள் ரோபோ அல்ல என்பதை உறுதிப்படுத்தவும்.",
+		"continue":          "தொடரவும்",
+		"email_subject":     "சரிபார்ப்பு முடிவு",
+		"email_intro":       "நீங்கள் கேட்டபடி, இந்தச் சரிபார்ப்பின் முடிவு இதோ.",
+		"email_certificate": "சரிபார்ப்புச் சான்றிதழ் PDF ஆக இணைக்கப்பட்டுள்ளது.",
+		"email_recheck":     "தற்போதைய நிலையை எப்போது வேண்டுமானாலும் சரிபார்க்கவும்:",
+		"email_ignore":      "நீங்கள் இந்த மின்னஞ்சலைக் கேட்கவில்லை என்றால், இதைப் புறக்கணிக்கலாம்.",
+		"email_label":       "இந்த முடிவை மின்னஞ்சலில் அனுப்பவும்",
+		"email_attach":      "PDF சான்றிதழை இணைக்கவும்",
+		"email_send":        "அனுப்பு",
+
+		"ID is required":                            "அடையாள எண் தேவை",
+		"Invalid ID format":                         "தவறான அடையாள எண் வடிவம்",
+		"Person not found":                          "நபர் கிடைக்கவில்லை",
+		"Internal server error":                     "உள் சேவையக பிழை",
+		"Too many requests, please try again later": "அதிகமான கோரிக்கைகள், பின்னர் மீண்டும் முயற்சிக்கவும்",
+		"Share links are not enabled":               "பகிர்வு இணைப்புகள் இயக்கப்படவில்லை",
+		"token is required":                         "டோக்கன் தேவை",
+		"Invalid share link":                        "தவறான பகிர்வு இணைப்பு",
+		"This share link has expired":               "இந்தப் பகிர்வு இணைப்பு காலாவதியாகிவிட்டது",
+		registrarOnlyNotice:                         "இந்த நபரைச் சரிபார்க்கப் பதிவாளரைத் தொடர்புகொள்ளவும்.",
+		nameRequiredMsg:                             "குடும்பப் பெயரின் ஒரு பகுதி தேவை",
+		captchaRequiredMsg:                          "தொடர சவாலை முடிக்கவும்",
+		captchaUnavailableMsg:                       "சவாலைச் சரிபார்க்க முடியவில்லை, பின்னர் மீண்டும் முயற்சிக்கவும்",
+		emailDisabledMsg:                            "மின்னஞ்சல் இயக்கப்படவில்லை",
+		emailInvalidMsg:                             "சரியான மின்னஞ்சல் முகவரியை உள்ளிடவும்",
+		emailNotFoundMsg:                            "சரிபார்ப்பு கிடைக்கவில்லை",
+		emailExpiredMsg:                             "இந்தச் சரிபார்ப்பு மின்னஞ்சலில் அனுப்ப மிகவும் பழையது, மீண்டும் சரிபார்க்கவும்",
+		emailLimitMsg:                               "அதிகமான மின்னஞ்சல்கள், பின்னர் மீண்டும் முயற்சிக்கவும்",
+		emailFailedMsg:                              "மின்னஞ்சலை அனுப்ப முடியவில்லை, பின்னர் மீண்டும் முயற்சிக்கவும்",
+		emailSentMsg:                                "சரிபார்ப்பு முடிவு உங்கள் மின்னஞ்சல் முகவரிக்கு அனுப்பப்பட்டது.",
+		accessBlockedMsg:                            "இந்த முகவரியிலிருந்து அணுகல் தடுக்கப்பட்டுள்ளது",
+		maintenanceMsg:                              "சரிபார்ப்புச் சேவை பராமரிப்புக்காகத் தற்காலிகமாக நிறுத்தப்பட்டுள்ளது. சிறிது நேரத்தில் மீண்டும் முயற்சிக்கவும்.",
+		busyMsg:                                     "சேவை தற்போது நெரிசலாக உள்ளது, சில நிமிடங்களில் மீண்டும் முயற்சிக்கவும்",
+	},
+}
+
+// pageMsg returns the text for key in lang, falling back to English and
+// then to key itself, so untranslated error messages stay readable
+func pageMsg(lang, key string) string {
+	return institutionPageMsg("", lang, key)
+}
+
+// institutionPageMsg is pageMsg with the wording of the institution
+func institutionPageMsg(institution, lang, key string) string {
+	if m, ok := templateMsg(institution, messageChannelWeb, lang, key); ok {
+		return m
+	}
+	return key
+}
+
+// pageErrorMsg translates an error message passed to verifyError. NIC
+// validation errors carry an English reason, so other languages get the
+// generic invalid NIC message instead.
+func pageErrorMsg(lang, message string) string {
+	if lang != langEnglish && strings.HasPrefix(message, nicErrorPrefix) {
+		return pageMsg(lang, "invalid_nic")
+	}
+	return pageMsg(lang, message)
+}
+
+// pageLang picks the page language: a supported ?lang= wins, then the
+// best supported Accept-Language entry, then English
+func pageLang(r *http.Request) string {
+	if lang := strings.ToLower(r.URL.Query().Get("lang")); pageMessages[lang] != nil {
+		return lang
+	}
+	for _, lang := range acceptLanguages(r.Header.Get("Accept-Language")) {
+		if pageMessages[lang] != nil {
+			return lang
+		}
+	}
+	return langEnglish
+}
+
+// acceptLanguages returns the primary language subtags of an
+// Accept-Language header ("si-LK;q=0.9, en;q=0.8") in preference order,
+// dropping entries with q=0
+func acceptLanguages(header string) []string {
+	type entry struct {
+		lang string
+		q    float64
+	}
+	var entries []entry
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary != "" && q > 0 {
+			entries = append(entries, entry{primary, q})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].q > entries[j].q })
+	langs := make([]string, len(entries))
+	for i, e := range entries {
+		langs[i] = e.lang
+	}
+	return langs
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// partnerRefresh is how long the partners are cached before the table is
+// read again, so other instances pick up quota and endpoint changes
+const partnerRefresh = time.Minute
+
+// The endpoints a partner's keys may be allowed to call, by the names
+// partners list them under
+const (
+	endpointVerify        = "verify"
+	endpointBatch         = "batch"
+	endpointShare         = "share"
+	endpointPeople        = "people"
+	endpointSearch        = "search"
+	endpointVerifications = "verifications"
+)
+
+// partnerEndpoints are the valid endpoint names, in the order they are
+// listed in errors
+var partnerEndpoints = []string{endpointVerify, endpointBatch, endpointShare, endpointPeople, endpointSearch, endpointVerifications}
+
+// partnerCache holds the partners by ID
+var partnerCache struct {
+	sync.Mutex
+	byID   map[string]store.Partner
+	loaded time.Time
+}
+
+// apiPartner is the v1 JSON schema for a partner. An empty Endpoints
+// allows all of them.
+type apiPartner struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	MonthlyQuota int64     `json:"monthly_quota"`
+	Endpoints    []string  `json:"endpoints"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// apiPartnerUsage is the v1 JSON schema for GET /partners/{id}/usage.
+// Remaining is left out for partners without a quota.
+type apiPartnerUsage struct {
+	PartnerID    string               `json:"partner_id"`
+	Month        string               `json:"month"`
+	MonthlyQuota int64                `json:"monthly_quota"`
+	Requests     int64                `json:"requests"`
+	Remaining    *int64               `json:"remaining,omitempty"`
+	Days         []apiPartnerUsageDay `json:"days"`
+	Keys         []apiPartnerUsageKey `json:"keys"`
+}
+
+type apiPartnerUsageDay struct {
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"`
+}
+
+type apiPartnerUsageKey struct {
+	KeyID    int64  `json:"key_id"`
+	Name     string `json:"name"`
+	Prefix   string `json:"prefix"`
+	Requests int64  `json:"requests"`
+}
+
+func toAPIPartner(p *store.Partner) apiPartner {
+	endpoints := p.Endpoints
+	if endpoints == nil {
+		endpoints = []string{}
+	}
+	return apiPartner{ID: p.ID, Name: p.Name, MonthlyQuota: p.MonthlyQuota, Endpoints: endpoints, CreatedAt: p.CreatedAt.UTC()}
+}
+
+// currentPartners returns the cached partners by ID, reading the table
+// again after partnerRefresh. A failed reload keeps the last good ones.
+func currentPartners(ctx context.Context) map[string]store.Partner {
+	partnerCache.Lock()
+	defer partnerCache.Unlock()
+	if !partnerCache.loaded.IsZero() && time.Since(partnerCache.loaded) < partnerRefresh {
+		return partnerCache.byID
+	}
+	list, err := st.ListPartners(ctx)
+	if err != nil {
+		logErrorContext(ctx, "PARTNERS_ERROR", fmt.Sprintf("Failed to load partners: %v", err))
+		partnerCache.loaded = time.Now()
+		return partnerCache.byID
+	}
+	byID := make(map[string]store.Partner, len(list))
+	for _, p := range list {
+		byID[p.ID] = p
+	}
+	partnerCache.byID, partnerCache.loaded = byID, time.Now()
+	return byID
+}
+
+// resetPartners makes the next request read the table again
+func resetPartners() {
+	partnerCache.Lock()
+	partnerCache.loaded = time.Time{}
+	partnerCache.Unlock()
+}
+
+// monthStart is the start of t's UTC calendar month, when quotas reset
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// checkPartner applies the limits of k's partner to a request to endpoint,
+// writing a 403 or 429 and returning false when it may not be made. Keys
+// without a partner have no limits; so do all keys when the quota cannot
+// be counted, as a failure to count should not lock partners out.
+func checkPartner(w http.ResponseWriter, r *http.Request, k *store.APIKey, endpoint string) bool {
+	if k.PartnerID == "" {
+		return true
+	}
+	p, ok := currentPartners(r.Context())[k.PartnerID]
+	if !ok {
+		return true
+	}
+	if len(p.Endpoints) > 0 && !slices.Contains(p.Endpoints, endpoint) {
+		logErrorContext(r.Context(), "APIKEY_FORBIDDEN", fmt.Sprintf("API key %d of partner %s may not call %s", k.ID, p.ID, endpoint))
+		writeAPIError(w, http.StatusForbidden, "endpoint_not_allowed", "This API key may not call this endpoint")
+		return false
+	}
+	if p.MonthlyQuota <= 0 {
+		return true
+	}
+	now := time.Now()
+	used, err := st.PartnerRequests(r.Context(), p.ID, monthStart(now))
+	if err != nil {
+		logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to count requests of partner %s: %v", p.ID, err))
+		return true
+	}
+	w.Header().Set("X-Quota-Limit", strconv.FormatInt(p.MonthlyQuota, 10))
+	if used >= p.MonthlyQuota {
+		quotaExceededTotal.Inc()
+		logErrorContext(r.Context(), "QUOTA_EXCEEDED", fmt.Sprintf("Partner %s used its %d monthly requests; key %d refused on %s", p.ID, p.MonthlyQuota, k.ID, r.URL.Path))
+		reset := monthStart(now).AddDate(0, 1, 0)
+		w.Header().Set("X-Quota-Remaining", "0")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Sub(now).Seconds()))))
+		writeAPIError(w, http.StatusTooManyRequests, "quota_exceeded", "The monthly request quota is used up; it resets on "+reset.Format(time.DateOnly))
+		return false
+	}
+	// This request is counted once it is let through
+	w.Header().Set("X-Quota-Remaining", strconv.FormatInt(p.MonthlyQuota-used-1, 10))
+	return true
+}
+
+// apiPartnersHandler lists the partners by ID
+func apiPartnersHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := st.ListPartners(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "PARTNERS_ERROR", fmt.Sprintf("Failed to list partners: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	out := make([]apiPartner, len(list))
+	for i := range list {
+		out[i] = toAPIPartner(&list[i])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"partners": out})
+}
+
+// apiPutPartnerHandler adds or replaces a partner: PUT /partners/{id} with
+// {"name", "monthly_quota", "endpoints"}. Only the name is required; no
+// quota or endpoints means no limit.
+func apiPutPartnerHandler(w http.ResponseWriter, r *http.Request) {
+	// Partner IDs are slugs like institution IDs
+	id := mux.Vars(r)["id"]
+	if !institutionIDPattern.MatchString(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "id must be 1 to 50 lower-case letters, digits, hyphens or underscores")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Name         string   `json:"name"`
+		MonthlyQuota int64    `json:"monthly_quota"`
+		Endpoints    []string `json:"endpoints"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"name": "...", "monthly_quota": 10000, "endpoints": ["..."]}`)
+		return
+	}
+	p := &store.Partner{ID: id, Name: strings.TrimSpace(body.Name), MonthlyQuota: body.MonthlyQuota}
+	if p.Name == "" || utf8.RuneCountInString(p.Name) > maxAPIKeyNameLength {
+		writeAPIError(w, http.StatusBadRequest, "invalid_name", fmt.Sprintf("name must be 1 to %d characters", maxAPIKeyNameLength))
+		return
+	}
+	if p.MonthlyQuota < 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_quota", "monthly_quota must be 0, for no limit, or more")
+		return
+	}
+	for _, e := range body.Endpoints {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if !slices.Contains(partnerEndpoints, e) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_endpoints", "endpoints must be among "+strings.Join(partnerEndpoints, ", "))
+			return
+		}
+		if !slices.Contains(p.Endpoints, e) {
+			p.Endpoints = append(p.Endpoints, e)
+		}
+	}
+
+	saved, err := st.PutPartner(r.Context(), p)
+	if err != nil {
+		logErrorContext(r.Context(), "PARTNERS_ERROR", fmt.Sprintf("Failed to save partner %s: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	resetPartners()
+	logErrorContext(r.Context(), "PARTNER_UPDATE_SUCCESS", fmt.Sprintf("%s saved partner %s", requestUser(r.Context()).Username, id))
+	out := toAPIPartner(saved)
+	publishChange(r.Context(), eventPartnerUpdated, "", out)
+	writeJSON(w, http.StatusOK, out)
+}
+
+// apiDeletePartnerHandler removes a partner without active keys
+func apiDeletePartnerHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := st.DeletePartner(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No partner with this ID")
+		return
+	} else if errors.Is(err, store.ErrInUse) {
+		writeAPIError(w, http.StatusConflict, "partner_in_use", "Active API keys still belong to this partner; revoke them first")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "PARTNERS_ERROR", fmt.Sprintf("Failed to delete partner %s: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	resetPartners()
+	logErrorContext(r.Context(), "PARTNER_DELETE_SUCCESS", fmt.Sprintf("%s deleted partner %s", requestUser(r.Context()).Username, id))
+	publishChange(r.Context(), eventPartnerDeleted, "", map[string]string{"id": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiPartnerUsageHandler reports a partner's requests in one UTC month
+// (?month=YYYY-MM, the current one by default): the total against the
+// quota, and the requests per day and per key
+func apiPartnerUsageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	since := monthStart(time.Now())
+	if v := r.URL.Query().Get("month"); v != "" {
+		t, err := time.Parse("2006-01", v)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_month", "month must be YYYY-MM")
+			return
+		}
+		since = t
+	}
+
+	p, err := st.GetPartner(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No partner with this ID")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "PARTNERS_ERROR", fmt.Sprintf("Failed to load partner %s: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	usage, err := st.PartnerUsage(r.Context(), id, since, since.AddDate(0, 1, 0))
+	if err != nil {
+		logErrorContext(r.Context(), "PARTNERS_ERROR", fmt.Sprintf("Failed to load usage of partner %s: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	keys, err := st.ListAPIKeys(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "APIKEY_DB_ERROR", fmt.Sprintf("Failed to list API keys: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	resp := apiPartnerUsage{PartnerID: p.ID, Month: since.Format("2006-01"), MonthlyQuota: p.MonthlyQuota,
+		Days: []apiPartnerUsageDay{}, Keys: []apiPartnerUsageKey{}}
+	byKey := make(map[int64]int64)
+	for _, u := range usage {
+		if n := len(resp.Days); n > 0 && resp.Days[n-1].Day == u.Day {
+			resp.Days[n-1].Requests += u.Requests
+		} else {
+			resp.Days = append(resp.Days, apiPartnerUsageDay{Day: u.Day, Requests: u.Requests})
+		}
+		byKey[u.KeyID] += u.Requests
+		resp.Requests += u.Requests
+	}
+	for _, k := range keys {
+		if k.PartnerID == p.ID {
+			resp.Keys = append(resp.Keys, apiPartnerUsageKey{KeyID: k.ID, Name: k.Name, Prefix: k.Prefix, Requests: byKey[k.ID]})
+		}
+	}
+	sort.SliceStable(resp.Keys, func(i, j int) bool { return resp.Keys[i].Requests > resp.Keys[j].Requests })
+	if p.MonthlyQuota > 0 {
+		remaining := max(p.MonthlyQuota-resp.Requests, 0)
+		resp.Remaining = &remaining
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// photoTypes are the image types accepted for upload, checked against the
+// sniffed content rather than the request's Content-Type
+var photoTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// apiPhoto is the v1 JSON schema for a stored photo
+type apiPhoto struct {
+	NationalID  string    `json:"national_id"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	URL         string    `json:"url"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// photoURL is the public link to a person's photo
+func photoURL(r *http.Request, nationalID string) string {
+	return publicBaseURL(r) + "/api/v1/people/" + url.PathEscape(nationalID) + "/photo"
+}
+
+// apiPhotoHandler serves a person's photo. The ID is looked up like any API
+// verification, so it counts towards rate limits and lockout and is audited.
+func apiPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := apiLookup(w, r, mux.Vars(r)["id"], "API_PHOTO")
+	if p == nil {
+		return
+	}
+	if !p.HasPhoto {
+		writeAPIError(w, http.StatusNotFound, "no_photo", "No photo is stored for this person")
+		return
+	}
+
+	ph, err := st.GetPhoto(r.Context(), p.NationalID)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "no_photo", "No photo is stored for this person")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "PHOTO_DB_ERROR", fmt.Sprintf("Failed to load photo for %s: %v", maskID(p.NationalID), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", ph.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%d"`, ph.UpdatedAt.Unix(), len(ph.Data)))
+	http.ServeContent(w, r, "", ph.UpdatedAt, bytes.NewReader(ph.Data))
+}
+
+// apiSetPhotoHandler stores the request body as a person's photo:
+// PUT /people/{id}/photo with a JPEG, PNG or WebP body of at most
+// PHOTO_MAX_BYTES
+func apiSetPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(cfg.PhotoMaxBytes)))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "photo_too_large",
+			fmt.Sprintf("Photos may be at most %d bytes", cfg.PhotoMaxBytes))
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "Failed to read the photo")
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if len(data) == 0 || !photoTypes[contentType] {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_photo", "The body must be a JPEG, PNG or WebP image")
+		return
+	}
+
+	ph, err := st.SetPhoto(r.Context(), id, contentType, data)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "PHOTO_DB_ERROR", fmt.Sprintf("Failed to store photo for %s: %v", maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "PHOTO_SET_SUCCESS", fmt.Sprintf("Stored %d byte %s photo for %s", len(data), contentType, maskID(ph.NationalID)))
+	writeJSON(w, http.StatusOK, apiPhoto{
+		NationalID:  ph.NationalID,
+		ContentType: ph.ContentType,
+		Size:        len(data),
+		URL:         photoURL(r, ph.NationalID),
+		UpdatedAt:   ph.UpdatedAt,
+	})
+}
+
+// apiDeletePhotoHandler removes a person's photo
+func apiDeletePhotoHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+
+	err := st.DeletePhoto(r.Context(), id)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No photo is stored for this person")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "PHOTO_DB_ERROR", fmt.Sprintf("Failed to delete photo for %s: %v", maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "PHOTO_DELETE_SUCCESS", "Deleted photo for "+maskID(id))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+// Personal data kept out of logs and stored history unless LOG_PII is on:
+// names and remarks are left out, IDs are truncated and caller numbers are
+// replaced with a hash.
+
+// redacted takes the place of a name or remark
+const redacted = "[redacted]"
+
+// piiText returns s for a log message, or redacted when LOG_PII is off
+func piiText(s string) string {
+	if cfg.LogPII || s == "" {
+		return s
+	}
+	return redacted
+}
+
+// maskID shortens an ID or other caller input to its first and last two
+// characters when LOG_PII is off, enough to tell entries apart while
+// reading a log but not to look anyone up
+func maskID(s string) string {
+	if cfg.LogPII || s == "" {
+		return s
+	}
+	r := []rune(s)
+	if len(r) <= 4 {
+		return strings.Repeat("*", len(r))
+	}
+	return string(r[:2]) + strings.Repeat("*", len(r)-4) + string(r[len(r)-2:])
+}
+
+// maskCaller replaces a caller's phone number with "h:" and a hash of it
+// when LOG_PII is off, so entries from one caller still match each other
+// and a filter on the number. The hash is keyed with PII_HASH_KEY when set;
+// without a key, numbers can be recovered by hashing every possible one.
+func maskCaller(s string) string {
+	if cfg.LogPII || s == "" {
+		return s
+	}
+	return "h:" + piiHash(s)[:16]
+}
+
+// piiHash is the hex SHA-256 of s, as an HMAC keyed with PII_HASH_KEY when
+// that is set
+func piiHash(s string) string {
+	if cfg.PIIHashKey == "" {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.PIIHashKey))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// callerFilter turns a phone number in a source or from filter into the
+// hash it is stored as; IP addresses and hashes are used as they are
+func callerFilter(v string) string {
+	if v == "" || strings.HasPrefix(v, "h:") || net.ParseIP(v) != nil {
+		return v
+	}
+	return maskCaller(v)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	qrDefaultSize = 256
+	qrMinSize     = 64
+	qrMaxSize     = 1024
+	// qrEmbedSize is the size of the QR code embedded in the HTML view
+	qrEmbedSize = 160
+)
+
+// publicBaseURL is PUBLIC_BASE_URL, falling back to the host r was sent to
+func publicBaseURL(r *http.Request) string {
+	base := strings.TrimSuffix(cfg.PublicBaseURL, "/")
+	if base == "" && r != nil {
+		base = "https://" + r.Host
+	}
+	return base
+}
+
+// verifyURL is the canonical link to the HTML verification view for id
+func verifyURL(r *http.Request, id string) string {
+	return publicBaseURL(r) + "/verify?id=" + url.QueryEscape(id)
+}
+
+// qrPNG encodes the verification URL for id as a PNG of size pixels square
+func qrPNG(r *http.Request, id string, size int) ([]byte, error) {
+	return qrcode.Encode(verifyURL(r, id), qrcode.Medium, size)
+}
+
+// qrDataURI returns the QR code for id as a data: URI to inline in the HTML
+// view, so the fragment also works when a frontend on another origin embeds
+// it. It returns "" if encoding fails.
+func qrDataURI(r *http.Request, id string) string {
+	png, err := qrPNG(r, id, qrEmbedSize)
+	if err != nil {
+		logErrorContext(r.Context(), "QR_ERROR", fmt.Sprintf("Failed to encode QR code for %s: %v", maskID(id), err))
+		return ""
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+}
+
+// qrHandler serves GET /qr/{id}.png, optionally sized with ?size=N. It does
+// not look the ID up, so it reveals nothing about whether it exists.
+func qrHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	size := qrDefaultSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			http.Error(w, fmt.Sprintf("size must be between %d and %d", qrMinSize, qrMaxSize), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	png, err := qrPNG(r, id, size)
+	if err != nil {
+		logErrorContext(r.Context(), "QR_ERROR", fmt.Sprintf("Failed to encode QR code for %s: %v", maskID(id), err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(png)
+}
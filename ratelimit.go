@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimit is a token bucket: Rate requests per minute on average, with
+// bursts of up to Burst requests. A zero Rate disables the limit.
+type rateLimit struct {
+	Name  string
+	Rate  int
+	Burst int
+}
+
+// bucketStore keeps token buckets. take removes a token from key's bucket
+// and reports whether one was available and, if not, how long until one is.
+type bucketStore interface {
+	take(ctx context.Context, key string, l rateLimit) (bool, time.Duration, error)
+}
+
+// buckets holds the rate-limit buckets; main swaps in Redis when configured
+var buckets bucketStore = newMemoryBuckets()
+
+// memoryBuckets is a process-local bucketStore
+type memoryBuckets struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newMemoryBuckets() *memoryBuckets {
+	return &memoryBuckets{buckets: make(map[string]*tokenBucket)}
+}
+
+// perSecond is the refill rate of l in tokens per second
+func (l rateLimit) perSecond() float64 {
+	return float64(l.Rate) / 60
+}
+
+func (m *memoryBuckets) take(ctx context.Context, key string, l rateLimit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	b, ok := m.buckets[key]
+	if !ok {
+		// Forget buckets that have refilled completely so the map stays small
+		if len(m.buckets) > 10000 {
+			full := time.Duration(float64(l.Burst) / l.perSecond() * float64(time.Second))
+			for k, old := range m.buckets {
+				if now.Sub(old.last) > full {
+					delete(m.buckets, k)
+				}
+			}
+		}
+		b = &tokenBucket{tokens: float64(l.Burst), last: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.perSecond())
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / l.perSecond() * float64(time.Second))
+	return false, wait, nil
+}
+
+// redisBucketScript refills and takes from a bucket atomically. Times are
+// in milliseconds; it returns {allowed, wait_ms}.
+var redisBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1]) / 60000
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + (now - ts) * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return {allowed, wait}
+`)
+
+// redisBuckets is a bucketStore shared by every instance through Redis
+type redisBuckets struct {
+	client *redis.Client
+}
+
+// redisBucketPrefix namespaces rate-limit buckets in a shared Redis
+const redisBucketPrefix = "hogwarts:bucket:"
+
+func (rb *redisBuckets) take(ctx context.Context, key string, l rateLimit) (bool, time.Duration, error) {
+	res, err := redisBucketScript.Run(ctx, rb.client, []string{redisBucketPrefix + key},
+		l.Rate, l.Burst, time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+// verifyRateLimit is shared by /verify and the API lookup endpoints, so a
+// client cannot double its allowance by switching between them
+func verifyRateLimit() rateLimit {
+	return rateLimit{Name: "verify", Rate: cfg.RateLimitVerify, Burst: cfg.RateLimitVerifyBurst}
+}
+
+// withRateLimit applies l to next, bucketing requests by key(r). Rejected
+// requests get a Retry-After header and are answered by deny. If the bucket
+// store fails the request is let through rather than blocking everyone.
+// Allowlisted clients are not limited.
+func withRateLimit(l rateLimit, key func(*http.Request) string, deny func(http.ResponseWriter, *http.Request)) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if l.Rate <= 0 {
+			return next
+		}
+		if l.Burst < 1 {
+			l.Burst = 1
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			if allowlisted(r.Context()) {
+				next(w, r)
+				return
+			}
+			k := key(r)
+			ok, wait, err := buckets.take(r.Context(), l.Name+":"+k, l)
+			if err != nil {
+				logErrorContext(r.Context(), "RATE_LIMIT_ERROR", fmt.Sprintf("Rate limit check failed for %s: %v", k, err))
+				next(w, r)
+				return
+			}
+			if !ok {
+				rateLimitedTotal.WithLabelValues(l.Name).Inc()
+				logErrorContext(r.Context(), "RATE_LIMITED", fmt.Sprintf("Rate limit %s exceeded by %s on %s", l.Name, k, r.URL.Path))
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				deny(w, r)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only used
+// when TRUST_PROXY_HEADERS is set, since clients can send it themselves;
+// the last entry is the one added by our own load balancer.
+func clientIP(r *http.Request) string {
+	if cfg.TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// twilioClientKey buckets Twilio webhooks by the hash of the caller
+// number: every webhook comes from Twilio's own addresses, so the IP says
+// nothing about the caller
+func twilioClientKey(r *http.Request) string {
+	if from := twilioCaller(r); from != "" {
+		return callerHash(from)
+	}
+	return clientIP(r)
+}
+
+// denyVerify answers a rate-limited /verify request in the negotiated format
+func denyVerify(w http.ResponseWriter, r *http.Request) {
+	verifyError(w, r, "Too many requests, please try again later", http.StatusTooManyRequests)
+}
+
+// denyAPI answers a rate-limited API request
+func denyAPI(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusTooManyRequests, "rate_limited", "Too many requests, please try again later")
+}
+
+// denyTwilioVoice ends a rate-limited call politely. Twilio treats any 4xx
+// as an application error, so the reply is TwiML with a 200 status.
+func denyTwilioVoice(w http.ResponseWriter, r *http.Request) {
+	lang := twilioLang(r)
+	writeTwiML(w, say(lang, twilioMsg(lang, "rate_limited")), twimlHangup{})
+}
+
+// denyTwilioMessage answers a rate-limited SMS or WhatsApp message
+func denyTwilioMessage(w http.ResponseWriter, r *http.Request) {
+	writeTwiML(w, twimlMessage{Body: textMsg("rate_limited")})
+}
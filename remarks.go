@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// maxRemarkLength bounds the text of one remark
+const maxRemarkLength = 2000
+
+// remarkTypes are the accepted remark_type values
+var remarkTypes = map[string]bool{
+	store.RemarkGeneral:      true,
+	store.RemarkAcademic:     true,
+	store.RemarkDisciplinary: true,
+	store.RemarkNote:         true,
+}
+
+// remarkVisibilities are the accepted visibility values
+var remarkVisibilities = map[string]bool{
+	store.RemarkPublic:  true,
+	store.RemarkWritten: true,
+	store.RemarkPrivate: true,
+}
+
+func remarkTypeList() string {
+	return strings.Join([]string{store.RemarkGeneral, store.RemarkAcademic, store.RemarkDisciplinary, store.RemarkNote}, ", ")
+}
+
+func remarkVisibilityList() string {
+	return strings.Join([]string{store.RemarkPublic, store.RemarkWritten, store.RemarkPrivate}, ", ")
+}
+
+// apiRemark is the v1 JSON schema for a remark
+type apiRemark struct {
+	ID         int64     `json:"id"`
+	Type       string    `json:"type"`
+	Visibility string    `json:"visibility"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func toAPIRemarks(remarks []store.Remark) []apiRemark {
+	out := make([]apiRemark, len(remarks))
+	for i, r := range remarks {
+		out[i] = apiRemark{ID: r.ID, Type: r.Type, Visibility: r.Visibility, Text: r.Text, CreatedAt: r.CreatedAt.UTC()}
+	}
+	return out
+}
+
+// remarksFor returns the remarks of p that may be shown on channel, one of
+// the audit channels: private remarks never are, and written ones are not
+// read out in calls
+func remarksFor(p *store.Person, channel string) []store.Remark {
+	var shown []store.Remark
+	for _, r := range p.Remarks {
+		if r.Visibility == store.RemarkPublic || r.Visibility == store.RemarkWritten && channel != auditChannelVoice {
+			shown = append(shown, r)
+		}
+	}
+	return shown
+}
+
+// remarkText joins the text of remarks into one remark, each on its own
+// line of HTML
+func remarkText(remarks []store.Remark) string {
+	texts := make([]string, len(remarks))
+	for i, r := range remarks {
+		texts[i] = r.Text
+	}
+	return strings.Join(texts, "<br>")
+}
+
+// apiRemarksHandler lists (GET, filtered by type and visibility) or adds
+// (POST {"type", "visibility", "text"}) remarks for
+// /people/{id}/remarks. Listing includes private remarks and deleted
+// people; type defaults to general and visibility to public.
+func apiRemarksHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+	ids, err := lookupIDs(id, "")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		remarkType, visibility := q.Get("type"), q.Get("visibility")
+		if remarkType != "" && !remarkTypes[remarkType] {
+			writeAPIError(w, http.StatusBadRequest, "invalid_type", "type must be one of "+remarkTypeList())
+			return
+		}
+		if visibility != "" && !remarkVisibilities[visibility] {
+			writeAPIError(w, http.StatusBadRequest, "invalid_visibility", "visibility must be one of "+remarkVisibilityList())
+			return
+		}
+		p, err := findPersonOrDeleted(r.Context(), ids)
+		if err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+			return
+		} else if err != nil {
+			logErrorContext(r.Context(), "REMARKS_DB_ERROR", fmt.Sprintf("Failed to list remarks for %s: %v", maskID(id), err))
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+		var remarks []store.Remark
+		for _, rm := range p.Remarks {
+			if (remarkType == "" || rm.Type == remarkType) && (visibility == "" || rm.Visibility == visibility) {
+				remarks = append(remarks, rm)
+			}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, map[string]interface{}{"national_id": p.NationalID, "remarks": toAPIRemarks(remarks)})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Type       string `json:"type"`
+		Visibility string `json:"visibility"`
+		Text       string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"type": "...", "visibility": "...", "text": "..."}`)
+		return
+	}
+	rm := &store.Remark{
+		Type:       strings.ToLower(strings.TrimSpace(body.Type)),
+		Visibility: strings.ToLower(strings.TrimSpace(body.Visibility)),
+		Text:       strings.TrimSpace(body.Text),
+	}
+	if rm.Type == "" {
+		rm.Type = store.RemarkGeneral
+	}
+	if rm.Visibility == "" {
+		rm.Visibility = store.RemarkPublic
+	}
+	if !remarkTypes[rm.Type] {
+		writeAPIError(w, http.StatusBadRequest, "invalid_type", "type must be one of "+remarkTypeList())
+		return
+	}
+	if !remarkVisibilities[rm.Visibility] {
+		writeAPIError(w, http.StatusBadRequest, "invalid_visibility", "visibility must be one of "+remarkVisibilityList())
+		return
+	}
+	if rm.Text == "" || utf8.RuneCountInString(rm.Text) > maxRemarkLength {
+		writeAPIError(w, http.StatusBadRequest, "invalid_text", fmt.Sprintf("text is required and must be at most %d characters", maxRemarkLength))
+		return
+	}
+
+	p, err := findPerson(r.Context(), ids, "")
+	if err == nil {
+		rm.NationalID = p.NationalID
+		rm, err = st.AddRemark(r.Context(), rm)
+	}
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "REMARKS_DB_ERROR", fmt.Sprintf("Failed to add a remark for %s: %v", maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "REMARK_ADD_SUCCESS", fmt.Sprintf("%s added %s %s remark %d for %s", requestUser(r.Context()).Username, rm.Visibility, rm.Type, rm.ID, maskID(rm.NationalID)))
+	writeJSON(w, http.StatusCreated, toAPIRemarks([]store.Remark{*rm})[0])
+}
+
+// apiDeleteRemarkHandler handles DELETE /people/{id}/remarks/{remark_id}
+func apiDeleteRemarkHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+	ids, err := lookupIDs(id, "")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return
+	}
+	remarkID, err := strconv.ParseInt(vars["remark_id"], 10, 64)
+	if err != nil || remarkID < 1 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_remark_id", "remark_id must be a positive integer")
+		return
+	}
+
+	p, err := findPerson(r.Context(), ids, "")
+	if err == nil {
+		err = st.DeleteRemark(r.Context(), p.NationalID, remarkID)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person or remark not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "REMARKS_DB_ERROR", fmt.Sprintf("Failed to delete remark %d for %s: %v", remarkID, maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "REMARK_DELETE_SUCCESS", fmt.Sprintf("%s deleted remark %d for %s", requestUser(r.Context()).Username, remarkID, maskID(p.NationalID)))
+	w.WriteHeader(http.StatusNoContent)
+}
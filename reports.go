@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Supported REPORT_FORMATS values
+const (
+	reportFormatCSV = "csv"
+	reportFormatPDF = "pdf"
+)
+
+// reportTimeLayout formats the bounds of a report's period
+const reportTimeLayout = "2006-01-02 15:04 MST"
+
+// reportFile is one file of a report, attached to the email and uploaded
+// to S3
+type reportFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// reportEmail is the HTML body of a report email; the figures are in the
+// attachments
+var reportEmail = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>{{.Title}}</h2>
+<p>{{.Period}}</p>
+<table cellpadding="4">
+<tr><td>Checks</td><td>{{.Stats.Checks}}</td></tr>
+<tr><td>Matches</td><td>{{.Stats.Matches}}</td></tr>
+<tr><td>Match rate</td><td>{{.MatchRate}}</td></tr>
+<tr><td>Distinct IDs found</td><td>{{.Stats.DistinctIDs}}</td></tr>
+</table>
+<p>The daily figures and the most frequent errors are attached.</p>
+</body></html>
+`))
+
+// validateReports checks the REPORT_* settings at startup
+func validateReports(c config) error {
+	if c.ReportSchedule == "" {
+		return nil
+	}
+	if _, err := parseCron(c.ReportSchedule); err != nil {
+		return fmt.Errorf("REPORT_SCHEDULE: %v", err)
+	}
+	if _, err := time.LoadLocation(c.ReportTimezone); err != nil {
+		return fmt.Errorf("REPORT_TIMEZONE: %v", err)
+	}
+	if c.ReportPeriod < time.Hour || c.ReportPeriod > statsMaxDays*24*time.Hour {
+		return fmt.Errorf("REPORT_PERIOD must be between 1h and %dh", statsMaxDays*24)
+	}
+	if len(c.ReportFormats) == 0 {
+		return errors.New("REPORT_FORMATS must list csv, pdf or both")
+	}
+	for _, format := range c.ReportFormats {
+		if format != reportFormatCSV && format != reportFormatPDF {
+			return fmt.Errorf("REPORT_FORMATS must list csv, pdf or both, not %q", format)
+		}
+	}
+	if len(c.ReportEmailTo) == 0 && c.ReportS3Bucket == "" {
+		return errors.New("REPORT_EMAIL_TO or REPORT_S3_BUCKET is required with REPORT_SCHEDULE")
+	}
+	if len(c.ReportEmailTo) > 0 && c.SMTPHost == "" {
+		return errors.New("REPORT_EMAIL_TO needs SMTP_HOST")
+	}
+	for _, to := range c.ReportEmailTo {
+		if _, err := parseEmail(to); err != nil {
+			return fmt.Errorf("REPORT_EMAIL_TO: %q is not an email address", to)
+		}
+	}
+	if c.ReportS3Bucket != "" {
+		if c.ReportS3AccessKey == "" || c.ReportS3SecretKey == "" {
+			return errors.New("REPORT_S3_ACCESS_KEY_ID and REPORT_S3_SECRET_ACCESS_KEY are required with REPORT_S3_BUCKET")
+		}
+		if c.ReportS3Endpoint != "" {
+			if u, err := url.Parse(c.ReportS3Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return errors.New("REPORT_S3_ENDPOINT must be an absolute http(s) URL")
+			}
+		}
+	}
+	return nil
+}
+
+// reportJob generates and delivers the report due at run, over
+// REPORT_PERIOD before it
+func reportJob(ctx context.Context, run time.Time) (string, error) {
+	since := run.Add(-cfg.ReportPeriod)
+	stats, err := computeStats(ctx, "", since.UTC(), run.UTC())
+	if err != nil {
+		return "", fmt.Errorf("failed to compute the report: %v", err)
+	}
+	files, err := reportFiles(ctx, stats, since, run)
+	if err != nil {
+		return "", fmt.Errorf("failed to render the report: %v", err)
+	}
+	if err := deliverReport(ctx, stats, since, run, files); err != nil {
+		return "", fmt.Errorf("failed to deliver the report: %v", err)
+	}
+	return fmt.Sprintf("sent the report for %s to %s: %d checks", since.Format(reportTimeLayout), run.Format(reportTimeLayout), stats.Checks), nil
+}
+
+// reportTitle names the report after the branding
+func reportTitle(ctx context.Context) string {
+	if name := currentBranding(ctx).Name; name != "" {
+		return name + " verification report"
+	}
+	return "Verification report"
+}
+
+// reportFiles renders the report in REPORT_FORMATS: usage and errors CSVs
+// and a PDF of both, named after the day the period ends
+func reportFiles(ctx context.Context, stats *apiStats, since, until time.Time) ([]reportFile, error) {
+	suffix := until.Format("2006-01-02")
+	var files []reportFile
+	for _, format := range cfg.ReportFormats {
+		switch format {
+		case reportFormatCSV:
+			usage, err := reportUsageCSV(stats)
+			if err != nil {
+				return nil, err
+			}
+			errorTypes, err := reportErrorsCSV(stats)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files,
+				reportFile{Name: "usage-" + suffix + ".csv", ContentType: "text/csv", Data: usage},
+				reportFile{Name: "errors-" + suffix + ".csv", ContentType: "text/csv", Data: errorTypes},
+			)
+		case reportFormatPDF:
+			pdf, err := reportPDF(ctx, stats, since, until)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, reportFile{Name: "report-" + suffix + ".pdf", ContentType: "application/pdf", Data: pdf})
+		}
+	}
+	return files, nil
+}
+
+// reportUsageCSV lists checks and matches per day and channel, then per
+// channel and in total
+func reportUsageCSV(stats *apiStats) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"day", "channel", "checks", "matches", "match_rate"})
+	for _, d := range stats.Daily {
+		w.Write([]string{d.Day, d.Channel, strconv.Itoa(d.Checks), strconv.Itoa(d.Matches), formatRate(matchRate(d.Matches, d.Checks))})
+	}
+	for _, name := range reportChannels(stats) {
+		c := stats.Channels[name]
+		w.Write([]string{"total", name, strconv.Itoa(c.Checks), strconv.Itoa(c.Matches), formatRate(c.MatchRate)})
+	}
+	w.Write([]string{"total", "all", strconv.Itoa(stats.Checks), strconv.Itoa(stats.Matches), formatRate(stats.MatchRate)})
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// reportErrorsCSV lists the most frequent error types
+func reportErrorsCSV(stats *apiStats) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"error_type", "count"})
+	for _, e := range stats.TopErrors {
+		w.Write([]string{e.ErrorType, strconv.Itoa(e.Count)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// reportChannels returns the channels with checks, in name order
+func reportChannels(stats *apiStats) []string {
+	names := make([]string, 0, len(stats.Channels))
+	for name := range stats.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatRate renders a match rate as a percentage
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate*100, 'f', 1, 64) + "%"
+}
+
+// reportPDF renders the report as an A4 PDF: the totals, then tables per
+// channel, outcome, day and error type
+func reportPDF(ctx context.Context, stats *apiStats, since, until time.Time) ([]byte, error) {
+	title := reportTitle(ctx)
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("hogwarts_verify", true)
+	pdf.SetCreationDate(time.Now())
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetAutoPageBreak(true, 20)
+	face, bold, tr := pdfFonts(pdf)
+	pdf.AddPage()
+
+	pdf.SetFont(face, bold, 18)
+	pdf.CellFormat(0, 10, tr(title), "", 1, "L", false, 0, "")
+	pdf.SetFont(face, "", 11)
+	pdf.CellFormat(0, 7, tr(since.Format(reportTimeLayout)+" to "+until.Format(reportTimeLayout)), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	table := func(heading string, header []string, rows [][]string) {
+		pdf.SetFont(face, bold, 13)
+		pdf.CellFormat(0, 9, tr(heading), "", 1, "L", false, 0, "")
+		if len(rows) == 0 {
+			pdf.SetFont(face, "", 10)
+			pdf.CellFormat(0, 6, "None", "", 1, "L", false, 0, "")
+			pdf.Ln(4)
+			return
+		}
+		width := 170 / float64(len(rows[0]))
+		if header != nil {
+			pdf.SetFont(face, bold, 10)
+			for _, h := range header {
+				pdf.CellFormat(width, 6, tr(h), "B", 0, "L", false, 0, "")
+			}
+			pdf.Ln(-1)
+		}
+		pdf.SetFont(face, "", 10)
+		for _, row := range rows {
+			for _, cell := range row {
+				pdf.CellFormat(width, 6, tr(cell), "", 0, "L", false, 0, "")
+			}
+			pdf.Ln(-1)
+		}
+		pdf.Ln(4)
+	}
+
+	table("Summary", nil, [][]string{
+		{"Checks", strconv.Itoa(stats.Checks)},
+		{"Matches", strconv.Itoa(stats.Matches)},
+		{"Match rate", formatRate(stats.MatchRate)},
+		{"Distinct IDs found", strconv.Itoa(stats.DistinctIDs)},
+	})
+	var rows [][]string
+	for _, name := range reportChannels(stats) {
+		c := stats.Channels[name]
+		rows = append(rows, []string{name, strconv.Itoa(c.Checks), strconv.Itoa(c.Matches), formatRate(c.MatchRate)})
+	}
+	table("Channels", []string{"Channel", "Checks", "Matches", "Match rate"}, rows)
+
+	rows = nil
+	for outcome, n := range stats.Outcomes {
+		rows = append(rows, []string{outcome, strconv.Itoa(n)})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	table("Outcomes", []string{"Outcome", "Checks"}, rows)
+
+	rows = nil
+	for _, d := range stats.Daily {
+		rows = append(rows, []string{d.Day, d.Channel, strconv.Itoa(d.Checks), strconv.Itoa(d.Matches)})
+	}
+	table("Daily (UTC)", []string{"Day", "Channel", "Checks", "Matches"}, rows)
+
+	rows = nil
+	for _, e := range stats.TopErrors {
+		rows = append(rows, []string{e.ErrorType, strconv.Itoa(e.Count)})
+	}
+	table("Most frequent errors", []string{"Error type", "Count"}, rows)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deliverReport emails the files to each of REPORT_EMAIL_TO and uploads
+// them under REPORT_S3_PREFIX, trying every destination even if one fails
+func deliverReport(ctx context.Context, stats *apiStats, since, until time.Time, files []reportFile) error {
+	var errs []error
+	if len(cfg.ReportEmailTo) > 0 {
+		title := reportTitle(ctx)
+		period := since.Format(reportTimeLayout) + " to " + until.Format(reportTimeLayout)
+		var html bytes.Buffer
+		err := reportEmail.Execute(&html, map[string]interface{}{
+			"Title": title, "Period": period, "Stats": stats, "MatchRate": formatRate(stats.MatchRate),
+		})
+		if err != nil {
+			return err
+		}
+		text := fmt.Sprintf("%s\n%s\n\nChecks: %d\nMatches: %d\nMatch rate: %s\nDistinct IDs found: %d\n\nThe daily figures and the most frequent errors are attached.\n",
+			title, period, stats.Checks, stats.Matches, formatRate(stats.MatchRate), stats.DistinctIDs)
+		m := &mailMessage{Subject: title + ", " + period, Text: text, HTML: html.String()}
+		for _, f := range files {
+			m.Attachments = append(m.Attachments, mailAttachment{Name: f.Name, ContentType: f.ContentType, Data: f.Data})
+		}
+		for _, to := range cfg.ReportEmailTo {
+			m.To = to
+			m.MessageID = "report." + newReference(time.Now())
+			if err := sendMail(ctx, m); err != nil {
+				errs = append(errs, fmt.Errorf("email to %s: %v", to, err))
+			}
+		}
+	}
+	if cfg.ReportS3Bucket != "" {
+		for _, f := range files {
+			key := cfg.ReportS3Prefix + f.Name
+			if err := reportBucket().put(ctx, key, f.ContentType, f.Data); err != nil {
+				errs = append(errs, fmt.Errorf("upload of %s: %v", key, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return nil
+}
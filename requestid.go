@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// requestIDRegex bounds accepted IDs to a safe charset, since they end up
+// in logs and response headers
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// requestIDMiddleware gives every request an ID: the load balancer's
+// X-Request-ID when TRUST_PROXY_HEADERS is set and it looks sane,
+// otherwise a new ULID. The ID is echoed in the response and carried in
+// the request context for logErrorContext and the audit log.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !cfg.TrustProxyHeaders || !requestIDRegex.MatchString(id) {
+			id = ulid.Make().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID of the request ctx belongs to, or ""
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// verifyResponse is the JSON representation of a successful verification
+type verifyResponse struct {
+	ID       string `json:"id"`
+	FullName string `json:"full_name"`
+	Category string `json:"category"`
+	// CategoryLabel is how the category is displayed
+	CategoryLabel string `json:"category_label"`
+	// Remark joins the text of the Remarks the channel may show
+	Remark  string      `json:"remark"`
+	Remarks []apiRemark `json:"remarks"`
+	Courses []string    `json:"courses,omitempty"`
+	// IssueDate and ExpiryDate are YYYY-MM-DD, omitted when not recorded
+	IssueDate  string `json:"issue_date,omitempty"`
+	ExpiryDate string `json:"expiry_date,omitempty"`
+	Expired    bool   `json:"expired"`
+	Revoked    bool   `json:"revoked"`
+	// Verified is false for revoked credentials
+	Verified bool   `json:"verified"`
+	Status   string `json:"status"`
+	// Reference identifies this check for GET /api/v1/verifications/{reference}
+	Reference string    `json:"reference"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// formatDate renders a DATE column as YYYY-MM-DD, or "" when unset
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// Credential states; revocation takes precedence over expiry
+const (
+	stateValid   = "valid"
+	stateExpired = "expired"
+	stateRevoked = "revoked"
+)
+
+// revokedNotice is shown on every channel for a revoked credential
+const revokedNotice = "This certificate has been revoked."
+
+// credentialState reports whether p's credential is valid, expired or revoked
+func credentialState(p *store.Person) string {
+	switch {
+	case p.Revoked:
+		return stateRevoked
+	case p.Expired(time.Now()):
+		return stateExpired
+	default:
+		return stateValid
+	}
+}
+
+// verificationStatus is the status line shown for a verified person
+func verificationStatus(p *store.Person) string {
+	switch credentialState(p) {
+	case stateRevoked:
+		return "REVOKED"
+	case stateExpired:
+		return "EXPIRED"
+	default:
+		return "APPROVED AND VERIFIED"
+	}
+}
+
+// plainStatus is the closing status line of SMS replies
+func plainStatus(state string) string {
+	switch state {
+	case stateRevoked:
+		return "STATUS: REVOKED. " + revokedNotice
+	case stateExpired:
+		return "STATUS: EXPIRED"
+	default:
+		return "APPROVED AND VERIFIED: YES"
+	}
+}
+
+// errorResponse is the JSON body returned for failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// wantsJSON reports whether the client prefers JSON over HTML, based on the
+// Accept header. Browsers send text/html first, so they keep getting HTML.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html", "application/xhtml+xml":
+			return false
+		}
+	}
+	return false
+}
+
+// writeJSON encodes v as the response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// verifyError writes an error in the format negotiated by the client; HTML
+// clients get the not-found or error page with message translated
+func verifyError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if wantsJSON(r) {
+		writeJSON(w, status, errorResponse{Error: message})
+		return
+	}
+	name := pageError
+	if status == http.StatusNotFound {
+		name = pageNotFound
+	}
+	page := errorPage{pageContext: newPageContext(r), Status: status}
+	page.Message = pageErrorMsg(page.Lang, message)
+	renderPage(w, status, name, page)
+}
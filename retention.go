@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// retentionTable is a table rows are deleted from once they are older than
+// its retention window
+type retentionTable struct {
+	Name      string
+	Retention time.Duration
+	// count and purge act on the rows from before a time
+	count func(ctx context.Context, before time.Time) (int, error)
+	purge func(ctx context.Context, before time.Time) (int64, error)
+}
+
+// apiRetention is the v1 JSON schema for a table's retention. Before and
+// Rows, the rows a purge would delete now, are omitted for a table kept for
+// ever.
+type apiRetention struct {
+	Table     string     `json:"table"`
+	Retention string     `json:"retention"`
+	Before    *time.Time `json:"before,omitempty"`
+	Rows      int        `json:"rows"`
+}
+
+// retentionTables are the tables with a retention setting
+func retentionTables() []retentionTable {
+	return []retentionTable{{
+		Name:      "errors",
+		Retention: cfg.ErrorsRetention,
+		count: func(ctx context.Context, before time.Time) (int, error) {
+			_, n, err := st.ListErrors(ctx, store.ErrorFilter{Until: errorsZone(before)}, 0, 0)
+			return n, err
+		},
+		purge: func(ctx context.Context, before time.Time) (int64, error) {
+			return st.PurgeErrors(ctx, store.ErrorFilter{Until: errorsZone(before)})
+		},
+	}, {
+		Name:      "audit_log",
+		Retention: cfg.AuditLogRetention,
+		count: func(ctx context.Context, before time.Time) (int, error) {
+			_, n, err := st.ListAudit(ctx, store.AuditFilter{Until: before}, 0, 0)
+			return n, err
+		},
+		purge: st.PurgeAudit,
+	}, {
+		Name:      "calls",
+		Retention: cfg.CallsRetention,
+		count: func(ctx context.Context, before time.Time) (int, error) {
+			_, n, err := st.ListCalls(ctx, store.CallFilter{Until: before}, 0, 0)
+			return n, err
+		},
+		purge: st.PurgeCalls,
+	}}
+}
+
+// retentionJob deletes the rows older than each table's retention window,
+// or with RETENTION_DRY_RUN only counts them
+func retentionJob(ctx context.Context, at time.Time) (string, error) {
+	var done []string
+	for _, t := range retentionTables() {
+		if t.Retention <= 0 {
+			continue
+		}
+		before := at.Add(-t.Retention).UTC()
+		if cfg.RetentionDryRun {
+			n, err := t.count(ctx, before)
+			if err != nil {
+				return strings.Join(done, ", "), fmt.Errorf("failed to count %s rows: %v", t.Name, err)
+			}
+			if n > 0 {
+				done = append(done, fmt.Sprintf("would delete %d %s rows from before %s", n, t.Name, before.Format(time.RFC3339)))
+			}
+			continue
+		}
+		n, err := t.purge(ctx, before)
+		if err != nil {
+			return strings.Join(done, ", "), fmt.Errorf("failed to purge %s: %v", t.Name, err)
+		}
+		if n > 0 {
+			done = append(done, fmt.Sprintf("deleted %d %s rows from before %s", n, t.Name, before.Format(time.RFC3339)))
+		}
+	}
+	return strings.Join(done, ", "), nil
+}
+
+// apiRetentionHandler reports each table's retention and how many rows a
+// purge would delete now, deleting nothing
+func apiRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	list := []apiRetention{}
+	for _, t := range retentionTables() {
+		out := apiRetention{Table: t.Name, Retention: "0"}
+		if t.Retention > 0 {
+			before := now.Add(-t.Retention)
+			n, err := t.count(r.Context(), before)
+			if err != nil {
+				logErrorContext(r.Context(), "RETENTION_DB_ERROR", fmt.Sprintf("Failed to count %s rows: %v", t.Name, err))
+				writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+				return
+			}
+			out.Retention, out.Before, out.Rows = t.Retention.String(), &before, n
+		}
+		list = append(list, out)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"dry_run": cfg.RetentionDryRun, "tables": list})
+}
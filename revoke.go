@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// maxRevocationReasonLength bounds the stored revocation reason
+const maxRevocationReasonLength = 1000
+
+// apiRevocation is the v1 JSON schema for a person's revocation state. The
+// reason is only returned to admins; public responses show revoked: true.
+type apiRevocation struct {
+	NationalID       string     `json:"national_id"`
+	Revoked          bool       `json:"revoked"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+}
+
+func toAPIRevocation(p *store.Person) apiRevocation {
+	rev := apiRevocation{NationalID: p.NationalID, Revoked: p.Revoked, RevocationReason: p.RevocationReason}
+	if !p.RevokedAt.IsZero() {
+		rev.RevokedAt = &p.RevokedAt
+	}
+	return rev
+}
+
+// apiRevokeHandler revokes a credential: POST /people/{id}/revoke with
+// {"reason": "..."}
+func apiRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"reason": "..."}`)
+		return
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if reason == "" || len(reason) > maxRevocationReasonLength {
+		writeAPIError(w, http.StatusBadRequest, "invalid_reason",
+			fmt.Sprintf("reason is required and may be at most %d characters", maxRevocationReasonLength))
+		return
+	}
+	setRevoked(w, r, true, reason)
+}
+
+// apiUnrevokeHandler reinstates a revoked credential: POST /people/{id}/unrevoke
+func apiUnrevokeHandler(w http.ResponseWriter, r *http.Request) {
+	setRevoked(w, r, false, "")
+}
+
+func setRevoked(w http.ResponseWriter, r *http.Request, revoked bool, reason string) {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return
+	}
+
+	p, err := st.SetRevoked(r.Context(), id, revoked, reason)
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Person not found")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "REVOKE_DB_ERROR", fmt.Sprintf("Failed to update revocation for %s: %v", maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	rev := toAPIRevocation(p)
+	if revoked {
+		logErrorContext(r.Context(), "REVOKE_SUCCESS", fmt.Sprintf("Revoked %s: %s", maskID(p.NationalID), reason))
+		publishEvent(r.Context(), eventPersonRevoked, p.NationalID, rev)
+	} else {
+		logErrorContext(r.Context(), "UNREVOKE_SUCCESS", fmt.Sprintf("Reinstated %s", maskID(p.NationalID)))
+		publishEvent(r.Context(), eventPersonReinstated, p.NationalID, rev)
+	}
+	writeJSON(w, http.StatusOK, rev)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// s3Client talks to S3 for reports and attachments; both are small, so a
+// minute is plenty
+var s3Client = &http.Client{Timeout: time.Minute}
+
+// errS3NotFound is returned by get for a key that does not exist
+var errS3NotFound = errors.New("no such S3 object")
+
+// s3Bucket is an S3 bucket and the credentials to use it
+type s3Bucket struct {
+	Name      string
+	Region    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+}
+
+// reportBucket is REPORT_S3_BUCKET
+func reportBucket() s3Bucket {
+	return s3Bucket{Name: cfg.ReportS3Bucket, Region: cfg.ReportS3Region, Endpoint: cfg.ReportS3Endpoint,
+		AccessKey: cfg.ReportS3AccessKey, SecretKey: cfg.ReportS3SecretKey}
+}
+
+// objectURL is where key is stored in b: path-style on its endpoint (MinIO
+// and other S3-compatible stores), else the virtual-hosted AWS URL for its
+// region
+func (b s3Bucket) objectURL(key string) *url.URL {
+	path := "/" + strings.TrimLeft(key, "/")
+	if b.Endpoint != "" {
+		u, _ := url.Parse(strings.TrimRight(b.Endpoint, "/"))
+		u.Path += "/" + b.Name + path
+		return u
+	}
+	return &url.URL{Scheme: "https", Host: b.Name + ".s3." + b.Region + ".amazonaws.com", Path: path}
+}
+
+// put uploads data to key
+func (b s3Bucket) put(ctx context.Context, key, contentType string, data []byte) error {
+	_, err := b.do(ctx, http.MethodPut, key, contentType, data)
+	return err
+}
+
+// get downloads the object at key; errS3NotFound if there is none
+func (b s3Bucket) get(ctx context.Context, key string) ([]byte, error) {
+	return b.do(ctx, http.MethodGet, key, "", nil)
+}
+
+// remove deletes the object at key; S3 reports success for a missing key
+func (b s3Bucket) remove(ctx context.Context, key string) error {
+	_, err := b.do(ctx, http.MethodDelete, key, "", nil)
+	return err
+}
+
+// do sends a request for key signed with AWS Signature Version 4 and
+// returns the response body
+func (b s3Bucket) do(ctx context.Context, method, key, contentType string, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.sign(req, data, time.Now().UTC())
+
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return nil, errS3NotFound
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("S3 returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// sign adds the x-amz-* headers and Authorization of a SigV4 signature
+// over the host, content type (when set) and payload hash
+func (b s3Bucket) sign(req *http.Request, payload []byte, now time.Time) {
+	const algorithm = "AWS4-HMAC-SHA256"
+	stamp := now.Format("20060102T150405Z")
+	day := stamp[:8]
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed, headers := "host;x-amz-content-sha256;x-amz-date", ""
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		signed, headers = "content-type;"+signed, "content-type:"+ct+"\n"
+	}
+	headers += "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + stamp + "\n"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers,
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + b.Region + "/s3/aws4_request"
+	toSign := algorithm + "\n" + stamp + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := []byte("AWS4" + b.SecretKey)
+	for _, part := range []string{day, b.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, b.AccessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	searchDefaultPerPage = 20
+	searchMaxPerPage     = 100
+	searchMinQueryLen    = 2
+)
+
+// apiSearchCandidate is a single name search hit
+type apiSearchCandidate struct {
+	NationalID string `json:"national_id"`
+	FullName   string `json:"full_name"`
+	Category   string `json:"category"`
+	Match      string `json:"match"`
+	Distance   int    `json:"distance"`
+}
+
+// apiSearchResult is the v1 JSON schema for a paginated name search
+type apiSearchResult struct {
+	Query   string               `json:"query"`
+	Page    int                  `json:"page"`
+	PerPage int                  `json:"per_page"`
+	Total   int                  `json:"total"`
+	Results []apiSearchCandidate `json:"results"`
+}
+
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := strings.TrimSpace(q.Get("name"))
+	if utf8.RuneCountInString(name) < searchMinQueryLen || len(name) > 100 {
+		logErrorContext(r.Context(), "API_SEARCH_INVALID_QUERY", fmt.Sprintf("Invalid search query: %q", piiText(name)))
+		writeAPIError(w, http.StatusBadRequest, "invalid_query",
+			fmt.Sprintf("name must be between %d and 100 characters", searchMinQueryLen))
+		return
+	}
+
+	page, perPage, ok := parsePagination(w, r, searchDefaultPerPage, searchMaxPerPage)
+	if !ok {
+		return
+	}
+
+	matches, err := st.Search(r.Context(), name)
+	if breakerRefused(w, err) {
+		denyBusyAPI(w, r)
+		return
+	} else if errors.Is(err, store.ErrTooBroad) {
+		logErrorContext(r.Context(), "API_SEARCH_TOO_BROAD", fmt.Sprintf("Search for %q would rank more than %d people", piiText(name), cfg.SearchMaxCandidates))
+		writeAPIError(w, http.StatusUnprocessableEntity, "too_broad", "Too many people to search through; try more of the name")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "API_SEARCH_DB_ERROR", fmt.Sprintf("Database error searching for %q: %v", piiText(name), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	// People who opted out of public lookups are only found by partners
+	partner := partnerRequest(r)
+	candidates := make([]apiSearchCandidate, 0, len(matches))
+	for _, m := range matches {
+		if (!m.Person.PublicLookup && !partner) || !inScope(r.Context(), &m.Person) {
+			continue
+		}
+		candidates = append(candidates, apiSearchCandidate{
+			NationalID: m.Person.NationalID,
+			FullName:   m.Person.FullName,
+			Category:   m.Person.Category,
+			Match:      m.Match,
+			Distance:   m.Distance,
+		})
+	}
+
+	result := apiSearchResult{
+		Query:   name,
+		Page:    page,
+		PerPage: perPage,
+		Total:   len(candidates),
+		Results: []apiSearchCandidate{},
+	}
+	start := (page - 1) * perPage
+	if start < len(candidates) {
+		end := start + perPage
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+		result.Results = candidates[start:end]
+	}
+
+	logErrorContext(r.Context(), "API_SEARCH_SUCCESS", fmt.Sprintf("Search for %q returned %d candidates", piiText(name), len(candidates)))
+	writeJSON(w, http.StatusOK, result)
+}
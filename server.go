@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// unixSocketMode lets a reverse proxy in the service's group connect
+const unixSocketMode = 0o660
+
+// listen opens addr: host:port for TCP, or unix:/path for a unix socket.
+// A socket file left behind by a previous run is removed first.
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, unixSocketMode); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_ADDR %q: %w", addr, err)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serve runs the server on LISTEN_ADDR until it fails: HTTPS with
+// CERT_FILE and KEY_FILE (reloaded when they change, see certReloader) or
+// Let's Encrypt certificates, or plain HTTP when TLS_ENABLED=false and TLS is
+// terminated by a reverse proxy. main checks the cert settings first.
+func serve(c config, h http.Handler) error {
+	l, err := listen(c.ListenAddr)
+	if err != nil {
+		return err
+	}
+	srv := newServer(c, h)
+
+	if !c.TLSEnabled {
+		logError("SERVER_START_SUCCESS", "Listening for plain HTTP on "+c.ListenAddr)
+		return srv.Serve(l)
+	}
+
+	redirect := httpsRedirectHandler(c)
+	if len(c.AutocertDomains) > 0 {
+		m := autocertManager(c)
+		srv.TLSConfig = m.TLSConfig()
+		// The redirect listener also answers ACME HTTP-01 challenges
+		redirect = m.HTTPHandler(redirect)
+		logError("SERVER_START_SUCCESS", fmt.Sprintf("Listening for HTTPS on %s with Let's Encrypt certificates for %s",
+			c.ListenAddr, strings.Join(c.AutocertDomains, ", ")))
+	} else {
+		cr, err := newCertReloader(c.CertFile, c.KeyFile)
+		if err != nil {
+			l.Close()
+			return fmt.Errorf("load CERT_FILE and KEY_FILE: %w", err)
+		}
+		go cr.watch(c.CertReloadInterval)
+		srv.TLSConfig = &tls.Config{GetCertificate: cr.GetCertificate}
+		logError("SERVER_START_SUCCESS", "Listening for HTTPS on "+c.ListenAddr)
+	}
+	if c.HTTPRedirectAddr != "" {
+		go serveRedirects(newServer(c, redirect), c.HTTPRedirectAddr)
+	}
+	return srv.ServeTLS(l, "", "")
+}
+
+// newServer returns a server for h with the SERVER_*_TIMEOUT settings, so
+// slow clients cannot hold connections open indefinitely. Handlers that
+// stream large responses lift the write deadline with
+// extendWriteDeadline.
+func newServer(c config, h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: c.ServerReadHeaderTimeout,
+		ReadTimeout:       c.ServerReadTimeout,
+		WriteTimeout:      c.ServerWriteTimeout,
+		IdleTimeout:       c.ServerIdleTimeout,
+	}
+}
+
+// extendWriteDeadline removes the server write timeout for a streaming
+// response. It is best effort: writers that cannot set deadlines are left
+// alone.
+func extendWriteDeadline(w http.ResponseWriter) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// limitForm caps form bodies at maxBytes and parses them up front, so a
+// larger body is refused with 413 before any handler or limiter reads it
+// (a failed ParseForm is not reported again on later calls)
+func limitForm(maxBytes int64) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			if err := r.ParseForm(); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					logErrorContext(r.Context(), "REQUEST_TOO_LARGE", fmt.Sprintf("Rejected %d+ byte form body for %s from %s", maxBytes, r.URL.Path, clientIP(r)))
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				logErrorContext(r.Context(), "REQUEST_INVALID_FORM", fmt.Sprintf("Failed to parse form data for %s: %v", r.URL.Path, err))
+				http.Error(w, "Invalid form data", http.StatusBadRequest)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// autocertManager obtains certificates for AUTOCERT_DOMAINS only, caching
+// them in AUTOCERT_CACHE_DIR so restarts do not hit Let's Encrypt's rate
+// limits. Certificates are renewed in the background before they expire.
+func autocertManager(c config) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.AutocertDomains...),
+		Cache:      autocert.DirCache(c.AutocertCacheDir),
+		Email:      c.AutocertEmail,
+	}
+}
+
+// serveRedirects serves plain HTTP on addr with srv. A failure is logged
+// but not fatal: the HTTPS listener keeps running.
+func serveRedirects(srv *http.Server, addr string) {
+	logError("SERVER_START_SUCCESS", "Listening for plain HTTP redirects on "+addr)
+	srv.Addr = addr
+	if err := srv.ListenAndServe(); err != nil {
+		logError("SERVER_ERROR", fmt.Sprintf("HTTP redirect listener on %s failed: %v", addr, err))
+	}
+}
+
+// httpsRedirectHandler answers every request with a 301 to the same path
+// on the HTTPS endpoint: PUBLIC_BASE_URL when it is https, otherwise the
+// requested host on LISTEN_ADDR's port
+func httpsRedirectHandler(c config) http.Handler {
+	base := strings.TrimSuffix(c.PublicBaseURL, "/")
+	if !strings.HasPrefix(base, "https://") {
+		base = ""
+	}
+	_, port, _ := net.SplitHostPort(c.ListenAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := base
+		if target == "" {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+			target = "https://" + host
+			if port != "" && port != "443" {
+				target += ":" + port
+			}
+		}
+		http.Redirect(w, r, target+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
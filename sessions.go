@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	// sessionCookie holds the dashboard session token
+	sessionCookie = "hv_session"
+	// Password length limits; bcrypt ignores anything past 72 bytes
+	minPasswordLength = 12
+	maxPasswordLength = 72
+)
+
+// dummyPasswordHash is compared against when a login names an unknown
+// user, so the response time does not reveal which usernames exist
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// loginPage is the data for the login template
+type loginPage struct {
+	Next     string
+	Username string
+	Error    string
+	// SSO names the OIDC provider to offer, "" when none is configured
+	SSO string
+}
+
+// loginRateLimit bounds password guessing per client IP
+func loginRateLimit() rateLimit {
+	return rateLimit{Name: "login", Rate: cfg.RateLimitLogin, Burst: cfg.RateLimitLoginBurst}
+}
+
+// denyLogin answers a rate-limited login attempt
+func denyLogin(w http.ResponseWriter, r *http.Request) {
+	renderLogin(w, r, http.StatusTooManyRequests, "Too many sign-in attempts, please try again later")
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	renderPage(w, status, pageAdminLogin, loginPage{
+		Next:     loginNext(r.FormValue("next")),
+		Username: r.PostFormValue("username"),
+		Error:    message,
+		SSO:      oidcProviderName(),
+	})
+}
+
+// loginNext returns where to go after logging in: next if it is an admin
+// page on this site, otherwise the dashboard
+func loginNext(next string) string {
+	if !strings.HasPrefix(next, "/admin/") || strings.HasPrefix(next, "//") || strings.Contains(next, `\`) {
+		return "/admin/"
+	}
+	return next
+}
+
+// validPassword checks a new password against the length limits
+func validPassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be %d to %d bytes long", minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+// hashPassword returns the bcrypt hash stored for password
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// hasSession reports whether the request carries a session cookie
+func hasSession(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookie)
+	return err == nil && c.Value != ""
+}
+
+// sessionUser returns the user logged in with the request's session
+// cookie and how they logged in, or nil when there is no live session.
+// Sessions end after SESSION_IDLE_TIMEOUT without a request or
+// SESSION_MAX_AGE after login.
+func sessionUser(r *http.Request) (*store.User, string, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return nil, "", nil
+	}
+	now := time.Now()
+	u, method, err := st.SessionUser(r.Context(), hashToken(c.Value), now.Add(-cfg.SessionMaxAge), now.Add(-cfg.SessionIdleTimeout))
+	if err == store.ErrNotFound {
+		return nil, "", nil
+	}
+	return u, method, err
+}
+
+// setSessionCookie sets the session cookie, or clears it when token is "".
+// It is limited to /admin, hidden from scripts and never sent cross-site.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string) {
+	c := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/admin",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	}
+	if token == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// adminLoginPageHandler shows the login form, or goes straight on when the
+// browser is already logged in
+func adminLoginPageHandler(w http.ResponseWriter, r *http.Request) {
+	if u, _, err := sessionUser(r); err == nil && u != nil {
+		http.Redirect(w, r, loginNext(r.URL.Query().Get("next")), http.StatusSeeOther)
+		return
+	}
+	renderLogin(w, r, http.StatusOK, "")
+}
+
+// adminLoginHandler checks a username and password posted from the login
+// form and starts a session
+func adminLoginHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	if err := r.ParseForm(); err != nil {
+		renderLogin(w, r, http.StatusBadRequest, "Invalid sign-in form")
+		return
+	}
+	username := strings.ToLower(strings.TrimSpace(r.PostFormValue("username")))
+	password := r.PostFormValue("password")
+
+	u, hash, err := st.UserPassword(r.Context(), username)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		logErrorContext(r.Context(), "AUTH_DB_ERROR", fmt.Sprintf("Failed to look up user %q: %v", username, err))
+		renderLogin(w, r, http.StatusInternalServerError, "Sign-in is unavailable, please try again later")
+		return
+	}
+	if hash == "" {
+		hash = string(dummyPasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || u == nil || !u.HasPassword {
+		logErrorContext(r.Context(), "AUTH_FAILED", fmt.Sprintf("Failed login for %q from %s", username, clientIP(r)))
+		renderLogin(w, r, http.StatusUnauthorized, "Invalid username, password or code")
+		return
+	}
+	if u.TOTPEnabled {
+		ok, err := checkSecondFactor(r.Context(), u, r.PostFormValue("code"))
+		if err != nil {
+			logErrorContext(r.Context(), "AUTH_DB_ERROR", fmt.Sprintf("Failed to check the two-factor code of %s: %v", u.Username, err))
+			renderLogin(w, r, http.StatusInternalServerError, "Sign-in is unavailable, please try again later")
+			return
+		}
+		if !ok {
+			logErrorContext(r.Context(), "AUTH_FAILED", fmt.Sprintf("Failed two-factor code for %q from %s", username, clientIP(r)))
+			renderLogin(w, r, http.StatusUnauthorized, "Invalid username, password or code")
+			return
+		}
+	}
+
+	token, err := startSession(r, u, store.SessionPassword)
+	if err != nil {
+		logErrorContext(r.Context(), "AUTH_DB_ERROR", fmt.Sprintf("Failed to create session for %s: %v", u.Username, err))
+		renderLogin(w, r, http.StatusInternalServerError, "Sign-in is unavailable, please try again later")
+		return
+	}
+	logErrorContext(r.Context(), "AUTH_LOGIN_SUCCESS", fmt.Sprintf("User %s logged in from %s", u.Username, clientIP(r)))
+	setSessionCookie(w, r, token)
+	http.Redirect(w, r, loginNext(r.PostFormValue("next")), http.StatusSeeOther)
+}
+
+// startSession stores a new session for u and returns its cookie value
+func startSession(r *http.Request, u *store.User, method string) (string, error) {
+	token, err := newToken("")
+	if err != nil {
+		return "", err
+	}
+	if err := st.CreateSession(r.Context(), hashToken(token), u.ID, method); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// adminLogoutHandler ends the browser's session
+func adminLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		if err := st.DeleteSession(r.Context(), hashToken(c.Value)); err != nil {
+			logErrorContext(r.Context(), "AUTH_DB_ERROR", fmt.Sprintf("Failed to delete session: %v", err))
+		}
+	}
+	setSessionCookie(w, r, "")
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// Share link tokens are "<payload>.<signature>", both unpadded base64url.
+// The payload is "<unix expiry>:<national ID>" and the signature is
+// HMAC-SHA256 over it with SHARE_LINK_SECRET.
+var (
+	errShareInvalid = errors.New("Invalid share link")
+	errShareExpired = errors.New("This share link has expired")
+)
+
+// apiShareLink is the v1 JSON schema for an issued share link
+type apiShareLink struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// signShareToken returns a token for nationalID that expires at expires
+func signShareToken(nationalID string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(expires.Unix(), 10) + ":" + nationalID))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(shareMAC(payload))
+}
+
+func shareMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(cfg.ShareLinkSecret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// parseShareToken checks the signature and expiry of token and returns the
+// national ID it was issued for
+func parseShareToken(token string, now time.Time) (string, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errShareInvalid
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, shareMAC(payload)) {
+		return "", errShareInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", errShareInvalid
+	}
+	exp, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return "", errShareInvalid
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", errShareInvalid
+	}
+	if !now.Before(time.Unix(unix, 0)) {
+		return "", errShareExpired
+	}
+	return id, nil
+}
+
+// shareURL is the public link for a share token
+func shareURL(r *http.Request, token string) string {
+	return publicBaseURL(r) + "/verify/shared?token=" + url.QueryEscape(token)
+}
+
+// apiShareHandler issues a share link from {"id": "...", "ttl": "24h"}.
+// The ID is looked up like any API verification, so the lookup counts
+// towards rate limits and lockout; ttl defaults to SHARE_LINK_TTL.
+func apiShareHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.ShareLinkSecret == "" {
+		writeAPIError(w, http.StatusNotFound, "share_links_disabled", "Share links are not enabled")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		ID  string `json:"id"`
+		TTL string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"id": "...", "ttl": "24h"}`)
+		return
+	}
+	ttl := cfg.ShareLinkTTL
+	if body.TTL != "" {
+		d, err := time.ParseDuration(body.TTL)
+		if err != nil || d <= 0 || d > cfg.ShareLinkMaxTTL {
+			writeAPIError(w, http.StatusBadRequest, "invalid_ttl",
+				fmt.Sprintf("ttl must be a duration such as 24h, at most %s", cfg.ShareLinkMaxTTL))
+			return
+		}
+		ttl = d
+	}
+
+	p, _ := apiLookup(w, r, body.ID, "API_SHARE")
+	if p == nil {
+		return
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second).UTC()
+	token := signShareToken(p.NationalID, expires)
+	logErrorContext(r.Context(), "SHARE_LINK_SUCCESS", fmt.Sprintf("Issued share link for %s until %s", maskID(p.NationalID), expires.Format(time.RFC3339)))
+	writeJSON(w, http.StatusCreated, apiShareLink{URL: shareURL(r, token), Token: token, ExpiresAt: expires})
+}
+
+// sharedVerifyHandler serves /verify/shared?token=..., showing the same
+// result as /verify for the ID the token was issued for
+func sharedVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if cfg.ShareLinkSecret == "" {
+		verifyError(w, r, "Share links are not enabled", http.StatusNotFound)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		verifyError(w, r, "token is required", http.StatusBadRequest)
+		return
+	}
+	id, err := parseShareToken(token, time.Now())
+	if err == errShareExpired {
+		logErrorContext(r.Context(), "SHARE_LINK_EXPIRED", "Expired share link used")
+		verifyError(w, r, err.Error(), http.StatusGone)
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "SHARE_LINK_INVALID", "Share link with a bad signature from "+clientIP(r))
+		verifyError(w, r, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	p, err := st.GetByNationalID(r.Context(), id)
+	if err == nil && !inScope(r.Context(), p) {
+		err = store.ErrNotFound
+	}
+	if err == store.ErrNotFound {
+		// The record was deleted after the link was issued, or the link
+		// was opened at another institution
+		recordAudit(r, auditChannelShare, id, callResultNoMatch, "")
+		verifyError(w, r, "Person not found", http.StatusNotFound)
+		return
+	} else if breakerRefused(w, err) {
+		denyBusyWeb(w, r)
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "VERIFY_DB_ERROR", fmt.Sprintf("Database error for shared ID %s: %v", maskID(id), err))
+		recordAudit(r, auditChannelShare, id, callResultError, "")
+		verifyError(w, r, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	check := recordMatch(r, auditChannelShare, id, p)
+	logErrorContext(r.Context(), "VERIFY_SHARED_SUCCESS", fmt.Sprintf("Verified shared link for ID: %s, Reference: %s", maskID(p.NationalID), check.Reference))
+	writeVerification(w, r, p.NationalID, p, check)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareToken(t *testing.T) {
+	defer func(secret string) { cfg.ShareLinkSecret = secret }(cfg.ShareLinkSecret)
+	cfg.ShareLinkSecret = "test-secret"
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	valid := signShareToken("853400939V", now.Add(time.Hour))
+	payload, sig, _ := strings.Cut(valid, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte("1900000000:200012345679")) + "." + sig
+
+	tests := []struct {
+		name, token string
+		now         time.Time
+		want        string
+		err         error
+	}{
+		{name: "valid", token: valid, now: now, want: "853400939V"},
+		{name: "last second", token: valid, now: now.Add(time.Hour - time.Second), want: "853400939V"},
+		{name: "at expiry", token: valid, now: now.Add(time.Hour), err: errShareExpired},
+		{name: "after expiry", token: valid, now: now.Add(48 * time.Hour), err: errShareExpired},
+		{name: "other payload", token: forged, now: now, err: errShareInvalid},
+		{name: "truncated signature", token: valid[:len(valid)-2], now: now, err: errShareInvalid},
+		{name: "no signature", token: payload, now: now, err: errShareInvalid},
+		{name: "bad base64", token: "!!!." + sig, now: now, err: errShareInvalid},
+		{name: "empty", token: "", now: now, err: errShareInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseShareToken(tt.token, tt.now)
+			if err != tt.err || got != tt.want {
+				t.Errorf("parseShareToken = %q, %v; want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestShareTokenOtherSecret(t *testing.T) {
+	defer func(secret string) { cfg.ShareLinkSecret = secret }(cfg.ShareLinkSecret)
+	now := time.Now()
+
+	cfg.ShareLinkSecret = "old-secret"
+	token := signShareToken("853400939V", now.Add(time.Hour))
+	cfg.ShareLinkSecret = "new-secret"
+	if _, err := parseShareToken(token, now); err != errShareInvalid {
+		t.Errorf("token signed with another secret: err = %v, want %v", err, errShareInvalid)
+	}
+}
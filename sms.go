@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// twimlMessage is a messaging <Message> reply
+type twimlMessage struct {
+	XMLName xml.Name `xml:"Message"`
+	Body    string   `xml:",chardata"`
+}
+
+// smsKeywords are optional words callers put before the ID ("VERIFY 1234V")
+var smsKeywords = []string{"verify", "check", "id"}
+
+// twilioSMSHandler answers an inbound SMS containing a national ID with the
+// verification result, using the same normalization and lookup as voice.
+func twilioSMSHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	input := normalizeSpokenID(stripSMSKeyword(r.PostFormValue("Body")))
+	p, reply, result := messagingLookup(r.Context(), "SMS", input)
+	matchedID := ""
+	if p != nil {
+		matchedID = p.NationalID
+	}
+	if result == callResultMatch {
+		check := recordMatch(r, auditChannelSMS, input, p)
+		reply = smsText(r.Context(), p, check)
+	} else {
+		recordAudit(r, auditChannelSMS, input, result, matchedID)
+	}
+	recordCall(r, input, result, matchedID)
+	writeTwiML(w, twimlMessage{Body: reply})
+}
+
+// stripSMSKeyword removes a leading keyword such as "VERIFY" from body
+func stripSMSKeyword(body string) string {
+	body = strings.TrimSpace(body)
+	fields := strings.Fields(body)
+	if len(fields) > 1 {
+		for _, k := range smsKeywords {
+			if strings.EqualFold(fields[0], k) || strings.EqualFold(strings.TrimSuffix(fields[0], ":"), k) {
+				return strings.Join(fields[1:], " ")
+			}
+		}
+	}
+	return body
+}
+
+// textMessages holds the plain-text replies of the messaging channels (SMS,
+// WhatsApp and Telegram), keyed like twilioMessages. Replies are in
+// English only.
+var textMessages = map[string]map[string]string{
+	langEnglish: {
+		"no_input":         "Please send the ID number you want to verify, e.g. VERIFY 123456785V",
+		"invalid":          "Invalid ID format. Please use only numbers or letters.",
+		"no_match":         "Sorry, no match found for %s.",
+		"unavailable":      "Sorry, we could not complete the verification. Please try again later.",
+		"maintenance":      "Sorry, the verification service is down for maintenance. Please try again later.",
+		"busy":             "Sorry, the service is busy right now. Please try again in a few minutes.",
+		"rate_limited":     "Too many requests. Please try again later.",
+		"blocked":          "This number cannot use the verification service.",
+		"telegram_help":    "Send the ID number you want to verify, e.g. 123456785V or /verify 123456785V.",
+		"telegram_blocked": "You cannot use the verification service.",
+	},
+}
+
+// textMsg returns the messaging reply for key
+func textMsg(key string) string {
+	m, _ := templateMsg("", messageChannelText, langEnglish, key)
+	return m
+}
+
+// messagingLookup validates and looks input up for a messaging channel
+// (SMS, WHATSAPP). On success it returns the person; otherwise the
+// plain-text reply explains why. The person is also returned when they
+// opted out of public lookups, with callResultRestricted and
+// registrarOnlyNotice as the reply. The call result is returned for the
+// calls table either way.
+func messagingLookup(ctx context.Context, channel, input string) (*store.Person, string, string) {
+	prefix := "TWILIO_" + channel
+	if input == "" {
+		logErrorContext(ctx, prefix+"_NO_INPUT", "No ID in message body")
+		return nil, textMsg("no_input"), callResultInvalid
+	}
+	if !validID(input) {
+		logErrorContext(ctx, prefix+"_INVALID_INPUT", fmt.Sprintf("Invalid input format: %s", maskID(input)))
+		twilioLookupsTotal.WithLabelValues("invalid").Inc()
+		return nil, textMsg("invalid"), callResultInvalid
+	}
+
+	ids, err := lookupIDs(input, "")
+	if err != nil {
+		logErrorContext(ctx, prefix+"_INVALID_NIC", fmt.Sprintf("Invalid NIC %s: %v", maskID(input), err))
+		twilioLookupsTotal.WithLabelValues("invalid").Inc()
+		return nil, err.Error() + ".", callResultInvalid
+	}
+
+	p, err := findPerson(ctx, ids, "")
+	if err == store.ErrNotFound {
+		logErrorContext(ctx, prefix+"_NO_MATCH", fmt.Sprintf("No match found for input: %s", maskID(input)))
+		twilioLookupsTotal.WithLabelValues("no_match").Inc()
+		noteLookup(ctx, false, 1)
+		return nil, fmt.Sprintf(textMsg("no_match"), input), callResultNoMatch
+	} else if errors.Is(err, store.ErrUnavailable) {
+		busyDeniedTotal.Inc()
+		return nil, textMsg("busy"), callResultError
+	} else if err != nil {
+		logErrorContext(ctx, prefix+"_DB_ERROR", fmt.Sprintf("Database error for input %s: %v", maskID(input), err))
+		twilioLookupsTotal.WithLabelValues("error").Inc()
+		return nil, textMsg("unavailable"), callResultError
+	}
+
+	noteLookup(ctx, true, 0)
+	if !p.PublicLookup {
+		logErrorContext(ctx, prefix+"_RESTRICTED", fmt.Sprintf("Input %s opted out of public lookups", maskID(input)))
+		twilioLookupsTotal.WithLabelValues("restricted").Inc()
+		return p, registrarOnlyNotice, callResultRestricted
+	}
+	twilioLookupsTotal.WithLabelValues("match").Inc()
+	logErrorContext(ctx, prefix+"_SUCCESS", fmt.Sprintf("Verified input: %s, Name: %s, Category: %s", maskID(input), piiText(p.FullName), p.Category))
+	return p, "", callResultMatch
+}
+
+// smsText formats a verification result as a plain-text SMS, ending with
+// the reference of check
+func smsText(ctx context.Context, p *store.Person, check *store.AuditEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ID: %s\nFULL NAME: %s\n", p.NationalID, p.FullName)
+	state := credentialState(p)
+	if !p.ExpiryDate.IsZero() {
+		fmt.Fprintf(&b, "VALID UNTIL: %s\n", formatDate(p.ExpiryDate))
+	}
+	if c := categoryOf(ctx, p.Category); c.ShowCourses {
+		fmt.Fprintf(&b, "COURSES COMPLETED: %d workshops\n", len(p.Courses))
+		b.WriteString(plainStatus(state))
+	} else {
+		fmt.Fprintf(&b, "CATEGORY: %s\nREMARKS: %s", c.Label, stripHTML(remarkText(remarksFor(p, auditChannelSMS))))
+		if state != stateValid {
+			b.WriteString("\n" + plainStatus(state))
+		}
+	}
+	fmt.Fprintf(&b, "\nREF: %s (%s)", check.Reference, check.CreatedAt.Format(checkedAtLayout))
+	return b.String()
+}
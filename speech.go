@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// spokenDigits maps spoken number words to digits
+var spokenDigits = map[string]string{
+	"zero": "0", "oh": "0", "o": "0", "nought": "0",
+	"one": "1", "won": "1",
+	"two": "2", "to": "2", "too": "2",
+	"three": "3", "tree": "3",
+	"four": "4", "for": "4", "fore": "4",
+	"five":  "5",
+	"six":   "6",
+	"seven": "7",
+	"eight": "8", "ate": "8",
+	"nine": "9",
+}
+
+// defaultSpeechHints is the TWILIO_SPEECH_HINTS default: Google's classes
+// for digit and letter-digit sequences, and the NIC letters by the names
+// callers use for them
+var defaultSpeechHints = []string{
+	"$OOV_CLASS_DIGIT_SEQUENCE", "$OOV_CLASS_ALPHANUMERIC_SEQUENCE",
+	"V", "vee", "victor", "X", "ex", "x-ray",
+}
+
+// spokenLetters maps letter names (as transcribed by speech recognition)
+// to letters. V and X are the NIC suffixes; the rest cover other ID types.
+var spokenLetters = map[string]string{
+	"vee": "V", "v": "V", "victor": "V",
+	"ex": "X", "x": "X", "x-ray": "X", "xray": "X",
+	"a": "A", "ay": "A", "bee": "B", "b": "B", "see": "C", "c": "C",
+	"dee": "D", "d": "D", "e": "E", "ef": "F", "f": "F", "gee": "G", "g": "G",
+	"aitch": "H", "h": "H", "i": "I", "jay": "J", "j": "J", "kay": "K", "k": "K",
+	"el": "L", "l": "L", "em": "M", "m": "M", "en": "N", "n": "N",
+	"pee": "P", "p": "P", "queue": "Q", "q": "Q", "ar": "R", "r": "R",
+	"es": "S", "s": "S", "tee": "T", "t": "T", "you": "U", "u": "U",
+	"double-u": "W", "w": "W", "why": "Y", "y": "Y", "zed": "Z", "zee": "Z", "z": "Z",
+	// The phonetic words the ID is read back with
+	"alpha": "A", "alfa": "A", "bravo": "B", "charlie": "C", "delta": "D",
+	"echo": "E", "foxtrot": "F", "golf": "G", "hotel": "H", "india": "I",
+	"juliet": "J", "juliett": "J", "kilo": "K", "lima": "L", "mike": "M",
+	"november": "N", "oscar": "O", "papa": "P", "quebec": "Q", "romeo": "R",
+	"sierra": "S", "tango": "T", "uniform": "U", "whiskey": "W", "whisky": "W",
+	"yankee": "Y", "zulu": "Z",
+}
+
+// spokenRepeats maps repetition words to how many times the next token repeats
+var spokenRepeats = map[string]int{"double": 2, "triple": 3}
+
+// normalizeSpokenID converts a transcribed ID such as "one two three double
+// four vee" into its canonical form "123444V". Digits and letters already in
+// the input pass through, so DTMF input is unchanged apart from removing
+// spaces and punctuation. Unknown words are kept as-is so that validation
+// rejects them instead of silently dropping parts of the ID.
+func normalizeSpokenID(input string) string {
+	tokens := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return unicode.IsSpace(r) || (unicode.IsPunct(r) && r != '-')
+	})
+
+	var b strings.Builder
+	repeat := 1
+	for _, tok := range tokens {
+		tok = strings.Trim(tok, "-")
+		if tok == "" {
+			continue
+		}
+		if n, ok := spokenRepeats[tok]; ok {
+			repeat = n
+			continue
+		}
+
+		var out string
+		if d, ok := spokenDigits[tok]; ok {
+			out = d
+		} else if l, ok := spokenLetters[tok]; ok {
+			out = l
+		} else {
+			// Already alphanumeric (e.g. "1234" or "123v"), or unrecognised
+			out = strings.ToUpper(strings.ReplaceAll(tok, "-", ""))
+		}
+		b.WriteString(strings.Repeat(out, repeat))
+		repeat = 1
+	}
+	return b.String()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	// statsDefaultDays is the period covered when since is not given
+	statsDefaultDays = 30
+	// statsMaxDays bounds the period, and so the daily breakdown
+	statsMaxDays = 366
+	// statsTopErrors is how many error types are listed
+	statsTopErrors = 10
+)
+
+// apiStats is the v1 JSON schema for GET /stats. MatchRate is the share of
+// checks that found a person; DistinctIDs counts the different IDs found,
+// since the IDs of failed checks are stored masked.
+type apiStats struct {
+	Since       time.Time                  `json:"since"`
+	Until       time.Time                  `json:"until"`
+	Checks      int                        `json:"checks"`
+	Matches     int                        `json:"matches"`
+	MatchRate   float64                    `json:"match_rate"`
+	DistinctIDs int                        `json:"distinct_ids"`
+	Outcomes    map[string]int             `json:"outcomes"`
+	Channels    map[string]apiStatsChannel `json:"channels"`
+	Daily       []apiStatsDay              `json:"daily"`
+	TopErrors   []apiStatsError            `json:"top_errors"`
+}
+
+// apiStatsChannel totals the checks made on one channel
+type apiStatsChannel struct {
+	Checks    int     `json:"checks"`
+	Matches   int     `json:"matches"`
+	MatchRate float64 `json:"match_rate"`
+}
+
+// apiStatsDay is the checks made on one channel on one day (UTC)
+type apiStatsDay struct {
+	Day     string `json:"day"`
+	Channel string `json:"channel"`
+	Checks  int    `json:"checks"`
+	Matches int    `json:"matches"`
+}
+
+// apiStatsError is how often an error type was logged
+type apiStatsError struct {
+	ErrorType string `json:"error_type"`
+	Count     int    `json:"count"`
+}
+
+// matchRate is matches as a share of checks, rounded to four places
+func matchRate(matches, checks int) float64 {
+	if checks == 0 {
+		return 0
+	}
+	return float64(matches*10000/checks) / 10000
+}
+
+// apiStatsHandler aggregates audit_log and the errors table over since
+// (default statsDefaultDays ago) to until (default now, exclusive), both
+// RFC 3339 or YYYY-MM-DD: since=2026-09-01&until=2026-10-01 is September.
+// An institution's staff get the checks that matched its people, and no
+// errors.
+func apiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	since, until, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+	if until.IsZero() {
+		until = time.Now().UTC()
+	}
+	if since.IsZero() {
+		since = until.AddDate(0, 0, -statsDefaultDays)
+	}
+	if !since.Before(until) || until.Sub(since) > statsMaxDays*24*time.Hour {
+		writeAPIError(w, http.StatusBadRequest, "invalid_range", fmt.Sprintf("since must be before until, at most %d days apart", statsMaxDays))
+		return
+	}
+
+	stats, err := computeStats(r.Context(), staffInstitution(r.Context()), since, until)
+	if err != nil {
+		logErrorContext(r.Context(), "STATS_DB_ERROR", fmt.Sprintf("Failed to compute statistics: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// computeStats aggregates the checks made and errors logged in [since,
+// until). Given an institution it counts only the checks that matched its
+// people and leaves the errors, which are the deployment's, out.
+func computeStats(ctx context.Context, institution string, since, until time.Time) (*apiStats, error) {
+	outcomes, err := st.AuditOutcomeCounts(ctx, institution, since, until)
+	if err != nil {
+		return nil, err
+	}
+	daily, err := st.DailyAuditCounts(ctx, institution, since, until)
+	if err != nil {
+		return nil, err
+	}
+	distinct, err := st.DistinctMatchedIDs(ctx, institution, since, until)
+	if err != nil {
+		return nil, err
+	}
+	var errorTypes []store.Count
+	if institution == "" {
+		if errorTypes, err = st.ErrorTypeCounts(ctx, errorsZone(since), errorsZone(until), statsTopErrors); err != nil {
+			return nil, err
+		}
+	}
+
+	stats := &apiStats{
+		Since:       since,
+		Until:       until,
+		DistinctIDs: distinct,
+		Outcomes:    make(map[string]int, len(outcomes)),
+		Channels:    make(map[string]apiStatsChannel),
+		Daily:       make([]apiStatsDay, len(daily)),
+		TopErrors:   make([]apiStatsError, len(errorTypes)),
+	}
+	for _, c := range outcomes {
+		stats.Outcomes[c.Key] = c.Count
+		stats.Checks += c.Count
+	}
+	stats.Matches = stats.Outcomes[callResultMatch]
+	stats.MatchRate = matchRate(stats.Matches, stats.Checks)
+	for i, d := range daily {
+		stats.Daily[i] = apiStatsDay{Day: d.Day, Channel: d.Channel, Checks: d.Checks, Matches: d.Matches}
+		c := stats.Channels[d.Channel]
+		c.Checks += d.Checks
+		c.Matches += d.Matches
+		stats.Channels[d.Channel] = c
+	}
+	for name, c := range stats.Channels {
+		c.MatchRate = matchRate(c.Matches, c.Checks)
+		stats.Channels[name] = c
+	}
+	for i, c := range errorTypes {
+		stats.TopErrors[i] = apiStatsError{ErrorType: c.Key, Count: c.Count}
+	}
+	return stats, nil
+}
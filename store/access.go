@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const accessRuleColumns = `id, action, kind, value, reason, created_by, created_at, expires_at`
+
+func scanAccessRule(row scanner) (*AccessRule, error) {
+	a := &AccessRule{}
+	var expires sql.NullTime
+	if err := row.Scan(&a.ID, &a.Action, &a.Kind, &a.Value, &a.Reason, &a.CreatedBy, &a.CreatedAt, &expires); err != nil {
+		return nil, err
+	}
+	a.ExpiresAt = expires.Time
+	return a, nil
+}
+
+// CreateAccessRule stores a rule and returns the stored row
+func (s *SQLStore) CreateAccessRule(ctx context.Context, a *AccessRule) (*AccessRule, error) {
+	ctx, done := s.begin(ctx, "create_access_rule")
+	defer done()
+
+	now := time.Now().UTC()
+	// An expired rule only keeps the value from being added again
+	_, err := s.exec(ctx, `DELETE FROM access_rules WHERE action = ? AND kind = ? AND value = ? AND expires_at <= ?`,
+		a.Action, a.Kind, a.Value, now)
+	if err != nil {
+		return nil, err
+	}
+	var expires interface{}
+	if !a.ExpiresAt.IsZero() {
+		expires = a.ExpiresAt.UTC()
+	}
+	_, err = s.exec(ctx, `INSERT INTO access_rules (action, kind, value, reason, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		a.Action, a.Kind, a.Value, a.Reason, a.CreatedBy, now, expires)
+	if err != nil {
+		return nil, err
+	}
+	// Look the row up by its unique key, since Postgres has no LastInsertId
+	return scanAccessRule(s.queryRow(ctx, `SELECT `+accessRuleColumns+` FROM access_rules WHERE action = ? AND kind = ? AND value = ?`,
+		a.Action, a.Kind, a.Value))
+}
+
+// ListAccessRules returns every rule, oldest first
+func (s *SQLStore) ListAccessRules(ctx context.Context) ([]AccessRule, error) {
+	ctx, done := s.begin(ctx, "list_access_rules")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT `+accessRuleColumns+` FROM access_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AccessRule{}
+	for rows.Next() {
+		a, err := scanAccessRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *a)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteAccessRule removes a rule by ID
+func (s *SQLStore) DeleteAccessRule(ctx context.Context, id int64) error {
+	ctx, done := s.begin(ctx, "delete_access_rule")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM access_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteExpiredAccessRules removes rules whose expiry is before before and
+// returns how many there were
+func (s *SQLStore) DeleteExpiredAccessRules(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := s.begin(ctx, "delete_expired_access_rules")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM access_rules WHERE expires_at IS NOT NULL AND expires_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const apiKeyColumns = `id, name, prefix, COALESCE(institution_id, ''), COALESCE(partner_id, ''), created_at, last_used_at, revoked_at,
+(SELECT COALESCE(SUM(requests), 0) FROM api_key_usage WHERE key_id = api_keys.id)`
+
+// scanAPIKey reads a row selected with apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	k := &APIKey{}
+	var lastUsed, revoked sql.NullTime
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.InstitutionID, &k.PartnerID, &k.CreatedAt, &lastUsed, &revoked, &k.Requests); err != nil {
+		return nil, err
+	}
+	k.LastUsedAt, k.RevokedAt = lastUsed.Time, revoked.Time
+	return k, nil
+}
+
+// CreateAPIKey stores a new key by its hash and returns the stored row
+func (s *SQLStore) CreateAPIKey(ctx context.Context, name, prefix, hash, institutionID, partnerID string) (*APIKey, error) {
+	ctx, done := s.begin(ctx, "create_api_key")
+	defer done()
+
+	_, err := s.exec(ctx, `INSERT INTO api_keys (name, prefix, key_hash, institution_id, partner_id, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		name, prefix, hash, nullString(institutionID), nullString(partnerID), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	// Look the row up by its unique hash, since Postgres has no LastInsertId
+	return scanAPIKey(s.queryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, hash))
+}
+
+// ListAPIKeys returns every key, revoked ones included, oldest first
+func (s *SQLStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ctx, done := s.begin(ctx, "list_api_keys")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// GetAPIKey returns one key by ID
+func (s *SQLStore) GetAPIKey(ctx context.Context, id int64) (*APIKey, error) {
+	ctx, done := s.begin(ctx, "get_api_key")
+	defer done()
+
+	k, err := scanAPIKey(s.queryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+	return k, notFound(err)
+}
+
+// FindAPIKey returns the key with this hash, revoked or not
+func (s *SQLStore) FindAPIKey(ctx context.Context, hash string) (*APIKey, error) {
+	ctx, done := s.begin(ctx, "find_api_key")
+	defer done()
+
+	k := &APIKey{}
+	var revoked sql.NullTime
+	err := s.queryRow(ctx, `SELECT id, name, prefix, COALESCE(institution_id, ''), COALESCE(partner_id, ''), created_at, revoked_at FROM api_keys WHERE key_hash = ?`, hash).
+		Scan(&k.ID, &k.Name, &k.Prefix, &k.InstitutionID, &k.PartnerID, &k.CreatedAt, &revoked)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	k.RevokedAt = revoked.Time
+	return k, nil
+}
+
+// RevokeAPIKey marks a key revoked; ErrNotFound if there is no such
+// active key
+func (s *SQLStore) RevokeAPIKey(ctx context.Context, id int64) error {
+	ctx, done := s.begin(ctx, "revoke_api_key")
+	defer done()
+
+	res, err := s.exec(ctx, `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordAPIKeyUse counts one request made with the key at at. The day's
+// usage row is updated, or inserted for the first request of the day;
+// update and insert rather than upsert, since the dialects spell upserts
+// differently.
+func (s *SQLStore) RecordAPIKeyUse(ctx context.Context, id int64, at time.Time) error {
+	ctx, done := s.begin(ctx, "record_api_key_use")
+	defer done()
+
+	at = at.UTC()
+	if _, err := s.exec(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, at, id); err != nil {
+		return err
+	}
+	day := at.Format(time.DateOnly)
+	update := func() (int64, error) {
+		res, err := s.exec(ctx, `UPDATE api_key_usage SET requests = requests + 1 WHERE key_id = ? AND day = ?`, id, day)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+	if n, err := update(); err != nil || n > 0 {
+		return err
+	}
+	_, err := s.exec(ctx, `INSERT INTO api_key_usage (key_id, day, requests) VALUES (?, ?, 1)`, id, day)
+	if errors.Is(err, ErrDuplicate) {
+		// A concurrent request inserted the row first
+		_, err = update()
+	}
+	return err
+}
+
+// APIKeyUsage returns the key's request count per UTC day, in date order.
+// since and until are compared by day only; until is exclusive.
+func (s *SQLStore) APIKeyUsage(ctx context.Context, id int64, since, until time.Time) ([]Count, error) {
+	ctx, done := s.begin(ctx, "api_key_usage")
+	defer done()
+
+	query := `SELECT day, requests FROM api_key_usage WHERE key_id = ?`
+	args := []interface{}{id}
+	if !since.IsZero() {
+		query += ` AND day >= ?`
+		args = append(args, since.UTC().Format(time.DateOnly))
+	}
+	if !until.IsZero() {
+		query += ` AND day < ?`
+		args = append(args, until.UTC().Format(time.DateOnly))
+	}
+	rows, err := s.query(ctx, query+` ORDER BY day`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []Count{}
+	for rows.Next() {
+		var c Count
+		if err := rows.Scan(&c.Key, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// PruneAPIKeyUsage deletes the usage rows of days before before and returns
+// how many there were
+func (s *SQLStore) PruneAPIKeyUsage(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := s.begin(ctx, "prune_api_key_usage")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM api_key_usage WHERE day < ?`, before.UTC().Format(time.DateOnly))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const attachmentColumns = `id, national_id, filename, content_type, size, sha256, storage_key, COALESCE(uploaded_by, ''), created_at`
+
+func scanAttachment(row scanner) (*Attachment, error) {
+	a := &Attachment{}
+	err := row.Scan(&a.ID, &a.NationalID, &a.Filename, &a.ContentType, &a.Size, &a.SHA256, &a.StorageKey, &a.UploadedBy, &a.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return a, nil
+}
+
+// ListAttachments returns the attachments of a person, oldest first
+func (s *SQLStore) ListAttachments(ctx context.Context, nationalID string) ([]Attachment, error) {
+	ctx, done := s.begin(ctx, "list_attachments")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT `+attachmentColumns+` FROM person_attachments WHERE national_id = ? ORDER BY id`, nationalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *a)
+	}
+	return list, rows.Err()
+}
+
+// GetAttachment returns one attachment of a person
+func (s *SQLStore) GetAttachment(ctx context.Context, nationalID string, id int64) (*Attachment, error) {
+	ctx, done := s.begin(ctx, "get_attachment")
+	defer done()
+
+	return scanAttachment(s.queryRow(ctx, `SELECT `+attachmentColumns+` FROM person_attachments WHERE id = ? AND national_id = ?`, id, nationalID))
+}
+
+// AddAttachment inserts a and records it in the person's history
+func (s *SQLStore) AddAttachment(ctx context.Context, a *Attachment) (*Attachment, error) {
+	ctx, done := s.begin(ctx, "add_attachment")
+	defer done()
+
+	added := *a
+	added.UploadedBy = actorFrom(ctx)
+	added.CreatedAt = time.Now().UTC()
+	err := s.change(ctx, a.NationalID, HistoryAttachmentAdded, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT national_id FROM people WHERE national_id = ?`+notDeleted), a.NationalID).Scan(&added.NationalID)
+		if err != nil {
+			return notFound(err)
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO person_attachments (national_id, filename, content_type, size, sha256, storage_key, uploaded_by, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+			added.NationalID, a.Filename, a.ContentType, a.Size, a.SHA256, a.StorageKey, nullString(added.UploadedBy), added.CreatedAt); err != nil {
+			return err
+		}
+		// The storage key is unique, and Postgres has no LastInsertId
+		return tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT id FROM person_attachments WHERE storage_key = ?`), a.StorageKey).Scan(&added.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+// DeleteAttachment removes one attachment and records it in the person's
+// history
+func (s *SQLStore) DeleteAttachment(ctx context.Context, nationalID string, id int64) (*Attachment, error) {
+	ctx, done := s.begin(ctx, "delete_attachment")
+	defer done()
+
+	var deleted *Attachment
+	err := s.change(ctx, nationalID, HistoryAttachmentRemoved, func(tx *sql.Tx) error {
+		a, err := scanAttachment(tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT `+attachmentColumns+` FROM person_attachments WHERE id = ? AND national_id IN
+(SELECT national_id FROM people WHERE national_id = ?`+notDeleted+`)`), id, nationalID))
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM person_attachments WHERE id = ?`), a.ID)
+		deleted = a
+		return updatedOne(res, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
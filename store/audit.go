@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// RecordAudit inserts entries into audit_log with one multi-row INSERT, so
+// a batch lookup costs a single round trip
+func (s *SQLStore) RecordAudit(ctx context.Context, entries ...*AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ctx, done := s.begin(ctx, "record_audit")
+	defer done()
+
+	placeholders := make([]string, len(entries))
+	args := make([]interface{}, 0, len(entries)*9)
+	for i, e := range entries {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, e.Channel, e.Source, nullString(e.Input), e.Outcome, nullString(e.MatchedID),
+			nullString(e.Reference), nullString(e.State), nullString(e.RequestID), e.CreatedAt)
+	}
+	query := `INSERT INTO audit_log (channel, source, input, outcome, matched_id, reference, state, request_id, created_at) VALUES ` +
+		strings.Join(placeholders, ", ")
+	_, err := s.exec(ctx, query, args...)
+	return err
+}
+
+// ListAudit returns a page of audit entries matching f, newest first
+func (s *SQLStore) ListAudit(ctx context.Context, f AuditFilter, limit, offset int) ([]AuditEntry, int, error) {
+	ctx, done := s.begin(ctx, "list_audit")
+	defer done()
+
+	where := ` WHERE 1=1`
+	var args []interface{}
+	if f.Channel != "" {
+		where += ` AND channel = ?`
+		args = append(args, f.Channel)
+	}
+	if f.Source != "" {
+		where += ` AND source = ?`
+		args = append(args, f.Source)
+	}
+	if f.MatchedID != "" {
+		where += ` AND matched_id = ?`
+		args = append(args, f.MatchedID)
+	}
+	if f.Outcome != "" {
+		where += ` AND outcome = ?`
+		args = append(args, f.Outcome)
+	}
+	if f.RequestID != "" {
+		where += ` AND request_id = ?`
+		args = append(args, f.RequestID)
+	}
+	if f.Institution != "" {
+		where += institutionMatches
+		args = append(args, f.Institution)
+	}
+	if !f.Since.IsZero() {
+		where += ` AND created_at >= ?`
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where += ` AND created_at < ?`
+		args = append(args, f.Until)
+	}
+
+	var total int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM audit_log` + where + ` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`
+	rows, err := s.query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, total, rows.Err()
+}
+
+// GetAuditByReference returns the audit entry of one successful check
+func (s *SQLStore) GetAuditByReference(ctx context.Context, reference string) (*AuditEntry, error) {
+	ctx, done := s.begin(ctx, "get_audit_reference")
+	defer done()
+
+	e, err := scanAudit(s.queryRow(ctx, `SELECT `+auditColumns+` FROM audit_log WHERE reference = ?`, reference))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return e, nil
+}
+
+// institutionMatches keeps the audit_log or calls rows that matched a
+// person of the institution given as its argument. Failed checks match
+// no one, so they are left out too.
+const institutionMatches = ` AND matched_id IN (SELECT national_id FROM people WHERE institution_id = ?)`
+
+const auditColumns = `id, channel, source, COALESCE(input, ''), outcome, COALESCE(matched_id, ''),
+COALESCE(reference, ''), COALESCE(state, ''), COALESCE(request_id, ''), created_at`
+
+func scanAudit(row scanner) (*AuditEntry, error) {
+	e := &AuditEntry{}
+	err := row.Scan(&e.ID, &e.Channel, &e.Source, &e.Input, &e.Outcome, &e.MatchedID, &e.Reference, &e.State, &e.RequestID, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// PurgeAudit deletes the audit entries made before before
+func (s *SQLStore) PurgeAudit(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := s.begin(ctx, "purge_audit")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM audit_log WHERE created_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,312 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Breaker stops calls to a failing database. After Threshold calls in a
+// row fail with a timeout or a connection error it opens, and every call
+// fails at once with ErrUnavailable for Cooldown. Then one call is let
+// through as a trial: success closes the breaker, failure opens it again.
+// Other errors, such as a duplicate key, mean the database answered and
+// count as successes; a call canceled by its caller counts as neither.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	// OnChange, when set, is called when the breaker opens or closes
+	OnChange func(open bool)
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	trial    bool
+}
+
+// NewBreaker returns a closed breaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// OpenFor returns how long the breaker keeps failing calls, or 0 when a
+// call would be let through
+func (b *Breaker) OpenFor() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return 0
+	}
+	if left := b.Cooldown - time.Since(b.openedAt); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// allow returns ErrUnavailable while the breaker is open or its trial call
+// is still running; every nil return must be followed by record
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if b.trial || time.Since(b.openedAt) < b.Cooldown {
+		return ErrUnavailable
+	}
+	b.trial = true
+	return nil
+}
+
+// record counts the outcome of a call let through by allow
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	changed, open := false, !b.openedAt.IsZero()
+	b.trial = false
+	switch {
+	case errors.Is(err, driver.ErrSkip):
+		// The driver asked for another method; nothing reached the server
+	case errors.Is(err, context.Canceled):
+		// The client hung up; the database may not have answered yet, so
+		// a trial cut short leaves the breaker open for the next call
+	case !unavailable(err):
+		b.failures = 0
+		if open {
+			b.openedAt, changed, open = time.Time{}, true, false
+		}
+	default:
+		b.failures++
+		if open {
+			b.openedAt = time.Now()
+		} else if b.failures >= b.Threshold {
+			b.openedAt, changed, open = time.Now(), true, true
+		}
+	}
+	b.mu.Unlock()
+	if changed && b.OnChange != nil {
+		b.OnChange(open)
+	}
+}
+
+// unavailable reports whether err means the database could not be reached
+// or did not answer in time, rather than that it rejected the query
+func unavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr)
+}
+
+// guard runs call through s.Breaker, if there is one
+func (s *SQLStore) guard(call func() error) error {
+	b := s.Breaker
+	if b == nil {
+		return call()
+	}
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := call()
+	b.record(err)
+	return err
+}
+
+// breakerConnector opens the pool's connections through the store's
+// breaker. The breaker is read on every call, so it can be set after Open.
+type breakerConnector struct {
+	driver.Connector
+	s *SQLStore
+}
+
+func (c breakerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	err := c.s.guard(func() (err error) {
+		conn, err = c.Connector.Connect(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &breakerConn{Conn: conn, s: c.s}, nil
+}
+
+// dsnConnector is the connector of a driver that has none of its own
+type dsnConnector struct {
+	dsn string
+	d   driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.d.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.d }
+
+// newConnector returns the connector database/sql would use for d and dsn
+func newConnector(d driver.Driver, dsn string) (driver.Connector, error) {
+	if dc, ok := d.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+	return dsnConnector{dsn: dsn, d: d}, nil
+}
+
+// breakerConn passes every round trip of a connection through the breaker
+// and forwards the optional driver interfaces database/sql looks for
+type breakerConn struct {
+	driver.Conn
+	s *SQLStore
+}
+
+func (c *breakerConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *breakerConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	err := c.s.guard(func() (err error) {
+		if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+			stmt, err = pc.PrepareContext(ctx, query)
+		} else {
+			stmt, err = c.Conn.Prepare(query)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &breakerStmt{Stmt: stmt, s: c.s}, nil
+}
+
+func (c *breakerConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	err := c.s.guard(func() (err error) {
+		if bt, ok := c.Conn.(driver.ConnBeginTx); ok {
+			tx, err = bt.BeginTx(ctx, opts)
+		} else if opts.Isolation != 0 || opts.ReadOnly {
+			err = errors.New("store: driver does not support transaction options")
+		} else {
+			tx, err = c.Conn.Begin()
+		}
+		return err
+	})
+	return tx, err
+}
+
+func (c *breakerConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var rows driver.Rows
+	err := c.s.guard(func() (err error) {
+		rows, err = q.QueryContext(ctx, query, args)
+		return err
+	})
+	return rows, err
+}
+
+func (c *breakerConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var res driver.Result
+	err := c.s.guard(func() (err error) {
+		res, err = e.ExecContext(ctx, query, args)
+		return err
+	})
+	return res, err
+}
+
+func (c *breakerConn) Ping(ctx context.Context) error {
+	p, ok := c.Conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	return c.s.guard(func() error { return p.Ping(ctx) })
+}
+
+func (c *breakerConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *breakerConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *breakerConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// breakerStmt passes the round trips of a prepared statement through the
+// breaker
+type breakerStmt struct {
+	driver.Stmt
+	s *SQLStore
+}
+
+func (st *breakerStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	var res driver.Result
+	err := st.s.guard(func() (err error) {
+		if se, ok := st.Stmt.(driver.StmtExecContext); ok {
+			res, err = se.ExecContext(ctx, args)
+			return err
+		}
+		values, err := namedValues(args)
+		if err != nil {
+			return err
+		}
+		res, err = st.Stmt.Exec(values)
+		return err
+	})
+	return res, err
+}
+
+func (st *breakerStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	var rows driver.Rows
+	err := st.s.guard(func() (err error) {
+		if sq, ok := st.Stmt.(driver.StmtQueryContext); ok {
+			rows, err = sq.QueryContext(ctx, args)
+			return err
+		}
+		values, err := namedValues(args)
+		if err != nil {
+			return err
+		}
+		rows, err = st.Stmt.Query(values)
+		return err
+	})
+	return rows, err
+}
+
+func (st *breakerStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := st.Stmt.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// namedValues converts positional arguments for drivers that predate
+// the context methods
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("store: driver does not support named arguments")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"deadline", context.DeadlineExceeded, true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"connection done", sql.ErrConnDone, true},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"wrapped deadline", fmt.Errorf("store: get: %w", context.DeadlineExceeded), true},
+		{"canceled", context.Canceled, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"duplicate key", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, false},
+	}
+	for _, tt := range tests {
+		if got := unavailable(tt.err); got != tt.want {
+			t.Errorf("unavailable(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// trip records threshold failures on a closed breaker
+func trip(t *testing.T, b *Breaker) {
+	t.Helper()
+	for i := 0; i < b.Threshold; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow while closed: %v", err)
+		}
+		b.record(context.DeadlineExceeded)
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	var changes []bool
+	b := NewBreaker(3, time.Minute)
+	b.OnChange = func(open bool) { changes = append(changes, open) }
+
+	tests := []struct {
+		name     string
+		err      error
+		failures int
+	}{
+		{"timeout", context.DeadlineExceeded, 1},
+		{"bad connection", driver.ErrBadConn, 2},
+		{"answered", &mysql.MySQLError{Number: 1062}, 0},
+		{"timeout again", context.DeadlineExceeded, 1},
+		{"skipped", driver.ErrSkip, 1},
+		{"canceled", context.Canceled, 1},
+		{"network", &net.OpError{Op: "read", Err: errors.New("reset")}, 2},
+	}
+	for _, tt := range tests {
+		if err := b.allow(); err != nil {
+			t.Fatalf("%s: allow while closed: %v", tt.name, err)
+		}
+		b.record(tt.err)
+		if b.failures != tt.failures || b.OpenFor() != 0 {
+			t.Errorf("%s: %d failures, open for %s; want %d, closed", tt.name, b.failures, b.OpenFor(), tt.failures)
+		}
+	}
+	if len(changes) != 0 {
+		t.Fatalf("OnChange called %v before the threshold", changes)
+	}
+
+	b.allow()
+	b.record(context.DeadlineExceeded)
+	if left := b.OpenFor(); left <= 0 || left > time.Minute {
+		t.Errorf("OpenFor = %s after the threshold, want up to a minute", left)
+	}
+	if !errors.Is(b.allow(), ErrUnavailable) {
+		t.Error("allow let a call through while open")
+	}
+	if len(changes) != 1 || !changes[0] {
+		t.Errorf("OnChange calls = %v, want [true]", changes)
+	}
+}
+
+func TestBreakerTrial(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		open      bool
+		reopened  bool
+		wantCalls []bool
+	}{
+		{name: "success closes", err: nil, wantCalls: []bool{true, false}},
+		{name: "answered closes", err: sql.ErrNoRows, wantCalls: []bool{true, false}},
+		{name: "failure reopens", err: context.DeadlineExceeded, open: true, reopened: true, wantCalls: []bool{true}},
+		{name: "canceled stays open", err: context.Canceled, open: true, wantCalls: []bool{true}},
+		{name: "skipped stays open", err: driver.ErrSkip, open: true, wantCalls: []bool{true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []bool
+			b := NewBreaker(2, time.Minute)
+			b.OnChange = func(open bool) { calls = append(calls, open) }
+			trip(t, b)
+
+			// Let the cooldown run out
+			expired := time.Now().Add(-time.Minute)
+			b.openedAt = expired
+			if b.OpenFor() != 0 {
+				t.Fatalf("OpenFor = %s after the cooldown, want 0", b.OpenFor())
+			}
+			if err := b.allow(); err != nil {
+				t.Fatalf("trial refused: %v", err)
+			}
+			if !errors.Is(b.allow(), ErrUnavailable) {
+				t.Fatal("a second call got through during the trial")
+			}
+			b.record(tt.err)
+
+			if open := !b.openedAt.IsZero(); open != tt.open {
+				t.Errorf("open = %v after the trial, want %v", open, tt.open)
+			}
+			if reopened := b.openedAt.After(expired); tt.open && reopened != tt.reopened {
+				t.Errorf("cooldown restarted = %v, want %v", reopened, tt.reopened)
+			}
+			if fmt.Sprint(calls) != fmt.Sprint(tt.wantCalls) {
+				t.Errorf("OnChange calls = %v, want %v", calls, tt.wantCalls)
+			}
+			// A trial that settled nothing hands over to the next call
+			if tt.open && !tt.reopened {
+				if err := b.allow(); err != nil {
+					t.Errorf("next call after the trial refused: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	if err := s.Create(ctx, &Person{NationalID: "853400939V", FullName: "Harry Potter", Category: "student"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Breaker = NewBreaker(1, time.Minute)
+	trip(t, s.Breaker)
+
+	if _, err := s.GetByNationalID(ctx, "853400939V"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("lookup while open = %v, want ErrUnavailable", err)
+	}
+	s.Breaker.openedAt = time.Now().Add(-time.Minute)
+	if p, err := s.GetByNationalID(ctx, "853400939V"); err != nil || p.FullName != "Harry Potter" {
+		t.Fatalf("trial lookup = %+v, %v", p, err)
+	}
+	if s.Breaker.OpenFor() != 0 || !s.Breaker.openedAt.IsZero() {
+		t.Error("a successful trial left the breaker open")
+	}
+}
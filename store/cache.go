@@ -0,0 +1,299 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache holds recently verified people keyed by national ID. Only
+// successful lookups are cached, so a newly imported person is never
+// hidden behind a cached miss.
+type Cache interface {
+	Get(ctx context.Context, id string) (*Person, bool)
+	Set(ctx context.Context, p *Person)
+	Delete(ctx context.Context, ids ...string)
+}
+
+// MemoryCache is a size-bounded LRU cache whose entries expire after a TTL.
+// It is local to the process.
+type MemoryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	id      string
+	person  Person
+	expires time.Time
+}
+
+// NewMemoryCache returns a cache holding at most size people for ttl each
+func NewMemoryCache(size int, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		ttl:     ttl,
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns a copy of the cached person for id, if present and fresh
+func (c *MemoryCache) Get(ctx context.Context, id string) (*Person, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, id)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	p := e.person
+	return &p, true
+}
+
+// Set caches a copy of p, evicting the least recently used entry when full
+func (c *MemoryCache) Set(ctx context.Context, p *Person) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &memoryEntry{id: p.NationalID, person: *p, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.entries[e.id]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[e.id] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryEntry).id)
+	}
+}
+
+// Delete drops ids from the cache
+func (c *MemoryCache) Delete(ctx context.Context, ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if el, ok := c.entries[id]; ok {
+			c.order.Remove(el)
+			delete(c.entries, id)
+		}
+	}
+}
+
+// Prune drops expired entries, which otherwise stay until they are looked
+// up or evicted, and returns how many there were
+func (c *MemoryCache) Prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now, n := time.Now(), 0
+	for id, el := range c.entries {
+		if now.After(el.Value.(*memoryEntry).expires) {
+			c.order.Remove(el)
+			delete(c.entries, id)
+			n++
+		}
+	}
+	return n
+}
+
+// CachedStore wraps a Store and serves repeated ID lookups from a Cache.
+// Writes through CachedStore invalidate the affected IDs.
+type CachedStore struct {
+	Store
+	cache Cache
+
+	// OnLookup, when set, is called for every cacheable lookup with whether
+	// it was served from the cache
+	OnLookup func(hit bool)
+}
+
+// NewCachedStore returns s with lookups cached in c
+func NewCachedStore(s Store, c Cache) *CachedStore {
+	return &CachedStore{Store: s, cache: c}
+}
+
+func (s *CachedStore) observe(hit bool) {
+	if s.OnLookup != nil {
+		s.OnLookup(hit)
+	}
+}
+
+// GetByNationalID serves id from the cache when possible
+func (s *CachedStore) GetByNationalID(ctx context.Context, id string) (*Person, error) {
+	if p, ok := s.cache.Get(ctx, id); ok {
+		s.observe(true)
+		return p, nil
+	}
+	s.observe(false)
+	// A miss is read from the primary: right after a revocation or
+	// deletion drops the entry, a lagging replica would still return the
+	// old record and it would be cached for the whole TTL
+	p, err := s.Store.GetByNationalID(onPrimary(ctx), id)
+	if err == nil {
+		s.cache.Set(ctx, p)
+	}
+	return p, err
+}
+
+// Create inserts p and drops any cached entry for its ID
+func (s *CachedStore) Create(ctx context.Context, p *Person) error {
+	return s.CreateMany(ctx, []*Person{p})
+}
+
+// CreateMany inserts people and drops any cached entries for their IDs
+func (s *CachedStore) CreateMany(ctx context.Context, people []*Person) error {
+	err := s.Store.CreateMany(ctx, people)
+	s.Invalidate(ctx, peopleIDs(people)...)
+	return err
+}
+
+// SetRevoked updates revocation and drops the person's cached entry, so a
+// revoked credential is never served from the cache
+func (s *CachedStore) SetRevoked(ctx context.Context, nationalID string, revoked bool, reason string) (*Person, error) {
+	p, err := s.Store.SetRevoked(ctx, nationalID, revoked, reason)
+	s.Invalidate(ctx, nationalID)
+	if p != nil {
+		s.Invalidate(ctx, p.NationalID)
+	}
+	return p, err
+}
+
+// SetPublicLookup updates the opt-out and drops the person's cached entry,
+// so an opted-out person is never served to the public from the cache
+func (s *CachedStore) SetPublicLookup(ctx context.Context, nationalID string, public bool) (*Person, error) {
+	p, err := s.Store.SetPublicLookup(ctx, nationalID, public)
+	s.Invalidate(ctx, nationalID)
+	if p != nil {
+		s.Invalidate(ctx, p.NationalID)
+	}
+	return p, err
+}
+
+// SetInstitution moves a person and drops their cached entry, so lookups
+// scoped to an institution see the move at once
+func (s *CachedStore) SetInstitution(ctx context.Context, nationalID, institutionID string) (*Person, error) {
+	p, err := s.Store.SetInstitution(ctx, nationalID, institutionID)
+	s.Invalidate(ctx, nationalID)
+	if p != nil {
+		s.Invalidate(ctx, p.NationalID)
+	}
+	return p, err
+}
+
+// DeletePerson marks the person deleted and drops their cached entry, so a
+// deleted person is never served from the cache
+func (s *CachedStore) DeletePerson(ctx context.Context, nationalID, deletedBy string) (*Person, error) {
+	p, err := s.Store.DeletePerson(ctx, nationalID, deletedBy)
+	s.Invalidate(ctx, nationalID)
+	return p, err
+}
+
+// AssignCourse records a completion and drops the person's cached entry
+func (s *CachedStore) AssignCourse(ctx context.Context, nationalID string, courseID int64) error {
+	err := s.Store.AssignCourse(ctx, nationalID, courseID)
+	s.Invalidate(ctx, nationalID)
+	return err
+}
+
+// UnassignCourse removes a completion and drops the person's cached entry
+func (s *CachedStore) UnassignCourse(ctx context.Context, nationalID string, courseID int64) error {
+	err := s.Store.UnassignCourse(ctx, nationalID, courseID)
+	s.Invalidate(ctx, nationalID)
+	return err
+}
+
+// AddRemark adds a remark and drops the person's cached entry
+func (s *CachedStore) AddRemark(ctx context.Context, r *Remark) (*Remark, error) {
+	added, err := s.Store.AddRemark(ctx, r)
+	s.Invalidate(ctx, r.NationalID)
+	return added, err
+}
+
+// DeleteRemark removes a remark and drops the person's cached entry
+func (s *CachedStore) DeleteRemark(ctx context.Context, nationalID string, id int64) error {
+	err := s.Store.DeleteRemark(ctx, nationalID, id)
+	s.Invalidate(ctx, nationalID)
+	return err
+}
+
+// SetPhoto stores a photo and drops the person's cached entry, whose
+// HasPhoto would otherwise be stale
+func (s *CachedStore) SetPhoto(ctx context.Context, nationalID, contentType string, data []byte) (*Photo, error) {
+	ph, err := s.Store.SetPhoto(ctx, nationalID, contentType, data)
+	s.Invalidate(ctx, nationalID)
+	if ph != nil {
+		s.Invalidate(ctx, ph.NationalID)
+	}
+	return ph, err
+}
+
+// DeletePhoto removes a photo and drops the person's cached entry
+func (s *CachedStore) DeletePhoto(ctx context.Context, nationalID string) error {
+	err := s.Store.DeletePhoto(ctx, nationalID)
+	s.Invalidate(ctx, nationalID)
+	return err
+}
+
+// AddAttachment records an attachment and drops the person's cached
+// entry, whose Attachments would otherwise be stale
+func (s *CachedStore) AddAttachment(ctx context.Context, a *Attachment) (*Attachment, error) {
+	added, err := s.Store.AddAttachment(ctx, a)
+	s.Invalidate(ctx, a.NationalID)
+	return added, err
+}
+
+// DeleteAttachment removes an attachment and drops the person's cached
+// entry
+func (s *CachedStore) DeleteAttachment(ctx context.Context, nationalID string, id int64) (*Attachment, error) {
+	a, err := s.Store.DeleteAttachment(ctx, nationalID, id)
+	s.Invalidate(ctx, nationalID)
+	return a, err
+}
+
+// MergePeople merges fromID into keepID and drops both cached entries
+func (s *CachedStore) MergePeople(ctx context.Context, keepID, fromID, fromType string) (*Person, error) {
+	p, err := s.Store.MergePeople(ctx, keepID, fromID, fromType)
+	s.Invalidate(ctx, keepID, fromID)
+	if p != nil {
+		s.Invalidate(ctx, p.NationalID)
+	}
+	return p, err
+}
+
+// EraseSubject erases a data subject and drops their cached entries
+func (s *CachedStore) EraseSubject(ctx context.Context, nationalID string, ids []string, e *Erasure) error {
+	err := s.Store.EraseSubject(ctx, nationalID, ids, e)
+	if err == nil {
+		s.Invalidate(ctx, append([]string{nationalID}, ids...)...)
+	}
+	return err
+}
+
+// Invalidate drops ids from the cache; admin operations that change a
+// person call it after writing
+func (s *CachedStore) Invalidate(ctx context.Context, ids ...string) {
+	s.cache.Delete(ctx, ids...)
+}
+
+func peopleIDs(people []*Person) []string {
+	ids := make([]string, len(people))
+	for i, p := range people {
+		ids[i] = p.NationalID
+	}
+	return ids
+}
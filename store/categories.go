@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+func scanCategory(row scanner) (*Category, error) {
+	c := &Category{}
+	var spoken string
+	if err := row.Scan(&c.Name, &c.Label, &spoken, &c.Template, &c.ShowCourses, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(spoken), &c.SpokenLabels); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ListCategories returns every category, by name
+func (s *SQLStore) ListCategories(ctx context.Context) ([]Category, error) {
+	ctx, done := s.begin(ctx, "list_categories")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT name, label, spoken_labels, template, show_courses, created_at FROM categories ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, *c)
+	}
+	return categories, rows.Err()
+}
+
+// PutCategory updates the category named c.Name, or inserts it when there
+// is none, and returns the stored row
+func (s *SQLStore) PutCategory(ctx context.Context, c *Category) (*Category, error) {
+	ctx, done := s.begin(ctx, "put_category")
+	defer done()
+
+	spoken, err := json.Marshal(c.SpokenLabels)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.exec(ctx, `UPDATE categories SET label = ?, spoken_labels = ?, template = ?, show_courses = ? WHERE name = ?`,
+		c.Label, string(spoken), c.Template, c.ShowCourses, c.Name)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		// MySQL counts only changed rows, so an unchanged category lands
+		// here too and its insert is a duplicate
+		_, err := s.exec(ctx, `INSERT INTO categories (name, label, spoken_labels, template, show_courses) VALUES (?, ?, ?, ?, ?)`,
+			c.Name, c.Label, string(spoken), c.Template, c.ShowCourses)
+		if err != nil && !errors.Is(err, ErrDuplicate) {
+			return nil, err
+		}
+	}
+	return scanCategory(s.queryRow(ctx, `SELECT name, label, spoken_labels, template, show_courses, created_at FROM categories WHERE name = ?`, c.Name))
+}
+
+// DeleteCategory removes a category no person is in
+func (s *SQLStore) DeleteCategory(ctx context.Context, name string) error {
+	ctx, done := s.begin(ctx, "delete_category")
+	defer done()
+
+	var n int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM people WHERE category = ?`, name).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrInUse
+	}
+	res, err := s.exec(ctx, `DELETE FROM categories WHERE name = ?`, name)
+	return updatedOne(res, err)
+}
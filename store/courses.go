@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// loadCourses fills in Courses for each of people with one query
+func (s *SQLStore) loadCourses(ctx context.Context, people ...*Person) error {
+	if len(people) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Person, len(people))
+	args := make([]interface{}, 0, len(people))
+	for _, p := range people {
+		byID[p.NationalID] = p
+		args = append(args, p.NationalID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+
+	query := `SELECT pc.national_id, c.name FROM person_courses pc JOIN courses c ON c.id = pc.course_id
+WHERE pc.national_id IN (` + placeholders + `) ORDER BY c.id`
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		if p, ok := byID[id]; ok {
+			p.Courses = append(p.Courses, name)
+		}
+	}
+	return rows.Err()
+}
+
+// ListCourses returns every course, oldest first
+func (s *SQLStore) ListCourses(ctx context.Context) ([]Course, error) {
+	ctx, done := s.begin(ctx, "list_courses")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT id, name, created_at FROM courses ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	courses := []Course{}
+	for rows.Next() {
+		var c Course
+		if err := rows.Scan(&c.ID, &c.Name, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		courses = append(courses, c)
+	}
+	return courses, rows.Err()
+}
+
+// CreateCourse inserts a course and returns it with its ID
+func (s *SQLStore) CreateCourse(ctx context.Context, name string) (*Course, error) {
+	ctx, done := s.begin(ctx, "create_course")
+	defer done()
+
+	if _, err := s.exec(ctx, `INSERT INTO courses (name) VALUES (?)`, name); err != nil {
+		return nil, err
+	}
+	// Look the row up by its unique name, since Postgres has no LastInsertId
+	c := &Course{}
+	err := s.queryRow(ctx, `SELECT id, name, created_at FROM courses WHERE name = ?`, name).Scan(&c.ID, &c.Name, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AssignCourse records a completion, using the person's stored ID so the
+// join table matches it exactly
+func (s *SQLStore) AssignCourse(ctx context.Context, nationalID string, courseID int64) error {
+	ctx, done := s.begin(ctx, "assign_course")
+	defer done()
+
+	var id string
+	if err := s.queryRow(ctx, `SELECT national_id FROM people WHERE national_id = ?`+notDeleted, nationalID).Scan(&id); err != nil {
+		return notFound(err)
+	}
+	var exists int
+	if err := s.queryRow(ctx, `SELECT 1 FROM courses WHERE id = ?`, courseID).Scan(&exists); err != nil {
+		return notFound(err)
+	}
+
+	return s.change(ctx, id, HistoryCourseAssigned, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO person_courses (national_id, course_id) VALUES (?, ?)`), id, courseID)
+		if err = s.mapError(err); errors.Is(err, ErrDuplicate) {
+			return nil
+		}
+		return err
+	})
+}
+
+// UnassignCourse deletes a completion
+func (s *SQLStore) UnassignCourse(ctx context.Context, nationalID string, courseID int64) error {
+	ctx, done := s.begin(ctx, "unassign_course")
+	defer done()
+
+	return s.change(ctx, nationalID, HistoryCourseUnassigned, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM person_courses WHERE national_id = ? AND course_id = ?`), nationalID, courseID)
+		return updatedOne(res, err)
+	})
+}
@@ -0,0 +1,307 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encPrefix marks an encrypted column value: "enc1:<key id>:<base64 of
+// nonce, ciphertext and tag>". Values without it are plaintext, written
+// before encryption was turned on, and are read as they are.
+const encPrefix = "enc1:"
+
+// Cipher encrypts sensitive columns with AES-GCM. Values are written with
+// the first key and read with whichever key they name, so old keys stay
+// usable until RotateKeys has re-encrypted everything under the new one.
+type Cipher struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewCipher builds a Cipher from "id:key" entries, each key being 16, 24 or
+// 32 bytes in standard base64 (AES-128, -192 or -256). The first entry is
+// the one new values are encrypted with.
+func NewCipher(entries []string) (*Cipher, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("no keys")
+	}
+	c := &Cipher{keys: make(map[string]cipher.AEAD, len(entries))}
+	for _, entry := range entries {
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("key %q must be id:base64", redactKey(entry))
+		}
+		if _, dup := c.keys[id]; dup {
+			return nil, fmt.Errorf("key id %q is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys[id] = aead
+		if c.current == "" {
+			c.current = id
+		}
+	}
+	return c, nil
+}
+
+// redactKey keeps the id of a malformed key entry for error messages
+func redactKey(entry string) string {
+	if id, _, ok := strings.Cut(entry, ":"); ok {
+		return id + ":..."
+	}
+	return "..."
+}
+
+// CurrentKey is the id of the key new values are encrypted with
+func (c *Cipher) CurrentKey() string {
+	return c.current
+}
+
+// Encrypt seals value under the current key. The row and column it is
+// stored in are bound as additional data, so a value copied into another
+// row or column fails to decrypt. A nil Cipher returns value unchanged.
+func (c *Cipher) Encrypt(value, row, column string) (string, error) {
+	if c == nil || value == "" {
+		return value, nil
+	}
+	aead := c.keys[c.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(row+"\x00"+column))
+	return encPrefix + c.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value written by Encrypt, returning plaintext values as
+// they are
+func (c *Cipher) Decrypt(value, row, column string) (string, error) {
+	id, sealed, ok := splitEncrypted(value)
+	if !ok {
+		return value, nil
+	}
+	if c == nil {
+		return "", fmt.Errorf("%s is encrypted but no keys are configured", column)
+	}
+	aead, ok := c.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%s is encrypted with unknown key %q", column, id)
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("%s is not a valid encrypted value", column)
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, data, []byte(row+"\x00"+column))
+	if err != nil {
+		return "", fmt.Errorf("decrypt %s with key %q: %w", column, id, err)
+	}
+	return string(plain), nil
+}
+
+// needsRotation reports whether value is plaintext or sealed under a key
+// other than the current one
+func (c *Cipher) needsRotation(value string) bool {
+	if value == "" {
+		return false
+	}
+	id, _, ok := splitEncrypted(value)
+	return !ok || id != c.current
+}
+
+// splitEncrypted splits an encrypted value into its key id and payload
+func splitEncrypted(value string) (id, sealed string, ok bool) {
+	rest, ok := strings.CutPrefix(value, encPrefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// encryptPerson returns p's full_name as it is stored
+func (s *SQLStore) encryptPerson(p *Person) (string, error) {
+	return s.Cipher.Encrypt(p.FullName, p.NationalID, "full_name")
+}
+
+// decryptPerson replaces p's stored full_name with its plaintext. Errors
+// leave out the ID, since callers log them.
+func (s *SQLStore) decryptPerson(p *Person) error {
+	var err error
+	p.FullName, err = s.Cipher.Decrypt(p.FullName, p.NationalID, "full_name")
+	return err
+}
+
+// rotateBatchSize is how many people RotateKeys re-encrypts per transaction
+const rotateBatchSize = 500
+
+// RotateKeys re-encrypts every full_name, remark and person_history value
+// that is plaintext or sealed under an old key with the current key, a
+// batch of rows per transaction, and returns how many people, remarks and
+// history entries it rewrote. It is safe to run while the server is up and to
+// re-run after an interruption. Like ExportPeople it is not bound by
+// QueryTimeout.
+func (s *SQLStore) RotateKeys(ctx context.Context) (int, error) {
+	if s.Cipher == nil {
+		return 0, errors.New("no encryption keys are configured")
+	}
+	defer s.timeQuery("rotate_keys")()
+
+	rotated, after := 0, ""
+	for {
+		n, last, err := s.rotateBatch(ctx, after)
+		rotated += n
+		if err != nil {
+			return rotated, err
+		}
+		if last == "" {
+			break
+		}
+		after = last
+	}
+	for _, rotate := range []func(context.Context, int64) (int, int64, error){s.rotateRemarksBatch, s.rotateHistoryBatch} {
+		for afterID := int64(0); ; {
+			n, last, err := rotate(ctx, afterID)
+			rotated += n
+			if err != nil {
+				return rotated, err
+			}
+			if last == 0 {
+				break
+			}
+			afterID = last
+		}
+	}
+	return rotated, nil
+}
+
+// rotateBatch re-encrypts the people in the batch after the ID after and
+// returns how many it rewrote and the last ID of the batch, or "" when
+// there were none left
+func (s *SQLStore) rotateBatch(ctx context.Context, after string) (int, string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`SELECT national_id, full_name FROM people
+WHERE national_id > ? ORDER BY national_id LIMIT ?`), after, rotateBatchSize)
+	if err != nil {
+		return 0, "", err
+	}
+	var batch []Person
+	for rows.Next() {
+		var p Person
+		if err := rows.Scan(&p.NationalID, &p.FullName); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(batch) == 0 {
+		return 0, "", err
+	}
+
+	n := 0
+	for i := range batch {
+		p := &batch[i]
+		if !s.Cipher.needsRotation(p.FullName) {
+			continue
+		}
+		if err := s.decryptPerson(p); err != nil {
+			return 0, "", err
+		}
+		fullName, err := s.encryptPerson(p)
+		if err != nil {
+			return 0, "", err
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE people SET full_name = ? WHERE national_id = ?`),
+			fullName, p.NationalID); err != nil {
+			return 0, "", err
+		}
+		n++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return n, batch[len(batch)-1].NationalID, nil
+}
+
+// rotateHistoryBatch re-encrypts the person_history entries in the batch
+// after the id after, like rotateBatch, returning the last id or 0
+func (s *SQLStore) rotateHistoryBatch(ctx context.Context, after int64) (int, int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`SELECT id, national_id, COALESCE(old_values, ''), new_values FROM person_history
+WHERE id > ? ORDER BY id LIMIT ?`), after, rotateBatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	type entry struct {
+		id                               int64
+		nationalID, oldValues, newValues string
+	}
+	var batch []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.nationalID, &e.oldValues, &e.newValues); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(batch) == 0 {
+		return 0, 0, err
+	}
+
+	n := 0
+	for _, e := range batch {
+		if !s.Cipher.needsRotation(e.oldValues) && !s.Cipher.needsRotation(e.newValues) {
+			continue
+		}
+		var values [2]interface{}
+		for i, col := range []struct{ name, value string }{{"old_values", e.oldValues}, {"new_values", e.newValues}} {
+			if col.value == "" {
+				continue
+			}
+			plain, err := s.Cipher.Decrypt(col.value, e.nationalID, "person_history."+col.name)
+			if err != nil {
+				return 0, 0, err
+			}
+			if values[i], err = s.Cipher.Encrypt(plain, e.nationalID, "person_history."+col.name); err != nil {
+				return 0, 0, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE person_history SET old_values = ?, new_values = ? WHERE id = ?`),
+			values[0], values[1], e.id); err != nil {
+			return 0, 0, err
+		}
+		n++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return n, batch[len(batch)-1].id, nil
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey is a base64 AES key of n bytes, each b
+func testKey(n int, b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), n)))
+}
+
+// openTestStore opens a migrated SQLite store in a temporary directory
+func openTestStore(t *testing.T) *SQLStore {
+	t.Helper()
+	s, err := Open(DriverSQLite, SQLiteDSN(filepath.Join(t.TempDir(), "test.db")), PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestNewCipher(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		current string
+		wantErr bool
+	}{
+		{name: "aes-128", entries: []string{"k1:" + testKey(16, 'a')}, current: "k1"},
+		{name: "aes-256 first is current", entries: []string{"new:" + testKey(32, 'b'), "old:" + testKey(24, 'c')}, current: "new"},
+		{name: "no keys", wantErr: true},
+		{name: "no id", entries: []string{testKey(16, 'a')}, wantErr: true},
+		{name: "empty id", entries: []string{":" + testKey(16, 'a')}, wantErr: true},
+		{name: "bad base64", entries: []string{"k1:not base64"}, wantErr: true},
+		{name: "bad length", entries: []string{"k1:" + testKey(20, 'a')}, wantErr: true},
+		{name: "duplicate id", entries: []string{"k1:" + testKey(16, 'a'), "k1:" + testKey(16, 'b')}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCipher(tt.entries)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewCipher(%q) succeeded; want an error", tt.entries)
+				}
+				if strings.Contains(err.Error(), testKey(16, 'a')) {
+					t.Errorf("error %q contains the key", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewCipher(%q) failed: %v", tt.entries, err)
+			}
+			if c.CurrentKey() != tt.current {
+				t.Errorf("CurrentKey() = %q, want %q", c.CurrentKey(), tt.current)
+			}
+		})
+	}
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	c, err := NewCipher([]string{"k1:" + testKey(32, 'a')})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"Harry Potter", "හැරී පොටර්", strings.Repeat("x", 5000)} {
+		sealed, err := c.Encrypt(value, "853400939V", "full_name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(sealed, encPrefix+"k1:") || strings.Contains(sealed, value) {
+			t.Errorf("Encrypt(%.20q) = %.40q; want it sealed under k1", value, sealed)
+		}
+		again, _ := c.Encrypt(value, "853400939V", "full_name")
+		if again == sealed {
+			t.Error("Encrypt reused a nonce")
+		}
+		if got, err := c.Decrypt(sealed, "853400939V", "full_name"); err != nil || got != value {
+			t.Errorf("Decrypt = %.20q, %v; want %.20q", got, err, value)
+		}
+	}
+}
+
+func TestCipherDecrypt(t *testing.T) {
+	c, err := NewCipher([]string{"k1:" + testKey(32, 'a')})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCipher([]string{"k2:" + testKey(32, 'b')})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := c.Encrypt("Harry Potter", "853400939V", "full_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := sealed[:len(sealed)-4] + "AAAA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-4] + "BBBB"
+	}
+
+	tests := []struct {
+		name, value, row, column string
+		cipher                   *Cipher
+		want                     string
+		wantErr                  bool
+	}{
+		{name: "plaintext passes through", value: "Harry Potter", row: "853400939V", column: "full_name", cipher: c, want: "Harry Potter"},
+		{name: "plaintext without keys", value: "Harry Potter", row: "853400939V", column: "full_name", want: "Harry Potter"},
+		{name: "empty", value: "", row: "853400939V", column: "full_name", cipher: c, want: ""},
+		{name: "other row", value: sealed, row: "925671231X", column: "full_name", cipher: c, wantErr: true},
+		{name: "other column", value: sealed, row: "853400939V", column: "remarks", cipher: c, wantErr: true},
+		{name: "tampered", value: tampered, row: "853400939V", column: "full_name", cipher: c, wantErr: true},
+		{name: "unknown key", value: sealed, row: "853400939V", column: "full_name", cipher: other, wantErr: true},
+		{name: "no keys", value: sealed, row: "853400939V", column: "full_name", wantErr: true},
+		{name: "truncated", value: encPrefix + "k1:AAAA", row: "853400939V", column: "full_name", cipher: c, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cipher.Decrypt(tt.value, tt.row, tt.column)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Decrypt = %q; want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Decrypt = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCipherRotation(t *testing.T) {
+	old, err := NewCipher([]string{"old:" + testKey(32, 'a')})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewCipher([]string{"new:" + testKey(32, 'b'), "old:" + testKey(32, 'a')})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedOld, _ := old.Encrypt("Harry Potter", "853400939V", "full_name")
+	sealedNew, _ := rotated.Encrypt("Harry Potter", "853400939V", "full_name")
+
+	tests := []struct {
+		name, value string
+		want        bool
+	}{
+		{"old key", sealedOld, true},
+		{"current key", sealedNew, false},
+		{"plaintext", "Harry Potter", true},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		if got := rotated.needsRotation(tt.value); got != tt.want {
+			t.Errorf("needsRotation(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got, err := rotated.Decrypt(sealedOld, "853400939V", "full_name"); err != nil || got != "Harry Potter" {
+		t.Errorf("rotated cipher reading the old key: %q, %v", got, err)
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	var err error
+	if s.Cipher, err = NewCipher([]string{"old:" + testKey(32, 'a')}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*Person{
+		{NationalID: "853400939V", FullName: "Harry Potter", Category: "student"},
+		{NationalID: "925671231X", FullName: "Hermione Granger", Category: "student"},
+	} {
+		if err := s.Create(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.Cipher, err = NewCipher([]string{"new:" + testKey(32, 'b'), "old:" + testKey(32, 'a')}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.RotateKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < 2 {
+		t.Errorf("RotateKeys rewrote %d values, want at least the 2 names", n)
+	}
+	if n, err := s.RotateKeys(ctx); err != nil || n != 0 {
+		t.Errorf("second RotateKeys = %d, %v; want nothing left to rewrite", n, err)
+	}
+
+	var stored string
+	if err := s.db.QueryRow(`SELECT full_name FROM people WHERE national_id = ?`, "853400939V").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, encPrefix+"new:") {
+		t.Errorf("stored full_name %.20q is not under the new key", stored)
+	}
+	// Only the new key is needed once everything is rotated
+	if s.Cipher, err = NewCipher([]string{"new:" + testKey(32, 'b')}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.GetByNationalID(ctx, "853400939V")
+	if err != nil || p.FullName != "Harry Potter" {
+		t.Errorf("GetByNationalID after rotation = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported DB_DRIVER values
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// dialect captures the differences between SQL databases
+type dialect struct {
+	// driverName is the database/sql driver to open
+	driverName string
+	// migrations is the directory under migrations/ for this dialect
+	migrations string
+	// rebind rewrites ? placeholders into the dialect's form
+	rebind func(query string) string
+	// isDuplicate reports whether err is a unique-key violation
+	isDuplicate func(err error) bool
+	// isConflict, when set, reports whether err is a deadlock or similar
+	// conflict the database rolled back, so running it again is safe
+	isConflict func(err error) bool
+	// day formats a timestamp column as a YYYY-MM-DD string for grouping
+	day func(column string) string
+	// init, when set, runs once after the pool is opened
+	init func(ctx context.Context, s *SQLStore) error
+}
+
+// PoolConfig tunes the database/sql connection pool. Zero values leave the
+// database/sql defaults in place.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// apply sets the pool limits on db
+func (p PoolConfig) apply(db *sql.DB) {
+	if p.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	}
+}
+
+// Open connects to the database for driver (DB_DRIVER) using dsn, applies
+// the pool settings and checks connectivity with a ping
+func Open(driver, dsn string, pool PoolConfig) (*SQLStore, error) {
+	var d dialect
+	switch driver {
+	case DriverMySQL, "":
+		d = mysqlDialect
+	case DriverPostgres:
+		d = postgresDialect
+	case DriverSQLite:
+		d = sqliteDialect
+		d.init = initSQLite
+	default:
+		return nil, fmt.Errorf("store: unsupported driver %q", driver)
+	}
+
+	// Every connection goes through breakerConnector, which leaves calls
+	// alone until a Breaker is set
+	probe, err := sql.Open(d.driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := newConnector(probe.Driver(), dsn)
+	probe.Close()
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLStore{dialect: d}
+	db := sql.OpenDB(breakerConnector{Connector: connector, s: s})
+	s.db = db
+	pool.apply(db)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store: ping %s: %w", driver, err)
+	}
+	if d.init != nil {
+		if err := d.init(ctx, s); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("store: init %s: %w", driver, err)
+		}
+	}
+	return s, nil
+}
+
+// rebindNumbered converts ? placeholders to $1, $2, ... skipping any ?
+// inside single-quoted string literals
+func rebindNumbered(query string) string {
+	var b strings.Builder
+	n := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inString = !inString
+			b.WriteByte(c)
+		case c == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"strings"
+)
+
+// LogError inserts entries into the errors table with one multi-row
+// INSERT, like RecordAudit
+func (s *SQLStore) LogError(ctx context.Context, entries ...*ErrorEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ctx, done := s.begin(ctx, "log_error")
+	defer done()
+
+	placeholders := make([]string, len(entries))
+	args := make([]interface{}, 0, len(entries)*6)
+	for i, e := range entries {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?)"
+		args = append(args, e.Timestamp, e.Severity, e.Category, e.ErrorType, e.Remark, nullString(e.RequestID))
+	}
+	query := `INSERT INTO errors (timestamp, severity, category, error_type, remark, request_id) VALUES ` +
+		strings.Join(placeholders, ", ")
+	_, err := s.exec(ctx, query, args...)
+	return err
+}
+
+// errorWhere builds the WHERE clause shared by the errors table queries
+func errorWhere(f ErrorFilter) (string, []interface{}) {
+	where := ` WHERE 1=1`
+	var args []interface{}
+	if f.ErrorType != "" {
+		where += ` AND error_type = ?`
+		args = append(args, f.ErrorType)
+	}
+	if f.Category != "" {
+		where += ` AND category = ?`
+		args = append(args, f.Category)
+	}
+	if f.RequestID != "" {
+		where += ` AND request_id = ?`
+		args = append(args, f.RequestID)
+	}
+	if f.MinSeverity != "" {
+		// Severities is ordered, so the matching ones are a suffix of it
+		var levels []string
+		for i, sev := range Severities {
+			if sev == f.MinSeverity {
+				levels = Severities[i:]
+			}
+		}
+		if len(levels) == 0 {
+			levels = []string{f.MinSeverity}
+		}
+		where += ` AND severity IN (?` + strings.Repeat(", ?", len(levels)-1) + `)`
+		for _, sev := range levels {
+			args = append(args, sev)
+		}
+	}
+	if !f.Since.IsZero() {
+		where += ` AND timestamp >= ?`
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where += ` AND timestamp < ?`
+		args = append(args, f.Until)
+	}
+	return where, args
+}
+
+// ListErrors returns a page of logged events matching f, newest first
+func (s *SQLStore) ListErrors(ctx context.Context, f ErrorFilter, limit, offset int) ([]ErrorEntry, int, error) {
+	ctx, done := s.begin(ctx, "list_errors")
+	defer done()
+
+	where, args := errorWhere(f)
+	var total int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM errors`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + errorColumns + ` FROM errors` + where + ` ORDER BY timestamp DESC, id DESC LIMIT ? OFFSET ?`
+	rows, err := s.query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []ErrorEntry{}
+	for rows.Next() {
+		var e ErrorEntry
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Severity, &e.Category, &e.ErrorType, &e.Remark, &e.RequestID); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+// ExportErrors streams every logged event matching f to fn, newest first.
+// Like ExportPeople it is not bound by QueryTimeout.
+func (s *SQLStore) ExportErrors(ctx context.Context, f ErrorFilter, fn func(*ErrorEntry) error) error {
+	defer s.timeQuery("export_errors")()
+
+	where, args := errorWhere(f)
+	rows, err := s.query(ctx, `SELECT `+errorColumns+` FROM errors`+where+` ORDER BY timestamp DESC, id DESC`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e ErrorEntry
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Severity, &e.Category, &e.ErrorType, &e.Remark, &e.RequestID); err != nil {
+			return err
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// PurgeErrors deletes the logged events matching f and returns how many
+// were removed
+func (s *SQLStore) PurgeErrors(ctx context.Context, f ErrorFilter) (int64, error) {
+	ctx, done := s.begin(ctx, "purge_errors")
+	defer done()
+
+	where, args := errorWhere(f)
+	res, err := s.exec(ctx, `DELETE FROM errors`+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+const errorColumns = `id, timestamp, severity, category, error_type, COALESCE(remark, ''), COALESCE(request_id, '')`
@@ -0,0 +1,241 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// History actions, as stored in person_history.action
+const (
+	HistoryCreated            = "created"
+	HistoryRevoked            = "revoked"
+	HistoryReinstated         = "reinstated"
+	HistoryPublicLookup       = "public_lookup_changed"
+	HistoryInstitutionChanged = "institution_changed"
+	HistoryDeleted            = "deleted"
+	HistoryRestored           = "restored"
+	HistoryCourseAssigned     = "course_assigned"
+	HistoryCourseUnassigned   = "course_unassigned"
+	HistoryPhotoChanged       = "photo_changed"
+	HistoryPhotoRemoved       = "photo_removed"
+	HistoryMerged             = "merged"
+	HistoryRemarkAdded        = "remark_added"
+	HistoryRemarkRemoved      = "remark_removed"
+	HistoryAttachmentAdded    = "attachment_added"
+	HistoryAttachmentRemoved  = "attachment_removed"
+)
+
+type actorCtxKey struct{}
+
+// WithActor returns ctx recording actor as who makes the changes to people
+// done with it
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorCtxKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorCtxKey{}).(string)
+	return actor
+}
+
+// HistoryFields returns the fields of p that person_history tracks, as
+// they appear in its old_values and new_values
+func HistoryFields(p *Person) map[string]interface{} {
+	courses := p.Courses
+	if courses == nil {
+		courses = []string{}
+	}
+	remarks := make([]map[string]interface{}, len(p.Remarks))
+	for i, r := range p.Remarks {
+		remarks[i] = map[string]interface{}{"type": r.Type, "visibility": r.Visibility, "text": r.Text}
+	}
+	deletedAt := ""
+	if !p.DeletedAt.IsZero() {
+		deletedAt = p.DeletedAt.UTC().Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"full_name":         p.FullName,
+		"category":          p.Category,
+		"institution":       p.InstitutionID,
+		"remarks":           remarks,
+		"issue_date":        formatDate(p.IssueDate),
+		"expiry_date":       formatDate(p.ExpiryDate),
+		"revoked":           p.Revoked,
+		"revocation_reason": p.RevocationReason,
+		"public_lookup":     p.PublicLookup,
+		"deleted_at":        deletedAt,
+		"has_photo":         p.HasPhoto,
+		"attachments":       p.Attachments,
+		"courses":           courses,
+	}
+}
+
+// formatDate renders a DATE column as in HistoryFields, "" when unset
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(dateLayout)
+}
+
+// snapshot reads a person, deleted or not, with their courses and remarks
+// inside tx; nil when the ID does not exist
+func (s *SQLStore) snapshot(ctx context.Context, tx *sql.Tx, nationalID string) (*Person, error) {
+	p, err := s.scanPerson(tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT `+personColumns+` FROM people WHERE national_id = ?`), nationalID))
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`SELECT c.name FROM person_courses pc JOIN courses c ON c.id = pc.course_id
+WHERE pc.national_id = ? ORDER BY c.id`), p.NationalID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		p.Courses = append(p.Courses, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if rows, err = tx.QueryContext(ctx, s.dialect.rebind(`SELECT `+remarkColumns+` FROM person_remarks WHERE national_id = ? ORDER BY id`), p.NationalID); err != nil {
+		return nil, err
+	}
+	return p, s.scanRemarks(rows, map[string]*Person{p.NationalID: p})
+}
+
+// change runs update in a transaction and records how it changed the
+// person as action, by the actor of ctx. Nothing is recorded when the
+// person does not exist or update changed none of the tracked fields. A
+// transaction rolled back by a deadlock is run again, update included.
+func (s *SQLStore) change(ctx context.Context, nationalID, action string, update func(tx *sql.Tx) error) error {
+	return s.retry(ctx, true, func() error {
+		return s.changeOnce(ctx, nationalID, action, update)
+	})
+}
+
+func (s *SQLStore) changeOnce(ctx context.Context, nationalID, action string, update func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := s.snapshot(ctx, tx, nationalID)
+	if err != nil {
+		return err
+	}
+	if err := update(tx); err != nil {
+		return s.mapError(err)
+	}
+	after, err := s.snapshot(ctx, tx, nationalID)
+	if err != nil {
+		return err
+	}
+	if before != nil && after != nil {
+		oldValues, newValues := diffFields(before, after)
+		if len(newValues) > 0 {
+			if err := s.recordHistory(ctx, tx, after.NationalID, action, oldValues, newValues); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// diffFields returns the tracked fields that differ between before and
+// after, with their values in each
+func diffFields(before, after *Person) (oldValues, newValues map[string]interface{}) {
+	oldValues, newValues = HistoryFields(before), HistoryFields(after)
+	for k, v := range oldValues {
+		if reflect.DeepEqual(v, newValues[k]) {
+			delete(oldValues, k)
+			delete(newValues, k)
+		}
+	}
+	return oldValues, newValues
+}
+
+// recordHistory inserts one person_history row; oldValues is nil for a
+// person just created
+func (s *SQLStore) recordHistory(ctx context.Context, tx *sql.Tx, nationalID, action string, oldValues, newValues map[string]interface{}) error {
+	var old interface{}
+	if oldValues != nil {
+		sealed, err := s.sealHistory(nationalID, "old_values", oldValues)
+		if err != nil {
+			return err
+		}
+		old = sealed
+	}
+	sealed, err := s.sealHistory(nationalID, "new_values", newValues)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO person_history (national_id, action, old_values, new_values, actor, created_at) VALUES (?, ?, ?, ?, ?, ?)`),
+		nationalID, action, old, sealed, actorFrom(ctx), time.Now().UTC())
+	return err
+}
+
+// sealHistory encodes values as JSON, encrypted like full_name and remarks
+// since they can hold both
+func (s *SQLStore) sealHistory(nationalID, column string, values map[string]interface{}) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return s.Cipher.Encrypt(string(data), nationalID, "person_history."+column)
+}
+
+// openHistory decodes a value written by sealHistory; "" is nil
+func (s *SQLStore) openHistory(nationalID, column, value string) (map[string]interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+	plain, err := s.Cipher.Decrypt(value, nationalID, "person_history."+column)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	return values, json.Unmarshal([]byte(plain), &values)
+}
+
+// ListPersonHistory returns the changes made to the person stored under
+// nationalID, deleted or not, oldest first
+func (s *SQLStore) ListPersonHistory(ctx context.Context, nationalID string) ([]PersonChange, error) {
+	ctx, done := s.begin(ctx, "list_person_history")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT id, national_id, action, COALESCE(old_values, ''), new_values, actor, COALESCE(merged_from, ''), created_at FROM person_history
+WHERE national_id = ? ORDER BY created_at, id`, nationalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []PersonChange{}
+	for rows.Next() {
+		var c PersonChange
+		var oldValues, newValues string
+		if err := rows.Scan(&c.ID, &c.NationalID, &c.Action, &oldValues, &newValues, &c.Actor, &c.MergedFrom, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		if c.Old, err = s.openHistory(c.NationalID, "old_values", oldValues); err != nil {
+			return nil, err
+		}
+		if c.New, err = s.openHistory(c.NationalID, "new_values", newValues); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"strings"
+)
+
+// ListIdentifiers returns the identifiers registered for a person, by type,
+// deleted or not
+func (s *SQLStore) ListIdentifiers(ctx context.Context, nationalID string) ([]Identifier, error) {
+	ctx, done := s.begin(ctx, "list_identifiers")
+	defer done()
+
+	var id string
+	if err := s.queryRow(ctx, `SELECT national_id FROM people WHERE national_id = ?`, nationalID).Scan(&id); err != nil {
+		return nil, notFound(err)
+	}
+
+	rows, err := s.query(ctx, `SELECT id_type, value, national_id, created_at FROM person_identifiers
+WHERE national_id = ? ORDER BY id_type, value`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []Identifier{}
+	for rows.Next() {
+		var i Identifier
+		if err := rows.Scan(&i.Type, &i.Value, &i.NationalID, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		ids = append(ids, i)
+	}
+	return ids, rows.Err()
+}
+
+// AddIdentifier registers an identifier against the person's stored ID so
+// the foreign key matches it exactly
+func (s *SQLStore) AddIdentifier(ctx context.Context, nationalID, idType, value string) (*Identifier, error) {
+	ctx, done := s.begin(ctx, "add_identifier")
+	defer done()
+
+	var id string
+	if err := s.queryRow(ctx, `SELECT national_id FROM people WHERE national_id = ?`+notDeleted, nationalID).Scan(&id); err != nil {
+		return nil, notFound(err)
+	}
+	if _, err := s.exec(ctx, `INSERT INTO person_identifiers (id_type, value, national_id) VALUES (?, ?, ?)`, idType, value, id); err != nil {
+		return nil, err
+	}
+
+	i := &Identifier{}
+	err := s.queryRow(ctx, `SELECT id_type, value, national_id, created_at FROM person_identifiers WHERE id_type = ? AND value = ?`,
+		idType, value).Scan(&i.Type, &i.Value, &i.NationalID, &i.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// RemoveIdentifier deletes one of a person's identifiers
+func (s *SQLStore) RemoveIdentifier(ctx context.Context, nationalID, idType, value string) error {
+	ctx, done := s.begin(ctx, "remove_identifier")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM person_identifiers WHERE national_id = ? AND id_type = ? AND value = ?`,
+		nationalID, idType, value)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListIdentifiersOfType returns the identifiers of idType of people who are
+// not deleted, by value
+func (s *SQLStore) ListIdentifiersOfType(ctx context.Context, idType string) ([]Identifier, error) {
+	ctx, done := s.begin(ctx, "list_identifiers_of_type")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT i.id_type, i.value, i.national_id, i.created_at FROM person_identifiers i
+JOIN people ON people.national_id = i.national_id
+WHERE i.id_type = ? AND people.deleted_at IS NULL ORDER BY i.value`, idType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []Identifier{}
+	for rows.Next() {
+		var i Identifier
+		if err := rows.Scan(&i.Type, &i.Value, &i.NationalID, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		ids = append(ids, i)
+	}
+	return ids, rows.Err()
+}
+
+// FindByIdentifier looks values up in person_identifiers and returns the
+// first matching person with their courses
+func (s *SQLStore) FindByIdentifier(ctx context.Context, idType string, values []string) (*Person, error) {
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+	ctx, done := s.begin(ctx, "find_identifier")
+	defer done()
+	ctx = onReplica(ctx)
+
+	args := make([]interface{}, 0, len(values)+1)
+	for _, v := range values {
+		args = append(args, v)
+	}
+	where := `value IN (` + strings.TrimSuffix(strings.Repeat("?,", len(values)), ",") + `)`
+	if idType != "" {
+		where += ` AND id_type = ?`
+		args = append(args, idType)
+	}
+
+	query := `SELECT ` + personColumns + ` FROM people WHERE national_id IN (SELECT national_id FROM person_identifiers WHERE ` +
+		where + `)` + notDeleted + ` ORDER BY national_id LIMIT 1`
+	return s.scanPersonWithCourses(ctx, s.queryRow(ctx, query, args...))
+}
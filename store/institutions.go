@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+const institutionColumns = `id, name, hosts, logo_url, primary_color, accent_color, footer_text, created_at`
+
+func scanInstitution(row scanner) (*Institution, error) {
+	i := &Institution{}
+	var hosts string
+	if err := row.Scan(&i.ID, &i.Name, &hosts, &i.LogoURL, &i.PrimaryColor, &i.AccentColor, &i.FooterText, &i.CreatedAt); err != nil {
+		return nil, err
+	}
+	if hosts != "" {
+		i.Hosts = strings.Split(hosts, ",")
+	}
+	return i, nil
+}
+
+// ListInstitutions returns every institution, by ID
+func (s *SQLStore) ListInstitutions(ctx context.Context) ([]Institution, error) {
+	ctx, done := s.begin(ctx, "list_institutions")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT `+institutionColumns+` FROM institutions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	institutions := []Institution{}
+	for rows.Next() {
+		i, err := scanInstitution(rows)
+		if err != nil {
+			return nil, err
+		}
+		institutions = append(institutions, *i)
+	}
+	return institutions, rows.Err()
+}
+
+// PutInstitution updates the institution with i.ID, or inserts it when
+// there is none, and returns the stored row
+func (s *SQLStore) PutInstitution(ctx context.Context, i *Institution) (*Institution, error) {
+	ctx, done := s.begin(ctx, "put_institution")
+	defer done()
+
+	hosts := strings.Join(i.Hosts, ",")
+	res, err := s.exec(ctx, `UPDATE institutions SET name = ?, hosts = ?, logo_url = ?, primary_color = ?, accent_color = ?, footer_text = ? WHERE id = ?`,
+		i.Name, hosts, i.LogoURL, i.PrimaryColor, i.AccentColor, i.FooterText, i.ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		// MySQL counts only changed rows, so an unchanged institution
+		// lands here too and its insert is a duplicate
+		_, err := s.exec(ctx, `INSERT INTO institutions (id, name, hosts, logo_url, primary_color, accent_color, footer_text) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			i.ID, i.Name, hosts, i.LogoURL, i.PrimaryColor, i.AccentColor, i.FooterText)
+		if err != nil && !errors.Is(err, ErrDuplicate) {
+			return nil, err
+		}
+	}
+	return scanInstitution(s.queryRow(ctx, `SELECT `+institutionColumns+` FROM institutions WHERE id = ?`, i.ID))
+}
+
+// DeleteInstitution removes an institution no person or active API key
+// belongs to, along with its message templates. Revoked keys are kept,
+// with no institution.
+func (s *SQLStore) DeleteInstitution(ctx context.Context, id string) error {
+	defer s.timeQuery("delete_institution")()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT (SELECT COUNT(*) FROM people WHERE institution_id = ?) +
+(SELECT COUNT(*) FROM users WHERE institution_id = ?) +
+(SELECT COUNT(*) FROM api_keys WHERE institution_id = ? AND revoked_at IS NULL)`), id, id, id).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrInUse
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE api_keys SET institution_id = NULL WHERE institution_id = ?`), id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM message_templates WHERE institution_id = ?`), id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM institutions WHERE id = ?`), id)
+	if err := updatedOne(res, err); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// MergePeople consolidates the duplicate record fromID into keepID in one
+// transaction. The kept person's own fields win; fromID's identifiers,
+// courses, remarks, attachments and history move over, and its photo too when keepID has none.
+// The history entries keep fromID in merged_from and the merged entry
+// records the fields the merge changed.
+func (s *SQLStore) MergePeople(ctx context.Context, keepID, fromID, fromType string) (*Person, error) {
+	ctx, done := s.begin(ctx, "merge_people")
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	keep, err := s.snapshot(ctx, tx, keepID)
+	if err != nil {
+		return nil, err
+	}
+	from, err := s.snapshot(ctx, tx, fromID)
+	if err != nil {
+		return nil, err
+	}
+	if keep == nil || from == nil || !keep.DeletedAt.IsZero() || !from.DeletedAt.IsZero() || keep.NationalID == from.NationalID {
+		return nil, ErrNotFound
+	}
+	exec := func(query string, args ...interface{}) error {
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(query), args...)
+		return s.mapError(err)
+	}
+
+	if err := exec(`UPDATE person_identifiers SET national_id = ? WHERE national_id = ?`, keep.NationalID, from.NationalID); err != nil {
+		return nil, err
+	}
+	if fromType != "" {
+		value := strings.ToUpper(from.NationalID)
+		var n int
+		if err := tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT COUNT(*) FROM person_identifiers WHERE id_type = ? AND value = ?`),
+			fromType, value).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			if err := exec(`INSERT INTO person_identifiers (id_type, value, national_id) VALUES (?, ?, ?)`, fromType, value, keep.NationalID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := exec(`INSERT INTO person_courses (national_id, course_id)
+SELECT ?, course_id FROM person_courses WHERE national_id = ?
+AND course_id NOT IN (SELECT course_id FROM person_courses WHERE national_id = ?)`, keep.NationalID, from.NationalID, keep.NationalID); err != nil {
+		return nil, err
+	}
+	if !keep.HasPhoto && from.HasPhoto {
+		if err := exec(`UPDATE person_photos SET national_id = ? WHERE national_id = ?`, keep.NationalID, from.NationalID); err != nil {
+			return nil, err
+		}
+	}
+	if err := exec(`UPDATE person_attachments SET national_id = ? WHERE national_id = ?`, keep.NationalID, from.NationalID); err != nil {
+		return nil, err
+	}
+	if err := s.moveRemarks(ctx, tx, from.NationalID, keep.NationalID); err != nil {
+		return nil, err
+	}
+	if err := s.moveHistory(ctx, tx, from.NationalID, keep.NationalID); err != nil {
+		return nil, err
+	}
+	if err := exec(`DELETE FROM people WHERE national_id = ?`, from.NationalID); err != nil {
+		return nil, err
+	}
+
+	after, err := s.snapshot(ctx, tx, keep.NationalID)
+	if err != nil {
+		return nil, err
+	}
+	oldValues, newValues := diffFields(keep, after)
+	newValues["merged_from"] = from.NationalID
+	if err := s.recordHistory(ctx, tx, keep.NationalID, HistoryMerged, oldValues, newValues); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return after, nil
+}
+
+// moveHistory re-files the history of fromID under keepID. The values are
+// sealed to the ID they are stored under, so each is re-encrypted.
+func (s *SQLStore) moveHistory(ctx context.Context, tx *sql.Tx, fromID, keepID string) error {
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`SELECT id, COALESCE(old_values, ''), new_values, COALESCE(merged_from, '') FROM person_history
+WHERE national_id = ? ORDER BY id`), fromID)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		id                               int64
+		oldValues, newValues, mergedFrom string
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.oldValues, &e.newValues, &e.mergedFrom); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		var values [2]interface{}
+		for i, col := range []struct{ name, value string }{{"old_values", e.oldValues}, {"new_values", e.newValues}} {
+			if col.value == "" {
+				continue
+			}
+			opened, err := s.openHistory(fromID, col.name, col.value)
+			if err != nil {
+				return err
+			}
+			if values[i], err = s.sealHistory(keepID, col.name, opened); err != nil {
+				return err
+			}
+		}
+		mergedFrom := e.mergedFrom
+		if mergedFrom == "" {
+			mergedFrom = fromID
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE person_history SET national_id = ?, old_values = ?, new_values = ?, merged_from = ? WHERE id = ?`),
+			keepID, values[0], values[1], mergedFrom, e.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
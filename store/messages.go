@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// ListMessageTemplates returns every stored template, ordered by
+// institution, channel, language and key
+func (s *SQLStore) ListMessageTemplates(ctx context.Context) ([]MessageTemplate, error) {
+	ctx, done := s.begin(ctx, "list_message_templates")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT institution_id, channel, lang, msg_key, body, updated_by, updated_at FROM message_templates
+ORDER BY institution_id, channel, lang, msg_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []MessageTemplate{}
+	for rows.Next() {
+		var t MessageTemplate
+		if err := rows.Scan(&t.InstitutionID, &t.Channel, &t.Lang, &t.Key, &t.Text, &t.UpdatedBy, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// SetMessageTemplate replaces the template for t's institution, channel,
+// language and key. Like SetSettings it deletes and re-inserts rather than upserting.
+func (s *SQLStore) SetMessageTemplate(ctx context.Context, t *MessageTemplate) error {
+	defer s.timeQuery("set_message_template")()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if t.UpdatedAt.IsZero() {
+		t.UpdatedAt = time.Now().UTC()
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM message_templates WHERE institution_id = ? AND channel = ? AND lang = ? AND msg_key = ?`),
+		t.InstitutionID, t.Channel, t.Lang, t.Key); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO message_templates (institution_id, channel, lang, msg_key, body, updated_by, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		t.InstitutionID, t.Channel, t.Lang, t.Key, t.Text, t.UpdatedBy, t.UpdatedAt); err != nil {
+		return s.mapError(err)
+	}
+	return tx.Commit()
+}
+
+// DeleteMessageTemplate removes a template, so the built-in message applies
+// again
+func (s *SQLStore) DeleteMessageTemplate(ctx context.Context, institutionID, channel, lang, key string) error {
+	ctx, done := s.begin(ctx, "delete_message_template")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM message_templates WHERE institution_id = ? AND channel = ? AND lang = ? AND msg_key = ?`, institutionID, channel, lang, key)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema history for every driver, in
+// migrations/<driver>/NNNN_name.sql. Versions are shared across drivers so
+// the same change has the same number everywhere.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one embedded schema change
+type Migration struct {
+	Version string
+	Name    string
+	Applied bool
+}
+
+// migrationsDir returns the embedded directory for this store's driver
+func (s *SQLStore) migrationsDir() string {
+	return "migrations/" + s.dialect.migrations
+}
+
+// Migrations lists the embedded migrations and whether each has been applied
+func (s *SQLStore) Migrations(ctx context.Context) ([]Migration, error) {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := fs.ReadDir(migrationFiles, s.migrationsDir())
+	if err != nil {
+		return nil, err
+	}
+	var list []Migration
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		version, _, _ := strings.Cut(name, "_")
+		list = append(list, Migration{Version: version, Name: name, Applied: applied[version]})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Migrate applies every pending migration in version order and returns the
+// names of the ones it ran
+func (s *SQLStore) Migrate(ctx context.Context) ([]string, error) {
+	list, err := s.Migrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []string
+	for _, m := range list {
+		if m.Applied {
+			continue
+		}
+		body, err := migrationFiles.ReadFile(path.Join(s.migrationsDir(), m.Name+".sql"))
+		if err != nil {
+			return ran, err
+		}
+		for _, stmt := range splitStatements(string(body)) {
+			if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+				return ran, fmt.Errorf("migration %s: %w", m.Name, err)
+			}
+		}
+		if _, err := s.exec(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+			m.Version, m.Name, time.Now().UTC()); err != nil {
+			return ran, fmt.Errorf("record migration %s: %w", m.Name, err)
+		}
+		ran = append(ran, m.Name)
+	}
+	return ran, nil
+}
+
+func (s *SQLStore) ensureMigrationsTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version VARCHAR(20) NOT NULL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`)
+	return err
+}
+
+func (s *SQLStore) appliedMigrations(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// splitStatements splits a migration file on semicolons that end a line,
+// dropping comment-only and empty statements. Drivers differ in whether
+// they accept several statements per Exec, so each runs on its own.
+func splitStatements(body string) []string {
+	var stmts []string
+	var cur strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			if stmt := strings.TrimSpace(cur.String()); stmt != ";" {
+				stmts = append(stmts, strings.TrimSuffix(stmt, ";"))
+			}
+			cur.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
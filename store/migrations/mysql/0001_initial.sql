@@ -1,4 +1,6 @@
-CREATE TABLE errors (
+-- Original errors and people tables. IF NOT EXISTS lets this run against
+-- databases created before migrations existed.
+CREATE TABLE IF NOT EXISTS errors (
     id BIGINT NOT NULL AUTO_INCREMENT,
     timestamp DATETIME NOT NULL,
     error_type VARCHAR(50) NOT NULL,
@@ -8,9 +10,9 @@ CREATE TABLE errors (
     INDEX idx_error_type (error_type)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
-CREATE TABLE people (
+CREATE TABLE IF NOT EXISTS people (
     national_id VARCHAR(50) PRIMARY KEY,
     full_name VARCHAR(100) NOT NULL,
     category ENUM('student', 'staff') NOT NULL,
     remark LONGTEXT
-);ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,4 @@
+-- created_at is used by the export date filters
+ALTER TABLE people
+    ADD COLUMN created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD INDEX idx_created_at (created_at);
@@ -0,0 +1,15 @@
+-- One row per Twilio webhook interaction
+CREATE TABLE IF NOT EXISTS calls (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    call_sid VARCHAR(64) NOT NULL,
+    from_number VARCHAR(32),
+    endpoint VARCHAR(50) NOT NULL,
+    input VARCHAR(50),
+    result VARCHAR(20) NOT NULL,
+    matched_id VARCHAR(50),
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_call_sid (call_sid),
+    INDEX idx_from_number (from_number),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,39 @@
+-- Courses and the people who completed them, replacing the hardcoded list
+CREATE TABLE IF NOT EXISTS courses (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(200) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_courses_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS person_courses (
+    national_id VARCHAR(50) NOT NULL,
+    course_id BIGINT NOT NULL,
+    assigned_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (national_id, course_id),
+    INDEX idx_person_courses_course (course_id),
+    CONSTRAINT fk_person_courses_person FOREIGN KEY (national_id) REFERENCES people (national_id) ON DELETE CASCADE,
+    CONSTRAINT fk_person_courses_course FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- The workshops every student completed before assignments were tracked;
+-- existing students keep them
+INSERT INTO courses (name) VALUES
+    ('Introduction to Basic Psychology (One Hour Workshop)'),
+    ('Introduction to Career Guidance (One Hour Workshop)'),
+    ('Introduction to Basic Counselling (One Hour Workshop)'),
+    ('Introduction to Basic IT (One Hour Workshop)'),
+    ('Introduction to Basic Business Management (One Hour Workshop)'),
+    ('Introduction to Basic Spoken English (One Hour Workshop)'),
+    ('Introduction to Memory Boosting (One Hour Workshop)'),
+    ('Introduction to Basic Personality Development (One Hour Workshop)'),
+    ('Introduction to Entrepreneurship (One Hour Workshop)'),
+    ('Introduction to Basic Body Language (One Hour Workshop)'),
+    ('Introduction to Basic Counselling Skills (One Hour Workshop)'),
+    ('Introduction to Basic Human Resource Management (One Hour Workshop)'),
+    ('Introduction to Basic Teaching Methodologies (One Hour Workshop)'),
+    ('Introduction to Basic Marketing Management (One Hour Workshop)');
+
+INSERT INTO person_courses (national_id, course_id)
+SELECT p.national_id, c.id FROM people p CROSS JOIN courses c WHERE p.category = 'student';
@@ -0,0 +1,5 @@
+-- Validity period of a credential; NULL expiry_date means it never expires
+ALTER TABLE people
+    ADD COLUMN issue_date DATE NULL,
+    ADD COLUMN expiry_date DATE NULL,
+    ADD INDEX idx_expiry_date (expiry_date);
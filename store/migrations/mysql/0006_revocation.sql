@@ -0,0 +1,5 @@
+-- Withdrawn credentials; revoked people are still found but never verified
+ALTER TABLE people
+    ADD COLUMN revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN revocation_reason TEXT NULL,
+    ADD COLUMN revoked_at DATETIME NULL;
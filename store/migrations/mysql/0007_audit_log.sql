@@ -0,0 +1,14 @@
+-- One row per verification attempt on any channel, for compliance queries
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    channel VARCHAR(16) NOT NULL,
+    source VARCHAR(64) NOT NULL,
+    input VARCHAR(100),
+    outcome VARCHAR(20) NOT NULL,
+    matched_id VARCHAR(50),
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_audit_source (source),
+    INDEX idx_audit_matched_id (matched_id),
+    INDEX idx_audit_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,12 @@
+-- Further identifiers a person can be verified by (passport, student
+-- number, ...); people.national_id stays the record key
+CREATE TABLE IF NOT EXISTS person_identifiers (
+    id_type VARCHAR(20) NOT NULL,
+    value VARCHAR(50) NOT NULL,
+    national_id VARCHAR(50) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id_type, value),
+    INDEX idx_person_identifiers_person (national_id),
+    INDEX idx_person_identifiers_value (value),
+    CONSTRAINT fk_person_identifiers_person FOREIGN KEY (national_id) REFERENCES people (national_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,7 @@
+-- Runtime settings edited through the admin API (branding, ...)
+CREATE TABLE IF NOT EXISTS settings (
+    name VARCHAR(100) NOT NULL,
+    value TEXT NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,9 @@
+-- One photograph per person, shown on the verification page
+CREATE TABLE IF NOT EXISTS person_photos (
+    national_id VARCHAR(50) NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    data MEDIUMBLOB NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (national_id),
+    CONSTRAINT fk_person_photos_person FOREIGN KEY (national_id) REFERENCES people (national_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
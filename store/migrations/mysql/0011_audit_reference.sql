@@ -0,0 +1,6 @@
+-- Successful checks get a reference verifiers can cite later, with the
+-- credential state (valid, expired, revoked) at the time of the check
+ALTER TABLE audit_log
+    ADD COLUMN reference CHAR(26) NULL,
+    ADD COLUMN state VARCHAR(10) NULL,
+    ADD UNIQUE INDEX idx_audit_reference (reference);
@@ -0,0 +1,10 @@
+-- Logged events carry a severity (DEBUG, INFO, WARN, ERROR) and a category,
+-- the lower-cased error_type prefix. Existing rows are classified by the
+-- error_type naming convention.
+ALTER TABLE errors
+    ADD COLUMN severity VARCHAR(5) NOT NULL DEFAULT 'WARN',
+    ADD COLUMN category VARCHAR(30) NOT NULL DEFAULT '',
+    ADD INDEX idx_severity (severity);
+UPDATE errors SET severity = 'INFO' WHERE error_type LIKE '%SUCCESS';
+UPDATE errors SET severity = 'ERROR' WHERE error_type LIKE '%ERROR' OR error_type LIKE '%FAILED';
+UPDATE errors SET category = LOWER(SUBSTRING_INDEX(error_type, '_', 1));
@@ -0,0 +1,8 @@
+-- The ID of the HTTP request that logged an event or made a check, to
+-- correlate rows with log lines and the X-Request-ID response header
+ALTER TABLE errors
+    ADD COLUMN request_id VARCHAR(128) NULL,
+    ADD INDEX idx_errors_request_id (request_id);
+ALTER TABLE audit_log
+    ADD COLUMN request_id VARCHAR(128) NULL,
+    ADD INDEX idx_audit_request_id (request_id);
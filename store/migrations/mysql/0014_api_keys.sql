@@ -0,0 +1,22 @@
+-- Keys for partner access to the JSON API. Only a SHA-256 hash of each key
+-- is stored; prefix is its first characters, to tell keys apart.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_api_keys_hash (key_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Requests made with each key per UTC day (YYYY-MM-DD)
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    key_id BIGINT NOT NULL,
+    day CHAR(10) NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (key_id, day),
+    CONSTRAINT fk_api_key_usage_key FOREIGN KEY (key_id) REFERENCES api_keys (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
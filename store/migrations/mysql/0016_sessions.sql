@@ -0,0 +1,14 @@
+-- Password logins for the admin dashboard: a bcrypt hash per user (NULL
+-- until one is set) and one row per logged-in browser, keyed by the
+-- SHA-256 of the session cookie
+ALTER TABLE users ADD COLUMN password_hash VARCHAR(100) NULL;
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id_hash CHAR(64) NOT NULL,
+    user_id BIGINT NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_seen_at DATETIME NOT NULL,
+    PRIMARY KEY (id_hash),
+    INDEX idx_sessions_user (user_id),
+    CONSTRAINT fk_sessions_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
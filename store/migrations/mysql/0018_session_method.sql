@@ -0,0 +1,4 @@
+-- Single sign-on: how each session was started, "password" for the login
+-- form or "oidc" for the identity provider, which handles two-factor login
+-- itself
+ALTER TABLE sessions ADD COLUMN method VARCHAR(10) NOT NULL DEFAULT 'password';
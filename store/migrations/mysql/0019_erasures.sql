@@ -0,0 +1,18 @@
+-- Tombstones of data subject erasures: who erased whose data and why, and
+-- how many rows were removed or anonymised. The subject is only kept as a
+-- hash of their national ID, so a later request can be matched to it.
+CREATE TABLE IF NOT EXISTS erasures (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    reference CHAR(26) NOT NULL,
+    subject_hash CHAR(64) NOT NULL,
+    requested_by VARCHAR(100) NOT NULL,
+    reason TEXT NOT NULL,
+    people INT NOT NULL,
+    audit_entries INT NOT NULL,
+    calls INT NOT NULL,
+    events INT NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_erasures_reference (reference),
+    INDEX idx_erasures_subject (subject_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+-- full_name and remark can hold AES-GCM ciphertext, which is longer than
+-- the plaintext: a 100 character name no longer fits VARCHAR(100)
+ALTER TABLE people MODIFY full_name TEXT NOT NULL;
@@ -0,0 +1,39 @@
+-- Endpoints notified of verification events. The secret signs each
+-- delivery, so unlike API keys it is stored as issued. events is a
+-- comma-separated list of event types; empty means every type.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    url VARCHAR(2000) NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    events VARCHAR(500) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_webhooks_secret (secret)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- One event to deliver to one webhook, and how delivery went. Pending rows
+-- are retried at next_attempt_at; subject_id is the national ID the event
+-- is about, so erasing the subject also deletes their deliveries.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    webhook_id BIGINT NOT NULL,
+    event_id CHAR(26) NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    subject_id VARCHAR(50) NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_status_code INT NULL,
+    last_error VARCHAR(500) NULL,
+    created_at DATETIME NOT NULL,
+    delivered_at DATETIME NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_webhook_deliveries_event (webhook_id, event_id),
+    INDEX idx_webhook_deliveries_due (status, next_attempt_at),
+    INDEX idx_webhook_deliveries_subject (subject_id),
+    CONSTRAINT fk_webhook_deliveries_webhook FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Webhook deliveries removed by each erasure
+ALTER TABLE erasures ADD COLUMN deliveries INT NOT NULL DEFAULT 0;
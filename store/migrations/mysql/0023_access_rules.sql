@@ -0,0 +1,16 @@
+-- Callers and IP addresses blocked from, or allowed through, the public
+-- endpoints. value is a caller's hash (as in calls.from) or an IP address
+-- or CIDR range; created_by is the admin who added the rule, and a NULL
+-- expires_at means the rule lasts until it is deleted.
+CREATE TABLE IF NOT EXISTS access_rules (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    action VARCHAR(8) NOT NULL,
+    kind VARCHAR(8) NOT NULL,
+    value VARCHAR(64) NOT NULL,
+    reason VARCHAR(500) NOT NULL DEFAULT '',
+    created_by VARCHAR(100) NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_access_rules_value (action, kind, value)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
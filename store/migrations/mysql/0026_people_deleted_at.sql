@@ -0,0 +1,5 @@
+-- Deleted people are kept, with who deleted them and when, so an admin can
+-- restore them; every lookup skips them
+ALTER TABLE people
+    ADD COLUMN deleted_at DATETIME NULL,
+    ADD COLUMN deleted_by VARCHAR(100) NULL;
@@ -0,0 +1,15 @@
+-- Every change to a person: the fields it changed, as JSON objects of their
+-- values before and after (NULL old_values when the person was created),
+-- who made it and when. Rows go with the person when they are erased.
+CREATE TABLE IF NOT EXISTS person_history (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    national_id VARCHAR(50) NOT NULL,
+    action VARCHAR(30) NOT NULL,
+    old_values TEXT NULL,
+    new_values TEXT NOT NULL,
+    actor VARCHAR(100) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_person_history_person (national_id, created_at),
+    CONSTRAINT fk_person_history_person FOREIGN KEY (national_id) REFERENCES people (national_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
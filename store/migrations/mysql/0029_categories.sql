@@ -0,0 +1,15 @@
+-- People's categories: the label shown and spoken for each, the HTML page
+-- template results are rendered with, and whether results list completed
+-- courses instead of the remark. people.category names one of them.
+CREATE TABLE IF NOT EXISTS categories (
+    name VARCHAR(30) NOT NULL PRIMARY KEY,
+    label VARCHAR(100) NOT NULL,
+    spoken_labels TEXT NOT NULL,
+    template VARCHAR(100) NOT NULL,
+    show_courses BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+INSERT INTO categories (name, label, spoken_labels, template, show_courses) VALUES
+    ('student', 'Student', '{"en":"student","si":"ශිෂ්‍යයා","ta":"மாணவர்"}', 'student.html', TRUE),
+    ('staff', 'Staff', '{"en":"staff member","si":"කාර්ය මණ්ඩල සාමාජිකයා","ta":"ஊழியர்"}', 'staff.html', FALSE);
+ALTER TABLE people MODIFY category VARCHAR(30) NOT NULL;
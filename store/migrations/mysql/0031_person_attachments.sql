@@ -0,0 +1,18 @@
+-- Supporting documents attached to a person. The file itself is kept in
+-- the attachment storage (a directory or S3 bucket) under storage_key;
+-- rows go with the person when they are erased.
+CREATE TABLE IF NOT EXISTS person_attachments (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    national_id VARCHAR(50) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    uploaded_by VARCHAR(100),
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_person_attachments_key (storage_key),
+    INDEX idx_person_attachments_person (national_id),
+    CONSTRAINT fk_person_attachments_person FOREIGN KEY (national_id) REFERENCES people (national_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
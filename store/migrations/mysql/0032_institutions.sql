@@ -0,0 +1,23 @@
+-- Affiliated institutions served by one deployment. hosts lists, comma
+-- separated, the host names whose pages and lookups belong to the
+-- institution; the other columns override the BRAND_* branding. People and
+-- API keys without an institution_id belong to the deployment itself, and
+-- message templates with an empty one apply to every institution.
+CREATE TABLE IF NOT EXISTS institutions (
+    id VARCHAR(50) NOT NULL,
+    name VARCHAR(200) NOT NULL,
+    hosts VARCHAR(1000) NOT NULL DEFAULT '',
+    logo_url VARCHAR(1000) NOT NULL DEFAULT '',
+    primary_color VARCHAR(7) NOT NULL DEFAULT '',
+    accent_color VARCHAR(7) NOT NULL DEFAULT '',
+    footer_text VARCHAR(1000) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+ALTER TABLE people ADD COLUMN institution_id VARCHAR(50),
+    ADD INDEX idx_people_institution (institution_id),
+    ADD CONSTRAINT fk_people_institution FOREIGN KEY (institution_id) REFERENCES institutions (id);
+ALTER TABLE api_keys ADD COLUMN institution_id VARCHAR(50),
+    ADD CONSTRAINT fk_api_keys_institution FOREIGN KEY (institution_id) REFERENCES institutions (id);
+ALTER TABLE message_templates ADD COLUMN institution_id VARCHAR(50) NOT NULL DEFAULT '' FIRST,
+    DROP PRIMARY KEY, ADD PRIMARY KEY (institution_id, channel, lang, msg_key);
@@ -0,0 +1,4 @@
+-- Staff users of an institution find and change only its people, like its
+-- API keys; users without an institution_id work for the deployment itself.
+ALTER TABLE users ADD COLUMN institution_id VARCHAR(50),
+    ADD CONSTRAINT fk_users_institution FOREIGN KEY (institution_id) REFERENCES institutions (id);
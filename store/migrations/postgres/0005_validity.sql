@@ -0,0 +1,4 @@
+-- Validity period of a credential; NULL expiry_date means it never expires
+ALTER TABLE people ADD COLUMN IF NOT EXISTS issue_date DATE;
+ALTER TABLE people ADD COLUMN IF NOT EXISTS expiry_date DATE;
+CREATE INDEX IF NOT EXISTS idx_people_expiry_date ON people (expiry_date);
@@ -0,0 +1,4 @@
+-- Withdrawn credentials; revoked people are still found but never verified
+ALTER TABLE people ADD COLUMN IF NOT EXISTS revoked BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE people ADD COLUMN IF NOT EXISTS revocation_reason TEXT;
+ALTER TABLE people ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP;
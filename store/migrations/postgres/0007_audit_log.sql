@@ -0,0 +1,13 @@
+-- One row per verification attempt on any channel, for compliance queries
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    channel VARCHAR(16) NOT NULL,
+    source VARCHAR(64) NOT NULL,
+    input VARCHAR(100),
+    outcome VARCHAR(20) NOT NULL,
+    matched_id VARCHAR(50),
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_source ON audit_log (source);
+CREATE INDEX IF NOT EXISTS idx_audit_matched_id ON audit_log (matched_id);
+CREATE INDEX IF NOT EXISTS idx_audit_created_at ON audit_log (created_at);
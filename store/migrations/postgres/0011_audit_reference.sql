@@ -0,0 +1,5 @@
+-- Successful checks get a reference verifiers can cite later, with the
+-- credential state (valid, expired, revoked) at the time of the check
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS reference CHAR(26);
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS state VARCHAR(10);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_reference ON audit_log (reference);
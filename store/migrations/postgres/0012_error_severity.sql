@@ -0,0 +1,9 @@
+-- Logged events carry a severity (DEBUG, INFO, WARN, ERROR) and a category,
+-- the lower-cased error_type prefix. Existing rows are classified by the
+-- error_type naming convention.
+ALTER TABLE errors ADD COLUMN IF NOT EXISTS severity VARCHAR(5) NOT NULL DEFAULT 'WARN';
+ALTER TABLE errors ADD COLUMN IF NOT EXISTS category VARCHAR(30) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_errors_severity ON errors (severity);
+UPDATE errors SET severity = 'INFO' WHERE error_type LIKE '%SUCCESS';
+UPDATE errors SET severity = 'ERROR' WHERE error_type LIKE '%ERROR' OR error_type LIKE '%FAILED';
+UPDATE errors SET category = LOWER(SPLIT_PART(error_type, '_', 1));
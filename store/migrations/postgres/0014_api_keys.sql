@@ -0,0 +1,19 @@
+-- Keys for partner access to the JSON API. Only a SHA-256 hash of each key
+-- is stored; prefix is its first characters, to tell keys apart.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+-- Requests made with each key per UTC day (YYYY-MM-DD)
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    key_id BIGINT NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    day CHAR(10) NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (key_id, day)
+);
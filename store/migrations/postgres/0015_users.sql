@@ -0,0 +1,9 @@
+-- Staff accounts for the admin API and dashboard. role is viewer,
+-- registrar or admin; token_hash is the SHA-256 of the user's bearer token.
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    username VARCHAR(100) NOT NULL UNIQUE,
+    role VARCHAR(20) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
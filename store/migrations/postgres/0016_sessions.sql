@@ -0,0 +1,12 @@
+-- Password logins for the admin dashboard: a bcrypt hash per user (NULL
+-- until one is set) and one row per logged-in browser, keyed by the
+-- SHA-256 of the session cookie
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash VARCHAR(100);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id_hash CHAR(64) PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);
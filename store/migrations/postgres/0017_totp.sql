@@ -0,0 +1,12 @@
+-- TOTP two-factor login: the base32 secret (pending until totp_enabled_at
+-- is set), the last time step used so a code cannot be replayed, and
+-- single-use recovery codes stored as SHA-256 hashes
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,16 @@
+-- Tombstones of data subject erasures: who erased whose data and why, and
+-- how many rows were removed or anonymised. The subject is only kept as a
+-- hash of their national ID, so a later request can be matched to it.
+CREATE TABLE IF NOT EXISTS erasures (
+    id BIGSERIAL PRIMARY KEY,
+    reference CHAR(26) NOT NULL UNIQUE,
+    subject_hash CHAR(64) NOT NULL,
+    requested_by VARCHAR(100) NOT NULL,
+    reason TEXT NOT NULL,
+    people INT NOT NULL,
+    audit_entries INT NOT NULL,
+    calls INT NOT NULL,
+    events INT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_erasures_subject ON erasures (subject_hash);
@@ -0,0 +1,3 @@
+-- People who opted out of the public channels (/verify, voice, SMS and
+-- WhatsApp) are only verified for API partners
+ALTER TABLE people ADD COLUMN IF NOT EXISTS public_lookup BOOLEAN NOT NULL DEFAULT TRUE;
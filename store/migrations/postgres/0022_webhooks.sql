@@ -0,0 +1,35 @@
+-- Endpoints notified of verification events. The secret signs each
+-- delivery, so unlike API keys it is stored as issued. events is a
+-- comma-separated list of event types; empty means every type.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id BIGSERIAL PRIMARY KEY,
+    url VARCHAR(2000) NOT NULL,
+    secret VARCHAR(64) NOT NULL UNIQUE,
+    events VARCHAR(500) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+-- One event to deliver to one webhook, and how delivery went. Pending rows
+-- are retried at next_attempt_at; subject_id is the national ID the event
+-- is about, so erasing the subject also deletes their deliveries.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id BIGINT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_id CHAR(26) NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    subject_id VARCHAR(50),
+    payload TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_status_code INT,
+    last_error VARCHAR(500),
+    created_at TIMESTAMP NOT NULL,
+    delivered_at TIMESTAMP,
+    UNIQUE (webhook_id, event_id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subject ON webhook_deliveries (subject_id);
+
+-- Webhook deliveries removed by each erasure
+ALTER TABLE erasures ADD COLUMN deliveries INT NOT NULL DEFAULT 0;
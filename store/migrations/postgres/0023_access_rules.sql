@@ -0,0 +1,15 @@
+-- Callers and IP addresses blocked from, or allowed through, the public
+-- endpoints. value is a caller's hash (as in calls.from) or an IP address
+-- or CIDR range; created_by is the admin who added the rule, and a NULL
+-- expires_at means the rule lasts until it is deleted.
+CREATE TABLE IF NOT EXISTS access_rules (
+    id BIGSERIAL PRIMARY KEY,
+    action VARCHAR(8) NOT NULL,
+    kind VARCHAR(8) NOT NULL,
+    value VARCHAR(64) NOT NULL,
+    reason VARCHAR(500) NOT NULL DEFAULT '',
+    created_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP,
+    UNIQUE (action, kind, value)
+);
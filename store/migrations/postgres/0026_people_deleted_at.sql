@@ -0,0 +1,4 @@
+-- Deleted people are kept, with who deleted them and when, so an admin can
+-- restore them; every lookup skips them
+ALTER TABLE people ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE people ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(100);
@@ -0,0 +1,13 @@
+-- Every change to a person: the fields it changed, as JSON objects of their
+-- values before and after (NULL old_values when the person was created),
+-- who made it and when. Rows go with the person when they are erased.
+CREATE TABLE IF NOT EXISTS person_history (
+    id BIGSERIAL PRIMARY KEY,
+    national_id VARCHAR(50) NOT NULL REFERENCES people (national_id) ON DELETE CASCADE,
+    action VARCHAR(30) NOT NULL,
+    old_values TEXT,
+    new_values TEXT NOT NULL,
+    actor VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_person_history_person ON person_history (national_id, created_at);
@@ -0,0 +1,21 @@
+-- Affiliated institutions served by one deployment. hosts lists, comma
+-- separated, the host names whose pages and lookups belong to the
+-- institution; the other columns override the BRAND_* branding. People and
+-- API keys without an institution_id belong to the deployment itself, and
+-- message templates with an empty one apply to every institution.
+CREATE TABLE IF NOT EXISTS institutions (
+    id VARCHAR(50) NOT NULL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    hosts VARCHAR(1000) NOT NULL DEFAULT '',
+    logo_url VARCHAR(1000) NOT NULL DEFAULT '',
+    primary_color VARCHAR(7) NOT NULL DEFAULT '',
+    accent_color VARCHAR(7) NOT NULL DEFAULT '',
+    footer_text VARCHAR(1000) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+ALTER TABLE people ADD COLUMN IF NOT EXISTS institution_id VARCHAR(50) REFERENCES institutions (id);
+CREATE INDEX IF NOT EXISTS idx_people_institution ON people (institution_id);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS institution_id VARCHAR(50) REFERENCES institutions (id);
+ALTER TABLE message_templates ADD COLUMN IF NOT EXISTS institution_id VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE message_templates DROP CONSTRAINT message_templates_pkey;
+ALTER TABLE message_templates ADD PRIMARY KEY (institution_id, channel, lang, msg_key);
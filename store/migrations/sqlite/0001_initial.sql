@@ -0,0 +1,33 @@
+-- Full schema as of 0003 in the MySQL migrations; later migrations use
+-- the same version numbers across all drivers.
+CREATE TABLE IF NOT EXISTS errors (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp DATETIME NOT NULL,
+    error_type VARCHAR(50) NOT NULL,
+    remark TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_errors_timestamp ON errors (timestamp);
+CREATE INDEX IF NOT EXISTS idx_errors_error_type ON errors (error_type);
+
+CREATE TABLE IF NOT EXISTS people (
+    national_id VARCHAR(50) PRIMARY KEY COLLATE NOCASE,
+    full_name VARCHAR(100) NOT NULL,
+    category VARCHAR(20) NOT NULL CHECK (category IN ('student', 'staff')),
+    remark TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_people_created_at ON people (created_at);
+
+CREATE TABLE IF NOT EXISTS calls (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    call_sid VARCHAR(64) NOT NULL,
+    from_number VARCHAR(32),
+    endpoint VARCHAR(50) NOT NULL,
+    input VARCHAR(50),
+    result VARCHAR(20) NOT NULL,
+    matched_id VARCHAR(50),
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_calls_call_sid ON calls (call_sid);
+CREATE INDEX IF NOT EXISTS idx_calls_from_number ON calls (from_number);
+CREATE INDEX IF NOT EXISTS idx_calls_created_at ON calls (created_at);
@@ -0,0 +1,35 @@
+-- Courses and the people who completed them, replacing the hardcoded list
+CREATE TABLE IF NOT EXISTS courses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(200) NOT NULL UNIQUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS person_courses (
+    national_id VARCHAR(50) NOT NULL COLLATE NOCASE REFERENCES people (national_id) ON DELETE CASCADE,
+    course_id INTEGER NOT NULL REFERENCES courses (id) ON DELETE CASCADE,
+    assigned_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (national_id, course_id)
+);
+CREATE INDEX IF NOT EXISTS idx_person_courses_course ON person_courses (course_id);
+
+-- The workshops every student completed before assignments were tracked;
+-- existing students keep them
+INSERT INTO courses (name) VALUES
+    ('Introduction to Basic Psychology (One Hour Workshop)'),
+    ('Introduction to Career Guidance (One Hour Workshop)'),
+    ('Introduction to Basic Counselling (One Hour Workshop)'),
+    ('Introduction to Basic IT (One Hour Workshop)'),
+    ('Introduction to Basic Business Management (One Hour Workshop)'),
+    ('Introduction to Basic Spoken English (One Hour Workshop)'),
+    ('Introduction to Memory Boosting (One Hour Workshop)'),
+    ('Introduction to Basic Personality Development (One Hour Workshop)'),
+    ('Introduction to Entrepreneurship (One Hour Workshop)'),
+    ('Introduction to Basic Body Language (One Hour Workshop)'),
+    ('Introduction to Basic Counselling Skills (One Hour Workshop)'),
+    ('Introduction to Basic Human Resource Management (One Hour Workshop)'),
+    ('Introduction to Basic Teaching Methodologies (One Hour Workshop)'),
+    ('Introduction to Basic Marketing Management (One Hour Workshop)');
+
+INSERT INTO person_courses (national_id, course_id)
+SELECT p.national_id, c.id FROM people p CROSS JOIN courses c WHERE p.category = 'student';
@@ -0,0 +1,4 @@
+-- Validity period of a credential; NULL expiry_date means it never expires
+ALTER TABLE people ADD COLUMN issue_date DATE;
+ALTER TABLE people ADD COLUMN expiry_date DATE;
+CREATE INDEX IF NOT EXISTS idx_people_expiry_date ON people (expiry_date);
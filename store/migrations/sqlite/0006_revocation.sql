@@ -0,0 +1,4 @@
+-- Withdrawn credentials; revoked people are still found but never verified
+ALTER TABLE people ADD COLUMN revoked BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE people ADD COLUMN revocation_reason TEXT;
+ALTER TABLE people ADD COLUMN revoked_at DATETIME;
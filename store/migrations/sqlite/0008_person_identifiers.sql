@@ -0,0 +1,11 @@
+-- Further identifiers a person can be verified by (passport, student
+-- number, ...); people.national_id stays the record key
+CREATE TABLE IF NOT EXISTS person_identifiers (
+    id_type VARCHAR(20) NOT NULL,
+    value VARCHAR(50) NOT NULL COLLATE NOCASE,
+    national_id VARCHAR(50) NOT NULL COLLATE NOCASE REFERENCES people (national_id) ON DELETE CASCADE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id_type, value)
+);
+CREATE INDEX IF NOT EXISTS idx_person_identifiers_person ON person_identifiers (national_id);
+CREATE INDEX IF NOT EXISTS idx_person_identifiers_value ON person_identifiers (value);
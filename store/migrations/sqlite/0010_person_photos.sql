@@ -0,0 +1,7 @@
+-- One photograph per person, shown on the verification page
+CREATE TABLE IF NOT EXISTS person_photos (
+    national_id VARCHAR(50) NOT NULL COLLATE NOCASE PRIMARY KEY REFERENCES people (national_id) ON DELETE CASCADE,
+    content_type VARCHAR(50) NOT NULL,
+    data BLOB NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,6 @@
+-- The ID of the HTTP request that logged an event or made a check, to
+-- correlate rows with log lines and the X-Request-ID response header
+ALTER TABLE errors ADD COLUMN request_id VARCHAR(128);
+CREATE INDEX IF NOT EXISTS idx_errors_request_id ON errors (request_id);
+ALTER TABLE audit_log ADD COLUMN request_id VARCHAR(128);
+CREATE INDEX IF NOT EXISTS idx_audit_request_id ON audit_log (request_id);
@@ -0,0 +1,16 @@
+-- Tombstones of data subject erasures: who erased whose data and why, and
+-- how many rows were removed or anonymised. The subject is only kept as a
+-- hash of their national ID, so a later request can be matched to it.
+CREATE TABLE IF NOT EXISTS erasures (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reference CHAR(26) NOT NULL UNIQUE,
+    subject_hash CHAR(64) NOT NULL,
+    requested_by VARCHAR(100) NOT NULL,
+    reason TEXT NOT NULL,
+    people INTEGER NOT NULL,
+    audit_entries INTEGER NOT NULL,
+    calls INTEGER NOT NULL,
+    events INTEGER NOT NULL,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_erasures_subject ON erasures (subject_hash);
@@ -0,0 +1,3 @@
+-- full_name and remark can hold AES-GCM ciphertext, which is longer than
+-- the plaintext. SQLite does not enforce VARCHAR lengths, so full_name
+-- needs no change; this keeps versions in step with the other drivers.
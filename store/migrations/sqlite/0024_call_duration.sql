@@ -0,0 +1,3 @@
+-- Length in seconds of a finished voice call, from Twilio's status
+-- callback; NULL on webhook rows and on calls that never connected
+ALTER TABLE calls ADD COLUMN duration INTEGER;
@@ -0,0 +1,12 @@
+-- Wording that replaces a built-in message: channel is voice, web or text,
+-- lang a language code and msg_key the message's key in the built-in
+-- catalog; updated_by is the admin who last changed it.
+CREATE TABLE IF NOT EXISTS message_templates (
+    channel VARCHAR(16) NOT NULL,
+    lang VARCHAR(8) NOT NULL,
+    msg_key VARCHAR(100) NOT NULL,
+    body TEXT NOT NULL,
+    updated_by VARCHAR(100) NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (channel, lang, msg_key)
+);
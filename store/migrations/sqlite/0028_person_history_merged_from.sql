@@ -0,0 +1,3 @@
+-- History moved to a person from a record merged into them keeps the ID it
+-- was recorded under
+ALTER TABLE person_history ADD COLUMN merged_from VARCHAR(50);
@@ -0,0 +1,40 @@
+-- People's categories: the label shown and spoken for each, the HTML page
+-- template results are rendered with, and whether results list completed
+-- courses instead of the remark. people.category names one of them.
+CREATE TABLE IF NOT EXISTS categories (
+    name VARCHAR(30) NOT NULL PRIMARY KEY,
+    label VARCHAR(100) NOT NULL,
+    spoken_labels TEXT NOT NULL,
+    template VARCHAR(100) NOT NULL,
+    show_courses BOOLEAN NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO categories (name, label, spoken_labels, template, show_courses) VALUES
+    ('student', 'Student', '{"en":"student","si":"ශිෂ්‍යයා","ta":"மாணவர்"}', 'student.html', 1),
+    ('staff', 'Staff', '{"en":"staff member","si":"කාර්ය මණ්ඩල සාමාජිකයා","ta":"ஊழியர்"}', 'staff.html', 0);
+-- SQLite cannot drop the CHECK on people.category, so the table is rebuilt
+-- without it. Foreign keys are off meanwhile, so dropping the old table
+-- leaves the rows that reference it alone.
+PRAGMA foreign_keys = OFF;
+CREATE TABLE people_new (
+    national_id VARCHAR(50) PRIMARY KEY COLLATE NOCASE,
+    full_name VARCHAR(100) NOT NULL,
+    category VARCHAR(30) NOT NULL,
+    remark TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    issue_date DATE,
+    expiry_date DATE,
+    revoked BOOLEAN NOT NULL DEFAULT 0,
+    revocation_reason TEXT,
+    revoked_at DATETIME,
+    public_lookup BOOLEAN NOT NULL DEFAULT 1,
+    deleted_at DATETIME,
+    deleted_by VARCHAR(100)
+);
+INSERT INTO people_new (national_id, full_name, category, remark, created_at, issue_date, expiry_date, revoked, revocation_reason, revoked_at, public_lookup, deleted_at, deleted_by)
+SELECT national_id, full_name, category, remark, created_at, issue_date, expiry_date, revoked, revocation_reason, revoked_at, public_lookup, deleted_at, deleted_by FROM people;
+DROP TABLE people;
+ALTER TABLE people_new RENAME TO people;
+CREATE INDEX IF NOT EXISTS idx_people_created_at ON people (created_at);
+CREATE INDEX IF NOT EXISTS idx_people_expiry_date ON people (expiry_date);
+PRAGMA foreign_keys = ON;
@@ -0,0 +1,16 @@
+-- Remarks on a person, several per person instead of people.remark: a
+-- type, the text (trusted HTML, encrypted like the column it replaces) and
+-- the channels that may show it. Existing remarks become public general
+-- remarks. Rows go with the person when they are erased.
+CREATE TABLE IF NOT EXISTS person_remarks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    national_id VARCHAR(50) NOT NULL COLLATE NOCASE REFERENCES people (national_id) ON DELETE CASCADE,
+    remark_type VARCHAR(30) NOT NULL,
+    text TEXT NOT NULL,
+    visibility VARCHAR(10) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_person_remarks_person ON person_remarks (national_id);
+INSERT INTO person_remarks (national_id, remark_type, text, visibility, created_at)
+    SELECT national_id, 'general', remark, 'public', created_at FROM people WHERE remark IS NOT NULL AND remark <> '';
+ALTER TABLE people DROP COLUMN remark;
@@ -0,0 +1,15 @@
+-- Supporting documents attached to a person. The file itself is kept in
+-- the attachment storage (a directory or S3 bucket) under storage_key;
+-- rows go with the person when they are erased.
+CREATE TABLE IF NOT EXISTS person_attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    national_id VARCHAR(50) NOT NULL COLLATE NOCASE REFERENCES people (national_id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL UNIQUE,
+    uploaded_by VARCHAR(100),
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_person_attachments_person ON person_attachments (national_id);
@@ -0,0 +1,33 @@
+-- Affiliated institutions served by one deployment. hosts lists, comma
+-- separated, the host names whose pages and lookups belong to the
+-- institution; the other columns override the BRAND_* branding. People and
+-- API keys without an institution_id belong to the deployment itself, and
+-- message templates with an empty one apply to every institution.
+CREATE TABLE IF NOT EXISTS institutions (
+    id VARCHAR(50) NOT NULL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    hosts VARCHAR(1000) NOT NULL DEFAULT '',
+    logo_url VARCHAR(1000) NOT NULL DEFAULT '',
+    primary_color VARCHAR(7) NOT NULL DEFAULT '',
+    accent_color VARCHAR(7) NOT NULL DEFAULT '',
+    footer_text VARCHAR(1000) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+ALTER TABLE people ADD COLUMN institution_id VARCHAR(50) REFERENCES institutions (id);
+CREATE INDEX IF NOT EXISTS idx_people_institution ON people (institution_id);
+ALTER TABLE api_keys ADD COLUMN institution_id VARCHAR(50) REFERENCES institutions (id);
+-- SQLite cannot change a primary key, so message_templates is rebuilt
+CREATE TABLE message_templates_new (
+    institution_id VARCHAR(50) NOT NULL DEFAULT '',
+    channel VARCHAR(16) NOT NULL,
+    lang VARCHAR(8) NOT NULL,
+    msg_key VARCHAR(100) NOT NULL,
+    body TEXT NOT NULL,
+    updated_by VARCHAR(100) NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (institution_id, channel, lang, msg_key)
+);
+INSERT INTO message_templates_new (channel, lang, msg_key, body, updated_by, updated_at)
+SELECT channel, lang, msg_key, body, updated_by, updated_at FROM message_templates;
+DROP TABLE message_templates;
+ALTER TABLE message_templates_new RENAME TO message_templates;
@@ -0,0 +1,12 @@
+-- Partners using the JSON API. Their keys together may make monthly_quota
+-- requests per UTC calendar month, 0 for no limit, to the comma-separated
+-- endpoints, empty for all. Keys without a partner_id have neither limit.
+CREATE TABLE IF NOT EXISTS partners (
+    id VARCHAR(50) NOT NULL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    monthly_quota BIGINT NOT NULL DEFAULT 0,
+    endpoints VARCHAR(500) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+ALTER TABLE api_keys ADD COLUMN partner_id VARCHAR(50) REFERENCES partners (id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_partner ON api_keys (partner_id);
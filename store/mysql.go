@@ -0,0 +1,44 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers: ER_DUP_ENTRY, and ER_LOCK_WAIT_TIMEOUT and
+// ER_LOCK_DEADLOCK, after which InnoDB has rolled the statement or
+// transaction back
+const (
+	mysqlDuplicateEntry  = 1062
+	mysqlLockWaitTimeout = 1205
+	mysqlLockDeadlock    = 1213
+)
+
+var mysqlDialect = dialect{
+	driverName: "mysql",
+	migrations: "mysql",
+	rebind:     func(query string) string { return query },
+	isDuplicate: func(err error) bool {
+		var myErr *mysql.MySQLError
+		return errors.As(err, &myErr) && myErr.Number == mysqlDuplicateEntry
+	},
+	isConflict: func(err error) bool {
+		var myErr *mysql.MySQLError
+		return errors.As(err, &myErr) && (myErr.Number == mysqlLockDeadlock || myErr.Number == mysqlLockWaitTimeout)
+	},
+	day: func(column string) string { return "DATE_FORMAT(" + column + ", '%Y-%m-%d')" },
+}
+
+// MySQLDSN builds a go-sql-driver DSN. parseTime=true makes DATETIME
+// columns scan into time.Time.
+func MySQLDSN(user, pass, host, port, name string) string {
+	c := mysql.NewConfig()
+	c.User = user
+	c.Passwd = pass
+	c.Net = "tcp"
+	c.Addr = host + ":" + port
+	c.DBName = name
+	c.ParseTime = true
+	return c.FormatDSN()
+}
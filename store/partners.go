@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+const partnerColumns = `id, name, monthly_quota, endpoints, created_at`
+
+func scanPartner(row scanner) (*Partner, error) {
+	p := &Partner{}
+	var endpoints string
+	if err := row.Scan(&p.ID, &p.Name, &p.MonthlyQuota, &endpoints, &p.CreatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	if endpoints != "" {
+		p.Endpoints = strings.Split(endpoints, ",")
+	}
+	return p, nil
+}
+
+// ListPartners returns every partner, by ID
+func (s *SQLStore) ListPartners(ctx context.Context) ([]Partner, error) {
+	ctx, done := s.begin(ctx, "list_partners")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT `+partnerColumns+` FROM partners ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partners := []Partner{}
+	for rows.Next() {
+		p, err := scanPartner(rows)
+		if err != nil {
+			return nil, err
+		}
+		partners = append(partners, *p)
+	}
+	return partners, rows.Err()
+}
+
+// GetPartner returns one partner by ID
+func (s *SQLStore) GetPartner(ctx context.Context, id string) (*Partner, error) {
+	ctx, done := s.begin(ctx, "get_partner")
+	defer done()
+
+	return scanPartner(s.queryRow(ctx, `SELECT `+partnerColumns+` FROM partners WHERE id = ?`, id))
+}
+
+// PutPartner updates the partner with p.ID, or inserts it when there is
+// none, and returns the stored row
+func (s *SQLStore) PutPartner(ctx context.Context, p *Partner) (*Partner, error) {
+	ctx, done := s.begin(ctx, "put_partner")
+	defer done()
+
+	endpoints := strings.Join(p.Endpoints, ",")
+	res, err := s.exec(ctx, `UPDATE partners SET name = ?, monthly_quota = ?, endpoints = ? WHERE id = ?`,
+		p.Name, p.MonthlyQuota, endpoints, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		// MySQL counts only changed rows, so an unchanged partner lands
+		// here too and its insert is a duplicate
+		_, err := s.exec(ctx, `INSERT INTO partners (id, name, monthly_quota, endpoints) VALUES (?, ?, ?, ?)`,
+			p.ID, p.Name, p.MonthlyQuota, endpoints)
+		if err != nil && !errors.Is(err, ErrDuplicate) {
+			return nil, err
+		}
+	}
+	return scanPartner(s.queryRow(ctx, `SELECT `+partnerColumns+` FROM partners WHERE id = ?`, p.ID))
+}
+
+// DeletePartner removes a partner no active API key belongs to. Revoked
+// keys are kept, with no partner.
+func (s *SQLStore) DeletePartner(ctx context.Context, id string) error {
+	defer s.timeQuery("delete_partner")()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT COUNT(*) FROM api_keys WHERE partner_id = ? AND revoked_at IS NULL`), id).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrInUse
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE api_keys SET partner_id = NULL WHERE partner_id = ?`), id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM partners WHERE id = ?`), id)
+	if err := updatedOne(res, err); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PartnerRequests sums the daily usage of the partner's keys from the day
+// of since, revoked keys included
+func (s *SQLStore) PartnerRequests(ctx context.Context, id string, since time.Time) (int64, error) {
+	ctx, done := s.begin(ctx, "partner_requests")
+	defer done()
+
+	var n int64
+	err := s.queryRow(ctx, `SELECT COALESCE(SUM(u.requests), 0) FROM api_key_usage u JOIN api_keys k ON k.id = u.key_id
+WHERE k.partner_id = ? AND u.day >= ?`, id, since.UTC().Format(time.DateOnly)).Scan(&n)
+	return n, err
+}
+
+// PartnerUsage returns the daily usage rows of the partner's keys.
+// since and until are compared by day only; until is exclusive.
+func (s *SQLStore) PartnerUsage(ctx context.Context, id string, since, until time.Time) ([]KeyUsage, error) {
+	ctx, done := s.begin(ctx, "partner_usage")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT u.key_id, u.day, u.requests FROM api_key_usage u JOIN api_keys k ON k.id = u.key_id
+WHERE k.partner_id = ? AND u.day >= ? AND u.day < ? ORDER BY u.day, u.key_id`,
+		id, since.UTC().Format(time.DateOnly), until.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []KeyUsage{}
+	for rows.Next() {
+		var u KeyUsage
+		if err := rows.Scan(&u.KeyID, &u.Day, &u.Requests); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// GetPhoto returns the photo stored for a person
+func (s *SQLStore) GetPhoto(ctx context.Context, nationalID string) (*Photo, error) {
+	ctx, done := s.begin(ctx, "get_photo")
+	defer done()
+
+	ph := &Photo{}
+	err := s.queryRow(ctx, `SELECT national_id, content_type, data, updated_at FROM person_photos WHERE national_id = ?`,
+		nationalID).Scan(&ph.NationalID, &ph.ContentType, &ph.Data, &ph.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return ph, nil
+}
+
+// SetPhoto replaces a person's photo against their stored ID, deleting and
+// re-inserting in one transaction since the dialects spell upserts
+// differently
+func (s *SQLStore) SetPhoto(ctx context.Context, nationalID, contentType string, data []byte) (*Photo, error) {
+	ctx, done := s.begin(ctx, "set_photo")
+	defer done()
+
+	var id string
+	if err := s.queryRow(ctx, `SELECT national_id FROM people WHERE national_id = ?`+notDeleted, nationalID).Scan(&id); err != nil {
+		return nil, notFound(err)
+	}
+
+	// The history shows whether there is a photo, so a replaced one
+	// is recorded only when the first is uploaded
+	err := s.change(ctx, id, HistoryPhotoChanged, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM person_photos WHERE national_id = ?`), id); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO person_photos (national_id, content_type, data) VALUES (?, ?, ?)`),
+			id, contentType, data)
+		return s.mapError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ph := &Photo{NationalID: id, ContentType: contentType}
+	err = s.queryRow(ctx, `SELECT updated_at FROM person_photos WHERE national_id = ?`, id).Scan(&ph.UpdatedAt)
+	return ph, err
+}
+
+// DeletePhoto removes the photo stored for a person
+func (s *SQLStore) DeletePhoto(ctx context.Context, nationalID string) error {
+	ctx, done := s.begin(ctx, "delete_photo")
+	defer done()
+
+	return s.change(ctx, nationalID, HistoryPhotoRemoved, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM person_photos WHERE national_id = ?`), nationalID)
+		return updatedOne(res, err)
+	})
+}
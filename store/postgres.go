@@ -0,0 +1,47 @@
+package store
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// SQLSTATE codes: unique_violation, and serialization_failure and
+// deadlock_detected, which abort the transaction
+const (
+	postgresUniqueViolation      = "23505"
+	postgresSerializationFailure = "40001"
+	postgresDeadlockDetected     = "40P01"
+)
+
+var postgresDialect = dialect{
+	driverName: "pgx",
+	migrations: "postgres",
+	rebind:     rebindNumbered,
+	isDuplicate: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation
+	},
+	isConflict: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && (pgErr.Code == postgresSerializationFailure || pgErr.Code == postgresDeadlockDetected)
+	},
+	day: func(column string) string { return "TO_CHAR(" + column + ", 'YYYY-MM-DD')" },
+}
+
+// PostgresDSN builds a postgres:// connection URL. sslMode is passed through
+// as the sslmode parameter (disable, require, verify-full, ...).
+func PostgresDSN(user, pass, host, port, name, sslMode string) string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(user, pass),
+		Host:   host + ":" + port,
+		Path:   "/" + name,
+	}
+	if sslMode != "" {
+		u.RawQuery = url.Values{"sslmode": {sslMode}}.Encode()
+	}
+	return u.String()
+}
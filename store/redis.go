@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisPersonPrefix namespaces cached people in a shared Redis
+const redisPersonPrefix = "hogwarts:person:"
+
+// RedisCache is a Cache shared by every instance pointing at the same
+// Redis. Entries expire after the TTL; Redis' maxmemory policy bounds size.
+type RedisCache struct {
+	client *redis.Client
+	ttl    time.Duration
+
+	// OnError, when set, is called when Redis fails. Failures are treated
+	// as cache misses so lookups fall through to the database.
+	OnError func(op string, err error)
+}
+
+// NewRedisCache returns a cache storing people in client for ttl each
+func NewRedisCache(client *redis.Client, ttl time.Duration) *RedisCache {
+	return &RedisCache{client: client, ttl: ttl}
+}
+
+func (c *RedisCache) fail(op string, err error) {
+	if c.OnError != nil {
+		c.OnError(op, err)
+	}
+}
+
+// Get returns the cached person for id, if present
+func (c *RedisCache) Get(ctx context.Context, id string) (*Person, bool) {
+	data, err := c.client.Get(ctx, redisPersonPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, false
+	} else if err != nil {
+		c.fail("get", err)
+		return nil, false
+	}
+	var p Person
+	if err := json.Unmarshal(data, &p); err != nil {
+		c.fail("decode", err)
+		return nil, false
+	}
+	return &p, true
+}
+
+// Set caches p for the TTL
+func (c *RedisCache) Set(ctx context.Context, p *Person) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		c.fail("encode", err)
+		return
+	}
+	if err := c.client.Set(ctx, redisPersonPrefix+p.NationalID, data, c.ttl).Err(); err != nil {
+		c.fail("set", err)
+	}
+}
+
+// Delete drops ids from the cache on every instance
+func (c *RedisCache) Delete(ctx context.Context, ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisPersonPrefix + id
+	}
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		c.fail("delete", err)
+	}
+}
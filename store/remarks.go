@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const remarkColumns = `id, national_id, remark_type, text, visibility, created_at`
+
+// remarkColumn is the additional data remark text is sealed with: that of
+// the people.remark column it replaced, so migration 0030 could copy
+// encrypted remarks as they were
+const remarkColumn = "remark"
+
+// scanRemarks appends the remarkColumns rows to the people they belong to,
+// decrypting the text, and closes rows
+func (s *SQLStore) scanRemarks(rows *sql.Rows, byID map[string]*Person) error {
+	defer rows.Close()
+	for rows.Next() {
+		var r Remark
+		if err := rows.Scan(&r.ID, &r.NationalID, &r.Type, &r.Text, &r.Visibility, &r.CreatedAt); err != nil {
+			return err
+		}
+		var err error
+		if r.Text, err = s.Cipher.Decrypt(r.Text, r.NationalID, remarkColumn); err != nil {
+			return err
+		}
+		if p, ok := byID[r.NationalID]; ok {
+			p.Remarks = append(p.Remarks, r)
+		}
+	}
+	return rows.Err()
+}
+
+// loadRemarks fills in Remarks for each of people with one query
+func (s *SQLStore) loadRemarks(ctx context.Context, people ...*Person) error {
+	if len(people) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Person, len(people))
+	args := make([]interface{}, 0, len(people))
+	for _, p := range people {
+		byID[p.NationalID] = p
+		args = append(args, p.NationalID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+
+	rows, err := s.query(ctx, `SELECT `+remarkColumns+` FROM person_remarks WHERE national_id IN (`+placeholders+`) ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+	return s.scanRemarks(rows, byID)
+}
+
+// AddRemark inserts r and records it in the person's history
+func (s *SQLStore) AddRemark(ctx context.Context, r *Remark) (*Remark, error) {
+	ctx, done := s.begin(ctx, "add_remark")
+	defer done()
+
+	added := *r
+	added.CreatedAt = time.Now().UTC()
+	err := s.change(ctx, r.NationalID, HistoryRemarkAdded, func(tx *sql.Tx) error {
+		// The text is sealed to the ID as people stores it
+		err := tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT national_id FROM people WHERE national_id = ?`+notDeleted), r.NationalID).Scan(&added.NationalID)
+		if err != nil {
+			return notFound(err)
+		}
+		text, err := s.Cipher.Encrypt(r.Text, added.NationalID, remarkColumn)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO person_remarks (national_id, remark_type, text, visibility, created_at) VALUES (?, ?, ?, ?, ?)`),
+			added.NationalID, r.Type, text, r.Visibility, added.CreatedAt); err != nil {
+			return err
+		}
+		// Read the id back inside the transaction, since Postgres has no
+		// LastInsertId
+		return tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT MAX(id) FROM person_remarks WHERE national_id = ?`), added.NationalID).Scan(&added.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+// DeleteRemark removes one remark and records it in the person's history
+func (s *SQLStore) DeleteRemark(ctx context.Context, nationalID string, id int64) error {
+	ctx, done := s.begin(ctx, "delete_remark")
+	defer done()
+
+	return s.change(ctx, nationalID, HistoryRemarkRemoved, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM person_remarks WHERE id = ? AND national_id IN
+(SELECT national_id FROM people WHERE national_id = ?`+notDeleted+`)`), id, nationalID)
+		return updatedOne(res, err)
+	})
+}
+
+// moveRemarks re-files the remarks of fromID under keepID, re-encrypting
+// each since the text is sealed to the ID it is stored under
+func (s *SQLStore) moveRemarks(ctx context.Context, tx *sql.Tx, fromID, keepID string) error {
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`SELECT `+remarkColumns+` FROM person_remarks WHERE national_id = ? ORDER BY id`), fromID)
+	if err != nil {
+		return err
+	}
+	from := &Person{NationalID: fromID}
+	if err := s.scanRemarks(rows, map[string]*Person{fromID: from}); err != nil {
+		return err
+	}
+	for _, r := range from.Remarks {
+		text, err := s.Cipher.Encrypt(r.Text, keepID, remarkColumn)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE person_remarks SET national_id = ?, text = ? WHERE id = ?`), keepID, text, r.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateRemarksBatch re-encrypts the remarks in the batch after the id
+// after, like rotateBatch, returning the last id or 0
+func (s *SQLStore) rotateRemarksBatch(ctx context.Context, after int64) (int, int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`SELECT id, national_id, text FROM person_remarks WHERE id > ? ORDER BY id LIMIT ?`), after, rotateBatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	var batch []Remark
+	for rows.Next() {
+		var r Remark
+		if err := rows.Scan(&r.ID, &r.NationalID, &r.Text); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(batch) == 0 {
+		return 0, 0, err
+	}
+
+	n := 0
+	for _, r := range batch {
+		if !s.Cipher.needsRotation(r.Text) {
+			continue
+		}
+		plain, err := s.Cipher.Decrypt(r.Text, r.NationalID, remarkColumn)
+		if err != nil {
+			return 0, 0, err
+		}
+		text, err := s.Cipher.Encrypt(plain, r.NationalID, remarkColumn)
+		if err != nil {
+			return 0, 0, err
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE person_remarks SET text = ? WHERE id = ?`), text, r.ID); err != nil {
+			return 0, 0, err
+		}
+		n++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return n, batch[len(batch)-1].ID, nil
+}
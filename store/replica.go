@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNoReplica is returned by PingReplica when no replica is configured
+var ErrNoReplica = errors.New("store: no read replica")
+
+// replica is a read-only copy of the database that person lookups are
+// sent to. After it fails to answer it is left alone for the store's
+// ReplicaRetry and lookups go to the primary.
+type replica struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	down    bool
+	retryAt time.Time
+}
+
+// replicaContext marks a context whose reads may be served by the
+// replica (true) or must be served by the primary (false)
+type replicaContext struct{}
+
+// onReplica lets the reads made with ctx go to the replica, unless
+// onPrimary already ruled it out
+func onReplica(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(replicaContext{}).(bool); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, replicaContext{}, true)
+}
+
+// onPrimary keeps the reads made with ctx on the primary, for results
+// that must not lag behind writes
+func onPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaContext{}, false)
+}
+
+// OpenReplica connects the replica at dsn, with the primary's driver and
+// the given pool settings. A replica that does not answer the ping is
+// still installed, marked down, and returned with the error so it can be
+// logged; lookups use the primary until it answers.
+func (s *SQLStore) OpenReplica(dsn string, pool PoolConfig) error {
+	if s.dialect.driverName == sqliteDialect.driverName {
+		return fmt.Errorf("store: sqlite has no read replicas")
+	}
+	db, err := sql.Open(s.dialect.driverName, dsn)
+	if err != nil {
+		return err
+	}
+	pool.apply(db)
+	s.replica = &replica{db: db}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		s.replicaDown(err)
+		return fmt.Errorf("store: ping replica: %w", err)
+	}
+	return nil
+}
+
+// PingReplica checks the replica's connectivity, marking it up again when
+// it answers
+func (s *SQLStore) PingReplica(ctx context.Context) error {
+	if s.replica == nil {
+		return ErrNoReplica
+	}
+	err := s.replica.db.PingContext(ctx)
+	if err != nil {
+		s.replicaDown(err)
+		return err
+	}
+	s.replicaUp()
+	return nil
+}
+
+// reader returns the replica when ctx allows reading from it and it is
+// up, or has been down for ReplicaRetry, and nil for the primary
+func (s *SQLStore) reader(ctx context.Context) *sql.DB {
+	r := s.replica
+	if use, _ := ctx.Value(replicaContext{}).(bool); r == nil || !use {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down && time.Now().Before(r.retryAt) {
+		return nil
+	}
+	return r.db
+}
+
+// read runs call on the replica when ctx allows it, and on the primary
+// when it does not, the replica is down or it cannot be reached. The
+// replica gets half of the time left on ctx, so a replica that hangs
+// leaves the primary the other half.
+func (s *SQLStore) read(ctx context.Context, call func(ctx context.Context, db *sql.DB) error) error {
+	if db := s.reader(ctx); db != nil {
+		replicaCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			replicaCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/2)
+		}
+		err := call(replicaCtx, db)
+		if !unavailable(err) && !connectionLost(err) {
+			// The rows are read after read returns, so the replica's
+			// deadline is released with ctx rather than here
+			context.AfterFunc(ctx, cancel)
+			s.replicaUp()
+			return err
+		}
+		cancel()
+		s.replicaDown(err)
+	}
+	return call(ctx, s.db)
+}
+
+// replicaDown sends lookups to the primary for ReplicaRetry
+func (s *SQLStore) replicaDown(err error) {
+	r := s.replica
+	r.mu.Lock()
+	was := r.down
+	r.down, r.retryAt = true, time.Now().Add(s.ReplicaRetry)
+	r.mu.Unlock()
+	if !was && s.OnReplica != nil {
+		s.OnReplica(false, err)
+	}
+}
+
+// replicaUp sends lookups to the replica again
+func (s *SQLStore) replicaUp() {
+	r := s.replica
+	r.mu.Lock()
+	was := r.down
+	r.down = false
+	r.mu.Unlock()
+	if was && s.OnReplica != nil {
+		s.OnReplica(true, nil)
+	}
+}
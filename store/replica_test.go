@@ -0,0 +1,182 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeReplica is a database whose queries and pings fail with err, or
+// hang until their context is done when hang is set
+type fakeReplica struct {
+	mu      sync.Mutex
+	err     error
+	hang    bool
+	queries int
+}
+
+func (f *fakeReplica) set(err error, hang bool) {
+	f.mu.Lock()
+	f.err, f.hang = err, hang
+	f.mu.Unlock()
+}
+
+func (f *fakeReplica) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries
+}
+
+func (f *fakeReplica) answer(ctx context.Context, query bool) error {
+	f.mu.Lock()
+	if query {
+		f.queries++
+	}
+	err, hang := f.err, f.hang
+	f.mu.Unlock()
+	if hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return err
+}
+
+func (f *fakeReplica) Connect(context.Context) (driver.Conn, error) { return fakeReplicaConn{f}, nil }
+func (f *fakeReplica) Driver() driver.Driver                        { return nil }
+
+type fakeReplicaConn struct{ f *fakeReplica }
+
+func (c fakeReplicaConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake replica: prepare not supported")
+}
+func (c fakeReplicaConn) Close() error { return nil }
+func (c fakeReplicaConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake replica: transactions not supported")
+}
+func (c fakeReplicaConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	return nil, c.f.answer(ctx, true)
+}
+func (c fakeReplicaConn) Ping(ctx context.Context) error { return c.f.answer(ctx, false) }
+
+// replicaStore returns a store whose primary has Harry under his current
+// name, with OnReplica recording the replica's ups and downs
+func replicaStore(t *testing.T) (*SQLStore, *[]bool) {
+	t.Helper()
+	s := openTestStore(t)
+	if err := s.Create(context.Background(), &Person{NationalID: "853400939V", FullName: "Harry Potter", Category: "student"}); err != nil {
+		t.Fatal(err)
+	}
+	var changes []bool
+	s.ReplicaRetry = time.Hour
+	s.OnReplica = func(up bool, err error) { changes = append(changes, up) }
+	return s, &changes
+}
+
+func TestReplicaReads(t *testing.T) {
+	ctx := context.Background()
+	s, changes := replicaStore(t)
+	// The replica lags behind with the name before a correction
+	lagging := openTestStore(t)
+	if err := lagging.Create(ctx, &Person{NationalID: "853400939V", FullName: "Harry Poter", Category: "student"}); err != nil {
+		t.Fatal(err)
+	}
+	s.replica = &replica{db: lagging.db}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"lookup", ctx, "Harry Poter"},
+		{"on the primary", onPrimary(ctx), "Harry Potter"},
+		{"primary wins over replica", onReplica(onPrimary(ctx)), "Harry Potter"},
+	}
+	for _, tt := range tests {
+		p, err := s.GetByNationalID(tt.ctx, "853400939V")
+		if err != nil || p.FullName != tt.want {
+			t.Errorf("%s: GetByNationalID = %+v, %v; want %q", tt.name, p, err, tt.want)
+		}
+	}
+	if len(*changes) != 0 {
+		t.Errorf("OnReplica calls = %v, want none", *changes)
+	}
+}
+
+func TestReplicaFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		hang bool
+	}{
+		{name: "lost connection", err: driver.ErrBadConn},
+		{name: "hangs", hang: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, changes := replicaStore(t)
+			fake := &fakeReplica{}
+			fake.set(tt.err, tt.hang)
+			s.replica = &replica{db: sql.OpenDB(fake)}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			p, err := s.GetByNationalID(ctx, "853400939V")
+			if err != nil || p.FullName != "Harry Potter" {
+				t.Fatalf("GetByNationalID = %+v, %v; want the primary's row", p, err)
+			}
+			if fake.count() == 0 {
+				t.Error("the replica was not tried")
+			}
+			if len(*changes) != 1 || (*changes)[0] {
+				t.Fatalf("OnReplica calls = %v, want [false]", *changes)
+			}
+
+			// Down for ReplicaRetry: lookups go straight to the primary
+			tried := fake.count()
+			if _, err := s.GetByNationalID(context.Background(), "853400939V"); err != nil {
+				t.Fatal(err)
+			}
+			if fake.count() != tried {
+				t.Error("a lookup went to the replica while it was down")
+			}
+
+			// Once ReplicaRetry is up it is tried again, still down
+			s.replica.retryAt = time.Now().Add(-time.Second)
+			ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if _, err := s.GetByNationalID(ctx, "853400939V"); err != nil {
+				t.Fatal(err)
+			}
+			if fake.count() == tried {
+				t.Error("the replica was not retried after ReplicaRetry")
+			}
+			if len(*changes) != 1 {
+				t.Errorf("OnReplica calls = %v; a replica still down was reported again", *changes)
+			}
+
+			// A ping that answers brings it back
+			fake.set(nil, false)
+			if err := s.PingReplica(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if len(*changes) != 2 || !(*changes)[1] {
+				t.Errorf("OnReplica calls = %v, want [false true]", *changes)
+			}
+		})
+	}
+}
+
+func TestReplicaNone(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.PingReplica(context.Background()); !errors.Is(err, ErrNoReplica) {
+		t.Errorf("PingReplica = %v, want ErrNoReplica", err)
+	}
+	if err := s.OpenReplica(SQLiteDSN(filepath.Join(t.TempDir(), "replica.db")), PoolConfig{}); err == nil {
+		t.Error("OpenReplica on sqlite succeeded; want an error")
+	}
+}
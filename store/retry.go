@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// retry runs call, and again up to s.Retries more times after a jittered
+// backoff while it fails with a transient error and ctx allows. A write
+// is only run again after a deadlock or serialization conflict, which
+// the database rolled back; one whose connection was lost may have been
+// applied.
+func (s *SQLStore) retry(ctx context.Context, write bool, call func() error) error {
+	err := call()
+	for attempt := 0; attempt < s.Retries && err != nil; attempt++ {
+		if !s.transient(err, write) {
+			return err
+		}
+		if s.OnRetry != nil {
+			s.OnRetry(err)
+		}
+		// Equal jitter: half the doubled backoff, plus up to as much again
+		wait := s.RetryBackoff << attempt
+		wait = wait/2 + rand.N(wait/2+1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		err = call()
+	}
+	return err
+}
+
+// transient reports whether err is worth running the query again for
+func (s *SQLStore) transient(err error, write bool) bool {
+	if s.dialect.isConflict != nil && s.dialect.isConflict(err) {
+		return true
+	}
+	return !write && connectionLost(err)
+}
+
+// connectionLost reports whether err came from a pooled connection the
+// server or network had dropped, typically after sitting idle
+func connectionLost(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
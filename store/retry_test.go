@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestConnectionLost(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad connection", driver.ErrBadConn, true},
+		{"mysql invalid connection", mysql.ErrInvalidConn, true},
+		{"broken pipe", &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, true},
+		{"reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"unexpected EOF", fmt.Errorf("reading packet: %w", io.ErrUnexpectedEOF), true},
+		{"deadline", context.DeadlineExceeded, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		if got := connectionLost(tt.err); got != tt.want {
+			t.Errorf("connectionLost(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTransient(t *testing.T) {
+	mysqlStore := &SQLStore{dialect: mysqlDialect}
+	postgresStore := &SQLStore{dialect: postgresDialect}
+	sqliteStore := &SQLStore{dialect: sqliteDialect}
+	tests := []struct {
+		name        string
+		s           *SQLStore
+		err         error
+		read, write bool
+	}{
+		{"mysql deadlock", mysqlStore, &mysql.MySQLError{Number: mysqlLockDeadlock}, true, true},
+		{"mysql lock wait timeout", mysqlStore, fmt.Errorf("update: %w", &mysql.MySQLError{Number: mysqlLockWaitTimeout}), true, true},
+		{"mysql duplicate key", mysqlStore, &mysql.MySQLError{Number: 1062}, false, false},
+		{"mysql bad connection", mysqlStore, mysql.ErrInvalidConn, true, false},
+		{"postgres serialization failure", postgresStore, &pgconn.PgError{Code: postgresSerializationFailure}, true, true},
+		{"postgres deadlock", postgresStore, &pgconn.PgError{Code: postgresDeadlockDetected}, true, true},
+		{"postgres unique violation", postgresStore, &pgconn.PgError{Code: "23505"}, false, false},
+		{"postgres bad connection", postgresStore, driver.ErrBadConn, true, false},
+		// A MySQL deadlock number means nothing to another database
+		{"postgres given a mysql error", postgresStore, &mysql.MySQLError{Number: mysqlLockDeadlock}, false, false},
+		{"sqlite bad connection", sqliteStore, driver.ErrBadConn, true, false},
+		{"no rows", mysqlStore, sql.ErrNoRows, false, false},
+		{"deadline", mysqlStore, context.DeadlineExceeded, false, false},
+		{"unavailable", mysqlStore, ErrUnavailable, false, false},
+	}
+	for _, tt := range tests {
+		if got := tt.s.transient(tt.err, false); got != tt.read {
+			t.Errorf("transient(%s) for a read = %v, want %v", tt.name, got, tt.read)
+		}
+		if got := tt.s.transient(tt.err, true); got != tt.write {
+			t.Errorf("transient(%s) for a write = %v, want %v", tt.name, got, tt.write)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: mysqlLockDeadlock}
+	tests := []struct {
+		name    string
+		write   bool
+		errs    []error // returned by the successive calls, then nil
+		retries int
+		calls   int
+		wantErr error
+	}{
+		{name: "success", retries: 3, calls: 1},
+		{name: "deadlock then success", write: true, errs: []error{deadlock}, retries: 3, calls: 2},
+		{name: "read after a lost connection", errs: []error{driver.ErrBadConn, driver.ErrBadConn}, retries: 3, calls: 3},
+		{name: "write after a lost connection", write: true, errs: []error{driver.ErrBadConn}, retries: 3, calls: 1, wantErr: driver.ErrBadConn},
+		{name: "not retryable", errs: []error{sql.ErrNoRows}, retries: 3, calls: 1, wantErr: sql.ErrNoRows},
+		{name: "retries used up", write: true, errs: []error{deadlock, deadlock, deadlock}, retries: 2, calls: 3, wantErr: deadlock},
+		{name: "retries off", write: true, errs: []error{deadlock}, calls: 1, wantErr: deadlock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retried := 0
+			s := &SQLStore{dialect: mysqlDialect, Retries: tt.retries, RetryBackoff: time.Millisecond}
+			s.OnRetry = func(error) { retried++ }
+			calls := 0
+			err := s.retry(context.Background(), tt.write, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("retry = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.calls || retried != tt.calls-1 {
+				t.Errorf("%d calls and %d retries, want %d and %d", calls, retried, tt.calls, tt.calls-1)
+			}
+		})
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &SQLStore{dialect: mysqlDialect, Retries: 5, RetryBackoff: time.Hour}
+	calls := 0
+	err := s.retry(ctx, false, func() error {
+		calls++
+		cancel()
+		return driver.ErrBadConn
+	})
+	if !errors.Is(err, driver.ErrBadConn) || calls != 1 {
+		t.Errorf("retry after cancel = %v after %d calls; want the first error after 1", err, calls)
+	}
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// rankNames filters and orders candidates by how well they match name.
+// Exact and partial (substring) matches come first, followed by fuzzy
+// matches where every query word is within a small Levenshtein distance of
+// some name word.
+func rankNames(name string, candidates []Person) []SearchMatch {
+	query := normalizeName(name)
+	queryWords := strings.Fields(query)
+
+	var matches []SearchMatch
+	for _, p := range candidates {
+		m := SearchMatch{Person: p}
+		full := normalizeName(p.FullName)
+		switch {
+		case full == query:
+			m.Match = MatchExact
+		case strings.Contains(full, query):
+			m.Match = MatchPartial
+		default:
+			distance, ok := fuzzyNameDistance(queryWords, strings.Fields(full))
+			if !ok {
+				continue
+			}
+			m.Match = MatchFuzzy
+			m.Distance = distance
+		}
+		matches = append(matches, m)
+	}
+
+	rank := map[string]int{MatchExact: 0, MatchPartial: 1, MatchFuzzy: 2}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if rank[a.Match] != rank[b.Match] {
+			return rank[a.Match] < rank[b.Match]
+		}
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		return a.Person.FullName < b.Person.FullName
+	})
+	return matches
+}
+
+// likeEscaper escapes the LIKE wildcards in a pattern matched with
+// ESCAPE '!', a character no dialect treats specially in a string literal
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// searchFilter returns an SQL condition on full_name, with its arguments,
+// that every name rankNames would match for name satisfies. A query word
+// allowed k edits by fuzzyNameDistance is cut into k+1 pieces, and k edits
+// leave at least one of them intact, so a match contains a piece of every
+// word. Words with cased letters beyond ASCII add no condition, since
+// SQLite's LOWER folds ASCII only.
+func searchFilter(name string) (string, []interface{}) {
+	var where strings.Builder
+	var args []interface{}
+	for _, word := range strings.Fields(normalizeName(name)) {
+		r := []rune(word)
+		pieces := max(len(r)/3, 1) + 1
+		if len(r) < pieces || !asciiCase(word) {
+			continue
+		}
+		where.WriteString(" AND (")
+		for i := 0; i < pieces; i++ {
+			if i > 0 {
+				where.WriteString(" OR ")
+			}
+			where.WriteString("LOWER(full_name) LIKE ? ESCAPE '!'")
+			piece := string(r[i*len(r)/pieces : (i+1)*len(r)/pieces])
+			args = append(args, "%"+likeEscaper.Replace(piece)+"%")
+		}
+		where.WriteString(")")
+	}
+	return where.String(), args
+}
+
+// asciiCase reports whether the only letters of s with an upper case are
+// ASCII ones
+func asciiCase(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII && (unicode.ToUpper(r) != r || unicode.ToLower(r) != r) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeName lowercases s and collapses whitespace
+func normalizeName(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// NameKey is the form of a name compared for duplicates: lower-cased, with
+// punctuation dropped and the words sorted, so "Potter, Harry" and
+// "harry potter" have the same key
+func NameKey(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// SimilarNameKeys reports whether two NameKeys are near-identical: at most
+// one edit apart per ten letters (min 1)
+func SimilarNameKeys(a, b string) bool {
+	if a == b {
+		return a != ""
+	}
+	allowed := max(utf8.RuneCountInString(a), utf8.RuneCountInString(b)) / 10
+	return levenshtein(a, b) <= max(allowed, 1)
+}
+
+// fuzzyNameDistance matches each query word against the closest name word.
+// A word matches when its distance is at most a third of its length (min 1),
+// or when it is a prefix of a name word. Returns the summed distance.
+func fuzzyNameDistance(queryWords, nameWords []string) (int, bool) {
+	total := 0
+	for _, qw := range queryWords {
+		best := -1
+		for _, nw := range nameWords {
+			d := 0
+			if !strings.HasPrefix(nw, qw) {
+				d = levenshtein(qw, nw)
+			}
+			if best == -1 || d < best {
+				best = d
+			}
+		}
+		allowed := utf8.RuneCountInString(qw) / 3
+		if allowed < 1 {
+			allowed = 1
+		}
+		if best == -1 || best > allowed {
+			return 0, false
+		}
+		total += best
+	}
+	return total, true
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSearchFilter(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	names := []string{
+		"Harry James Potter", "Hermione Granger", "Ron Weasley", "Ginny Weasley",
+		"Neville Longbottom", "Luna Lovegood", "Cho Chang", "Seamus Finnigan",
+		"Dean Thomas", "Parvati Patil", "Padma Patil", "O'Brien 100%_Sure",
+		"Ñuñez García", "හැරී පොටර්", "Al",
+	}
+	var all []Person
+	for i, name := range names {
+		p := &Person{NationalID: fmt.Sprintf("2000%07d", i), FullName: name, Category: "student"}
+		if err := s.Create(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, *p)
+	}
+
+	for _, q := range []string{
+		"harry potter", "Hary Poter", "hermoine", "weasly", "WEASLEY", "pat",
+		"Longbotom", "luna lovegod", "cho", "Finnegan", "100%_", "o'brien",
+		"ñuñez", "ÑUÑEZ", "garcia", "පොටර්", "al", "zzz", "x",
+	} {
+		t.Run(q, func(t *testing.T) {
+			got, err := s.Search(ctx, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The filter only saves work: the ranking is that of every person
+			want := rankNames(q, all)
+			if len(got) != len(want) {
+				t.Fatalf("Search(%q) found %d people, want %d", q, len(got), len(want))
+			}
+			for i := range want {
+				if got[i].Person.NationalID != want[i].Person.NationalID || got[i].Match != want[i].Match {
+					t.Errorf("match %d = %s (%s), want %s (%s)", i, got[i].Person.FullName, got[i].Match, want[i].Person.FullName, want[i].Match)
+				}
+			}
+		})
+	}
+
+	where, args := searchFilter("Hary Poter")
+	if strings.Count(where, "LIKE") != len(args) || len(args) != 4 {
+		t.Errorf("searchFilter = %q, %q; want two pieces for each word", where, args)
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	ctx := context.Background()
+	plain, encrypted := openTestStore(t), openTestStore(t)
+	var err error
+	if encrypted.Cipher, err = NewCipher([]string{"k1:" + testKey(32, 'a')}); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"Harry Potter", "Harriet Potts", "Harold Porter", "Draco Malfoy"} {
+		for _, s := range []*SQLStore{plain, encrypted} {
+			if err := s.Create(ctx, &Person{NationalID: fmt.Sprintf("2000%07d", i), FullName: name, Category: "student"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name    string
+		s       *SQLStore
+		limit   int
+		query   string
+		found   int
+		wantErr error
+	}{
+		{name: "no cap", s: encrypted, query: "harry", found: 1},
+		{name: "encrypted under the cap", s: encrypted, limit: 4, query: "harry", found: 1},
+		// Encrypted names are all read, whatever the query
+		{name: "encrypted over the cap", s: encrypted, limit: 3, query: "draco", wantErr: ErrTooBroad},
+		{name: "plaintext filtered under the cap", s: plain, limit: 1, query: "draco", found: 1},
+		{name: "plaintext over the cap", s: plain, limit: 2, query: "har", wantErr: ErrTooBroad},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.s.SearchLimit = tt.limit
+			got, err := tt.s.Search(ctx, tt.query)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("Search(%q) = %v, want %v", tt.query, err, tt.wantErr)
+			}
+			if len(got) != tt.found {
+				t.Errorf("Search(%q) found %d, want %d", tt.query, len(got), tt.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"strings"
+)
+
+// GetSettings returns the settings whose names start with prefix
+func (s *SQLStore) GetSettings(ctx context.Context, prefix string) (map[string]string, error) {
+	ctx, done := s.begin(ctx, "get_settings")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT name, value FROM settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(name, prefix) {
+			values[name] = value
+		}
+	}
+	return values, rows.Err()
+}
+
+// SetSettings replaces each named setting. It deletes and re-inserts rather
+// than upserting, since the dialects spell upserts differently.
+func (s *SQLStore) SetSettings(ctx context.Context, values map[string]string) error {
+	defer s.timeQuery("set_settings")()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for name, value := range values {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM settings WHERE name = ?`), name); err != nil {
+			return err
+		}
+		if value == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO settings (name, value) VALUES (?, ?)`), name, value); err != nil {
+			return s.mapError(err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// SharedStore wraps a Store so concurrent lookups of the same ID share a
+// single query: a burst of requests for one person, such as a shared
+// result page being opened by many people at once, costs one round trip
+// and every caller gets a copy of its result.
+type SharedStore struct {
+	Store
+	group singleflight.Group
+
+	// OnShared, when set, is called for every lookup that was answered by
+	// a query another lookup also waited on
+	OnShared func()
+}
+
+// NewSharedStore returns s with concurrent identical lookups deduplicated
+func NewSharedStore(s Store) *SharedStore {
+	return &SharedStore{Store: s}
+}
+
+// GetByNationalID shares the query with concurrent lookups of id
+func (s *SharedStore) GetByNationalID(ctx context.Context, id string) (*Person, error) {
+	return s.do(ctx, "id\x00"+id, func(ctx context.Context) (*Person, error) {
+		return s.Store.GetByNationalID(ctx, id)
+	})
+}
+
+// FindByIdentifier shares the query with concurrent lookups of the same
+// values and type
+func (s *SharedStore) FindByIdentifier(ctx context.Context, idType string, values []string) (*Person, error) {
+	return s.do(ctx, "identifier\x00"+idType+"\x00"+strings.Join(values, "\x00"), func(ctx context.Context) (*Person, error) {
+		return s.Store.FindByIdentifier(ctx, idType, values)
+	})
+}
+
+// do runs lookup once for every concurrent caller with the same key. The
+// query does not stop when the caller that started it gives up, so the
+// others still get its result; each caller can give up waiting on its
+// own.
+func (s *SharedStore) do(ctx context.Context, key string, lookup func(ctx context.Context) (*Person, error)) (*Person, error) {
+	ch := s.group.DoChan(key, func() (interface{}, error) {
+		return lookup(context.WithoutCancel(ctx))
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Shared && s.OnShared != nil {
+			s.OnShared()
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		p := *res.Val.(*Person)
+		return &p, nil
+	}
+}
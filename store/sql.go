@@ -0,0 +1,640 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLStore implements Store on top of database/sql. Queries are written
+// with ? placeholders and rewritten for the dialect in use.
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+
+	// Observe, when set, is called with the name and duration of every query
+	Observe func(query string, d time.Duration)
+	// QueryTimeout, when positive, bounds every query so a slow database
+	// fails fast instead of hanging the caller
+	QueryTimeout time.Duration
+	// Cipher, when set, encrypts names, remarks and history as they are
+	// written and decrypts them as they are read
+	Cipher *Cipher
+	// Breaker, when set, fails queries at once while the database is down
+	// instead of letting each one wait for its timeout
+	Breaker *Breaker
+	// Retries is how many more times a query that failed with a transient
+	// error is run, after a jittered backoff starting at RetryBackoff.
+	// OnRetry, when set, is called with the error before each retry.
+	Retries      int
+	RetryBackoff time.Duration
+	OnRetry      func(err error)
+	// ReplicaRetry is how long lookups stay on the primary after the read
+	// replica failed to answer. OnReplica, when set, is called when the
+	// replica goes down or answers again.
+	ReplicaRetry time.Duration
+	OnReplica    func(up bool, err error)
+	// SearchLimit, when positive, is the most people a name search reads
+	// and ranks; Search fails with ErrTooBroad rather than read more
+	SearchLimit int
+
+	replica *replica
+}
+
+// DB exposes the underlying pool (for pool tuning and health checks)
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+// timeQuery reports the duration of the named query to Observe;
+// use as defer s.timeQuery("name")()
+func (s *SQLStore) timeQuery(name string) func() {
+	start := time.Now()
+	return func() {
+		if s.Observe != nil {
+			s.Observe(name, time.Since(start))
+		}
+	}
+}
+
+// begin starts the named query: ctx is bounded by QueryTimeout and the
+// returned func, which must be deferred, cancels it and reports the duration
+func (s *SQLStore) begin(ctx context.Context, name string) (context.Context, func()) {
+	observe := s.timeQuery(name)
+	if s.QueryTimeout <= 0 {
+		return ctx, observe
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.QueryTimeout)
+	return ctx, func() {
+		cancel()
+		observe()
+	}
+}
+
+// queryRow, query and exec run a statement outside a transaction, retried
+// as retry allows. Errors that only show when scanning are not retried.
+// Reads go to the replica for person lookups, as read decides.
+func (s *SQLStore) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	var row *sql.Row
+	s.retry(ctx, false, func() error {
+		return s.read(ctx, func(ctx context.Context, db *sql.DB) error {
+			row = db.QueryRowContext(ctx, s.dialect.rebind(query), args...)
+			return row.Err()
+		})
+	})
+	return row
+}
+
+func (s *SQLStore) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := s.retry(ctx, false, func() error {
+		return s.read(ctx, func(ctx context.Context, db *sql.DB) (err error) {
+			rows, err = db.QueryContext(ctx, s.dialect.rebind(query), args...)
+			return err
+		})
+	})
+	return rows, err
+}
+
+func (s *SQLStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := s.retry(ctx, true, func() (err error) {
+		res, err = s.db.ExecContext(ctx, s.dialect.rebind(query), args...)
+		return err
+	})
+	return res, s.mapError(err)
+}
+
+// IsTimeout reports whether err is a query cancelled by QueryTimeout or by
+// the caller's context deadline
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// mapError translates driver-specific errors into store errors
+func (s *SQLStore) mapError(err error) error {
+	if err != nil && s.dialect.isDuplicate(err) {
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
+	}
+	return err
+}
+
+// updatedOne maps an UPDATE or DELETE that touched no rows to ErrNotFound
+func updatedOne(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Ping checks database connectivity
+func (s *SQLStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the connection pools
+func (s *SQLStore) Close() error {
+	if s.replica != nil {
+		s.replica.db.Close()
+	}
+	return s.db.Close()
+}
+
+const personColumns = `national_id, full_name, category, COALESCE(institution_id, ''), created_at, issue_date, expiry_date,
+revoked, COALESCE(revocation_reason, ''), revoked_at, public_lookup, deleted_at, COALESCE(deleted_by, ''),
+EXISTS (SELECT 1 FROM person_photos WHERE person_photos.national_id = people.national_id),
+(SELECT COUNT(*) FROM person_attachments WHERE person_attachments.national_id = people.national_id)`
+
+// notDeleted restricts a people query to the people not deleted
+const notDeleted = ` AND deleted_at IS NULL`
+
+// dateLayout is how DATE columns are written; plain strings compare
+// correctly against DATE values on every supported database
+const dateLayout = "2006-01-02"
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPerson scans a personColumns row, decrypting full_name
+func (s *SQLStore) scanPerson(row scanner) (*Person, error) {
+	p := &Person{}
+	var issue, expiry, revokedAt, deletedAt sql.NullTime
+	err := row.Scan(&p.NationalID, &p.FullName, &p.Category, &p.InstitutionID, &p.CreatedAt, &issue, &expiry,
+		&p.Revoked, &p.RevocationReason, &revokedAt, &p.PublicLookup, &deletedAt, &p.DeletedBy, &p.HasPhoto, &p.Attachments)
+	p.IssueDate, p.ExpiryDate, p.RevokedAt, p.DeletedAt = issue.Time, expiry.Time, revokedAt.Time, deletedAt.Time
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.decryptPerson(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetByNationalID returns the person with exactly this ID
+func (s *SQLStore) GetByNationalID(ctx context.Context, id string) (*Person, error) {
+	ctx, done := s.begin(ctx, "find_person")
+	defer done()
+	ctx = onReplica(ctx)
+	query := `SELECT ` + personColumns + ` FROM people WHERE national_id = ?` + notDeleted + ` LIMIT 1`
+	return s.scanPersonWithCourses(ctx, s.queryRow(ctx, query, id))
+}
+
+// scanPersonWithCourses scans a single person and loads their courses and
+// remarks
+func (s *SQLStore) scanPersonWithCourses(ctx context.Context, row *sql.Row) (*Person, error) {
+	p, err := s.scanPerson(row)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadCourses(ctx, p); err != nil {
+		return nil, err
+	}
+	if err := s.loadRemarks(ctx, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetMany looks up several IDs in a single query
+func (s *SQLStore) GetMany(ctx context.Context, ids []string) (map[string]*Person, error) {
+	ctx, done := s.begin(ctx, "find_people")
+	defer done()
+	ctx = onReplica(ctx)
+	return s.getMany(ctx, ids, notDeleted)
+}
+
+// GetDeleted looks up several IDs among the deleted people
+func (s *SQLStore) GetDeleted(ctx context.Context, ids []string) (map[string]*Person, error) {
+	ctx, done := s.begin(ctx, "find_deleted_people")
+	defer done()
+	return s.getMany(ctx, ids, ` AND deleted_at IS NOT NULL`)
+}
+
+// getMany looks up the people with ids matching the extra condition where
+func (s *SQLStore) getMany(ctx context.Context, ids []string, where string) (map[string]*Person, error) {
+	found := make(map[string]*Person, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	query := `SELECT ` + personColumns + ` FROM people WHERE national_id IN (` + placeholders + `)` + where
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var people []*Person
+	for rows.Next() {
+		p, err := s.scanPerson(rows)
+		if err != nil {
+			return nil, err
+		}
+		found[p.NationalID] = p
+		people = append(people, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := s.loadCourses(ctx, people...); err != nil {
+		return nil, err
+	}
+	return found, s.loadRemarks(ctx, people...)
+}
+
+// Search ranks people against name. Matching happens in Go so it behaves
+// the same on every database; while names are stored in plaintext,
+// searchFilter first narrows the rows to those that could match. Encrypted
+// names cannot be filtered, so every person is read and decrypted, up to
+// SearchLimit.
+func (s *SQLStore) Search(ctx context.Context, name string) ([]SearchMatch, error) {
+	ctx, done := s.begin(ctx, "search_people")
+	defer done()
+	ctx = onReplica(ctx)
+	query := `SELECT ` + personColumns + ` FROM people WHERE 1=1` + notDeleted
+	var args []interface{}
+	if s.Cipher == nil {
+		where, filterArgs := searchFilter(name)
+		query, args = query+where, filterArgs
+	}
+	if s.SearchLimit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, s.SearchLimit+1)
+	}
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []Person
+	for rows.Next() {
+		if s.SearchLimit > 0 && len(candidates) == s.SearchLimit {
+			return nil, ErrTooBroad
+		}
+		p, err := s.scanPerson(rows)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rankNames(name, candidates), nil
+}
+
+// Create inserts a single person
+func (s *SQLStore) Create(ctx context.Context, p *Person) error {
+	return s.CreateMany(ctx, []*Person{p})
+}
+
+// CreateMany inserts all people with their remarks, and the created entry
+// of their history, in a single transaction. Like ExportPeople it is not bound by
+// QueryTimeout, since imports can be large.
+func (s *SQLStore) CreateMany(ctx context.Context, people []*Person) error {
+	defer s.timeQuery("insert_people")()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.dialect.rebind(`INSERT INTO people (national_id, full_name, category, institution_id, issue_date, expiry_date, public_lookup) VALUES (?, ?, ?, ?, ?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	remarkStmt, err := tx.PrepareContext(ctx, s.dialect.rebind(`INSERT INTO person_remarks (national_id, remark_type, text, visibility, created_at) VALUES (?, ?, ?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer remarkStmt.Close()
+
+	now := time.Now().UTC()
+	for _, p := range people {
+		fullName, err := s.encryptPerson(p)
+		if err != nil {
+			return fmt.Errorf("insert %s: %w", p.NationalID, err)
+		}
+		if _, err := stmt.ExecContext(ctx, p.NationalID, fullName, p.Category, nullString(p.InstitutionID), nullDate(p.IssueDate), nullDate(p.ExpiryDate), p.PublicLookup); err != nil {
+			return fmt.Errorf("insert %s: %w", p.NationalID, s.mapError(err))
+		}
+		for _, r := range p.Remarks {
+			text, err := s.Cipher.Encrypt(r.Text, p.NationalID, remarkColumn)
+			if err != nil {
+				return fmt.Errorf("insert %s: %w", p.NationalID, err)
+			}
+			if _, err := remarkStmt.ExecContext(ctx, p.NationalID, r.Type, text, r.Visibility, now); err != nil {
+				return fmt.Errorf("insert %s: %w", p.NationalID, err)
+			}
+		}
+		if err := s.recordHistory(ctx, tx, p.NationalID, HistoryCreated, nil, HistoryFields(p)); err != nil {
+			return fmt.Errorf("insert %s: %w", p.NationalID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SetInstitution updates the institution_id column of one person
+func (s *SQLStore) SetInstitution(ctx context.Context, nationalID, institutionID string) (*Person, error) {
+	ctx, done := s.begin(ctx, "set_institution")
+	defer done()
+
+	err := s.change(ctx, nationalID, HistoryInstitutionChanged, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE people SET institution_id = ? WHERE national_id = ?`+notDeleted), nullString(institutionID), nationalID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + personColumns + ` FROM people WHERE national_id = ?` + notDeleted
+	return s.scanPersonWithCourses(ctx, s.queryRow(ctx, query, nationalID))
+}
+
+// SetRevoked updates the revocation columns of one person
+func (s *SQLStore) SetRevoked(ctx context.Context, nationalID string, revoked bool, reason string) (*Person, error) {
+	ctx, done := s.begin(ctx, "set_revoked")
+	defer done()
+
+	var revokedAt interface{}
+	if revoked {
+		revokedAt = time.Now().UTC()
+	} else {
+		reason = ""
+	}
+	action := HistoryReinstated
+	if revoked {
+		action = HistoryRevoked
+	}
+	err := s.change(ctx, nationalID, action, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE people SET revoked = ?, revocation_reason = ?, revoked_at = ? WHERE national_id = ?`+notDeleted),
+			revoked, nullString(reason), revokedAt, nationalID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Read the row back rather than trusting RowsAffected: MySQL reports
+	// unchanged rows as unaffected
+	query := `SELECT ` + personColumns + ` FROM people WHERE national_id = ?` + notDeleted
+	return s.scanPersonWithCourses(ctx, s.queryRow(ctx, query, nationalID))
+}
+
+// SetPublicLookup updates the public_lookup column of one person
+func (s *SQLStore) SetPublicLookup(ctx context.Context, nationalID string, public bool) (*Person, error) {
+	ctx, done := s.begin(ctx, "set_public_lookup")
+	defer done()
+
+	err := s.change(ctx, nationalID, HistoryPublicLookup, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE people SET public_lookup = ? WHERE national_id = ?`+notDeleted), public, nationalID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + personColumns + ` FROM people WHERE national_id = ?` + notDeleted
+	return s.scanPersonWithCourses(ctx, s.queryRow(ctx, query, nationalID))
+}
+
+// DeletePerson sets deleted_at and deleted_by of one person not yet deleted
+func (s *SQLStore) DeletePerson(ctx context.Context, nationalID, deletedBy string) (*Person, error) {
+	ctx, done := s.begin(ctx, "delete_person")
+	defer done()
+
+	err := s.change(ctx, nationalID, HistoryDeleted, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE people SET deleted_at = ?, deleted_by = ? WHERE national_id = ?`+notDeleted),
+			time.Now().UTC(), deletedBy, nationalID)
+		return updatedOne(res, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + personColumns + ` FROM people WHERE national_id = ?`
+	return s.scanPersonWithCourses(ctx, s.queryRow(ctx, query, nationalID))
+}
+
+// RestorePerson clears deleted_at and deleted_by of one deleted person
+func (s *SQLStore) RestorePerson(ctx context.Context, nationalID string) (*Person, error) {
+	ctx, done := s.begin(ctx, "restore_person")
+	defer done()
+
+	err := s.change(ctx, nationalID, HistoryRestored, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE people SET deleted_at = NULL, deleted_by = NULL WHERE national_id = ? AND deleted_at IS NOT NULL`), nationalID)
+		return updatedOne(res, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + personColumns + ` FROM people WHERE national_id = ?`
+	return s.scanPersonWithCourses(ctx, s.queryRow(ctx, query, nationalID))
+}
+
+// exportBatchSize is how many people ExportPeople reads per query
+const exportBatchSize = 500
+
+// ExportPeople streams every person matching f to fn, ordered by ID.
+// Iteration stops at the first error returned by fn. QueryTimeout does not
+// apply: an export runs as long as the client keeps reading.
+func (s *SQLStore) ExportPeople(ctx context.Context, f PersonFilter, fn func(*Person) error) error {
+	defer s.timeQuery("export_people")()
+
+	query := `SELECT ` + personColumns + ` FROM people WHERE 1=1`
+	var args []interface{}
+	if f.Institution != "" {
+		query += ` AND institution_id = ?`
+		args = append(args, f.Institution)
+	}
+	if f.Category != "" {
+		query += ` AND category = ?`
+		args = append(args, f.Category)
+	}
+	if !f.CreatedFrom.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, f.CreatedFrom)
+	}
+	if !f.CreatedTo.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, f.CreatedTo)
+	}
+	switch f.Validity {
+	case ValidityExpired:
+		query += ` AND expiry_date IS NOT NULL AND expiry_date < ?`
+		args = append(args, time.Now().Format(dateLayout))
+	case ValidityCurrent:
+		query += ` AND (expiry_date IS NULL OR expiry_date >= ?)`
+		args = append(args, time.Now().Format(dateLayout))
+	}
+	switch f.Deleted {
+	case DeletedInclude:
+	case DeletedOnly:
+		query += ` AND deleted_at IS NOT NULL`
+	default:
+		query += notDeleted
+	}
+	query += ` AND national_id > ? ORDER BY national_id LIMIT ?`
+
+	// People are read a batch at a time so their remarks can be loaded
+	// between batches, on the one connection SQLite has
+	for after := ""; ; {
+		rows, err := s.query(ctx, query, append(args, after, exportBatchSize)...)
+		if err != nil {
+			return err
+		}
+		var batch []*Person
+		for rows.Next() {
+			p, err := s.scanPerson(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if err := s.loadRemarks(ctx, batch...); err != nil {
+			return err
+		}
+		for _, p := range batch {
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		after = batch[len(batch)-1].NationalID
+	}
+}
+
+// RecordCall inserts one Twilio interaction
+func (s *SQLStore) RecordCall(ctx context.Context, c *Call) error {
+	ctx, done := s.begin(ctx, "record_call")
+	defer done()
+	query := `INSERT INTO calls (call_sid, from_number, endpoint, input, result, matched_id, duration, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.exec(ctx, query, c.CallSid, nullString(c.From), c.Endpoint,
+		nullString(c.Input), c.Result, nullString(c.MatchedID), nullInt(c.Duration), c.CreatedAt)
+	return err
+}
+
+// ListCalls returns a page of calls matching f, newest first
+func (s *SQLStore) ListCalls(ctx context.Context, f CallFilter, limit, offset int) ([]Call, int, error) {
+	ctx, done := s.begin(ctx, "list_calls")
+	defer done()
+
+	where := ` WHERE 1=1`
+	var args []interface{}
+	if f.CallSid != "" {
+		where += ` AND call_sid = ?`
+		args = append(args, f.CallSid)
+	}
+	if f.From != "" {
+		where += ` AND from_number = ?`
+		args = append(args, f.From)
+	}
+	if f.Result != "" {
+		where += ` AND result = ?`
+		args = append(args, f.Result)
+	}
+	if f.Institution != "" {
+		where += institutionMatches
+		args = append(args, f.Institution)
+	}
+	if !f.Since.IsZero() {
+		where += ` AND created_at >= ?`
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where += ` AND created_at < ?`
+		args = append(args, f.Until)
+	}
+
+	var total int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM calls`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id, call_sid, COALESCE(from_number, ''), endpoint, COALESCE(input, ''), result, COALESCE(matched_id, ''), COALESCE(duration, 0), created_at FROM calls` +
+		where + ` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`
+	rows, err := s.query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	calls := []Call{}
+	for rows.Next() {
+		var c Call
+		if err := rows.Scan(&c.ID, &c.CallSid, &c.From, &c.Endpoint, &c.Input, &c.Result, &c.MatchedID, &c.Duration, &c.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		calls = append(calls, c)
+	}
+	return calls, total, rows.Err()
+}
+
+// PurgeCalls deletes the calls made before before
+func (s *SQLStore) PurgeCalls(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := s.begin(ctx, "purge_calls")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM calls WHERE created_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// nullDate maps the zero time to NULL and other times to a DATE string
+func nullDate(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(dateLayout)
+}
+
+// nullString maps "" to NULL for optional columns
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullInt maps 0 to NULL
+func nullInt(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var sqliteDialect = dialect{
+	driverName: "sqlite",
+	migrations: "sqlite",
+	rebind:     func(query string) string { return query },
+	isDuplicate: func(err error) bool {
+		var liteErr *sqlite.Error
+		if !errors.As(err, &liteErr) {
+			return false
+		}
+		code := liteErr.Code()
+		return code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || code == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	},
+	// The driver stores times as Go's time.String() ("2006-01-02 15:04:05
+	// +0000 UTC"), which SQLite's date functions cannot parse; every stored
+	// format starts with the date, though
+	day: func(column string) string { return "substr(" + column + ", 1, 10)" },
+}
+
+// SQLiteDSN builds a DSN for a database file, or an in-memory database when
+// path is empty or ":memory:"
+func SQLiteDSN(path string) string {
+	if path == "" {
+		path = ":memory:"
+	}
+	q := url.Values{}
+	q.Add("_pragma", "foreign_keys(1)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	return "file:" + path + "?" + q.Encode()
+}
+
+// initSQLite prepares a freshly opened SQLite database. SQLite allows a
+// single writer, and an in-memory database exists per connection, so the
+// pool is limited to one connection that is never recycled, whatever the
+// DB_* pool settings say. The schema is always migrated so a new file or
+// in-memory database is usable straight away.
+func initSQLite(ctx context.Context, s *SQLStore) error {
+	s.db.SetMaxOpenConns(1)
+	s.db.SetMaxIdleConns(1)
+	s.db.SetConnMaxLifetime(0)
+	_, err := s.Migrate(ctx)
+	return err
+}
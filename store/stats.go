@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// AuditOutcomeCounts counts audit entries per outcome, most frequent first
+func (s *SQLStore) AuditOutcomeCounts(ctx context.Context, institution string, since, until time.Time) ([]Count, error) {
+	ctx, done := s.begin(ctx, "audit_outcome_counts")
+	defer done()
+	where, args := statsRange("created_at", since, until, institution)
+	return s.counts(ctx, `SELECT outcome, COUNT(*) AS n FROM audit_log WHERE `+where+`
+GROUP BY outcome ORDER BY n DESC, outcome`, args...)
+}
+
+// TopMatchedIDs returns the national IDs with the most successful checks
+func (s *SQLStore) TopMatchedIDs(ctx context.Context, institution string, since, until time.Time, limit int) ([]Count, error) {
+	ctx, done := s.begin(ctx, "top_matched_ids")
+	defer done()
+	where, args := statsRange("created_at", since, until, institution)
+	return s.counts(ctx, `SELECT matched_id, COUNT(*) AS n FROM audit_log
+WHERE `+where+` AND outcome = 'match' AND matched_id IS NOT NULL
+GROUP BY matched_id ORDER BY n DESC, matched_id LIMIT ?`, append(args, limit)...)
+}
+
+// ErrorTypeCounts returns the most frequent error types in the errors table
+func (s *SQLStore) ErrorTypeCounts(ctx context.Context, since, until time.Time, limit int) ([]Count, error) {
+	ctx, done := s.begin(ctx, "error_type_counts")
+	defer done()
+	where, args := statsRange("timestamp", since, until, "")
+	return s.counts(ctx, `SELECT error_type, COUNT(*) AS n FROM errors WHERE `+where+`
+GROUP BY error_type ORDER BY n DESC, error_type LIMIT ?`, append(args, limit)...)
+}
+
+// DailyCallCounts counts distinct call SIDs per day
+func (s *SQLStore) DailyCallCounts(ctx context.Context, institution string, since, until time.Time) ([]Count, error) {
+	ctx, done := s.begin(ctx, "daily_call_counts")
+	defer done()
+	day := s.dialect.day("created_at")
+	where, args := statsRange("created_at", since, until, institution)
+	return s.counts(ctx, `SELECT `+day+` AS day, COUNT(DISTINCT call_sid) FROM calls WHERE `+where+`
+GROUP BY `+day+` ORDER BY day`, args...)
+}
+
+// DailyAuditCounts counts checks and matches per day and channel
+func (s *SQLStore) DailyAuditCounts(ctx context.Context, institution string, since, until time.Time) ([]DailyCount, error) {
+	ctx, done := s.begin(ctx, "daily_audit_counts")
+	defer done()
+	day := s.dialect.day("created_at")
+	where, args := statsRange("created_at", since, until, institution)
+	rows, err := s.query(ctx, `SELECT `+day+` AS day, channel, COUNT(*),
+SUM(CASE WHEN outcome = 'match' THEN 1 ELSE 0 END) FROM audit_log WHERE `+where+`
+GROUP BY `+day+`, channel ORDER BY day, channel`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []DailyCount{}
+	for rows.Next() {
+		var c DailyCount
+		if err := rows.Scan(&c.Day, &c.Channel, &c.Checks, &c.Matches); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// DistinctMatchedIDs counts the national IDs with at least one successful check
+func (s *SQLStore) DistinctMatchedIDs(ctx context.Context, institution string, since, until time.Time) (int, error) {
+	ctx, done := s.begin(ctx, "distinct_matched_ids")
+	defer done()
+	where, args := statsRange("created_at", since, until, institution)
+	var n int
+	err := s.queryRow(ctx, `SELECT COUNT(DISTINCT matched_id) FROM audit_log
+WHERE `+where+` AND outcome = 'match' AND matched_id IS NOT NULL`, args...).Scan(&n)
+	return n, err
+}
+
+// statsRange bounds column to [since, until), and to the rows that matched
+// a person of institution when it is set; a zero until leaves the range
+// open
+func statsRange(column string, since, until time.Time, institution string) (string, []interface{}) {
+	where, args := column+` >= ?`, []interface{}{since}
+	if !until.IsZero() {
+		where += ` AND ` + column + ` < ?`
+		args = append(args, until)
+	}
+	if institution != "" {
+		where += institutionMatches
+		args = append(args, institution)
+	}
+	return where, args
+}
+
+// counts runs a query returning (key, count) rows
+func (s *SQLStore) counts(ctx context.Context, query string, args ...interface{}) ([]Count, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []Count{}
+	for rows.Next() {
+		var c Count
+		if err := rows.Scan(&c.Key, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,969 @@
+// Package store holds all database access for the verification service.
+// Handlers depend on the interfaces defined here rather than on *sql.DB.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a lookup matches no rows
+var ErrNotFound = errors.New("store: not found")
+
+// ErrDuplicate is returned when an insert violates a unique key
+var ErrDuplicate = errors.New("store: duplicate key")
+
+// ErrInUse is returned when deleting a row that other rows still name
+var ErrInUse = errors.New("store: still in use")
+
+// ErrUnavailable is returned without querying while the Breaker is open
+var ErrUnavailable = errors.New("store: database unavailable")
+
+// ErrTooBroad is returned by Search when more people than SearchLimit
+// would have to be ranked
+var ErrTooBroad = errors.New("store: search too broad")
+
+// Person is a row of the people table
+type Person struct {
+	NationalID string
+	FullName   string
+	Category   string
+	// InstitutionID names the institution the person belongs to; empty
+	// for the deployment's own people
+	InstitutionID string
+	CreatedAt     time.Time
+	// IssueDate and ExpiryDate bound the credential's validity; zero when
+	// not recorded. The credential is valid through ExpiryDate.
+	IssueDate  time.Time
+	ExpiryDate time.Time
+	// Revoked credentials were withdrawn by an admin, e.g. for misconduct
+	Revoked          bool
+	RevocationReason string
+	RevokedAt        time.Time
+	// HasPhoto reports whether a photograph is stored for the person
+	HasPhoto bool
+	// Attachments counts the documents attached to the person
+	Attachments int
+	// PublicLookup is false for people who opted out of the public
+	// channels; they can still be verified by API partners
+	PublicLookup bool
+	// DeletedAt is when an admin deleted the person, who then verifies
+	// nowhere until restored; zero for everyone else
+	DeletedAt time.Time
+	DeletedBy string
+	// Courses lists the names of completed courses; it is filled by the
+	// Get* lookups only
+	Courses []string
+	// Remarks are the person's remarks, oldest first; they are filled by
+	// the Get* lookups and ExportPeople
+	Remarks []Remark
+}
+
+// Remark types, as stored in person_remarks.remark_type
+const (
+	RemarkGeneral      = "general"
+	RemarkAcademic     = "academic"
+	RemarkDisciplinary = "disciplinary"
+	RemarkNote         = "note"
+)
+
+// Remark visibilities: public remarks are shown on every channel, written
+// ones on every channel but calls, and private ones only to staff
+const (
+	RemarkPublic  = "public"
+	RemarkWritten = "written"
+	RemarkPrivate = "private"
+)
+
+// Remark is a row of the person_remarks table. Text is trusted HTML
+// entered by staff; Visibility says which channels may show it.
+type Remark struct {
+	ID         int64
+	NationalID string
+	Type       string
+	Text       string
+	Visibility string
+	CreatedAt  time.Time
+}
+
+// Expired reports whether the credential's expiry date has passed at now
+func (p *Person) Expired(now time.Time) bool {
+	if p.ExpiryDate.IsZero() {
+		return false
+	}
+	return now.After(p.ExpiryDate.AddDate(0, 0, 1))
+}
+
+// Validity values for PersonFilter
+const (
+	ValidityExpired = "expired"
+	ValidityCurrent = "valid"
+)
+
+// Deleted values for PersonFilter
+const (
+	DeletedInclude = "include"
+	DeletedOnly    = "only"
+)
+
+// PersonFilter narrows ExportPeople. Zero values are ignored; CreatedTo is
+// exclusive.
+type PersonFilter struct {
+	Category string
+	// Institution selects the people of one institution
+	Institution string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Validity selects expired or currently valid credentials
+	Validity string
+	// Deleted includes deleted people or selects only them; they are left
+	// out by default
+	Deleted string
+}
+
+// Name match kinds returned by Search, best first
+const (
+	MatchExact   = "exact"
+	MatchPartial = "partial"
+	MatchFuzzy   = "fuzzy"
+)
+
+// SearchMatch is a ranked name search hit
+type SearchMatch struct {
+	Person   Person
+	Match    string
+	Distance int
+}
+
+// PersonStore reads and writes the people table. Lookups skip deleted
+// people unless they say otherwise.
+type PersonStore interface {
+	// GetByNationalID returns the person with exactly this ID
+	GetByNationalID(ctx context.Context, id string) (*Person, error)
+	// GetMany looks up several IDs at once; missing IDs are absent from the map
+	GetMany(ctx context.Context, ids []string) (map[string]*Person, error)
+	// Search ranks people by how well full_name matches name; ErrTooBroad
+	// if too many people would have to be ranked
+	Search(ctx context.Context, name string) ([]SearchMatch, error)
+	// Create inserts a single person
+	Create(ctx context.Context, p *Person) error
+	// CreateMany inserts all people in one transaction
+	CreateMany(ctx context.Context, people []*Person) error
+	// ExportPeople calls fn for every person matching f, ordered by ID
+	ExportPeople(ctx context.Context, f PersonFilter, fn func(*Person) error) error
+	// SetRevoked revokes (with a reason) or reinstates a credential and
+	// returns the updated person; ErrNotFound if the ID does not exist
+	SetRevoked(ctx context.Context, nationalID string, revoked bool, reason string) (*Person, error)
+	// SetPublicLookup opts a person in to or out of the public channels
+	// and returns the updated person; ErrNotFound if the ID does not exist
+	SetPublicLookup(ctx context.Context, nationalID string, public bool) (*Person, error)
+	// SetInstitution moves a person to another institution, "" for none,
+	// and returns the updated person; ErrNotFound if the ID does not exist
+	SetInstitution(ctx context.Context, nationalID, institutionID string) (*Person, error)
+	// GetDeleted looks up several IDs among the deleted people; missing
+	// IDs are absent from the map
+	GetDeleted(ctx context.Context, ids []string) (map[string]*Person, error)
+	// DeletePerson marks a person deleted by deletedBy and returns them;
+	// ErrNotFound if the ID does not exist or is already deleted
+	DeletePerson(ctx context.Context, nationalID, deletedBy string) (*Person, error)
+	// RestorePerson undoes DeletePerson and returns the person; ErrNotFound
+	// if the ID is not deleted
+	RestorePerson(ctx context.Context, nationalID string) (*Person, error)
+	// MergePeople moves the identifiers, courses, photo and history of
+	// fromID to keepID, registers fromID as an identifier of fromType
+	// unless that is empty, deletes fromID and returns the kept person;
+	// ErrNotFound if either does not exist or is deleted
+	MergePeople(ctx context.Context, keepID, fromID, fromType string) (*Person, error)
+}
+
+// PersonChange is a row of the person_history table: one change to a
+// person. Old and New hold the values of the fields it changed, keyed as
+// in HistoryFields; Old is nil for a person's creation.
+type PersonChange struct {
+	ID         int64
+	NationalID string
+	Action     string
+	Old        map[string]interface{}
+	New        map[string]interface{}
+	// Actor is the user who made the change, "" for changes made outside
+	// the API
+	Actor string
+	// MergedFrom is the ID the change was recorded under, for history
+	// moved from a record merged into this one
+	MergedFrom string
+	CreatedAt  time.Time
+}
+
+// HistoryStore reads the change history of people, which the PersonStore,
+// RemarkStore, CourseStore and PhotoStore writes record
+type HistoryStore interface {
+	// ListPersonHistory returns a person's changes, oldest first
+	ListPersonHistory(ctx context.Context, nationalID string) ([]PersonChange, error)
+}
+
+// Category is a row of the categories table: a value of people.category
+// and how results for people in it are presented
+type Category struct {
+	Name  string
+	Label string
+	// SpokenLabels is what calls say for the category, by language code
+	SpokenLabels map[string]string
+	// Template is the HTML page template results are rendered with
+	Template string
+	// ShowCourses makes results list the completed courses instead of the
+	// remark
+	ShowCourses bool
+	CreatedAt   time.Time
+}
+
+// Institution is an affiliated institution served by the deployment
+type Institution struct {
+	ID   string
+	Name string
+	// Hosts are the host names whose pages and lookups belong to the
+	// institution
+	Hosts []string
+	// LogoURL, the colors and FooterText override those of the
+	// deployment's branding when set
+	LogoURL      string
+	PrimaryColor string
+	AccentColor  string
+	FooterText   string
+	CreatedAt    time.Time
+}
+
+// InstitutionStore manages the institutions people and API keys belong to
+type InstitutionStore interface {
+	// ListInstitutions returns every institution by ID
+	ListInstitutions(ctx context.Context) ([]Institution, error)
+	// PutInstitution adds i, or replaces the institution with its ID
+	PutInstitution(ctx context.Context, i *Institution) (*Institution, error)
+	// DeleteInstitution removes an institution with its message
+	// templates; ErrNotFound if there is none, ErrInUse while people,
+	// deleted or not, users or active API keys belong to it
+	DeleteInstitution(ctx context.Context, id string) error
+}
+
+// CategoryStore manages the categories people are in
+type CategoryStore interface {
+	// ListCategories returns every category by name
+	ListCategories(ctx context.Context) ([]Category, error)
+	// PutCategory adds c, or replaces the category of the same name
+	PutCategory(ctx context.Context, c *Category) (*Category, error)
+	// DeleteCategory removes a category; ErrNotFound if there is none,
+	// ErrInUse while people, deleted or not, are in it
+	DeleteCategory(ctx context.Context, name string) error
+}
+
+// RemarkStore adds and removes the remarks on people
+type RemarkStore interface {
+	// AddRemark adds r to the person r.NationalID, who must not be
+	// deleted, and returns it as stored; ErrNotFound if there is no such
+	// person
+	AddRemark(ctx context.Context, r *Remark) (*Remark, error)
+	// DeleteRemark removes one remark of a person not deleted;
+	// ErrNotFound if they have no remark with this id
+	DeleteRemark(ctx context.Context, nationalID string, id int64) error
+}
+
+// Course is a row of the courses table
+type Course struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+// CourseStore manages courses and which people completed them
+type CourseStore interface {
+	ListCourses(ctx context.Context) ([]Course, error)
+	// CreateCourse adds a course; ErrDuplicate if the name is taken
+	CreateCourse(ctx context.Context, name string) (*Course, error)
+	// AssignCourse records that a person completed a course. Assigning it
+	// twice is not an error; ErrNotFound if either does not exist.
+	AssignCourse(ctx context.Context, nationalID string, courseID int64) error
+	// UnassignCourse removes a completion; ErrNotFound if it was not recorded
+	UnassignCourse(ctx context.Context, nationalID string, courseID int64) error
+}
+
+// Identifier types stored in person_identifiers.id_type
+const (
+	IdentifierNIC           = "nic"
+	IdentifierPassport      = "passport"
+	IdentifierStudentNumber = "student_number"
+)
+
+// Identifier is a row of person_identifiers: another ID the person with
+// NationalID can be verified by
+type Identifier struct {
+	Type       string
+	Value      string
+	NationalID string
+	CreatedAt  time.Time
+}
+
+// Photo is a person's photograph
+type Photo struct {
+	NationalID  string
+	ContentType string
+	Data        []byte
+	UpdatedAt   time.Time
+}
+
+// PhotoStore keeps one photograph per person
+type PhotoStore interface {
+	// GetPhoto returns a person's photo; ErrNotFound if there is none
+	GetPhoto(ctx context.Context, nationalID string) (*Photo, error)
+	// SetPhoto stores or replaces a person's photo; ErrNotFound if the
+	// person does not exist
+	SetPhoto(ctx context.Context, nationalID, contentType string, data []byte) (*Photo, error)
+	// DeletePhoto removes a person's photo; ErrNotFound if there was none
+	DeletePhoto(ctx context.Context, nationalID string) error
+}
+
+// Attachment describes a supporting document attached to a person; the
+// file itself is kept outside the database under StorageKey
+type Attachment struct {
+	ID          int64
+	NationalID  string
+	Filename    string
+	ContentType string
+	Size        int64
+	// SHA256 is the hex digest of the file, checked when it is downloaded
+	SHA256     string
+	StorageKey string
+	UploadedBy string
+	CreatedAt  time.Time
+}
+
+// AttachmentStore records the documents attached to people
+type AttachmentStore interface {
+	// ListAttachments returns the attachments of the person stored under
+	// nationalID, deleted or not, oldest first
+	ListAttachments(ctx context.Context, nationalID string) ([]Attachment, error)
+	// GetAttachment returns one attachment of a person; ErrNotFound if
+	// they have none with this id
+	GetAttachment(ctx context.Context, nationalID string, id int64) (*Attachment, error)
+	// AddAttachment records a to the person a.NationalID, who must not be
+	// deleted, and returns it as stored; ErrNotFound if there is no such
+	// person
+	AddAttachment(ctx context.Context, a *Attachment) (*Attachment, error)
+	// DeleteAttachment removes one attachment of a person not deleted and
+	// returns it, so its file can be removed; ErrNotFound if they have
+	// none with this id
+	DeleteAttachment(ctx context.Context, nationalID string, id int64) (*Attachment, error)
+}
+
+// IdentifierStore manages the extra identifiers registered for people
+type IdentifierStore interface {
+	// ListIdentifiers returns a person's identifiers; ErrNotFound if the
+	// person does not exist
+	ListIdentifiers(ctx context.Context, nationalID string) ([]Identifier, error)
+	// AddIdentifier registers an identifier; ErrDuplicate if it is already
+	// registered (to anyone), ErrNotFound if the person does not exist
+	AddIdentifier(ctx context.Context, nationalID, idType, value string) (*Identifier, error)
+	// RemoveIdentifier deletes an identifier; ErrNotFound if it was not registered
+	RemoveIdentifier(ctx context.Context, nationalID, idType, value string) error
+	// FindByIdentifier returns the person who has any of values registered
+	// as an identifier of idType, or of any type when idType is empty
+	FindByIdentifier(ctx context.Context, idType string, values []string) (*Person, error)
+	// ListIdentifiersOfType returns every identifier of idType registered
+	// to people who are not deleted
+	ListIdentifiersOfType(ctx context.Context, idType string) ([]Identifier, error)
+}
+
+// Call is a row of the calls table: one Twilio webhook interaction
+type Call struct {
+	ID        int64
+	CallSid   string
+	From      string
+	Endpoint  string
+	Input     string
+	Result    string
+	MatchedID string
+	// Duration is the length in seconds of a finished call, recorded from
+	// Twilio's status callback; zero on other rows
+	Duration  int
+	CreatedAt time.Time
+}
+
+// CallFilter narrows ListCalls. Zero values are ignored; Until is exclusive.
+type CallFilter struct {
+	CallSid string
+	From    string
+	Result  string
+	// Institution keeps the calls that matched one of its people
+	Institution string
+	Since       time.Time
+	Until       time.Time
+}
+
+// CallStore records and queries Twilio interactions
+type CallStore interface {
+	RecordCall(ctx context.Context, c *Call) error
+	// ListCalls returns a page of calls, newest first, and the total match count
+	ListCalls(ctx context.Context, f CallFilter, limit, offset int) ([]Call, int, error)
+	// PurgeCalls deletes calls made before before and returns how many
+	// there were
+	PurgeCalls(ctx context.Context, before time.Time) (int64, error)
+}
+
+// AuditEntry is a row of the audit_log table: one verification attempt.
+// Source is the client IP for web and API lookups and the caller's number
+// for Twilio channels.
+type AuditEntry struct {
+	ID        int64
+	Channel   string
+	Source    string
+	Input     string
+	Outcome   string
+	MatchedID string
+	// Reference identifies a successful check and State is the credential
+	// state it found; both are empty for other outcomes
+	Reference string
+	State     string
+	// RequestID is the X-Request-ID of the request that made the check
+	RequestID string
+	CreatedAt time.Time
+}
+
+// AuditFilter narrows ListAudit. Zero values are ignored; Until is exclusive.
+type AuditFilter struct {
+	Channel   string
+	Source    string
+	MatchedID string
+	Outcome   string
+	RequestID string
+	// Institution keeps the entries that matched one of its people
+	Institution string
+	Since       time.Time
+	Until       time.Time
+}
+
+// AuditStore records and queries verification attempts
+type AuditStore interface {
+	// RecordAudit inserts entries in a single statement
+	RecordAudit(ctx context.Context, entries ...*AuditEntry) error
+	// ListAudit returns a page of entries, newest first, and the total match count
+	ListAudit(ctx context.Context, f AuditFilter, limit, offset int) ([]AuditEntry, int, error)
+	// GetAuditByReference returns the check with this reference; ErrNotFound
+	// if there is none
+	GetAuditByReference(ctx context.Context, reference string) (*AuditEntry, error)
+	// PurgeAudit deletes entries made before before and returns how many
+	// there were
+	PurgeAudit(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Count is one row of an aggregate: a key (an outcome, an ID, a
+// YYYY-MM-DD day, ...) and how often it occurred
+type Count struct {
+	Key   string
+	Count int
+}
+
+// DailyCount is the number of checks made on one channel on one day
+// (YYYY-MM-DD, UTC) and how many of them found a person
+type DailyCount struct {
+	Day     string
+	Channel string
+	Checks  int
+	Matches int
+}
+
+// StatsStore aggregates the audit, calls and errors tables for the admin
+// dashboard and the stats API. Each method counts rows created at or after
+// since and before until; a zero until counts everything since. Those
+// given an institution count only the checks and calls that matched one of
+// its people, and "" counts them all.
+type StatsStore interface {
+	// AuditOutcomeCounts counts audit entries per outcome
+	AuditOutcomeCounts(ctx context.Context, institution string, since, until time.Time) ([]Count, error)
+	// TopMatchedIDs returns the most often verified national IDs
+	TopMatchedIDs(ctx context.Context, institution string, since, until time.Time, limit int) ([]Count, error)
+	// ErrorTypeCounts returns the most frequent error_type values in the
+	// errors table
+	ErrorTypeCounts(ctx context.Context, since, until time.Time, limit int) ([]Count, error)
+	// DailyCallCounts counts distinct Twilio calls and messages per day (UTC),
+	// in date order
+	DailyCallCounts(ctx context.Context, institution string, since, until time.Time) ([]Count, error)
+	// DailyAuditCounts counts checks and matches per day (UTC) and channel,
+	// in date order
+	DailyAuditCounts(ctx context.Context, institution string, since, until time.Time) ([]DailyCount, error)
+	// DistinctMatchedIDs counts the different national IDs found by checks
+	DistinctMatchedIDs(ctx context.Context, institution string, since, until time.Time) (int, error)
+}
+
+// SettingsStore keeps runtime settings as name/value pairs
+type SettingsStore interface {
+	// GetSettings returns every setting whose name starts with prefix
+	GetSettings(ctx context.Context, prefix string) (map[string]string, error)
+	// SetSettings stores values in one transaction; an empty value deletes
+	// the setting
+	SetSettings(ctx context.Context, values map[string]string) error
+}
+
+// APIKey is a row of the api_keys table: a partner's key for the JSON
+// API. The key itself is never stored, only its hash.
+type APIKey struct {
+	ID     int64
+	Name   string
+	Prefix string
+	// InstitutionID limits the key to the people of one institution; empty
+	// for a key that sees everyone
+	InstitutionID string
+	// PartnerID is the partner whose quota and endpoints the key uses;
+	// empty for a key with neither
+	PartnerID string
+	// Requests is how many requests the key has made; FindAPIKey leaves it 0
+	Requests   int64
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	// RevokedAt is zero while the key is active
+	RevokedAt time.Time
+}
+
+// Revoked reports whether the key has been revoked
+func (k *APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// APIKeyStore issues, revokes and accounts for API keys
+type APIKeyStore interface {
+	// CreateAPIKey stores a new key by its SHA-256 hash, limited to
+	// institutionID and belonging to partnerID unless they are empty
+	CreateAPIKey(ctx context.Context, name, prefix, hash, institutionID, partnerID string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// GetAPIKey returns a key by ID; ErrNotFound if there is none
+	GetAPIKey(ctx context.Context, id int64) (*APIKey, error)
+	// FindAPIKey returns the key with this hash; ErrNotFound if there is none
+	FindAPIKey(ctx context.Context, hash string) (*APIKey, error)
+	// RevokeAPIKey revokes an active key; ErrNotFound if there is none
+	RevokeAPIKey(ctx context.Context, id int64) error
+	// RecordAPIKeyUse counts a request made with the key
+	RecordAPIKeyUse(ctx context.Context, id int64, at time.Time) error
+	// APIKeyUsage returns requests per UTC day (YYYY-MM-DD) in date order;
+	// zero since or until is ignored and until is exclusive
+	APIKeyUsage(ctx context.Context, id int64, since, until time.Time) ([]Count, error)
+	// PruneAPIKeyUsage deletes the usage of UTC days before before
+	PruneAPIKeyUsage(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Partner is an organisation using the JSON API with one or more keys
+type Partner struct {
+	ID   string
+	Name string
+	// MonthlyQuota is how many requests the partner's keys may make per UTC
+	// calendar month together; 0 for no limit
+	MonthlyQuota int64
+	// Endpoints are the API endpoints its keys may call; empty for all
+	Endpoints []string
+	CreatedAt time.Time
+}
+
+// KeyUsage is the number of requests one key made on one UTC day
+type KeyUsage struct {
+	KeyID    int64
+	Day      string
+	Requests int64
+}
+
+// PartnerStore manages the partners API keys belong to
+type PartnerStore interface {
+	// ListPartners returns every partner by ID
+	ListPartners(ctx context.Context) ([]Partner, error)
+	// GetPartner returns a partner; ErrNotFound if there is none
+	GetPartner(ctx context.Context, id string) (*Partner, error)
+	// PutPartner adds p, or replaces the partner with its ID
+	PutPartner(ctx context.Context, p *Partner) (*Partner, error)
+	// DeletePartner removes a partner; ErrNotFound if there is none,
+	// ErrInUse while active API keys belong to it
+	DeletePartner(ctx context.Context, id string) error
+	// PartnerRequests returns how many requests the partner's keys made on
+	// the UTC days from since
+	PartnerRequests(ctx context.Context, id string, since time.Time) (int64, error)
+	// PartnerUsage returns the requests of each of the partner's keys per
+	// UTC day, in day and key order; until is exclusive
+	PartnerUsage(ctx context.Context, id string, since, until time.Time) ([]KeyUsage, error)
+}
+
+// User roles, least privileged first: viewers read logs, registrars also
+// edit people, admins also manage users, API keys and settings
+const (
+	RoleViewer    = "viewer"
+	RoleRegistrar = "registrar"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists the user roles, least privileged first
+var Roles = []string{RoleViewer, RoleRegistrar, RoleAdmin}
+
+// User is a row of the users table: a staff account for the admin API
+type User struct {
+	ID        int64
+	Username  string
+	Role      string
+	CreatedAt time.Time
+	// InstitutionID limits what the user finds and changes to the people
+	// of one institution; empty for the deployment's own staff
+	InstitutionID string
+	// HasPassword is set once the user can log into the dashboard, and
+	// TOTPEnabled once logging in also needs a two-factor code
+	HasPassword bool
+	TOTPEnabled bool
+}
+
+// UserStore manages staff accounts. Each user authenticates with a bearer
+// token, stored as its SHA-256 hash, or logs into the dashboard with a
+// password.
+type UserStore interface {
+	// CreateUser adds a user; ErrDuplicate if the username is taken
+	CreateUser(ctx context.Context, username, role, tokenHash string) (*User, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	// GetUser returns a user by ID; ErrNotFound if there is none
+	GetUser(ctx context.Context, id int64) (*User, error)
+	// FindUserByName returns a user by username; ErrNotFound if there is
+	// none
+	FindUserByName(ctx context.Context, username string) (*User, error)
+	// FindUserByToken returns the user with this token hash; ErrNotFound
+	// if there is none
+	FindUserByToken(ctx context.Context, tokenHash string) (*User, error)
+	// SetUserRole changes a user's role; ErrNotFound if there is no such user
+	SetUserRole(ctx context.Context, id int64, role string) (*User, error)
+	// SetUserInstitution moves a user to an institution, "" for none;
+	// ErrNotFound if there is no such user
+	SetUserInstitution(ctx context.Context, id int64, institutionID string) (*User, error)
+	// SetUserToken replaces a user's token; ErrNotFound if there is no such user
+	SetUserToken(ctx context.Context, id int64, tokenHash string) error
+	// DeleteUser removes a user; ErrNotFound if there is none
+	DeleteUser(ctx context.Context, id int64) error
+
+	// UserPassword returns a user by username with their bcrypt password
+	// hash ("" when none is set); ErrNotFound if there is no such user
+	UserPassword(ctx context.Context, username string) (*User, string, error)
+	// SetUserPassword replaces the password hash ("" removes it) and ends
+	// the user's sessions; ErrNotFound if there is no such user
+	SetUserPassword(ctx context.Context, id int64, hash string) error
+}
+
+// TOTPStore keeps the TOTP two-factor secrets and recovery codes of users
+type TOTPStore interface {
+	// UserTOTP returns the user's secret ("" when none), whether it is
+	// enabled and the last accepted time step; ErrNotFound if there is no
+	// such user
+	UserTOTP(ctx context.Context, id int64) (secret string, enabled bool, lastStep int64, err error)
+	// SetUserTOTPSecret stores a pending secret, or with "" turns two-factor
+	// login off, deleting the recovery codes either way
+	SetUserTOTPSecret(ctx context.Context, id int64, secret string) error
+	// EnableUserTOTP enables the pending secret with step used and replaces
+	// the recovery codes; ErrNotFound if there is no pending secret
+	EnableUserTOTP(ctx context.Context, id int64, step int64, codeHashes []string) error
+	// UseTOTPStep records a time step as used, reporting false if it or a
+	// later one already was
+	UseTOTPStep(ctx context.Context, id int64, step int64) (bool, error)
+	// UseRecoveryCode consumes a recovery code, reporting false if the user
+	// has no such code
+	UseRecoveryCode(ctx context.Context, id int64, codeHash string) (bool, error)
+	RecoveryCodesLeft(ctx context.Context, id int64) (int, error)
+}
+
+// How a dashboard session was started: the login form, or single sign-on
+// through an OIDC provider
+const (
+	SessionPassword = "password"
+	SessionOIDC     = "oidc"
+)
+
+// SessionStore keeps dashboard login sessions, keyed by the SHA-256 of the
+// session cookie
+type SessionStore interface {
+	// CreateSession starts a session; method is SessionPassword or
+	// SessionOIDC
+	CreateSession(ctx context.Context, idHash string, userID int64, method string) error
+	// SessionUser returns the user of a session created at or after
+	// createdSince and last seen at or after seenSince and how it was
+	// started, and marks it seen; ErrNotFound for unknown or expired sessions
+	SessionUser(ctx context.Context, idHash string, createdSince, seenSince time.Time) (*User, string, error)
+	// DeleteSession ends a session
+	DeleteSession(ctx context.Context, idHash string) error
+	// DeleteExpiredSessions removes sessions created before createdBefore
+	// or last seen before seenBefore
+	DeleteExpiredSessions(ctx context.Context, createdBefore, seenBefore time.Time) (int64, error)
+}
+
+// Severities of logged events, least severe first
+var Severities = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// ErrorEntry is a row of the errors table: one logged event. Severity is
+// one of Severities and Category groups related event types (verify,
+// twilio, import, ...).
+type ErrorEntry struct {
+	ID        int64
+	Timestamp time.Time
+	Severity  string
+	Category  string
+	ErrorType string
+	Remark    string
+	// RequestID is the X-Request-ID of the request that logged the event;
+	// empty for events outside a request
+	RequestID string
+}
+
+// ErrorFilter narrows the errors table queries. Zero values are ignored;
+// Until is exclusive and MinSeverity also matches more severe events.
+type ErrorFilter struct {
+	ErrorType   string
+	Category    string
+	MinSeverity string
+	RequestID   string
+	Since       time.Time
+	Until       time.Time
+}
+
+// ErrorLogStore persists and queries log events in the errors table
+type ErrorLogStore interface {
+	// LogError inserts entries in a single statement
+	LogError(ctx context.Context, entries ...*ErrorEntry) error
+	// ListErrors returns a page of events, newest first, and the total match count
+	ListErrors(ctx context.Context, f ErrorFilter, limit, offset int) ([]ErrorEntry, int, error)
+	// ExportErrors streams every matching event to fn, newest first
+	ExportErrors(ctx context.Context, f ErrorFilter, fn func(*ErrorEntry) error) error
+	// PurgeErrors deletes the matching events and returns how many there were
+	PurgeErrors(ctx context.Context, f ErrorFilter) (int64, error)
+}
+
+// SubjectRecords is everything stored about a data subject besides their
+// people row: audit entries, calls, logged events and webhook deliveries
+// that mention them
+type SubjectRecords struct {
+	Audit      []AuditEntry
+	Calls      []Call
+	Events     []ErrorEntry
+	Deliveries []WebhookDelivery
+}
+
+// Erasure is the tombstone of a data subject's erasure. The subject is
+// identified only by SubjectHash; the counts are the rows deleted (people,
+// events, webhook deliveries) or anonymised (audit entries, calls).
+type Erasure struct {
+	ID           int64
+	Reference    string
+	SubjectHash  string
+	RequestedBy  string
+	Reason       string
+	People       int64
+	AuditEntries int64
+	Calls        int64
+	Events       int64
+	Deliveries   int64
+	CreatedAt    time.Time
+}
+
+// SubjectStore serves data subject access and erasure requests. ids are
+// the forms of the subject's national ID and their other identifiers.
+type SubjectStore interface {
+	SubjectRecords(ctx context.Context, ids []string) (*SubjectRecords, error)
+	// EraseSubject removes the person and what mentions them and stores
+	// the tombstone with its counts filled in
+	EraseSubject(ctx context.Context, nationalID string, ids []string, e *Erasure) error
+	// ListErasures returns the tombstones for any of subjectHashes, or all
+	// of them when it is empty
+	ListErasures(ctx context.Context, subjectHashes []string) ([]Erasure, error)
+}
+
+// Webhook is a row of the webhooks table: an endpoint that is sent
+// signed events. Events lists the event types it receives; empty means all.
+type Webhook struct {
+	ID        int64
+	URL       string
+	Secret    string
+	Events    []string
+	CreatedAt time.Time
+}
+
+// Wants reports whether the webhook receives events of this type
+func (h *Webhook) Wants(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook delivery statuses: pending deliveries are (re)tried until they
+// are delivered or run out of attempts and fail
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is a row of webhook_deliveries: one event for one
+// webhook. EventID is shared by the deliveries of an event to every
+// webhook; SubjectID is the national ID the event is about, if any.
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	EventID       string
+	Event         string
+	SubjectID     string
+	Payload       string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	// LastStatusCode and LastError describe the latest attempt; the code
+	// is 0 when no response was received
+	LastStatusCode int
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    time.Time
+}
+
+// DeliveryFilter narrows ListDeliveries. Zero values are ignored.
+type DeliveryFilter struct {
+	WebhookID int64
+	Status    string
+	Event     string
+}
+
+// WebhookStore manages webhooks and the queue of deliveries to them
+type WebhookStore interface {
+	// CreateWebhook registers an endpoint with its signing secret
+	CreateWebhook(ctx context.Context, url, secret string, events []string) (*Webhook, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	// GetWebhook returns a webhook by ID; ErrNotFound if there is none
+	GetWebhook(ctx context.Context, id int64) (*Webhook, error)
+	// DeleteWebhook removes a webhook and its deliveries; ErrNotFound if
+	// there is none
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	// QueueDeliveries inserts pending deliveries in a single statement
+	QueueDeliveries(ctx context.Context, deliveries ...*WebhookDelivery) error
+	// ClaimDeliveries returns up to limit pending deliveries due at now and
+	// moves their next attempt to leaseUntil, so other instances leave
+	// them alone while they are being sent
+	ClaimDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]WebhookDelivery, error)
+	// UpdateDelivery stores the outcome of an attempt: Status, Attempts,
+	// NextAttemptAt, LastStatusCode, LastError and DeliveredAt
+	UpdateDelivery(ctx context.Context, d *WebhookDelivery) error
+	// RetryDelivery queues a failed delivery of the webhook again with a
+	// fresh set of attempts; ErrNotFound if there is no such failed delivery
+	RetryDelivery(ctx context.Context, webhookID, id int64, at time.Time) (*WebhookDelivery, error)
+	// ListDeliveries returns a page of deliveries, newest first, and the
+	// total match count
+	ListDeliveries(ctx context.Context, f DeliveryFilter, limit, offset int) ([]WebhookDelivery, int, error)
+	// PruneDeliveries deletes delivered and failed deliveries created
+	// before before and returns how many there were
+	PruneDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Access rule actions: blocked clients are refused by the public
+// endpoints, allowed ones skip blocks, rate limits and the lockout
+const (
+	AccessBlock = "block"
+	AccessAllow = "allow"
+)
+
+// Access rule kinds: a caller (a Twilio number or Telegram sender, stored
+// by its hash as in calls.from) or an IP address or CIDR range
+const (
+	AccessCaller = "caller"
+	AccessIP     = "ip"
+)
+
+// AccessRule is a row of the access_rules table
+type AccessRule struct {
+	ID     int64
+	Action string
+	Kind   string
+	Value  string
+	Reason string
+	// CreatedBy is the username of the admin who added the rule
+	CreatedBy string
+	CreatedAt time.Time
+	// ExpiresAt is zero for a rule that lasts until it is deleted
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the rule has stopped applying at now
+func (a *AccessRule) Expired(now time.Time) bool {
+	return !a.ExpiresAt.IsZero() && !now.Before(a.ExpiresAt)
+}
+
+// MessageTemplate is a row of the message_templates table: wording that
+// replaces a built-in message on a channel in one language
+type MessageTemplate struct {
+	// InstitutionID is the institution whose messages it words, or empty
+	// for every institution's
+	InstitutionID string
+	Channel       string
+	Lang          string
+	Key           string
+	Text          string
+	// UpdatedBy is the username of the admin who last changed it
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
+// MessageTemplateStore keeps the message wording edited through the API
+type MessageTemplateStore interface {
+	// ListMessageTemplates returns every stored template
+	ListMessageTemplates(ctx context.Context) ([]MessageTemplate, error)
+	// SetMessageTemplate stores t, replacing the one for its
+	// institution, channel, language and key
+	SetMessageTemplate(ctx context.Context, t *MessageTemplate) error
+	// DeleteMessageTemplate removes a template; ErrNotFound if there is none
+	DeleteMessageTemplate(ctx context.Context, institutionID, channel, lang, key string) error
+}
+
+// AccessRuleStore manages the caller and IP blocklist and allowlist
+type AccessRuleStore interface {
+	// CreateAccessRule stores a rule, replacing an expired one for the same
+	// action, kind and value; ErrDuplicate if an active one exists
+	CreateAccessRule(ctx context.Context, a *AccessRule) (*AccessRule, error)
+	// ListAccessRules returns every rule, expired ones included, oldest first
+	ListAccessRules(ctx context.Context) ([]AccessRule, error)
+	// DeleteAccessRule removes a rule; ErrNotFound if there is none
+	DeleteAccessRule(ctx context.Context, id int64) error
+	// DeleteExpiredAccessRules removes rules that expired before before
+	DeleteExpiredAccessRules(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Store is the full data layer used by the service
+type Store interface {
+	PersonStore
+	HistoryStore
+	CategoryStore
+	InstitutionStore
+	RemarkStore
+	CourseStore
+	IdentifierStore
+	PhotoStore
+	AttachmentStore
+	CallStore
+	AuditStore
+	SettingsStore
+	StatsStore
+	ErrorLogStore
+	APIKeyStore
+	PartnerStore
+	UserStore
+	SessionStore
+	TOTPStore
+	SubjectStore
+	WebhookStore
+	AccessRuleStore
+	MessageTemplateStore
+
+	// Migrate applies pending embedded schema migrations
+	Migrate(ctx context.Context) ([]string, error)
+	// Ping checks database connectivity
+	Ping(ctx context.Context) error
+	// PingReplica checks the read replica's connectivity; ErrNoReplica
+	// means none is configured
+	PingReplica(ctx context.Context) error
+	Close() error
+}
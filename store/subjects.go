@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// subjectWhere matches audit_log and calls rows about a data subject: those
+// that matched one of ids or were made with one of them as input
+func subjectWhere(ids []string) (string, []interface{}) {
+	in := `(` + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + `)`
+	args := make([]interface{}, 0, 2*len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	args = append(args, args...)
+	return ` WHERE matched_id IN ` + in + ` OR input IN ` + in, args
+}
+
+// eventWhere matches logged events whose remark mentions one of ids
+func eventWhere(ids []string) (string, []interface{}) {
+	conds := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		conds[i] = `remark LIKE ?`
+		args[i] = "%" + id + "%"
+	}
+	return ` WHERE ` + strings.Join(conds, " OR "), args
+}
+
+// deliveryWhere matches webhook deliveries of events about one of ids
+func deliveryWhere(ids []string) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return ` WHERE subject_id IN (` + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + `)`, args
+}
+
+// SubjectRecords returns the audit entries, calls, logged events and
+// webhook deliveries that mention any of ids, oldest first
+func (s *SQLStore) SubjectRecords(ctx context.Context, ids []string) (*SubjectRecords, error) {
+	ctx, done := s.begin(ctx, "subject_records")
+	defer done()
+
+	rec := &SubjectRecords{Audit: []AuditEntry{}, Calls: []Call{}, Events: []ErrorEntry{}, Deliveries: []WebhookDelivery{}}
+	if len(ids) == 0 {
+		return rec, nil
+	}
+	where, args := subjectWhere(ids)
+
+	rows, err := s.query(ctx, `SELECT `+auditColumns+` FROM audit_log`+where+` ORDER BY created_at, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rec.Audit = append(rec.Audit, *e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.query(ctx, `SELECT id, call_sid, COALESCE(from_number, ''), endpoint, COALESCE(input, ''), result,
+COALESCE(matched_id, ''), COALESCE(duration, 0), created_at FROM calls`+where+` ORDER BY created_at, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c Call
+		if err := rows.Scan(&c.ID, &c.CallSid, &c.From, &c.Endpoint, &c.Input, &c.Result, &c.MatchedID, &c.Duration, &c.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rec.Calls = append(rec.Calls, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	where, args = eventWhere(ids)
+	rows, err = s.query(ctx, `SELECT `+errorColumns+` FROM errors`+where+` ORDER BY timestamp, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var e ErrorEntry
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Severity, &e.Category, &e.ErrorType, &e.Remark, &e.RequestID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rec.Events = append(rec.Events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	where, args = deliveryWhere(ids)
+	rows, err = s.query(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries`+where+` ORDER BY created_at, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		rec.Deliveries = append(rec.Deliveries, *d)
+	}
+	return rec, rows.Err()
+}
+
+// EraseSubject deletes the person stored under nationalID with their
+// identifiers, courses and photo, anonymises the audit entries and calls
+// that mention any of ids, deletes the logged events and webhook
+// deliveries that do, and records
+// e as the tombstone, all in one transaction. The counts in e are filled
+// in before it is stored.
+func (s *SQLStore) EraseSubject(ctx context.Context, nationalID string, ids []string, e *Erasure) error {
+	defer s.timeQuery("erase_subject")()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exec := func(query string, args ...interface{}) (int64, error) {
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(query), args...)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+
+	if nationalID != "" {
+		if e.People, err = exec(`DELETE FROM people WHERE national_id = ?`, nationalID); err != nil {
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		// Audit entries and calls stay, so counts and verification
+		// references still add up, but no longer say who was checked
+		where, args := subjectWhere(ids)
+		if e.AuditEntries, err = exec(`UPDATE audit_log SET input = NULL, matched_id = NULL`+where, args...); err != nil {
+			return err
+		}
+		if e.Calls, err = exec(`UPDATE calls SET input = NULL, matched_id = NULL`+where, args...); err != nil {
+			return err
+		}
+		where, args = eventWhere(ids)
+		if e.Events, err = exec(`DELETE FROM errors`+where, args...); err != nil {
+			return err
+		}
+		where, args = deliveryWhere(ids)
+		if e.Deliveries, err = exec(`DELETE FROM webhook_deliveries`+where, args...); err != nil {
+			return err
+		}
+	}
+
+	e.CreatedAt = time.Now().UTC()
+	_, err = exec(`INSERT INTO erasures (reference, subject_hash, requested_by, reason, people, audit_entries, calls, events, deliveries, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Reference, e.SubjectHash, e.RequestedBy, e.Reason, e.People, e.AuditEntries, e.Calls, e.Events, e.Deliveries, e.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListErasures returns the tombstones of the subjects with these hashes,
+// or every tombstone when there are none, newest first
+func (s *SQLStore) ListErasures(ctx context.Context, subjectHashes []string) ([]Erasure, error) {
+	ctx, done := s.begin(ctx, "list_erasures")
+	defer done()
+
+	query := `SELECT id, reference, subject_hash, requested_by, reason, people, audit_entries, calls, events, deliveries, created_at FROM erasures`
+	var args []interface{}
+	if len(subjectHashes) > 0 {
+		query += ` WHERE subject_hash IN (` + strings.TrimSuffix(strings.Repeat("?,", len(subjectHashes)), ",") + `)`
+		for _, h := range subjectHashes {
+			args = append(args, h)
+		}
+	}
+	rows, err := s.query(ctx, query+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Erasure{}
+	for rows.Next() {
+		var e Erasure
+		if err := rows.Scan(&e.ID, &e.Reference, &e.SubjectHash, &e.RequestedBy, &e.Reason,
+			&e.People, &e.AuditEntries, &e.Calls, &e.Events, &e.Deliveries, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UserTOTP returns a user's TOTP secret ("" when none), whether it is
+// enabled and the last time step a code was accepted for
+func (s *SQLStore) UserTOTP(ctx context.Context, id int64) (secret string, enabled bool, lastStep int64, err error) {
+	ctx, done := s.begin(ctx, "user_totp")
+	defer done()
+
+	var sec sql.NullString
+	var enabledAt sql.NullTime
+	var step sql.NullInt64
+	err = s.queryRow(ctx, `SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = ?`, id).
+		Scan(&sec, &enabledAt, &step)
+	if err != nil {
+		return "", false, 0, notFound(err)
+	}
+	return sec.String, enabledAt.Valid, step.Int64, nil
+}
+
+// SetUserTOTPSecret stores a pending secret that is not yet enabled, or
+// with "" turns two-factor login off. Either way the user's recovery codes
+// are deleted.
+func (s *SQLStore) SetUserTOTPSecret(ctx context.Context, id int64, secret string) error {
+	defer s.timeQuery("set_user_totp_secret")()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE users SET totp_secret = ?, totp_enabled_at = NULL, totp_last_step = NULL
+WHERE id = ?`), nullString(secret), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM user_recovery_codes WHERE user_id = ?`), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EnableUserTOTP turns on the pending secret, recording step as used, and
+// replaces the user's recovery codes with codeHashes
+func (s *SQLStore) EnableUserTOTP(ctx context.Context, id int64, step int64, codeHashes []string) error {
+	defer s.timeQuery("enable_user_totp")()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE users SET totp_enabled_at = ?, totp_last_step = ?
+WHERE id = ? AND totp_secret IS NOT NULL`), time.Now().UTC(), step, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM user_recovery_codes WHERE user_id = ?`), id); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)`), id, h); err != nil {
+			return s.mapError(err)
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records step as used and reports whether it was later than
+// the last one accepted, so each code works only once
+func (s *SQLStore) UseTOTPStep(ctx context.Context, id int64, step int64) (bool, error) {
+	ctx, done := s.begin(ctx, "use_totp_step")
+	defer done()
+
+	res, err := s.exec(ctx, `UPDATE users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)`,
+		step, id, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode deletes one of the user's recovery codes and reports
+// whether it existed
+func (s *SQLStore) UseRecoveryCode(ctx context.Context, id int64, codeHash string) (bool, error) {
+	ctx, done := s.begin(ctx, "use_recovery_code")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = ? AND code_hash = ?`, id, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RecoveryCodesLeft counts the user's unused recovery codes
+func (s *SQLStore) RecoveryCodesLeft(ctx context.Context, id int64) (int, error) {
+	ctx, done := s.begin(ctx, "recovery_codes_left")
+	defer done()
+
+	var n int
+	err := s.queryRow(ctx, `SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ?`, id).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const userColumns = `id, username, role, created_at, COALESCE(institution_id, ''), CASE WHEN password_hash IS NULL THEN 0 ELSE 1 END,
+CASE WHEN totp_enabled_at IS NULL THEN 0 ELSE 1 END`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	u := &User{}
+	if err := row.Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt, &u.InstitutionID, &u.HasPassword, &u.TOTPEnabled); err != nil {
+		return nil, notFound(err)
+	}
+	return u, nil
+}
+
+// CreateUser stores a new user with the hash of their bearer token
+func (s *SQLStore) CreateUser(ctx context.Context, username, role, tokenHash string) (*User, error) {
+	ctx, done := s.begin(ctx, "create_user")
+	defer done()
+
+	_, err := s.exec(ctx, `INSERT INTO users (username, role, token_hash, created_at) VALUES (?, ?, ?, ?)`,
+		username, role, tokenHash, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	// Look the row up by its unique token, since Postgres has no LastInsertId
+	return scanUser(s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE token_hash = ?`, tokenHash))
+}
+
+// ListUsers returns every user by username
+func (s *SQLStore) ListUsers(ctx context.Context) ([]User, error) {
+	ctx, done := s.begin(ctx, "list_users")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT `+userColumns+` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// GetUser returns one user by ID
+func (s *SQLStore) GetUser(ctx context.Context, id int64) (*User, error) {
+	ctx, done := s.begin(ctx, "get_user")
+	defer done()
+
+	return scanUser(s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// FindUserByName returns one user by username
+func (s *SQLStore) FindUserByName(ctx context.Context, username string) (*User, error) {
+	ctx, done := s.begin(ctx, "find_user_name")
+	defer done()
+
+	return scanUser(s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+}
+
+// FindUserByToken returns the user whose bearer token has this hash
+func (s *SQLStore) FindUserByToken(ctx context.Context, tokenHash string) (*User, error) {
+	ctx, done := s.begin(ctx, "find_user_token")
+	defer done()
+
+	return scanUser(s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE token_hash = ?`, tokenHash))
+}
+
+// SetUserRole changes a user's role and returns the updated user
+func (s *SQLStore) SetUserRole(ctx context.Context, id int64, role string) (*User, error) {
+	ctx, done := s.begin(ctx, "set_user_role")
+	defer done()
+
+	if _, err := s.exec(ctx, `UPDATE users SET role = ? WHERE id = ?`, role, id); err != nil {
+		return nil, err
+	}
+	// Read the row back rather than trusting RowsAffected: MySQL reports
+	// unchanged rows as unaffected
+	return scanUser(s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// SetUserInstitution moves a user to an institution, "" for none, and
+// returns the updated user
+func (s *SQLStore) SetUserInstitution(ctx context.Context, id int64, institutionID string) (*User, error) {
+	ctx, done := s.begin(ctx, "set_user_institution")
+	defer done()
+
+	if _, err := s.exec(ctx, `UPDATE users SET institution_id = ? WHERE id = ?`, nullString(institutionID), id); err != nil {
+		return nil, err
+	}
+	return scanUser(s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// SetUserToken replaces a user's bearer token hash, so the old token stops
+// working
+func (s *SQLStore) SetUserToken(ctx context.Context, id int64, tokenHash string) error {
+	ctx, done := s.begin(ctx, "set_user_token")
+	defer done()
+
+	res, err := s.exec(ctx, `UPDATE users SET token_hash = ? WHERE id = ?`, tokenHash, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUser removes a user
+func (s *SQLStore) DeleteUser(ctx context.Context, id int64) error {
+	ctx, done := s.begin(ctx, "delete_user")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UserPassword returns the user with this username and their bcrypt
+// password hash, "" when none is set
+func (s *SQLStore) UserPassword(ctx context.Context, username string) (*User, string, error) {
+	ctx, done := s.begin(ctx, "user_password")
+	defer done()
+
+	u := &User{}
+	var hash sql.NullString
+	err := s.queryRow(ctx, `SELECT `+userColumns+`, password_hash FROM users WHERE username = ?`, username).
+		Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt, &u.InstitutionID, &u.HasPassword, &u.TOTPEnabled, &hash)
+	if err != nil {
+		return nil, "", notFound(err)
+	}
+	return u, hash.String, nil
+}
+
+// SetUserPassword replaces a user's bcrypt password hash ("" removes it)
+// and ends their sessions, so a changed password logs out every browser
+func (s *SQLStore) SetUserPassword(ctx context.Context, id int64, hash string) error {
+	defer s.timeQuery("set_user_password")()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE users SET password_hash = ? WHERE id = ?`), nullString(hash), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM sessions WHERE user_id = ?`), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateSession stores a new session for a user by the hash of its cookie,
+// recording how they logged in (SessionPassword or SessionOIDC)
+func (s *SQLStore) CreateSession(ctx context.Context, idHash string, userID int64, method string) error {
+	ctx, done := s.begin(ctx, "create_session")
+	defer done()
+
+	now := time.Now().UTC()
+	_, err := s.exec(ctx, `INSERT INTO sessions (id_hash, user_id, created_at, last_seen_at, method) VALUES (?, ?, ?, ?, ?)`,
+		idHash, userID, now, now, method)
+	return err
+}
+
+// SessionUser returns the user of a session that was created at or after
+// createdSince and last seen at or after seenSince, with how they logged
+// in, and marks it seen now. Expired and unknown sessions are ErrNotFound.
+func (s *SQLStore) SessionUser(ctx context.Context, idHash string, createdSince, seenSince time.Time) (*User, string, error) {
+	ctx, done := s.begin(ctx, "session_user")
+	defer done()
+
+	u := &User{}
+	var method string
+	err := s.queryRow(ctx, `SELECT `+userColumns+`, (SELECT method FROM sessions WHERE id_hash = ?) FROM users
+WHERE id = (SELECT user_id FROM sessions WHERE id_hash = ? AND created_at >= ? AND last_seen_at >= ?)`,
+		idHash, idHash, createdSince.UTC(), seenSince.UTC()).
+		Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt, &u.InstitutionID, &u.HasPassword, &u.TOTPEnabled, &method)
+	if err != nil {
+		return nil, "", notFound(err)
+	}
+	if _, err := s.exec(ctx, `UPDATE sessions SET last_seen_at = ? WHERE id_hash = ?`, time.Now().UTC(), idHash); err != nil {
+		return nil, "", err
+	}
+	return u, method, nil
+}
+
+// DeleteSession ends one session; an unknown session is not an error
+func (s *SQLStore) DeleteSession(ctx context.Context, idHash string) error {
+	ctx, done := s.begin(ctx, "delete_session")
+	defer done()
+
+	_, err := s.exec(ctx, `DELETE FROM sessions WHERE id_hash = ?`, idHash)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions created before createdBefore or
+// last seen before seenBefore and returns how many there were
+func (s *SQLStore) DeleteExpiredSessions(ctx context.Context, createdBefore, seenBefore time.Time) (int64, error) {
+	ctx, done := s.begin(ctx, "delete_expired_sessions")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM sessions WHERE created_at < ? OR last_seen_at < ?`, createdBefore.UTC(), seenBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const webhookColumns = `id, url, secret, events, created_at`
+
+func scanWebhook(row scanner) (*Webhook, error) {
+	h := &Webhook{}
+	var events string
+	if err := row.Scan(&h.ID, &h.URL, &h.Secret, &events, &h.CreatedAt); err != nil {
+		return nil, err
+	}
+	if events != "" {
+		h.Events = strings.Split(events, ",")
+	}
+	return h, nil
+}
+
+// CreateWebhook stores a new webhook and returns the stored row
+func (s *SQLStore) CreateWebhook(ctx context.Context, url, secret string, events []string) (*Webhook, error) {
+	ctx, done := s.begin(ctx, "create_webhook")
+	defer done()
+
+	_, err := s.exec(ctx, `INSERT INTO webhooks (url, secret, events, created_at) VALUES (?, ?, ?, ?)`,
+		url, secret, strings.Join(events, ","), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	// Look the row up by its unique secret, since Postgres has no LastInsertId
+	return scanWebhook(s.queryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE secret = ?`, secret))
+}
+
+// ListWebhooks returns every webhook, oldest first
+func (s *SQLStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	ctx, done := s.begin(ctx, "list_webhooks")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *h)
+	}
+	return hooks, rows.Err()
+}
+
+// GetWebhook returns one webhook by ID
+func (s *SQLStore) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	ctx, done := s.begin(ctx, "get_webhook")
+	defer done()
+
+	h, err := scanWebhook(s.queryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	return h, notFound(err)
+}
+
+// DeleteWebhook removes a webhook; its deliveries go with it (ON DELETE
+// CASCADE)
+func (s *SQLStore) DeleteWebhook(ctx context.Context, id int64) error {
+	ctx, done := s.begin(ctx, "delete_webhook")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// QueueDeliveries inserts deliveries with one multi-row INSERT, so an event
+// costs a single round trip however many webhooks receive it
+func (s *SQLStore) QueueDeliveries(ctx context.Context, deliveries ...*WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	ctx, done := s.begin(ctx, "queue_deliveries")
+	defer done()
+
+	placeholders := make([]string, len(deliveries))
+	args := make([]interface{}, 0, len(deliveries)*8)
+	for i, d := range deliveries {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, d.WebhookID, d.EventID, d.Event, nullString(d.SubjectID), d.Payload,
+			DeliveryPending, d.NextAttemptAt.UTC(), d.CreatedAt.UTC())
+	}
+	query := `INSERT INTO webhook_deliveries (webhook_id, event_id, event, subject_id, payload, status, next_attempt_at, created_at) VALUES ` +
+		strings.Join(placeholders, ", ")
+	_, err := s.exec(ctx, query, args...)
+	return err
+}
+
+const deliveryColumns = `id, webhook_id, event_id, event, COALESCE(subject_id, ''), payload, status, attempts,
+next_attempt_at, COALESCE(last_status_code, 0), COALESCE(last_error, ''), created_at, delivered_at`
+
+func scanDelivery(row scanner) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	var delivered sql.NullTime
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.SubjectID, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &delivered)
+	if err != nil {
+		return nil, err
+	}
+	d.DeliveredAt = delivered.Time
+	return d, nil
+}
+
+// ClaimDeliveries selects the due pending deliveries, oldest due first, and
+// claims each by moving its next attempt to leaseUntil. The update only
+// matches while the row still has the due time that was read, so when two
+// instances race for a delivery exactly one of them gets it.
+func (s *SQLStore) ClaimDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]WebhookDelivery, error) {
+	ctx, done := s.begin(ctx, "claim_deliveries")
+	defer done()
+
+	rows, err := s.query(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?`, DeliveryPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	var due []WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, *d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claimed := due[:0]
+	for _, d := range due {
+		res, err := s.exec(ctx, `UPDATE webhook_deliveries SET next_attempt_at = ?
+WHERE id = ? AND status = ? AND next_attempt_at = ?`, leaseUntil.UTC(), d.ID, DeliveryPending, d.NextAttemptAt)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 1 {
+			d.NextAttemptAt = leaseUntil.UTC()
+			claimed = append(claimed, d)
+		}
+	}
+	return claimed, nil
+}
+
+// UpdateDelivery stores the outcome of a delivery attempt
+func (s *SQLStore) UpdateDelivery(ctx context.Context, d *WebhookDelivery) error {
+	ctx, done := s.begin(ctx, "update_delivery")
+	defer done()
+
+	var code, delivered interface{}
+	if d.LastStatusCode != 0 {
+		code = d.LastStatusCode
+	}
+	if !d.DeliveredAt.IsZero() {
+		delivered = d.DeliveredAt.UTC()
+	}
+	_, err := s.exec(ctx, `UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?,
+last_status_code = ?, last_error = ?, delivered_at = ? WHERE id = ?`,
+		d.Status, d.Attempts, d.NextAttemptAt.UTC(), code, nullString(d.LastError), delivered, d.ID)
+	return err
+}
+
+// RetryDelivery resets a failed delivery of the webhook to pending with no
+// attempts made, due at at, and returns the updated row
+func (s *SQLStore) RetryDelivery(ctx context.Context, webhookID, id int64, at time.Time) (*WebhookDelivery, error) {
+	ctx, done := s.begin(ctx, "retry_delivery")
+	defer done()
+
+	res, err := s.exec(ctx, `UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?
+WHERE id = ? AND webhook_id = ? AND status = ?`, DeliveryPending, at.UTC(), id, webhookID, DeliveryFailed)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrNotFound
+	}
+	return scanDelivery(s.queryRow(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id))
+}
+
+// ListDeliveries returns a page of deliveries matching f, newest first
+func (s *SQLStore) ListDeliveries(ctx context.Context, f DeliveryFilter, limit, offset int) ([]WebhookDelivery, int, error) {
+	ctx, done := s.begin(ctx, "list_deliveries")
+	defer done()
+
+	where := ` WHERE 1=1`
+	var args []interface{}
+	if f.WebhookID != 0 {
+		where += ` AND webhook_id = ?`
+		args = append(args, f.WebhookID)
+	}
+	if f.Status != "" {
+		where += ` AND status = ?`
+		args = append(args, f.Status)
+	}
+	if f.Event != "" {
+		where += ` AND event = ?`
+		args = append(args, f.Event)
+	}
+
+	var total int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM webhook_deliveries`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries` + where + ` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`
+	rows, err := s.query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, total, rows.Err()
+}
+
+// PruneDeliveries deletes finished deliveries created before before;
+// pending ones are kept however old they are
+func (s *SQLStore) PruneDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := s.begin(ctx, "prune_deliveries")
+	defer done()
+
+	res, err := s.exec(ctx, `DELETE FROM webhook_deliveries WHERE status <> ? AND created_at < ?`, DeliveryPending, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
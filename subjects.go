@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// maxErasureReasonLength bounds the reason stored in an erasure tombstone
+const maxErasureReasonLength = 1000
+
+// apiSubjectExport is the v1 JSON schema for everything stored about a
+// data subject. Person, Revocation and Deletion are null when no record is
+// stored.
+type apiSubjectExport struct {
+	NationalID  string            `json:"national_id"`
+	ExportedAt  time.Time         `json:"exported_at"`
+	Person      *apiPerson        `json:"person"`
+	Revocation  *apiRevocation    `json:"revocation"`
+	Deletion    *apiDeletion      `json:"deletion"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	Identifiers []apiIdentifier   `json:"identifiers"`
+	History     []apiPersonChange `json:"history"`
+	Attachments []apiAttachment   `json:"attachments"`
+	Audit       []apiAuditEntry   `json:"audit"`
+	Calls       []apiCall         `json:"calls"`
+	Events      []apiErrorEntry   `json:"events"`
+	Deliveries  []apiDelivery     `json:"webhook_deliveries"`
+}
+
+// apiErasure is the v1 JSON schema for an erasure tombstone
+type apiErasure struct {
+	Reference    string    `json:"reference"`
+	SubjectHash  string    `json:"subject_hash"`
+	RequestedBy  string    `json:"requested_by"`
+	Reason       string    `json:"reason"`
+	People       int64     `json:"people"`
+	AuditEntries int64     `json:"audit_entries"`
+	Calls        int64     `json:"calls"`
+	Events       int64     `json:"events"`
+	Deliveries   int64     `json:"webhook_deliveries"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// apiErasureList is the v1 JSON schema for GET /erasures
+type apiErasureList struct {
+	Erasures []apiErasure `json:"erasures"`
+}
+
+func toAPIErasure(e *store.Erasure) apiErasure {
+	return apiErasure{
+		Reference:    e.Reference,
+		SubjectHash:  e.SubjectHash,
+		RequestedBy:  e.RequestedBy,
+		Reason:       e.Reason,
+		People:       e.People,
+		AuditEntries: e.AuditEntries,
+		Calls:        e.Calls,
+		Events:       e.Events,
+		Deliveries:   e.Deliveries,
+		CreatedAt:    e.CreatedAt,
+	}
+}
+
+// dataSubject is who a data subject request is about: their stored
+// record, if any, and every form of ID they may appear under elsewhere
+type dataSubject struct {
+	NationalID  string
+	Person      *store.Person
+	Identifiers []store.Identifier
+	IDs         []string
+}
+
+// findSubject resolves the {id} of a data subject request, writing an
+// error response and returning nil when it is malformed or the lookup
+// fails. A subject without a stored record is not an error: calls and
+// logs can still mention them.
+func findSubject(w http.ResponseWriter, r *http.Request) *dataSubject {
+	id := mux.Vars(r)["id"]
+	if !validID(id) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+		return nil
+	}
+	ids, err := lookupIDs(id, "")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return nil
+	}
+
+	sub := &dataSubject{NationalID: strings.ToUpper(ids[0])}
+	// Deleted people are still data subjects
+	p, err := findPersonOrDeleted(r.Context(), ids)
+	if err == nil {
+		sub.Person = p
+		sub.NationalID = p.NationalID
+		sub.Identifiers, err = st.ListIdentifiers(r.Context(), p.NationalID)
+	} else if err == store.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		logErrorContext(r.Context(), "SUBJECT_DB_ERROR", fmt.Sprintf("Failed to look up data subject %s: %v", maskID(id), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return nil
+	}
+
+	sub.IDs = append(ids, subjectIDForms(sub.NationalID)...)
+	for _, ident := range sub.Identifiers {
+		sub.IDs = append(sub.IDs, subjectIDForms(ident.Value)...)
+	}
+	sub.IDs = dedupeIDs(sub.IDs)
+	return sub
+}
+
+// subjectIDForms returns id in each NIC format, or just id when it is not
+// an NIC
+func subjectIDForms(id string) []string {
+	if ids, err := lookupIDs(id, ""); err == nil {
+		return ids
+	}
+	return []string{id}
+}
+
+// subjectHash identifies an erased subject in the erasures table without
+// storing their ID
+func subjectHash(nationalID string) string {
+	return piiHash(strings.ToUpper(nationalID))
+}
+
+// apiSubjectExportHandler returns everything stored about a data subject:
+// their record, identifiers, and the audit entries, calls and logged
+// events that mention any form of their ID
+func apiSubjectExportHandler(w http.ResponseWriter, r *http.Request) {
+	sub := findSubject(w, r)
+	if sub == nil {
+		return
+	}
+	rec, err := st.SubjectRecords(r.Context(), sub.IDs)
+	var history []store.PersonChange
+	if err == nil && sub.Person != nil {
+		history, err = st.ListPersonHistory(r.Context(), sub.NationalID)
+	}
+	var attached []store.Attachment
+	if err == nil && sub.Person != nil {
+		attached, err = st.ListAttachments(r.Context(), sub.NationalID)
+	}
+	if err != nil {
+		logErrorContext(r.Context(), "SUBJECT_DB_ERROR", fmt.Sprintf("Failed to export data subject %s: %v", maskID(sub.NationalID), err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	export := apiSubjectExport{
+		NationalID:  sub.NationalID,
+		ExportedAt:  time.Now().UTC(),
+		Identifiers: make([]apiIdentifier, len(sub.Identifiers)),
+		History:     make([]apiPersonChange, len(history)),
+		Attachments: make([]apiAttachment, len(attached)),
+		Audit:       make([]apiAuditEntry, len(rec.Audit)),
+		Calls:       make([]apiCall, len(rec.Calls)),
+		Events:      make([]apiErrorEntry, len(rec.Events)),
+		Deliveries:  make([]apiDelivery, len(rec.Deliveries)),
+	}
+	if p := sub.Person; p != nil {
+		export.Person = toAPIPerson(r, p)
+		rev := toAPIRevocation(p)
+		export.Revocation = &rev
+		del := toAPIDeletion(p)
+		export.Deletion = &del
+		export.CreatedAt = &p.CreatedAt
+	}
+	for i := range sub.Identifiers {
+		export.Identifiers[i] = toAPIIdentifier(&sub.Identifiers[i])
+	}
+	for i := range history {
+		export.History[i] = toAPIPersonChange(&history[i])
+	}
+	for i := range attached {
+		export.Attachments[i] = toAPIAttachment(r, &attached[i])
+	}
+	for i := range rec.Audit {
+		export.Audit[i] = toAPIAuditEntry(&rec.Audit[i])
+	}
+	for i := range rec.Calls {
+		export.Calls[i] = toAPICall(&rec.Calls[i])
+	}
+	for i := range rec.Events {
+		export.Events[i] = toAPIErrorEntry(&rec.Events[i])
+	}
+	for i := range rec.Deliveries {
+		export.Deliveries[i] = toAPIDelivery(&rec.Deliveries[i])
+	}
+
+	logErrorContext(r.Context(), "SUBJECT_EXPORT_SUCCESS", fmt.Sprintf("%s exported data subject %s (%d audit entries, %d calls, %d events)",
+		requestUser(r.Context()).Username, maskID(sub.NationalID), len(rec.Audit), len(rec.Calls), len(rec.Events)))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, export)
+}
+
+// apiSubjectEraseHandler erases a data subject on request: POST
+// /subjects/{id}/erase with {"reason": "..."}. It deletes their record
+// with its attached documents and the logged events that mention them,
+// strips their ID from audit entries and calls, deletes webhook
+// deliveries about them, and stores a tombstone naming who erased them
+// and why.
+func apiSubjectEraseHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"reason": "..."}`)
+		return
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if reason == "" || len(reason) > maxErasureReasonLength {
+		writeAPIError(w, http.StatusBadRequest, "invalid_reason",
+			fmt.Sprintf("reason is required and may be at most %d characters", maxErasureReasonLength))
+		return
+	}
+
+	sub := findSubject(w, r)
+	if sub == nil {
+		return
+	}
+	nationalID := ""
+	var attached []store.Attachment
+	if sub.Person != nil {
+		nationalID = sub.Person.NationalID
+		var err error
+		if attached, err = st.ListAttachments(r.Context(), nationalID); err != nil {
+			logErrorContext(r.Context(), "SUBJECT_DB_ERROR", fmt.Sprintf("Failed to list the attachments of a data subject: %v", err))
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+	}
+	e := &store.Erasure{
+		Reference:   newReference(time.Now()),
+		SubjectHash: subjectHash(sub.NationalID),
+		RequestedBy: requestUser(r.Context()).Username,
+		Reason:      reason,
+	}
+	if err := st.EraseSubject(r.Context(), nationalID, sub.IDs, e); err != nil {
+		logErrorContext(r.Context(), "SUBJECT_DB_ERROR", fmt.Sprintf("Failed to erase data subject: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	removeAttachmentFiles(r.Context(), attached)
+
+	// The subject's ID is not logged, or the log would hold it again
+	logErrorContext(r.Context(), "SUBJECT_ERASE_SUCCESS", fmt.Sprintf("%s erased data subject %s (%d records, %d audit entries, %d calls, %d events, %d webhook deliveries)",
+		e.RequestedBy, e.Reference, e.People, e.AuditEntries, e.Calls, e.Events, e.Deliveries))
+	// Nor is it sent with the event; consumers get the tombstone only
+	publishChange(r.Context(), eventPersonErased, "", toAPIErasure(e))
+	writeJSON(w, http.StatusOK, toAPIErasure(e))
+}
+
+// apiListErasuresHandler lists erasure tombstones, newest first. With ?id=
+// it only returns those of that subject, matching any form of an NIC.
+func apiListErasuresHandler(w http.ResponseWriter, r *http.Request) {
+	var hashes []string
+	if id := r.URL.Query().Get("id"); id != "" {
+		if !validID(id) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid ID format")
+			return
+		}
+		for _, form := range subjectIDForms(id) {
+			hashes = append(hashes, subjectHash(form))
+		}
+	}
+	list, err := st.ListErasures(r.Context(), hashes)
+	if err != nil {
+		logErrorContext(r.Context(), "SUBJECT_DB_ERROR", fmt.Sprintf("Failed to list erasures: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	result := apiErasureList{Erasures: make([]apiErasure, len(list))}
+	for i := range list {
+		result.Erasures[i] = toAPIErasure(&list[i])
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// telegramSecretHeader carries the secret_token given to setWebhook
+const telegramSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// telegramSecretPattern is what Telegram accepts as a secret_token
+var telegramSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// telegramUpdate is the part of a Telegram Update the bot reads: a text
+// message and who sent it
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		From *struct {
+			ID int64 `json:"id"`
+		} `json:"from"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// telegramReply answers an update by calling sendMessage in the webhook
+// response, so the bot needs no outbound connection or bot token
+type telegramReply struct {
+	Method string `json:"method"`
+	ChatID int64  `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+// telegramUpdateContext carries the update decoded by requireTelegramUpdate
+type telegramUpdateContext struct{}
+
+// validateTelegram checks the TELEGRAM_* settings at startup
+func validateTelegram(c config) error {
+	if c.TelegramWebhookSecret != "" && !telegramSecretPattern.MatchString(c.TelegramWebhookSecret) {
+		return errors.New("TELEGRAM_WEBHOOK_SECRET may only contain letters, digits, _ and -, up to 256 characters")
+	}
+	return nil
+}
+
+// requireTelegramUpdate rejects webhook requests without the secret set
+// with setWebhook, then decodes the update into the request context so rate
+// limiting can key on the sender. The endpoint does not exist until
+// TELEGRAM_WEBHOOK_SECRET is set.
+func requireTelegramUpdate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.TelegramWebhookSecret == "" {
+			http.NotFound(w, r)
+			return
+		}
+		secret := r.Header.Get(telegramSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(cfg.TelegramWebhookSecret)) != 1 {
+			logErrorContext(r.Context(), "TELEGRAM_INVALID_SECRET", fmt.Sprintf("Rejected Telegram webhook from %s", clientIP(r)))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.MaxFormBytes))
+		var u telegramUpdate
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			logErrorContext(r.Context(), "TELEGRAM_INVALID_UPDATE", fmt.Sprintf("Failed to decode update: %v", err))
+			http.Error(w, "Invalid update", http.StatusBadRequest)
+			return
+		}
+		if u.Message == nil || u.Message.From == nil {
+			// Edits, channel posts and the like need no answer
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), telegramUpdateContext{}, &u)))
+	}
+}
+
+// telegramMessage returns the update decoded by requireTelegramUpdate
+func telegramMessage(ctx context.Context) *telegramUpdate {
+	u, _ := ctx.Value(telegramUpdateContext{}).(*telegramUpdate)
+	return u
+}
+
+// telegramSender identifies the sender of the update in ctx for rate
+// limiting and audit_log, like a caller number on Twilio channels
+func telegramSender(ctx context.Context) string {
+	if u := telegramMessage(ctx); u != nil {
+		return "telegram:" + strconv.FormatInt(u.Message.From.ID, 10)
+	}
+	return ""
+}
+
+// telegramClientKey buckets Telegram updates by the hash of the sender:
+// every update comes from Telegram's own addresses
+func telegramClientKey(r *http.Request) string {
+	if from := telegramSender(r.Context()); from != "" {
+		return callerHash(from)
+	}
+	return clientIP(r)
+}
+
+// denyTelegram answers a rate-limited Telegram message
+func denyTelegram(w http.ResponseWriter, r *http.Request) {
+	writeTelegramReply(w, r, textMsg("rate_limited"))
+}
+
+// telegramHandler answers a message sent to the bot with the verification
+// result for the ID in it, using the same lookup as SMS and WhatsApp
+func telegramHandler(w http.ResponseWriter, r *http.Request) {
+	text := strings.TrimSpace(telegramMessage(r.Context()).Message.Text)
+	if cmd, rest := telegramCommand(text); cmd != "" {
+		if cmd != "verify" || rest == "" {
+			writeTelegramReply(w, r, textMsg("telegram_help"))
+			return
+		}
+		text = rest
+	}
+
+	input := normalizeSpokenID(stripSMSKeyword(text))
+	p, reply, result := messagingLookup(r.Context(), "TELEGRAM", input)
+	if input == "" {
+		reply = textMsg("telegram_help")
+	}
+	matchedID := ""
+	if p != nil {
+		matchedID = p.NationalID
+	}
+	if result == callResultMatch {
+		check := recordMatch(r, auditChannelTelegram, input, p)
+		reply = telegramText(r, p, check)
+	} else {
+		recordAudit(r, auditChannelTelegram, input, result, matchedID)
+	}
+	writeTelegramReply(w, r, reply)
+}
+
+// telegramCommand splits a bot command such as "/verify@our_bot 1234V"
+// into its name and argument; cmd is empty when text is not a command
+func telegramCommand(text string) (cmd, rest string) {
+	if !strings.HasPrefix(text, "/") {
+		return "", ""
+	}
+	cmd, rest, _ = strings.Cut(text[1:], " ")
+	cmd, _, _ = strings.Cut(cmd, "@")
+	return strings.ToLower(cmd), strings.TrimSpace(rest)
+}
+
+// writeTelegramReply sends text back to the chat the update came from
+func writeTelegramReply(w http.ResponseWriter, r *http.Request, text string) {
+	u := telegramMessage(r.Context())
+	if u == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeJSON(w, http.StatusOK, telegramReply{Method: "sendMessage", ChatID: u.Message.Chat.ID, Text: text})
+}
+
+// telegramText formats a verification result as a plain-text Telegram
+// message with a link to the HTML view and the reference of check
+func telegramText(r *http.Request, p *store.Person, check *store.AuditEntry) string {
+	var b strings.Builder
+	b.WriteString("Verification result\n\n")
+	fmt.Fprintf(&b, "ID: %s\nFull name: %s\n", p.NationalID, p.FullName)
+	state := credentialState(p)
+	if !p.ExpiryDate.IsZero() {
+		fmt.Fprintf(&b, "Valid until: %s\n", formatDate(p.ExpiryDate))
+	}
+	if c := categoryOf(r.Context(), p.Category); c.ShowCourses {
+		b.WriteString("Courses completed:\n")
+		for _, course := range p.Courses {
+			fmt.Fprintf(&b, "• %s\n", course)
+		}
+		b.WriteString("\n" + plainStatus(state))
+	} else {
+		fmt.Fprintf(&b, "Category: %s\nRemarks: %s", c.Label, stripHTML(remarkText(remarksFor(p, auditChannelTelegram))))
+		if state != stateValid {
+			b.WriteString("\n" + plainStatus(state))
+		}
+	}
+	fmt.Fprintf(&b, "\n\nReference: %s\nChecked %s", check.Reference, check.CreatedAt.Format(checkedAtLayout))
+	fmt.Fprintf(&b, "\n\nView online: %s", verifyURL(r, p.NationalID))
+	return b.String()
+}
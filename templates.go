@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// The built-in page templates. Any file of the same name in TEMPLATES_DIR
+// replaces its embedded copy, so layouts can be edited without rebuilding.
+//
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+// pages holds the parsed HTML templates; main loads it at startup
+var pages *template.Template
+
+// Page templates executed by name
+const (
+	pageStudent   = "student.html"
+	pageStaff     = "staff.html"
+	pageNotFound  = "not_found.html"
+	pageError     = "error.html"
+	pageChallenge = "challenge.html"
+	pageEmailSent = "email_sent.html"
+
+	pageMaintenance = "maintenance.html"
+
+	// tmplEmailVerification is the HTML body of an emailed verification
+	tmplEmailVerification = "email_verification.html"
+)
+
+// pageContext is embedded in the data of every page: the language chosen
+// by pageLang, the institution whose wording and branding it shows and
+// that branding
+type pageContext struct {
+	Lang        string
+	Institution string
+	Brand       branding
+}
+
+// T returns the page text for key in the page language
+func (c pageContext) T(key string) string {
+	return institutionPageMsg(c.Institution, c.Lang, key)
+}
+
+// newPageContext returns the page context for r, in the institution of the
+// host name or API key it was made with
+func newPageContext(r *http.Request) pageContext {
+	return institutionPageContext(r, requestInstitution(r.Context()))
+}
+
+// institutionPageContext returns the page context for r in the institution
+func institutionPageContext(r *http.Request, institution string) pageContext {
+	return pageContext{Lang: pageLang(r), Institution: institution, Brand: institutionBranding(r.Context(), institution)}
+}
+
+// verifyPage is the data for the student and staff templates
+type verifyPage struct {
+	pageContext
+	ID       string
+	FullName string
+	Category string
+	// CategoryLabel is how Category is displayed and ShowCourses whether
+	// results in it list Courses rather than Remark
+	CategoryLabel string
+	ShowCourses   bool
+	Courses       []string
+	IssueDate     string
+	ExpiryDate    string
+	// State is valid, expired or revoked
+	State string
+	// Remark is the trusted HTML of the remarks the channel may show,
+	// entered by staff and rendered as-is
+	Remark template.HTML
+	// QRCode is a data: URI of the QR code PNG; empty hides it
+	QRCode template.URL
+	QRSize int
+	// PhotoURL links to the person's photograph; empty when there is none
+	PhotoURL string
+	// Reference and CheckedAt identify this check so it can be cited
+	Reference string
+	CheckedAt string
+	// EmailAction is where the form emailing this result posts to; empty
+	// hides the form
+	EmailAction string
+}
+
+// errorPage is the data for the not-found and error templates
+type errorPage struct {
+	pageContext
+	Status  int
+	Message string
+}
+
+// challengePage is the data for the CAPTCHA challenge template: the widget
+// and the /verify parameters it resubmits once solved
+type challengePage struct {
+	pageContext
+	Message     string
+	Script      string
+	WidgetClass string
+	SiteKey     string
+	Params      url.Values
+}
+
+// loadTemplates parses the embedded templates and then any *.html files in
+// dir, which override embedded ones with the same name. A missing dir is not
+// an error. It returns the directory actually used, or "" for embedded only.
+func loadTemplates(dir string) (*template.Template, string, error) {
+	t, err := template.ParseFS(embeddedTemplates, "templates/*.html")
+	if err != nil {
+		return nil, "", err
+	}
+	if dir == "" {
+		return t, "", nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return t, "", nil
+	}
+	files, err := fs.Glob(os.DirFS(dir), "*.html")
+	if err != nil || len(files) == 0 {
+		return t, "", err
+	}
+	for i := range files {
+		files[i] = filepath.Join(dir, files[i])
+	}
+	if t, err = t.ParseFiles(files...); err != nil {
+		return nil, "", err
+	}
+	return t, dir, nil
+}
+
+// renderPage executes the named template into a buffer first, so a
+// template error produces a clean 500 instead of a half-written page
+func renderPage(w http.ResponseWriter, status int, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := pages.ExecuteTemplate(&buf, name, data); err != nil {
+		logError("TEMPLATE_ERROR", fmt.Sprintf("Failed to render %s: %v", name, err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept-Language")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// newVerifyPage builds the template data for a found person; check is the
+// audit row of this lookup. It shows the person's institution.
+func newVerifyPage(r *http.Request, id string, p *store.Person, check *store.AuditEntry) verifyPage {
+	page := verifyPage{
+		pageContext: institutionPageContext(r, p.InstitutionID),
+		ID:          id,
+		FullName:    p.FullName,
+		Category:    p.Category,
+		Courses:     p.Courses,
+		IssueDate:   formatDate(p.IssueDate),
+		ExpiryDate:  formatDate(p.ExpiryDate),
+		State:       credentialState(p),
+		Remark:      template.HTML(remarkText(remarksFor(p, check.Channel))),
+		QRSize:      qrEmbedSize,
+		Reference:   check.Reference,
+		CheckedAt:   check.CreatedAt.Format(checkedAtLayout),
+	}
+	c := categoryOf(r.Context(), p.Category)
+	page.CategoryLabel, page.ShowCourses = c.Label, c.ShowCourses
+	if p.HasPhoto {
+		page.PhotoURL = photoURL(r, p.NationalID)
+	}
+	// The QR code links to this record's page, e.g. for printed certificates
+	if uri := qrDataURI(r, p.NationalID); uri != "" {
+		page.QRCode = template.URL(uri)
+	}
+	if cfg.SMTPHost != "" && check.Reference != "" {
+		page.EmailAction = publicBaseURL(r) + "/verify/email"
+	}
+	return page
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// TOTP parameters (RFC 6238 defaults, which every authenticator app supports)
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many periods either side of now are accepted, for
+	// clock drift and slow typing
+	totpSkew = 1
+
+	pageAdmin2FA = "admin_2fa.html"
+	// recoveryCodeCount codes are issued when two-factor login is enabled
+	recoveryCodeCount = 10
+)
+
+// totpEncoding is base32 without padding, as authenticator apps expect
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// recoveryAlphabet avoids characters that are easily confused on paper
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// twoFactorPage is the data for the two-factor settings template
+type twoFactorPage struct {
+	User    *store.User
+	Enabled bool
+	// Required is set when TWO_FACTOR_REQUIRED sent the user here
+	Required bool
+	// Secret and QRCode are shown while enrolling
+	Secret string
+	QRCode template.URL
+	// RecoveryCodes are shown once, right after they are issued
+	RecoveryCodes []string
+	CodesLeft     int
+	Error         string
+}
+
+// newTOTPSecret returns a random 160-bit secret in base32
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode is the code for a time step (RFC 4226 HOTP with HMAC-SHA1)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000)
+}
+
+// checkTOTP returns the time step code is valid for at t, or ok=false
+func checkTOTP(secret, code string, t time.Time) (step int64, ok bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	now := t.Unix() / totpPeriod
+	for s := now - totpSkew; s <= now+totpSkew; s++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI is the otpauth:// link an authenticator app scans
+func totpURI(ctx context.Context, username, secret string) string {
+	issuer := currentBranding(ctx).Name
+	if issuer == "" {
+		issuer = "Verification admin"
+	}
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+username) + "?" + q.Encode()
+}
+
+// newRecoveryCodes returns recoveryCodeCount codes like "abcde-fghjk"
+func newRecoveryCodes() ([]string, error) {
+	// Bytes past the last multiple of the alphabet size are skipped, so
+	// every character is equally likely
+	limit := byte(256 / len(recoveryAlphabet) * len(recoveryAlphabet))
+	codes := make([]string, recoveryCodeCount)
+	buf := make([]byte, 1)
+	for i := range codes {
+		code := make([]byte, 0, 11)
+		for len(code) < 11 {
+			if len(code) == 5 {
+				code = append(code, '-')
+				continue
+			}
+			if _, err := rand.Read(buf); err != nil {
+				return nil, err
+			}
+			if buf[0] < limit {
+				code = append(code, recoveryAlphabet[int(buf[0])%len(recoveryAlphabet)])
+			}
+		}
+		codes[i] = string(code)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode lower-cases a typed recovery code and drops
+// separators, so it hashes like the issued one
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// checkSecondFactor accepts a current TOTP code that has not been used yet,
+// or one of the user's unused recovery codes, which it consumes
+func checkSecondFactor(ctx context.Context, u *store.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+	if len(code) == totpDigits {
+		secret, enabled, _, err := st.UserTOTP(ctx, u.ID)
+		if err != nil || !enabled {
+			return false, err
+		}
+		step, ok := checkTOTP(secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return st.UseTOTPStep(ctx, u.ID, step)
+	}
+	ok, err := st.UseRecoveryCode(ctx, u.ID, hashToken(normalizeRecoveryCode(code)))
+	if ok {
+		logErrorContext(ctx, "AUTH_RECOVERY_CODE", fmt.Sprintf("User %s logged in with a recovery code", u.Username))
+	}
+	return ok, err
+}
+
+// sessionOnlyUser returns the user of a page request that was made with a
+// session cookie, writing a 400 for token access: two-factor settings
+// belong to a user logging in with a password
+func sessionOnlyUser(w http.ResponseWriter, r *http.Request) *store.User {
+	u := requestUser(r.Context())
+	if u == nil || u.ID == 0 || !hasSession(r) {
+		http.Error(w, "Sign in with a password to manage two-factor login", http.StatusBadRequest)
+		return nil
+	}
+	return u
+}
+
+func render2FA(w http.ResponseWriter, status int, page twoFactorPage) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	renderPage(w, status, pageAdmin2FA, page)
+}
+
+// enrollmentPage fills page with the user's pending secret, creating one
+// if there is none yet
+func enrollmentPage(r *http.Request, page *twoFactorPage) error {
+	secret, _, _, err := st.UserTOTP(r.Context(), page.User.ID)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		if secret, err = newTOTPSecret(); err != nil {
+			return err
+		}
+		if err := st.SetUserTOTPSecret(r.Context(), page.User.ID, secret); err != nil {
+			return err
+		}
+	}
+	png, err := qrcode.Encode(totpURI(r.Context(), page.User.Username, secret), qrcode.Medium, 200)
+	if err != nil {
+		return err
+	}
+	page.Secret = secret
+	page.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	return nil
+}
+
+// admin2FAHandler shows the two-factor status, or the QR code and secret to
+// enroll with when it is off
+func admin2FAHandler(w http.ResponseWriter, r *http.Request) {
+	u := sessionOnlyUser(w, r)
+	if u == nil {
+		return
+	}
+	page := twoFactorPage{User: u, Enabled: u.TOTPEnabled, Required: cfg.TwoFactorRequired && !u.TOTPEnabled}
+	var err error
+	if u.TOTPEnabled {
+		page.CodesLeft, err = st.RecoveryCodesLeft(r.Context(), u.ID)
+	} else {
+		err = enrollmentPage(r, &page)
+	}
+	if err != nil {
+		logErrorContext(r.Context(), "AUTH_DB_ERROR", fmt.Sprintf("Failed to load two-factor settings of %s: %v", u.Username, err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	render2FA(w, http.StatusOK, page)
+}
+
+// admin2FAEnableHandler enables the pending secret once the user enters a
+// code from it, and shows their recovery codes. When two-factor login is
+// already on, a valid code issues new recovery codes instead.
+func admin2FAEnableHandler(w http.ResponseWriter, r *http.Request) {
+	u := sessionOnlyUser(w, r)
+	if u == nil {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	code := strings.TrimSpace(r.PostFormValue("code"))
+	fail := func(err error) {
+		logErrorContext(r.Context(), "AUTH_DB_ERROR", fmt.Sprintf("Failed to update two-factor settings of %s: %v", u.Username, err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+
+	secret, enabled, lastStep, err := st.UserTOTP(r.Context(), u.ID)
+	if err != nil {
+		fail(err)
+		return
+	}
+	step, ok := checkTOTP(secret, code, time.Now())
+	if !ok || (enabled && step <= lastStep) {
+		page := twoFactorPage{User: u, Enabled: enabled, Required: cfg.TwoFactorRequired && !enabled, Error: "That code is not valid, please try again"}
+		if enabled {
+			page.CodesLeft, err = st.RecoveryCodesLeft(r.Context(), u.ID)
+		} else {
+			err = enrollmentPage(r, &page)
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		render2FA(w, http.StatusBadRequest, page)
+		return
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		fail(err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashToken(normalizeRecoveryCode(c))
+	}
+	if err := st.EnableUserTOTP(r.Context(), u.ID, step, hashes); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+			return
+		}
+		fail(err)
+		return
+	}
+	if enabled {
+		logErrorContext(r.Context(), "AUTH_2FA_SUCCESS", fmt.Sprintf("User %s issued new recovery codes", u.Username))
+	} else {
+		logErrorContext(r.Context(), "AUTH_2FA_SUCCESS", fmt.Sprintf("User %s enabled two-factor login", u.Username))
+	}
+	u.TOTPEnabled = true
+	render2FA(w, http.StatusOK, twoFactorPage{User: u, Enabled: true, RecoveryCodes: codes, CodesLeft: len(codes)})
+}
+
+// apiResetUserTOTPHandler turns off a user's two-factor login, for a lost
+// phone; they enroll again at their next login when it is required
+func apiResetUserTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := st.SetUserTOTPSecret(r.Context(), id, "")
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No user with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "USERS_DB_ERROR", fmt.Sprintf("Failed to reset two-factor login of user %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "USERS_2FA_SUCCESS", fmt.Sprintf("%s turned off two-factor login for user %d",
+		requestUser(r.Context()).Username, id))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	// RFC 6238 appendix B, SHA-1, with the 8-digit values cut to 6
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	step := at.Unix() / totpPeriod
+	tests := []struct {
+		name, secret, code string
+		wantStep           int64
+		ok                 bool
+	}{
+		{name: "current", secret: rfc6238Secret, code: "050471", wantStep: step, ok: true},
+		{name: "previous period", secret: rfc6238Secret, code: totpCode([]byte("12345678901234567890"), step-1), wantStep: step - 1, ok: true},
+		{name: "next period", secret: rfc6238Secret, code: totpCode([]byte("12345678901234567890"), step+1), wantStep: step + 1, ok: true},
+		{name: "two periods late", secret: rfc6238Secret, code: totpCode([]byte("12345678901234567890"), step-2)},
+		{name: "wrong code", secret: rfc6238Secret, code: "123456"},
+		{name: "8 digits", secret: rfc6238Secret, code: "14050471"},
+		{name: "empty code", secret: rfc6238Secret, code: ""},
+		{name: "bad secret", secret: "not base32!", code: "050471"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := checkTOTP(tt.secret, tt.code, at)
+			if ok != tt.ok || got != tt.wantStep {
+				t.Errorf("checkTOTP(%q) = %d, %v; want %d, %v", tt.code, got, ok, tt.wantStep, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, %v; want 20", secret, len(key), err)
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	shape := regexp.MustCompile(`^[` + recoveryAlphabet + `]{5}-[` + recoveryAlphabet + `]{5}$`)
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if !shape.MatchString(c) {
+			t.Errorf("code %q is not five and five characters of the recovery alphabet", c)
+		}
+		if seen[c] {
+			t.Errorf("code %q issued twice", c)
+		}
+		seen[c] = true
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// callerCheckMaxAge is how long after a voice check its result can be read
+// again or texted; it only needs to cover the rest of the call
+const callerCheckMaxAge = 15 * time.Minute
+
+// charToWord maps characters to their spoken form for digit-by-digit
+// reading. Letters use the NATO phonetic alphabet, which callers can tell
+// apart on a phone line where "bee", "dee" and "vee" sound alike.
+var charToWord = map[rune]string{
+	'0': "zero", '1': "one", '2': "two", '3': "three", '4': "four",
+	'5': "five", '6': "six", '7': "seven", '8': "eight", '9': "nine",
+	'a': "alpha", 'b': "bravo", 'c': "charlie", 'd': "delta", 'e': "echo",
+	'f': "foxtrot", 'g': "golf", 'h': "hotel", 'i': "india", 'j': "juliett",
+	'k': "kilo", 'l': "lima", 'm': "mike", 'n': "november", 'o': "oscar",
+	'p': "papa", 'q': "quebec", 'r': "romeo", 's': "sierra", 't': "tango",
+	'u': "uniform", 'v': "victor", 'w': "whiskey", 'x': "x-ray", 'y': "yankee",
+	'z': "zulu",
+}
+
+// stripHTML removes HTML tags and converts <br> to periods for natural speech
+func stripHTML(input string) string {
+	// Replace <br> with periods
+	input = strings.ReplaceAll(input, "<br>", ". ")
+	// Simple regex to remove HTML tags
+	re := regexp.MustCompile(`<[^>]+>`)
+	clean := re.ReplaceAllString(input, "")
+	// Remove extra spaces and normalize
+	clean = strings.TrimSpace(clean)
+	clean = regexp.MustCompile(`\s+`).ReplaceAllString(clean, " ")
+	return clean
+}
+
+func twilioVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	// Extract input from direct form fields (case-insensitive)
+	input := r.PostFormValue("Digits")
+	if input == "" {
+		input = r.PostFormValue("digits")
+	}
+	if input == "" {
+		input = r.PostFormValue("SpeechResult")
+	}
+	if input == "" {
+		input = r.PostFormValue("speechresult")
+	}
+
+	// Fallback: Check if 'body' parameter contains Digits and SpeechResult
+	if input == "" {
+		body := r.PostFormValue("body")
+		if body != "" {
+			// Remove leading '?' if present
+			body = strings.TrimPrefix(body, "?")
+			// Decode URL-encoded body
+			parsed, err := url.ParseQuery(body)
+			if err != nil {
+				logErrorContext(r.Context(), "TWILIO_INVALID_BODY", fmt.Sprintf("Failed to parse body parameter: %v", err))
+				http.Error(w, "Invalid body parameter", http.StatusBadRequest)
+				return
+			}
+			input = parsed.Get("Digits")
+			if input == "" {
+				input = parsed.Get("digits")
+			}
+			if input == "" {
+				input = parsed.Get("SpeechResult")
+			}
+			if input == "" {
+				input = parsed.Get("speechresult")
+			}
+		}
+	}
+
+	if input == "" {
+		logErrorContext(r.Context(), "TWILIO_NO_INPUT", "No input provided in Digits or SpeechResult")
+		http.Error(w, "No input provided", http.StatusBadRequest)
+		return
+	}
+
+	// Convert spoken words ("one two vee") to the canonical ID and remove spaces
+	input = normalizeSpokenID(input)
+
+	attempt := twilioAttempt(r)
+	lang := twilioLang(r)
+
+	// Validate input (alphanumeric, max 50 chars)
+	if !validID(input) {
+		logErrorContext(r.Context(), "TWILIO_INVALID_INPUT", fmt.Sprintf("Invalid input format: %s (attempt %d)", maskID(input), attempt))
+		twilioLookupsTotal.WithLabelValues("invalid").Inc()
+		recordCall(r, input, callResultInvalid, "")
+		recordAudit(r, auditChannelVoice, input, callResultInvalid, "")
+		writeTwilioRetry(w, r, lang, attempt, twilioMsg(lang, "invalid"))
+		return
+	}
+
+	// Reject malformed NIC numbers before reading them back
+	if _, err := lookupIDs(input, ""); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_NIC", fmt.Sprintf("Invalid NIC %s (attempt %d): %v", maskID(input), attempt, err))
+		twilioLookupsTotal.WithLabelValues("invalid").Inc()
+		recordCall(r, input, callResultInvalid, "")
+		recordAudit(r, auditChannelVoice, input, callResultInvalid, "")
+		writeTwilioRetry(w, r, lang, attempt, twilioMsg(lang, "invalid"))
+		return
+	}
+
+	// Read the ID back and wait for the caller to confirm before disclosing anything
+	if cfg.TwilioConfirmInput {
+		recordCall(r, input, callResultConfirm, "")
+		writeTwilioConfirm(w, r, lang, attempt, input)
+		return
+	}
+	result, matchedID := writeTwilioResult(w, r, lang, attempt, input)
+	recordCall(r, input, result, matchedID)
+}
+
+// twilioConfirmHandler handles the caller's answer to the read-back:
+// 1 looks the ID up and reads the result, 2 re-prompts for the ID, and
+// anything else repeats the confirmation question.
+func twilioConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	attempt := twilioAttempt(r)
+	lang := twilioLang(r)
+	input := r.URL.Query().Get("id")
+	if input == "" {
+		input = callState(r).Input
+	}
+	if !validID(input) {
+		logErrorContext(r.Context(), "TWILIO_INVALID_INPUT", fmt.Sprintf("Invalid input format in confirmation: %s", maskID(input)))
+		recordCall(r, input, callResultInvalid, "")
+		recordAudit(r, auditChannelVoice, input, callResultInvalid, "")
+		writeTwilioRetry(w, r, lang, attempt, twilioMsg(lang, "invalid"))
+		return
+	}
+
+	switch r.PostFormValue("Digits") {
+	case "1":
+		result, matchedID := writeTwilioResult(w, r, lang, attempt, input)
+		recordCall(r, input, result, matchedID)
+	case "2":
+		logErrorContext(r.Context(), "TWILIO_REENTER", fmt.Sprintf("Caller rejected read-back of %s (attempt %d)", maskID(input), attempt))
+		recordCall(r, input, callResultReenter, "")
+		writeTwilioRetry(w, r, lang, attempt, "")
+	default:
+		writeTwilioConfirm(w, r, lang, attempt, input)
+	}
+}
+
+// writeTwilioConfirm reads the entered ID back in groups, pausing between
+// them, and asks the caller to press 1 to confirm or 2 to re-enter it
+func writeTwilioConfirm(w http.ResponseWriter, r *http.Request, lang string, attempt int, input string) {
+	updateCallState(r, func(s *callSession) { s.Attempt, s.Input = attempt, input })
+	writeTwiML(w,
+		twimlGather{
+			Input:     "dtmf",
+			NumDigits: 1,
+			Action:    twilioURL("/twilio/confirm", url.Values{"lang": {lang}}),
+			Method:    "POST",
+			Timeout:   cfg.TwilioGatherTimeout,
+			Verbs:     readBack(lang, twilioMsg(lang, "confirm"), input),
+		},
+		say(lang, twilioMsg(lang, "no_input")),
+	)
+}
+
+// writeTwilioResult looks input up and reads the person's details and the
+// verification reference, or re-prompts the caller when there is no match.
+// It records the lookup in audit_log and returns the call result and the
+// matched national ID for the calls table.
+func writeTwilioResult(w http.ResponseWriter, r *http.Request, lang string, attempt int, input string) (string, string) {
+	ctx := r.Context()
+
+	ids, err := lookupIDs(input, "")
+	if err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_NIC", fmt.Sprintf("Invalid NIC %s (attempt %d): %v", maskID(input), attempt, err))
+		twilioLookupsTotal.WithLabelValues("invalid").Inc()
+		recordAudit(r, auditChannelVoice, input, callResultInvalid, "")
+		writeTwilioRetry(w, r, lang, attempt, twilioMsg(lang, "invalid"))
+		return callResultInvalid, ""
+	}
+
+	p, err := findPerson(ctx, ids, "")
+	if breakerRefused(w, err) {
+		denyBusyTwilio(w, r)
+		return callResultError, ""
+	}
+	if err != nil && err != store.ErrNotFound {
+		// A slow or unavailable database must not leave the caller in
+		// silence until Twilio's webhook timeout; apologise and hang up
+		if store.IsTimeout(err) {
+			logErrorContext(r.Context(), "TWILIO_DB_TIMEOUT", fmt.Sprintf("Lookup timed out for input %s: %v", maskID(input), err))
+		} else {
+			logErrorContext(r.Context(), "TWILIO_DB_ERROR", fmt.Sprintf("Database error for input %s: %v", maskID(input), err))
+		}
+		twilioLookupsTotal.WithLabelValues("error").Inc()
+		recordAudit(r, auditChannelVoice, input, callResultError, "")
+		writeTwiML(w, say(lang, twilioMsg(lang, "unavailable")), twimlHangup{})
+		return callResultError, ""
+	}
+
+	// Callers are never API partners, so opted-out people are not read out
+	if err == nil && !p.PublicLookup {
+		twilioLookupsTotal.WithLabelValues("restricted").Inc()
+		noteLookup(ctx, true, 0)
+		recordAudit(r, auditChannelVoice, input, callResultRestricted, p.NationalID)
+		logErrorContext(r.Context(), "TWILIO_RESTRICTED", fmt.Sprintf("Input %s opted out of public lookups", maskID(input)))
+		writeTwiML(w, say(lang, twilioMsg(lang, "restricted")), twimlHangup{})
+		return callResultRestricted, p.NationalID
+	}
+
+	if err == nil {
+		twilioLookupsTotal.WithLabelValues("match").Inc()
+		noteLookup(ctx, true, 0)
+		check := recordMatch(r, auditChannelVoice, input, p)
+		updateCallState(r, func(s *callSession) { s.Input, s.Reference = input, check.Reference })
+		logErrorContext(r.Context(), "TWILIO_SUCCESS", fmt.Sprintf("Verified input: %s, Name: %s, Category: %s, Remark: %s, Reference: %s", maskID(input), piiText(p.FullName), twilioCategory(r.Context(), lang, p.Category), piiText(stripHTML(remarkText(remarksFor(p, auditChannelVoice)))), check.Reference))
+		writeTwiML(w, twilioResultVerbs(r, lang, input, p, check)...)
+		return callResultMatch, p.NationalID
+	}
+
+	// Generate TwiML for no match, including digit-by-digit input
+	twilioLookupsTotal.WithLabelValues("no_match").Inc()
+	noteLookup(ctx, false, 1)
+	recordAudit(r, auditChannelVoice, input, callResultNoMatch, "")
+	logErrorContext(r.Context(), "TWILIO_NO_MATCH", fmt.Sprintf("No match found for input: %s (attempt %d)", maskID(input), attempt))
+	writeTwilioRetry(w, r, lang, attempt, fmt.Sprintf(twilioMsg(lang, "no_match"), spokenID(lang, input)))
+	return callResultNoMatch, ""
+}
+
+// twilioCategory is a person's category as it is spoken
+func twilioCategory(ctx context.Context, lang, category string) string {
+	return spokenLabel(categoryOf(ctx, category), lang)
+}
+
+// twilioResultVerbs reads out the result of check: the entered ID, name and
+// category, the remark a sentence at a time, the credential state and the
+// reference, then offers to repeat it, check another ID or, when SMS is
+// set up, text it to the caller, in the wording of the person's institution
+func twilioResultVerbs(r *http.Request, lang, input string, p *store.Person, check *store.AuditEntry) []interface{} {
+	// The person answering an outbound call did not enter the ID
+	key := "result"
+	if check.Reference == callState(r).Outbound {
+		key = "outbound_result"
+	}
+	verbs := []interface{}{say(lang, fmt.Sprintf(institutionTwilioMsg(p.InstitutionID, lang, key), spokenID(lang, input), p.FullName, twilioCategory(r.Context(), lang, p.Category)))}
+	if sentences := remarkSentences(stripHTML(remarkText(remarksFor(p, auditChannelVoice)))); len(sentences) > 0 {
+		verbs = append(verbs, say(lang, institutionTwilioMsg(p.InstitutionID, lang, "remark")))
+		for i, sentence := range sentences {
+			if i > 0 {
+				verbs = append(verbs, twimlPause{Length: 1})
+			}
+			verbs = append(verbs, say(lang, sentence))
+		}
+		verbs = append(verbs, twimlPause{Length: 1})
+	}
+	switch credentialState(p) {
+	case stateRevoked:
+		verbs = append(verbs, say(lang, institutionTwilioMsg(p.InstitutionID, lang, "revoked")))
+	case stateExpired:
+		verbs = append(verbs, say(lang, fmt.Sprintf(institutionTwilioMsg(p.InstitutionID, lang, "expired"), formatDate(p.ExpiryDate))))
+	}
+	verbs = append(verbs, say(lang, fmt.Sprintf(institutionTwilioMsg(p.InstitutionID, lang, "reference"),
+		spokenID(lang, check.Reference), check.CreatedAt.Format(checkedAtLayout))))
+
+	options := []interface{}{say(lang, institutionTwilioMsg(p.InstitutionID, lang, "result_options"))}
+	// Remarks are hard to write down during a call; offer them by text
+	if smsFollowUpEnabled() && strings.HasPrefix(twilioCaller(r), "+") {
+		options = append(options, say(lang, institutionTwilioMsg(p.InstitutionID, lang, "followup_offer")))
+	}
+	return append(verbs,
+		twimlGather{
+			Input:     "dtmf",
+			NumDigits: 1,
+			Action:    twilioURL("/twilio/result", url.Values{"lang": {lang}}),
+			Method:    "POST",
+			Timeout:   cfg.TwilioGatherTimeout,
+			Verbs:     options,
+		},
+		say(lang, institutionTwilioMsg(p.InstitutionID, lang, "goodbye")),
+	)
+}
+
+// sentenceEnd matches the end of a sentence inside a remark
+var sentenceEnd = regexp.MustCompile(`[.!?;]+\s+`)
+
+// remarkSentences splits a remark into sentences, each read as its own
+// <Say> so a long remark is not one breathless stream
+func remarkSentences(remark string) []string {
+	var sentences []string
+	start := 0
+	for _, loc := range sentenceEnd.FindAllStringIndex(remark, -1) {
+		sentences = appendSentence(sentences, remark[start:loc[1]])
+		start = loc[1]
+	}
+	return appendSentence(sentences, remark[start:])
+}
+
+func appendSentence(sentences []string, s string) []string {
+	s = strings.TrimSpace(s)
+	// stripHTML turns "house.<br>" into "house.. "; a bare "." left by
+	// <br><br> is not worth a pause
+	if strings.HasSuffix(s, "..") {
+		s = strings.TrimRight(s, ".") + "."
+	}
+	if strings.Trim(s, ".!?; ") != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// twilioResultHandler answers the caller's key press after a voice result:
+// 1 reads the result again, 2 asks for another ID and 3 texts the result
+// (twilioFollowUpHandler). Anything else, or silence, ends the call.
+func twilioResultHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+	lang := twilioLang(r)
+	switch r.PostFormValue("Digits") {
+	case "1":
+		twilioRepeatResult(w, r, lang)
+	case "2":
+		recordCall(r, "", callResultAnother, "")
+		writeTwilioPrompt(w, r, lang, "")
+	case followUpDigit:
+		twilioFollowUpHandler(w, r)
+	default:
+		writeTwiML(w, say(lang, twilioMsg(lang, "goodbye")), twimlHangup{})
+	}
+}
+
+// twilioRepeatResult reads the result of the caller's check again, as the
+// person's record stands now, without counting a new lookup
+func twilioRepeatResult(w http.ResponseWriter, r *http.Request, lang string) {
+	ctx := r.Context()
+	ref := callReference(r)
+	check, p, refusal, err := callerCheck(r, ref)
+	if breakerRefused(w, err) {
+		denyBusyTwilio(w, r)
+		return
+	}
+	if err != nil {
+		logErrorContext(ctx, "TWILIO_DB_ERROR", fmt.Sprintf("Failed to load verification %s: %v", ref, err))
+		recordCall(r, "", callResultError, "")
+		writeTwiML(w, say(lang, twilioMsg(lang, "unavailable")), twimlHangup{})
+		return
+	}
+	if refusal != "" {
+		logErrorContext(ctx, "TWILIO_REPEAT_REFUSED", fmt.Sprintf("Refused to repeat verification %s to %s: %s", ref, maskCaller(twilioCaller(r)), refusal))
+		recordCall(r, "", callResultError, "")
+		writeTwiML(w, say(lang, twilioMsg(lang, "goodbye")), twimlHangup{})
+		return
+	}
+	input := r.URL.Query().Get("id")
+	if input == "" {
+		input = callState(r).Input
+	}
+	if !validID(input) {
+		input = p.NationalID
+	}
+	recordCall(r, input, callResultRepeat, p.NationalID)
+	writeTwiML(w, twilioResultVerbs(r, lang, input, p, check)...)
+}
+
+// callReference is the reference of the result the caller last heard
+func callReference(r *http.Request) string {
+	if ref := r.URL.Query().Get("ref"); ref != "" {
+		return ref
+	}
+	return callState(r).Reference
+}
+
+// callerCheck loads the voice check ref and the person it matched for a
+// key press after the result. refusal says why the caller may not have it:
+// the check must have been made from the same number (or be the one an
+// outbound call was placed for) within callerCheckMaxAge and the person must
+// still be publicly verifiable.
+func callerCheck(r *http.Request, ref string) (check *store.AuditEntry, p *store.Person, refusal string, err error) {
+	ctx := r.Context()
+	check, err = st.GetAuditByReference(ctx, ref)
+	if err == store.ErrNotFound {
+		return nil, nil, "no such check", nil
+	} else if err != nil {
+		return nil, nil, "", err
+	}
+	outbound := ref == callState(r).Outbound
+	if !outbound && (check.Channel != auditChannelVoice || check.Source != maskCaller(twilioCaller(r))) {
+		return nil, nil, "not checked by this caller", nil
+	}
+	if time.Since(check.CreatedAt) > callerCheckMaxAge {
+		return nil, nil, "check too old", nil
+	}
+	p, err = st.GetByNationalID(ctx, check.MatchedID)
+	if err != nil {
+		return nil, nil, fmt.Sprintf("person not available: %v", err), nil
+	}
+	// An outbound call is placed by the registrar's staff, who may verify
+	// people who opted out
+	if !p.PublicLookup && !outbound {
+		return nil, nil, "opted out of public lookups", nil
+	}
+	return check, p, "", nil
+}
+
+// twilioAttempt returns the 1-based number of the ID attempt, kept in the
+// call session (or ?attempt=N in Gather URLs from before sessions)
+func twilioAttempt(r *http.Request) int {
+	return attemptNumber(r.URL.Query().Get("attempt"), callState(r).Attempt)
+}
+
+// attemptNumber parses a 1-based attempt from the query, falling back to
+// the session's count
+func attemptNumber(query string, session int) int {
+	attempt, err := strconv.Atoi(query)
+	if err != nil {
+		attempt = session
+	}
+	if attempt < 1 {
+		return 1
+	}
+	return attempt
+}
+
+// writeTwilioRetry says message and, while attempts remain, re-prompts the
+// caller with a <Gather> that posts the next attempt back to /twilio/verify.
+// Once TWILIO_MAX_ATTEMPTS is reached the call ends.
+func writeTwilioRetry(w http.ResponseWriter, r *http.Request, lang string, attempt int, message string) {
+	if attempt >= cfg.TwilioMaxAttempts {
+		var verbs []interface{}
+		if message != "" {
+			verbs = append(verbs, say(lang, message))
+		}
+		verbs = append(verbs, say(lang, twilioMsg(lang, "max_attempts")), twimlHangup{})
+		writeTwiML(w, verbs...)
+		return
+	}
+
+	updateCallState(r, func(s *callSession) { s.Attempt = attempt + 1 })
+	writeTwiML(w,
+		idGather(lang, strings.TrimSpace(message+" "+twilioMsg(lang, "retry"))),
+		say(lang, twilioMsg(lang, "no_input")),
+	)
+}
+
+// idGather asks for an ID by keypad or speech, posting it to
+// /twilio/verify. Recognition is tuned for IDs: TWILIO_SPEECH_MODEL suits
+// digit strings, TWILIO_SPEECH_HINTS bias it towards digit sequences and
+// the NIC letters, and the profanity filter is off so no letter name is
+// starred out of the SpeechResult.
+func idGather(lang, prompt string) twimlGather {
+	return twimlGather{
+		Input:           "dtmf speech",
+		Action:          twilioURL("/twilio/verify", url.Values{"lang": {lang}}),
+		Method:          "POST",
+		Timeout:         cfg.TwilioGatherTimeout,
+		FinishOnKey:     "#",
+		Language:        sayLanguage(lang),
+		Hints:           strings.Join(cfg.TwilioSpeechHints, ", "),
+		SpeechModel:     cfg.TwilioSpeechModel,
+		ProfanityFilter: "false",
+		Verbs:           []interface{}{say(lang, prompt)},
+	}
+}
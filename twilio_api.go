@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// twilioAPIVersion is the REST API version messages are created under
+const twilioAPIVersion = "2010-04-01"
+
+// twilioAPIClient calls the Twilio REST API. Webhooks wait on it, so the
+// timeout stays well under Twilio's 15 second webhook timeout.
+var twilioAPIClient = &http.Client{Timeout: 5 * time.Second}
+
+// twilioAPIError is an error response from the REST API
+type twilioAPIError struct {
+	Status  int    `json:"status"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *twilioAPIError) Error() string {
+	return fmt.Sprintf("twilio returned %d (code %d): %s", e.Status, e.Code, e.Message)
+}
+
+// validateTwilioAPI checks the settings for calling the REST API at startup
+func validateTwilioAPI(c config) error {
+	switch c.TwilioMachineDetection {
+	case machineDetectMessageEnd, machineDetectEnable, machineDetectOff:
+	default:
+		return errors.New("TWILIO_MACHINE_DETECTION must be DetectMessageEnd, Enable or off")
+	}
+	if c.TwilioSMSFrom == "" && c.TwilioCallFrom == "" {
+		return nil
+	}
+	if c.TwilioAccountSID == "" || c.TwilioAuthToken == "" {
+		return errors.New("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN are required with TWILIO_SMS_FROM or TWILIO_CALL_FROM")
+	}
+	if c.TwilioCallFrom != "" && !e164Pattern.MatchString(c.TwilioCallFrom) {
+		return errors.New("TWILIO_CALL_FROM must be an E.164 number such as +94112345678")
+	}
+	if u, err := url.Parse(c.TwilioAPIURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("TWILIO_API_URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// twilioPost creates a resource under the account, e.g. "Messages", from
+// form and decodes the JSON response into out
+func twilioPost(ctx context.Context, resource string, form url.Values, out any) error {
+	endpoint := strings.TrimSuffix(cfg.TwilioAPIURL, "/") + "/" + twilioAPIVersion +
+		"/Accounts/" + url.PathEscape(cfg.TwilioAccountSID) + "/" + resource + ".json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(cfg.TwilioAccountSID, cfg.TwilioAuthToken)
+	resp, err := twilioAPIClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		apiErr := &twilioAPIError{Status: resp.StatusCode}
+		json.Unmarshal(body, apiErr)
+		return apiErr
+	}
+	return json.Unmarshal(body, out)
+}
+
+// sendSMS texts body to the number to from TWILIO_SMS_FROM, which is a
+// phone number or a messaging service SID (MG...), and returns the
+// message SID
+func sendSMS(ctx context.Context, to, body string) (string, error) {
+	form := url.Values{"To": {to}, "Body": {body}}
+	if strings.HasPrefix(cfg.TwilioSMSFrom, "MG") {
+		form.Set("MessagingServiceSid", cfg.TwilioSMSFrom)
+	} else {
+		form.Set("From", cfg.TwilioSMSFrom)
+	}
+	var msg struct {
+		SID string `json:"sid"`
+	}
+	if err := twilioPost(ctx, "Messages", form, &msg); err != nil {
+		return "", err
+	}
+	return msg.SID, nil
+}
+
+// placeCall calls to from TWILIO_CALL_FROM. Twilio fetches the call's TwiML
+// from answerURL once it is answered, with AnsweredBy set unless
+// TWILIO_MACHINE_DETECTION is off, and posts its final status to statusURL.
+// It returns the call SID and Twilio's initial status.
+func placeCall(ctx context.Context, to, answerURL, statusURL string) (sid, status string, err error) {
+	form := url.Values{
+		"To":                   {to},
+		"From":                 {cfg.TwilioCallFrom},
+		"Url":                  {answerURL},
+		"Method":               {"POST"},
+		"StatusCallback":       {statusURL},
+		"StatusCallbackMethod": {"POST"},
+		"Timeout":              {strconv.Itoa(outboundRingTimeout)},
+	}
+	if cfg.TwilioMachineDetection != machineDetectOff {
+		form.Set("MachineDetection", cfg.TwilioMachineDetection)
+	}
+	var call struct {
+		SID    string `json:"sid"`
+		Status string `json:"status"`
+	}
+	if err := twilioPost(ctx, "Calls", form, &call); err != nil {
+		return "", "", err
+	}
+	return call.SID, call.Status, nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Supported voice languages, keyed by the short code carried in ?lang=
+const (
+	langEnglish = "en"
+	langSinhala = "si"
+	langTamil   = "ta"
+)
+
+// twilioMenuOrder is the DTMF menu order: press 1 for English, 2 for Sinhala, 3 for Tamil
+var twilioMenuOrder = []string{langEnglish, langSinhala, langTamil}
+
+// twilioMessages holds the spoken message templates per language. The
+// greeting is empty, so says nothing, until set in message_templates.
+var twilioMessages = map[string]map[string]string{
+	langEnglish: {
+		"greeting":        "",
+		"menu":            "For English, press 1.",
+		"prompt":          "Please enter or say the ID number, followed by the hash key.",
+		"invalid":         "Invalid input format. Please use only numbers or letters.",
+		"no_match":        "Sorry, no match found for %s.",
+		"retry":           "Please enter or say the ID number again, followed by the hash key.",
+		"confirm":         "You entered %s. Press 1 to confirm, or 2 to enter the number again.",
+		"max_attempts":    "You have reached the maximum number of attempts. Goodbye.",
+		"no_input":        "We did not receive any input. Goodbye.",
+		"unavailable":     "Sorry, we cannot check this ID right now. Please try again later. Goodbye.",
+		"maintenance":     "Sorry, the verification service is down for maintenance. Please call again later. Goodbye.",
+		"busy":            "Sorry, the service is busy right now. Please call again in a few minutes. Goodbye.",
+		"rate_limited":    "Too many verification requests have been made from this number. Please try again later. Goodbye.",
+		"result":          "You entered %s. The name is %s. The category is %s.",
+		"remark":          "Remark:",
+		"expired":         "Please note: this credential expired on %s and is no longer valid.",
+		"revoked":         "Please note: this certificate has been revoked and is not valid.",
+		"restricted":      "This person can only be verified by contacting the registrar. Goodbye.",
+		"reference":       "Your verification reference is %s, checked at %s.",
+		"followup_offer":  "To receive this result by text message, press 3.",
+		"followup_sent":   "We have sent the result to your phone by text message. Goodbye.",
+		"followup_failed": "Sorry, we could not send the text message. Goodbye.",
+		"result_options":  "To hear this again, press 1. To verify another ID, press 2.",
+		"goodbye":         "Thank you for calling. Goodbye.",
+		"menu_verify":     "To verify an ID number, press 1.",
+		"menu_hours":      "For our office hours, press 2.",
+		"menu_registrar":  "To speak to the registrar, press 3.",
+		"menu_invalid":    "Sorry, that is not one of the options.",
+		"office_hours":    "Our office hours are %s.",
+		"transfer":        "Please hold while we connect you to the registrar.",
+		"registrar_away":  "Sorry, the registrar is not available right now.",
+		"blocked":         "This number cannot use the verification service. Goodbye.",
+		"outbound_intro":  "Hello. This is the verification service, calling on behalf of the registrar with the verification result you asked for.",
+		"outbound_result": "The result is for the ID number %s. The name is %s. The category is %s.",
+		"voicemail":       "Hello. This is the verification service, calling on behalf of the registrar about a verification. For privacy, results are not left in a message. Please contact the registrar's office. Goodbye.",
+	},
+	langSinhala: {
+		"menu":            "සිංහල සඳහා 2 ඔබන්න.",
+		"prompt":          "කරුණාකර හැඳුනුම්පත් අංකය ඇතුළත් කරන්න හෝ පවසන්න, ඉන්පසු හෑෂ් යතුර ඔබන්න.",
+		"invalid":         "වැරදි ආදාන ආකෘතියකි. කරුණාකර අංක හෝ අකුරු පමණක් භාවිතා කරන්න.",
+		"no_match":        "කණගාටුයි, %s සඳහා ගැළපීමක් හමු නොවීය.",
+		"retry":           "කරුණාකර හැඳුනුම්පත් අංකය නැවත ඇතුළත් කරන්න හෝ පවසන්න, ඉන්පසු හෑෂ් යතුර ඔබන්න.",
+		"confirm":         "ඔබ ඇතුළත් කළේ %s. තහවුරු කිරීමට 1 ද, නැවත ඇතුළත් කිරීමට 2 ද ඔබන්න.",
+		"max_attempts":    "ඔබ උපරිම උත්සාහයන් ගණනට ළඟා වී ඇත. ආයුබෝවන්.",
+		"no_input":        "අපට කිසිදු ආදානයක් ලැබුණේ නැත. ආයුබෝවන්.",
+		"unavailable":     "කණගාටුයි, මෙම අංකය දැන් පරීක්ෂා කළ නොහැක. කරුණාකර පසුව නැවත උත්සාහ කරන්න. ආයුබෝවන්.",
+		"maintenance":     "කණගාටුයි, සත්‍යාපන සේවාව නඩත්තු කටයුතු සඳහා තාවකාලිකව නවතා ඇත. කරුණාකර පසුව නැවත අමතන්න. ආයුබෝවන්.",
+		"busy":            "කණගාටුයි, සේවාව මේ මොහොතේ කාර්යබහුලයි. කරුණාකර මිනිත්තු කිහිපයකින් නැවත අමතන්න. ආයුබෝවන්.",
+		"rate_limited":    "මෙම අංකයෙන් සත්‍යාපන ඉල්ලීම් වැඩි ගණනක් ලැබී ඇත. කරුණාකර පසුව නැවත උත්සාහ කරන්න. ආයුබෝවන්.",
+		"result":          "ඔබ ඇතුළත් කළේ %s. නම %s. කාණ්ඩය %s.",
+		"remark":          "සටහන:",
+		"expired":         "කරුණාකර සලකන්න: මෙම සහතිකය %s දින කල් ඉකුත් වී ඇති අතර තවදුරටත් වලංගු නොවේ.",
+		"revoked":         "කරුණාකර සලකන්න: මෙම සහතිකය අවලංගු කර ඇති අතර වලංගු නොවේ.",
+		"restricted":      "මෙම පුද්ගලයා සත්‍යාපනය කළ හැක්කේ ලේඛකාධිකාරී අමතා පමණි. ආයුබෝවන්.",
+		"reference":       "ඔබේ සත්‍යාපන යොමු අංකය %s. පරීක්ෂා කළ වේලාව %s.",
+		"followup_offer":  "මෙම ප්‍රතිඵලය කෙටි පණිවිඩයකින් ලබා ගැනීමට 3 ඔබන්න.",
+		"followup_sent":   "ප්‍රතිඵලය කෙටි පණිවිඩයකින් ඔබේ දුරකථනයට යවා ඇත. ආයුබෝවන්.",
+		"followup_failed": "කණගාටුයි, කෙටි පණිවිඩය යැවීමට නොහැකි විය. ආයුබෝවන්.",
+		"result_options":  "මෙය නැවත ඇසීමට 1 ද, වෙනත් හැඳුනුම්පත් අංකයක් සත්‍යාපනය කිරීමට 2 ද ඔබන්න.",
+		"goodbye":         "ඇමතීමට ස්තුතියි. ආයුබෝවන්.",
+		"menu_verify":     "හැඳුනුම්පත් අංකයක් සත්‍යාපනය කිරීමට 1 ඔබන්න.",
+		"menu_hours":      "අපගේ කාර්යාල වේලාවන් සඳහා 2 ඔබන්න.",
+		"menu_registrar":  "ලේඛකාධිකාරී සමඟ කතා කිරීමට 3 ඔබන්න.",
+		"menu_invalid":    "කණගාටුයි, එය විකල්පයක් නොවේ.",
+		"office_hours":    "අපගේ කාර්යාල වේලාවන් %s.",
+		"transfer":        "ලේඛකාධිකාරී වෙත සම්බන්ධ කරන තෙක් කරුණාකර රැඳී සිටින්න.",
+		"registrar_away":  "කණගාටුයි, ලේඛකාධිකාරී මේ මොහොතේ නොමැත.",
+		"blocked":         "මෙම අංකයට සත්‍යාපන සේවාව භාවිතා කළ නොහැක. ආයුබෝවන්.",
+		"outbound_intro":  "ආයුබෝවන්. මේ ඔබ ඉල්ලූ සත්‍යාපන ප්‍රතිඵලය සමඟ ලේඛකාධිකාරී වෙනුවෙන් අමතන සත්‍යාපන සේවාවයි.",
+		"outbound_result": "මෙම ප්‍රතිඵලය %s හැඳුනුම්පත් අංකය සඳහා ය. නම %s. කාණ්ඩය %s.",
+		"voicemail":       "ආයුබෝවන්. මේ සත්‍යාපනයක් සම්බන්ධයෙන් ලේඛකාධිකාරී වෙනුවෙන් අමතන සත්‍යාපන සේවාවයි. පෞද්ගලිකත්වය සඳහා ප්‍රතිඵල පණිවිඩයක තබනු නොලැබේ. කරුණාකර ලේඛකාධිකාරී කාර්යාලය අමතන්න. ආයුබෝවන්.",
+	},
+	langTamil: {
+		"menu":            "தமிழுக்கு 3 ஐ அழுத்தவும்.",
+		"prompt":          "தயவுசெய்து அடையாள எண்ணை உள்ளிடவும் அல்லது சொல்லவும், பின்னர் ஹேஷ் விசையை அழுத்தவும்.",
+		"invalid":         "தவறான உள்ளீட்டு வடிவம். எண்கள் அல்லது எழுத்துக்களை மட்டும் பயன்படுத்தவும்.",
+		"no_match":        "மன்னிக்கவும், %s க்கு பொருத்தம் எதுவும் கிடைக்கவில்லை.",
+		"retry":           "தயவுசெய்து அடையாள எண்ணை மீண்டும் உள்ளிடவும் அல்லது சொல்லவும், பின்னர் ஹேஷ் விசையை அழுத்தவும்.",
+		"confirm":         "நீங்கள் உள்ளிட்டது %s. உறுதிப்படுத்த 1 ஐயும், மீண்டும் உள்ளிட 2 ஐயும் அழுத்தவும்.",
+		"max_attempts":    "அதிகபட்ச முயற்சிகளை அடைந்துவிட்டீர்கள். வணக்கம்.",
+		"no_input":        "எந்த உள்ளீடும் பெறப்படவில்லை. வணக்கம்.",
+		"unavailable":     "மன்னிக்கவும், இந்த எண்ணை இப்போது சரிபார்க்க முடியவில்லை. பின்னர் மீண்டும் முயற்சிக்கவும். வணக்கம்.",
+		"maintenance":     "மன்னிக்கவும், சரிபார்ப்புச் சேவை பராமரிப்புக்காகத் தற்காலிகமாக நிறுத்தப்பட்டுள்ளது. பின்னர் மீண்டும் அழைக்கவும். வணக்கம்.",
+		"busy":            "மன்னிக்கவும், சேவை தற்போது நெரிசலாக உள்ளது. சில நிமிடங்களில் மீண்டும் அழைக்கவும். வணக்கம்.",
+		"rate_limited":    "இந்த எண்ணிலிருந்து அதிகமான சரிபார்ப்பு கோரிக்கைகள் வந்துள்ளன. பின்னர் மீண்டும் முயற்சிக்கவும். வணக்கம்.",
+		"result":          "நீங்கள் உள்ளிட்டது %s. பெயர் %s. பிரிவு %s.",
+		"remark":          "குறிப்பு:",
+		"expired":         "கவனிக்கவும்: இந்தச் சான்றிதழ் %s அன்று காலாவதியானது, இனி செல்லுபடியாகாது.",
+		"revoked":         "கவனிக்கவும்: இந்தச் சான்றிதழ் ரத்து செய்யப்பட்டுள்ளது, செல்லுபடியாகாது.",
+		"restricted":      "இந்த நபரைப் பதிவாளரைத் தொடர்புகொண்டு மட்டுமே சரிபார்க்க முடியும். வணக்கம்.",
+		"reference":       "உங்கள் சரிபார்ப்புக் குறிப்பு எண் %s, சரிபார்த்த நேரம் %s.",
+		"followup_offer":  "இந்த முடிவைக் குறுஞ்செய்தியாகப் பெற 3 ஐ அழுத்தவும்.",
+		"followup_sent":   "முடிவு உங்கள் தொலைபேசிக்குக் குறுஞ்செய்தியாக அனுப்பப்பட்டுள்ளது. வணக்கம்.",
+		"followup_failed": "மன்னிக்கவும், குறுஞ்செய்தியை அனுப்ப முடியவில்லை. வணக்கம்.",
+		"result_options":  "இதை மீண்டும் கேட்க 1 ஐயும், வேறொரு அடையாள எண்ணைச் சரிபார்க்க 2 ஐயும் அழுத்தவும்.",
+		"goodbye":         "அழைத்தமைக்கு நன்றி. வணக்கம்.",
+		"menu_verify":     "அடையாள எண்ணைச் சரிபார்க்க 1 ஐ அழுத்தவும்.",
+		"menu_hours":      "எங்கள் அலுவலக நேரங்களுக்கு 2 ஐ அழுத்தவும்.",
+		"menu_registrar":  "பதிவாளருடன் பேச 3 ஐ அழுத்தவும்.",
+		"menu_invalid":    "மன்னிக்கவும், அது விருப்பங்களில் ஒன்றல்ல.",
+		"office_hours":    "எங்கள் அலுவலக நேரங்கள் %s.",
+		"transfer":        "பதிவாளருடன் இணைக்கும் வரை காத்திருக்கவும்.",
+		"registrar_away":  "மன்னிக்கவும், பதிவாளர் இப்போது கிடைக்கவில்லை.",
+		"blocked":         "இந்த எண்ணால் சரிபார்ப்புச் சேவையைப் பயன்படுத்த முடியாது. வணக்கம்.",
+		"outbound_intro":  "வணக்கம். நீங்கள் கேட்ட சரிபார்ப்பு முடிவுடன் பதிவாளர் சார்பாக அழைக்கும் சரிபார்ப்புச் சேவை இது.",
+		"outbound_result": "இந்த முடிவு %s என்ற அடையாள எண்ணுக்குரியது. பெயர் %s. பிரிவு %s.",
+		"voicemail":       "வணக்கம். ஒரு சரிபார்ப்பு தொடர்பாகப் பதிவாளர் சார்பாக அழைக்கும் சரிபார்ப்புச் சேவை இது. தனியுரிமைக்காக முடிவுகள் செய்தியாக விடப்படுவதில்லை. பதிவாளர் அலுவலகத்தைத் தொடர்புகொள்ளவும். வணக்கம்.",
+	},
+}
+
+// twilioMsg returns the message template for key in lang, falling back to English
+func twilioMsg(lang, key string) string {
+	return institutionTwilioMsg("", lang, key)
+}
+
+// institutionTwilioMsg is twilioMsg with the wording of the institution
+func institutionTwilioMsg(institution, lang, key string) string {
+	m, _ := templateMsg(institution, messageChannelVoice, lang, key)
+	return m
+}
+
+// sayLanguage maps a short language code to the <Say language="..."> value
+func sayLanguage(lang string) string {
+	switch lang {
+	case langSinhala:
+		return cfg.TwilioSayLanguageSinhala
+	case langTamil:
+		return cfg.TwilioSayLanguageTamil
+	default:
+		return cfg.TwilioSayLanguageEnglish
+	}
+}
+
+// sayVoice maps a short language code to the <Say voice="..."> value
+func sayVoice(lang string) string {
+	voice := cfg.TwilioVoiceEnglish
+	switch lang {
+	case langSinhala:
+		voice = cfg.TwilioVoiceSinhala
+	case langTamil:
+		voice = cfg.TwilioVoiceTamil
+	}
+	if voice == "" {
+		return cfg.TwilioVoice
+	}
+	return voice
+}
+
+// twilioVoicePattern matches Twilio voice names: man, woman, alice,
+// Polly.Joanna-Neural, Google.en-GB-Standard-A and the like
+var twilioVoicePattern = regexp.MustCompile(`^(man|woman|alice|(Polly|Google)\.[A-Za-z0-9-]+)$`)
+
+// validateTwilioVoice checks the TWILIO_VOICE* and TWILIO_LANGUAGE settings
+// at startup
+func validateTwilioVoice(c config) error {
+	voices := []struct{ name, voice string }{
+		{"TWILIO_VOICE", c.TwilioVoice},
+		{"TWILIO_VOICE_EN", c.TwilioVoiceEnglish},
+		{"TWILIO_VOICE_SI", c.TwilioVoiceSinhala},
+		{"TWILIO_VOICE_TA", c.TwilioVoiceTamil},
+	}
+	for _, v := range voices {
+		if v.voice != "" && !twilioVoicePattern.MatchString(v.voice) {
+			return fmt.Errorf("%s must be man, woman, alice or a Polly. or Google. voice name, not %q", v.name, v.voice)
+		}
+	}
+	if _, ok := twilioMessages[c.TwilioLanguage]; !ok {
+		return fmt.Errorf("TWILIO_LANGUAGE must be one of %s", strings.Join(twilioMenuOrder, ", "))
+	}
+	return nil
+}
+
+// twilioLang returns the caller's language from ?lang= or the call
+// session, defaulting to TWILIO_LANGUAGE
+func twilioLang(r *http.Request) string {
+	for _, lang := range []string{r.URL.Query().Get("lang"), callState(r).Lang} {
+		if _, ok := twilioMessages[lang]; ok {
+			return lang
+		}
+	}
+	return cfg.TwilioLanguage
+}
+
+// spokenID renders an ID character by character for reading back, with a
+// comma between idGroups so the voice pauses briefly. English uses the
+// charToWord names; other languages let the voice pronounce each character
+// on its own.
+func spokenID(lang, input string) string {
+	groups := idGroups(input)
+	for i, group := range groups {
+		groups[i] = spokenGroup(lang, group)
+	}
+	return strings.Join(groups, ", ")
+}
+
+// spokenGroup renders one group of an ID character by character
+func spokenGroup(lang, group string) string {
+	var spoken []string
+	for _, char := range group {
+		if word, exists := charToWord[unicode.ToLower(char)]; exists && lang == langEnglish {
+			spoken = append(spoken, word)
+		} else {
+			spoken = append(spoken, string(char))
+		}
+	}
+	return strings.Join(spoken, " ")
+}
+
+// idGroups splits an ID the way it is read back: letters and digits apart,
+// and runs of digits in threes, so 853400939V is 853 400 939 V. A single
+// digit left over joins the group before it, as in 853 400 9393.
+func idGroups(id string) []string {
+	var runs []string
+	start := 0
+	for i, char := range id {
+		if i > 0 && unicode.IsDigit(char) != unicode.IsDigit(rune(id[i-1])) {
+			runs = append(runs, id[start:i])
+			start = i
+		}
+	}
+	if start < len(id) {
+		runs = append(runs, id[start:])
+	}
+
+	var groups []string
+	for _, run := range runs {
+		if !unicode.IsDigit(rune(run[0])) {
+			groups = append(groups, run)
+			continue
+		}
+		n := len(groups)
+		for len(run) > 3 {
+			groups = append(groups, run[:3])
+			run = run[3:]
+		}
+		if len(run) == 1 && len(groups) > n {
+			groups[len(groups)-1] += run
+		} else {
+			groups = append(groups, run)
+		}
+	}
+	return groups
+}
+
+// readBack renders a message whose %s is an ID as <Say> verbs with a
+// <Pause> between the groups of the ID, so a caller can follow it
+func readBack(lang, format, id string) []interface{} {
+	before, after, _ := strings.Cut(format, "%s")
+	var verbs []interface{}
+	if before = strings.TrimSpace(before); before != "" {
+		verbs = append(verbs, say(lang, before))
+	}
+	for i, group := range idGroups(id) {
+		if i > 0 {
+			verbs = append(verbs, twimlPause{Length: 1})
+		}
+		verbs = append(verbs, say(lang, spokenGroup(lang, group)))
+	}
+	if after = strings.TrimSpace(strings.TrimPrefix(after, ".")); after != "" {
+		verbs = append(verbs, say(lang, after))
+	}
+	return verbs
+}
+
+// twilioLanguageHandler is the voice entry point. Callers from countries
+// listed in TWILIO_MENU_COUNTRIES (Twilio's FromCountry) hear a DTMF menu
+// to choose English, Sinhala or Tamil; everyone else goes straight to the
+// English prompt. The chosen language is carried to /twilio/verify as ?lang=.
+func twilioLanguageHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+	digits := r.PostFormValue("Digits")
+	lang := ""
+	for i, code := range twilioMenuOrder {
+		if digits == fmt.Sprint(i+1) {
+			lang = code
+		}
+	}
+	if lang == "" && digits == "" && !twilioOffersMenu(r.PostFormValue("FromCountry")) {
+		lang = cfg.TwilioLanguage
+	}
+
+	// The greeting plays once, before the language menu or, for callers
+	// who are not offered it, before the main menu or ID prompt
+	first := digits == "" && r.URL.Query().Get("default") == ""
+
+	if lang == "" {
+		if first {
+			// Offer the language menu, each option in its own language
+			menu := twimlGather{Input: "dtmf", NumDigits: 1, Action: "/twilio/language", Method: "POST", Timeout: cfg.TwilioGatherTimeout}
+			if greeting := twilioMsg(cfg.TwilioLanguage, "greeting"); greeting != "" {
+				menu.Verbs = append(menu.Verbs, say(cfg.TwilioLanguage, greeting))
+			}
+			for _, code := range twilioMenuOrder {
+				menu.Verbs = append(menu.Verbs, say(code, twilioMsg(code, "menu")))
+			}
+			writeTwiML(w, menu, twimlRedirect{Method: "POST", URL: twilioURL("/twilio/language", url.Values{"default": {"1"}})})
+			return
+		}
+		// No choice made after the menu played; fall back to TWILIO_LANGUAGE
+		lang = cfg.TwilioLanguage
+	}
+
+	logErrorContext(r.Context(), "TWILIO_LANGUAGE", fmt.Sprintf("Caller language: %s", lang))
+	recordCall(r, digits, callResultLanguage, "")
+	updateCallState(r, func(s *callSession) { s.Lang = lang })
+	greeting := ""
+	if first {
+		greeting = twilioMsg(lang, "greeting")
+	}
+	if twilioMenuEnabled() {
+		writeTwilioMenu(w, r, lang, 1, greeting)
+		return
+	}
+	writeTwilioPrompt(w, r, lang, greeting)
+}
+
+// writeTwilioPrompt asks the caller for an ID to verify, posting it to
+// /twilio/verify as the first attempt. A message, if any, is said first.
+func writeTwilioPrompt(w http.ResponseWriter, r *http.Request, lang, message string) {
+	updateCallState(r, func(s *callSession) { s.Attempt = 1 })
+	var verbs []interface{}
+	if message != "" {
+		verbs = append(verbs, say(lang, message))
+	}
+	writeTwiML(w, append(verbs,
+		idGather(lang, twilioMsg(lang, "prompt")),
+		say(lang, twilioMsg(lang, "no_input")),
+	)...)
+}
+
+// twilioOffersMenu reports whether callers from country (ISO code) get the menu
+func twilioOffersMenu(country string) bool {
+	for _, c := range cfg.TwilioMenuCountries {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
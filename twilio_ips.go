@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// twilioRangesRetry is how soon a failed download of TWILIO_IP_RANGES_URL
+// is tried again
+const twilioRangesRetry = time.Minute
+
+// twilioRangesClient downloads TWILIO_IP_RANGES_URL
+var twilioRangesClient = &http.Client{Timeout: 10 * time.Second}
+
+// twilioRanges holds the addresses Twilio webhooks may come from: the
+// static TWILIO_ALLOWED_IPS plus the last list downloaded from
+// TWILIO_IP_RANGES_URL
+var twilioRanges struct {
+	sync.RWMutex
+	static     []netip.Prefix
+	downloaded []netip.Prefix
+	loaded     bool
+}
+
+// twilioIPRestricted reports whether /twilio/* only accepts listed addresses
+func twilioIPRestricted() bool {
+	return len(cfg.TwilioAllowedIPs) > 0 || cfg.TwilioIPRangesURL != ""
+}
+
+// validateTwilioIPs checks TWILIO_ALLOWED_IPS and TWILIO_IP_RANGES_URL at
+// startup
+func validateTwilioIPs(c config) error {
+	if _, err := parsePrefixes(c.TwilioAllowedIPs); err != nil {
+		return fmt.Errorf("TWILIO_ALLOWED_IPS: %v", err)
+	}
+	if c.TwilioIPRangesURL != "" {
+		if u, err := url.Parse(c.TwilioIPRangesURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.New("TWILIO_IP_RANGES_URL must be an absolute https URL")
+		}
+		if c.TwilioIPRangesRefresh < time.Minute {
+			return errors.New("TWILIO_IP_RANGES_REFRESH must be at least 1m")
+		}
+	}
+	return nil
+}
+
+// parsePrefixes parses IP addresses and CIDR ranges, treating an address
+// as a range of one
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range values {
+		if addr, err := netip.ParseAddr(v); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", v)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// startTwilioIPRanges loads TWILIO_ALLOWED_IPS and, with TWILIO_IP_RANGES_URL
+// set, downloads the published ranges and keeps them up to date. Until the
+// first download succeeds only the static addresses are accepted.
+func startTwilioIPRanges(c config) {
+	static, _ := parsePrefixes(c.TwilioAllowedIPs)
+	twilioRanges.Lock()
+	twilioRanges.static = static
+	twilioRanges.Unlock()
+	if c.TwilioIPRangesURL == "" {
+		return
+	}
+	wait := c.TwilioIPRangesRefresh
+	if err := refreshTwilioRanges(c.TwilioIPRangesURL); err != nil {
+		logError("TWILIO_IP_RANGES_ERROR", fmt.Sprintf("Failed to download Twilio IP ranges, accepting only TWILIO_ALLOWED_IPS until a retry succeeds: %v", err))
+		wait = twilioRangesRetry
+	}
+	go func() {
+		for {
+			time.Sleep(wait)
+			wait = c.TwilioIPRangesRefresh
+			if err := refreshTwilioRanges(c.TwilioIPRangesURL); err != nil {
+				logError("TWILIO_IP_RANGES_ERROR", fmt.Sprintf("Failed to refresh Twilio IP ranges, keeping the current list: %v", err))
+				wait = twilioRangesRetry
+			}
+		}
+	}()
+}
+
+// refreshTwilioRanges downloads the ranges and swaps them in. An empty list
+// is refused so a broken download cannot lock Twilio out.
+func refreshTwilioRanges(rangesURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), twilioRangesClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rangesURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := twilioRangesClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", rangesURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	prefixes, err := parseRangeList(body)
+	if err != nil {
+		return err
+	}
+	if len(prefixes) == 0 {
+		return errors.New("the list is empty")
+	}
+
+	twilioRanges.Lock()
+	changed := !twilioRanges.loaded || !equalPrefixes(prefixes, twilioRanges.downloaded)
+	twilioRanges.downloaded, twilioRanges.loaded = prefixes, true
+	twilioRanges.Unlock()
+	if changed {
+		logError("TWILIO_IP_RANGES_SUCCESS", fmt.Sprintf("Loaded %d Twilio IP ranges from %s", len(prefixes), rangesURL))
+	}
+	return nil
+}
+
+// parseRangeList reads a downloaded list: a JSON array of addresses and
+// ranges, or plain text with one per line and # comments
+func parseRangeList(body []byte) ([]netip.Prefix, error) {
+	body = bytes.TrimSpace(body)
+	var values []string
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &values); err != nil {
+			return nil, fmt.Errorf("invalid JSON list: %v", err)
+		}
+	} else {
+		lines := bufio.NewScanner(bytes.NewReader(body))
+		for lines.Scan() {
+			line, _, _ := strings.Cut(lines.Text(), "#")
+			if line = strings.TrimSpace(line); line != "" {
+				values = append(values, line)
+			}
+		}
+	}
+	return parsePrefixes(values)
+}
+
+func equalPrefixes(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// twilioAddress reports whether ip is one Twilio webhooks may come from
+func twilioAddress(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	twilioRanges.RLock()
+	defer twilioRanges.RUnlock()
+	for _, list := range [][]netip.Prefix{twilioRanges.static, twilioRanges.downloaded} {
+		for _, prefix := range list {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requireTwilioIP rejects webhook requests from outside TWILIO_ALLOWED_IPS
+// and TWILIO_IP_RANGES_URL. It adds to signature validation rather than
+// replacing it: a leaked auth token alone is not enough to forge a call.
+func requireTwilioIP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !twilioIPRestricted() {
+			next(w, r)
+			return
+		}
+		if ip := clientIP(r); !twilioAddress(ip) {
+			twilioIPRejectedTotal.Inc()
+			logErrorContext(r.Context(), "TWILIO_IP_REJECTED", fmt.Sprintf("Rejected Twilio webhook %s from %s", r.URL.Path, ip))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
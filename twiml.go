@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// TwiML verbs. Rendering goes through encoding/xml so names and remarks
+// containing & or < are escaped and always produce valid TwiML.
+
+type twimlResponse struct {
+	XMLName xml.Name `xml:"Response"`
+	Verbs   []interface{}
+}
+
+type twimlSay struct {
+	XMLName  xml.Name `xml:"Say"`
+	Voice    string   `xml:"voice,attr,omitempty"`
+	Language string   `xml:"language,attr,omitempty"`
+	Text     string   `xml:",chardata"`
+}
+
+type twimlGather struct {
+	XMLName     xml.Name `xml:"Gather"`
+	Input       string   `xml:"input,attr,omitempty"`
+	Action      string   `xml:"action,attr,omitempty"`
+	Method      string   `xml:"method,attr,omitempty"`
+	Timeout     int      `xml:"timeout,attr,omitempty"`
+	NumDigits   int      `xml:"numDigits,attr,omitempty"`
+	FinishOnKey string   `xml:"finishOnKey,attr,omitempty"`
+	Language    string   `xml:"language,attr,omitempty"`
+	// Speech recognition tuning, for input="dtmf speech"
+	Hints           string `xml:"hints,attr,omitempty"`
+	SpeechModel     string `xml:"speechModel,attr,omitempty"`
+	ProfanityFilter string `xml:"profanityFilter,attr,omitempty"`
+	Verbs           []interface{}
+}
+
+type twimlRedirect struct {
+	XMLName xml.Name `xml:"Redirect"`
+	Method  string   `xml:"method,attr,omitempty"`
+	URL     string   `xml:",chardata"`
+}
+
+type twimlPause struct {
+	XMLName xml.Name `xml:"Pause"`
+	Length  int      `xml:"length,attr,omitempty"`
+}
+
+type twimlDial struct {
+	XMLName xml.Name `xml:"Dial"`
+	Action  string   `xml:"action,attr,omitempty"`
+	Method  string   `xml:"method,attr,omitempty"`
+	Timeout int      `xml:"timeout,attr,omitempty"`
+	Number  string   `xml:",chardata"`
+}
+
+type twimlHangup struct {
+	XMLName xml.Name `xml:"Hangup"`
+}
+
+// say builds a <Say> verb in the caller's language and voice
+func say(lang, text string) twimlSay {
+	return twimlSay{Voice: sayVoice(lang), Language: sayLanguage(lang), Text: text}
+}
+
+// twilioURL builds a webhook path with query parameters
+func twilioURL(path string, params url.Values) string {
+	if len(params) == 0 {
+		return path
+	}
+	return path + "?" + params.Encode()
+}
+
+// writeTwiML renders verbs as a TwiML <Response>
+func writeTwiML(w http.ResponseWriter, verbs ...interface{}) {
+	out, err := xml.MarshalIndent(twimlResponse{Verbs: verbs}, "", "\t")
+	if err != nil {
+		logError("TWIML_RENDER_ERROR", fmt.Sprintf("Failed to render TwiML: %v", err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
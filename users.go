@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// userTokenPrefix starts every user token, like apiKeyPrefix for API keys
+const userTokenPrefix = "hvu_"
+
+// usernameRegex allows plain names and email addresses, up to the length
+// of users.username
+var usernameRegex = regexp.MustCompile(`^[a-z0-9._@+-]{1,100}$`)
+
+// apiUser is the v1 JSON schema for a user. Token is only set in the
+// responses that issue one.
+type apiUser struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Institution is the institution whose people the user works with,
+	// "" for the deployment's own staff
+	Institution string `json:"institution"`
+	// HasPassword is set once the user can log into the dashboard
+	HasPassword bool `json:"has_password"`
+	TwoFactor   bool `json:"two_factor"`
+}
+
+// apiUserList is the v1 JSON schema for GET /users
+type apiUserList struct {
+	Users []apiUser `json:"users"`
+}
+
+func toAPIUser(u *store.User) apiUser {
+	return apiUser{ID: u.ID, Username: u.Username, Role: u.Role, CreatedAt: u.CreatedAt,
+		Institution: u.InstitutionID, HasPassword: u.HasPassword, TwoFactor: u.TOTPEnabled}
+}
+
+// validRole reports whether role is one of store.Roles
+func validRole(role string) bool {
+	return slices.Contains(store.Roles, role)
+}
+
+// notSelf rejects changes a user would make to their own account, so an
+// admin cannot lock themselves out by demoting or deleting themselves
+func notSelf(w http.ResponseWriter, r *http.Request, id int64) bool {
+	if u := requestUser(r.Context()); u != nil && u.ID == id {
+		writeAPIError(w, http.StatusConflict, "own_account", "You cannot change your own role or delete yourself")
+		return false
+	}
+	return true
+}
+
+func apiListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := st.ListUsers(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "USERS_DB_ERROR", fmt.Sprintf("Failed to list users: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	// An institution's staff see only its users
+	own := staffInstitution(r.Context())
+	list := apiUserList{Users: []apiUser{}}
+	for i := range users {
+		if own == "" || users[i].InstitutionID == own {
+			list.Users = append(list.Users, toAPIUser(&users[i]))
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// apiCreateUserHandler adds a user from a {"username": ..., "role": ...}
+// body and returns their bearer token, which cannot be shown again. An
+// optional "password" lets them log into the dashboard, and an optional
+// "institution" limits them to its people; users added by an institution's
+// staff always belong to it.
+func apiCreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Username    string `json:"username"`
+		Role        string `json:"role"`
+		Password    string `json:"password"`
+		Institution string `json:"institution"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"username": "...", "role": "..."}`)
+		return
+	}
+	username := strings.ToLower(strings.TrimSpace(body.Username))
+	if !usernameRegex.MatchString(username) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_username",
+			"username must be up to 100 letters, digits and ._@+- characters")
+		return
+	}
+	if !validRole(body.Role) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_role", "role must be one of "+strings.Join(store.Roles, ", "))
+		return
+	}
+	institution, ok := userInstitution(w, r, body.Institution)
+	if !ok {
+		return
+	}
+	var passwordHash string
+	if body.Password != "" {
+		if err := validPassword(body.Password); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_password", err.Error())
+			return
+		}
+	}
+
+	token, err := newToken(userTokenPrefix)
+	if err == nil && body.Password != "" {
+		passwordHash, err = hashPassword(body.Password)
+	}
+	if err != nil {
+		logErrorContext(r.Context(), "USERS_CREATE_ERROR", fmt.Sprintf("Failed to generate user credentials: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	u, err := st.CreateUser(r.Context(), username, body.Role, hashToken(token))
+	if err == nil && passwordHash != "" {
+		err = st.SetUserPassword(r.Context(), u.ID, passwordHash)
+		u.HasPassword = err == nil
+	}
+	if err == nil && institution != "" {
+		u, err = st.SetUserInstitution(r.Context(), u.ID, institution)
+	}
+	if errors.Is(err, store.ErrDuplicate) {
+		writeAPIError(w, http.StatusConflict, "duplicate_username", "A user with this username already exists")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "USERS_DB_ERROR", fmt.Sprintf("Failed to create user %q: %v", username, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "USERS_CREATE_SUCCESS", fmt.Sprintf("%s created user %s (%s)",
+		requestUser(r.Context()).Username, u.Username, u.Role))
+	publishChange(r.Context(), eventUserCreated, "", toAPIUser(u))
+	resp := toAPIUser(u)
+	resp.Token = token
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// apiUpdateUserHandler changes a user's role from a {"role": ...} body
+func apiUpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok || !notSelf(w, r, id) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"role": "..."}`)
+		return
+	}
+	if !validRole(body.Role) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_role", "role must be one of "+strings.Join(store.Roles, ", "))
+		return
+	}
+
+	u, err := st.SetUserRole(r.Context(), id, body.Role)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No user with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "USERS_DB_ERROR", fmt.Sprintf("Failed to update user %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "USERS_UPDATE_SUCCESS", fmt.Sprintf("%s set the role of user %s to %s",
+		requestUser(r.Context()).Username, u.Username, u.Role))
+	publishChange(r.Context(), eventUserUpdated, "", toAPIUser(u))
+	writeJSON(w, http.StatusOK, toAPIUser(u))
+}
+
+// apiSetUserInstitutionHandler moves a user to another institution: PUT
+// /users/{id}/institution with {"institution": "..."}, "" for the
+// deployment's own staff. Their signed-in sessions see the change on their
+// next request.
+func apiSetUserInstitutionHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Institution *string `json:"institution"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Institution == nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"institution": "..."}`)
+		return
+	}
+	institution, ok := userInstitution(w, r, *body.Institution)
+	if !ok {
+		return
+	}
+
+	u, err := st.SetUserInstitution(r.Context(), id, institution)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No user with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "USERS_DB_ERROR", fmt.Sprintf("Failed to move user %d to institution %q: %v", id, institution, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "USERS_UPDATE_SUCCESS", fmt.Sprintf("%s moved user %s to institution %q",
+		requestUser(r.Context()).Username, u.Username, institution))
+	publishChange(r.Context(), eventUserUpdated, "", toAPIUser(u))
+	writeJSON(w, http.StatusOK, toAPIUser(u))
+}
+
+// userInstitution checks the institution a user or API key is being put
+// in, answering the request when it is unknown or another than the
+// signed-in user's own. An institution's staff put users and keys in their
+// institution when they give none.
+func userInstitution(w http.ResponseWriter, r *http.Request, institution string) (string, bool) {
+	if institution == "" {
+		institution = staffInstitution(r.Context())
+	}
+	if institution != "" && !validInstitution(r.Context(), institution) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_institution", "institution must be the ID of an institution, or empty")
+		return "", false
+	}
+	if !assignableInstitution(r.Context(), institution) {
+		writeAPIError(w, http.StatusForbidden, "other_institution", "You can only act within your own institution")
+		return "", false
+	}
+	return institution, true
+}
+
+// apiResetUserTokenHandler issues a new token for a user, for a lost or
+// leaked one; the old token stops working at once
+func apiResetUserTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	token, err := newToken(userTokenPrefix)
+	if err != nil {
+		logErrorContext(r.Context(), "USERS_CREATE_ERROR", fmt.Sprintf("Failed to generate user token: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	err = st.SetUserToken(r.Context(), id, hashToken(token))
+	var u *store.User
+	if err == nil {
+		u, err = st.GetUser(r.Context(), id)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No user with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "USERS_DB_ERROR", fmt.Sprintf("Failed to reset the token of user %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "USERS_TOKEN_SUCCESS", fmt.Sprintf("%s issued a new token for user %s",
+		requestUser(r.Context()).Username, u.Username))
+	resp := toAPIUser(u)
+	resp.Token = token
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// apiSetUserPasswordHandler sets a user's dashboard password from a
+// {"password": ...} body; an empty password removes it. Either way the
+// user's sessions end.
+func apiSetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"password": "..."}`)
+		return
+	}
+	var hash string
+	if body.Password != "" {
+		if err := validPassword(body.Password); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_password", err.Error())
+			return
+		}
+		var err error
+		if hash, err = hashPassword(body.Password); err != nil {
+			logErrorContext(r.Context(), "USERS_PASSWORD_ERROR", fmt.Sprintf("Failed to hash password: %v", err))
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+	}
+
+	err := st.SetUserPassword(r.Context(), id, hash)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No user with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "USERS_DB_ERROR", fmt.Sprintf("Failed to set the password of user %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	action := "set"
+	if hash == "" {
+		action = "removed"
+	}
+	logErrorContext(r.Context(), "USERS_PASSWORD_SUCCESS", fmt.Sprintf("%s %s the password of user %d",
+		requestUser(r.Context()).Username, action, id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok || !notSelf(w, r, id) {
+		return
+	}
+	err := st.DeleteUser(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No user with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "USERS_DB_ERROR", fmt.Sprintf("Failed to delete user %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	logErrorContext(r.Context(), "USERS_DELETE_SUCCESS", fmt.Sprintf("%s deleted user %d", requestUser(r.Context()).Username, id))
+	publishChange(r.Context(), eventUserDeleted, "", deletedObject{ID: id})
+	w.WriteHeader(http.StatusNoContent)
+}
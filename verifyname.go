@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// nameRequiredMsg is the /verify error when VERIFY_REQUIRE_NAME is on and
+// ?name= is missing or shorter than VERIFY_NAME_MIN_LENGTH
+const nameRequiredMsg = "Part of the surname is required"
+
+// verifyNameParam returns the trimmed ?name= of a /verify request, and
+// false when VERIFY_REQUIRE_NAME is on and it is too short to check
+func verifyNameParam(r *http.Request) (string, bool) {
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if cfg.VerifyRequireName && utf8.RuneCountInString(name) < cfg.VerifyNameMinLength {
+		return name, false
+	}
+	return name, true
+}
+
+// nameMatches reports whether every word of query, ignoring case, starts
+// one of the words of fullName, so "pot" and "Harry Potter" both match
+// "Harry Potter". Words shorter than VERIFY_NAME_MIN_LENGTH do not count,
+// or a single letter would match most people.
+func nameMatches(fullName, query string) bool {
+	nameWords := strings.Fields(strings.ToLower(fullName))
+	matched := false
+	for _, qw := range strings.Fields(strings.ToLower(query)) {
+		if utf8.RuneCountInString(qw) < cfg.VerifyNameMinLength {
+			continue
+		}
+		found := false
+		for _, nw := range nameWords {
+			if strings.HasPrefix(nw, qw) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
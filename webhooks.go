@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	// webhookSecretPrefix starts every signing secret
+	webhookSecretPrefix = "whsec_"
+	// maxWebhookURLLength matches webhooks.url
+	maxWebhookURLLength = 2000
+
+	deliveriesDefaultPerPage = 50
+	deliveriesMaxPerPage     = 500
+)
+
+// apiWebhook is the v1 JSON schema for a webhook. Secret is only set in the
+// response that registers it.
+type apiWebhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiWebhookList is the v1 JSON schema for GET /webhooks
+type apiWebhookList struct {
+	Webhooks []apiWebhook `json:"webhooks"`
+}
+
+// apiDelivery is the v1 JSON schema for a webhook delivery
+type apiDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// apiDeliveryList is the v1 JSON schema for a page of deliveries
+type apiDeliveryList struct {
+	Page       int           `json:"page"`
+	PerPage    int           `json:"per_page"`
+	Total      int           `json:"total"`
+	Deliveries []apiDelivery `json:"deliveries"`
+}
+
+func toAPIWebhook(h *store.Webhook) apiWebhook {
+	events := h.Events
+	if events == nil {
+		events = []string{}
+	}
+	return apiWebhook{ID: h.ID, URL: h.URL, Events: events, CreatedAt: h.CreatedAt}
+}
+
+func toAPIDelivery(d *store.WebhookDelivery) apiDelivery {
+	out := apiDelivery{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		Event:          d.Event,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt,
+		Payload:        json.RawMessage(d.Payload),
+	}
+	if d.Status == store.DeliveryPending {
+		out.NextAttemptAt = &d.NextAttemptAt
+	}
+	if !d.DeliveredAt.IsZero() {
+		out.DeliveredAt = &d.DeliveredAt
+	}
+	return out
+}
+
+// parseWebhookURL checks a webhook URL: absolute http or https with a host
+func parseWebhookURL(raw string) (string, error) {
+	if raw == "" || len(raw) > maxWebhookURLLength {
+		return "", fmt.Errorf("url is required and may be at most %d characters", maxWebhookURLLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", errors.New("url must be an absolute http or https URL")
+	}
+	return u.String(), nil
+}
+
+// apiListWebhooksHandler lists the registered webhooks, without secrets
+func apiListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := st.ListWebhooks(r.Context())
+	if err != nil {
+		logErrorContext(r.Context(), "WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to list webhooks: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	result := apiWebhookList{Webhooks: make([]apiWebhook, len(list))}
+	for i := range list {
+		result.Webhooks[i] = toAPIWebhook(&list[i])
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiCreateWebhookHandler registers a webhook from {"url": "...",
+// "events": [...]}; no events means every type. The signing secret is in
+// the response only.
+func apiCreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	var body struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", `Body must be {"url": "...", "events": [...]}`)
+		return
+	}
+	hookURL, err := parseWebhookURL(strings.TrimSpace(body.URL))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_url", err.Error())
+		return
+	}
+	var events []string
+	for _, e := range body.Events {
+		if !slices.Contains(eventTypes, e) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_event",
+				"events must be among "+strings.Join(eventTypes, ", "))
+			return
+		}
+		if !slices.Contains(events, e) {
+			events = append(events, e)
+		}
+	}
+
+	secret, err := newToken(webhookSecretPrefix)
+	if err != nil {
+		logErrorContext(r.Context(), "WEBHOOK_CREATE_ERROR", fmt.Sprintf("Failed to generate webhook secret: %v", err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	h, err := st.CreateWebhook(r.Context(), hookURL, secret, events)
+	if err != nil {
+		logErrorContext(r.Context(), "WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to store webhook for %s: %v", hookURL, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	hooks.reload()
+	logErrorContext(r.Context(), "WEBHOOK_CREATE_SUCCESS", fmt.Sprintf("%s registered webhook %d for %s",
+		requestUser(r.Context()).Username, h.ID, h.URL))
+	publishChange(r.Context(), eventWebhookCreated, "", toAPIWebhook(h))
+	resp := toAPIWebhook(h)
+	resp.Secret = secret
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// apiDeleteWebhookHandler removes a webhook; its pending deliveries are
+// dropped with it
+func apiDeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := st.DeleteWebhook(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No webhook with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to delete webhook %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	hooks.reload()
+	logErrorContext(r.Context(), "WEBHOOK_DELETE_SUCCESS", fmt.Sprintf("%s deleted webhook %d", requestUser(r.Context()).Username, id))
+	publishChange(r.Context(), eventWebhookDeleted, "", deletedObject{ID: id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiDeliveriesHandler lists a webhook's deliveries, newest first.
+// Optional filters: status (pending, delivered, failed) and event.
+func apiDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	page, perPage, ok := parsePagination(w, r, deliveriesDefaultPerPage, deliveriesMaxPerPage)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	f := store.DeliveryFilter{WebhookID: id, Status: q.Get("status"), Event: q.Get("event")}
+	if f.Status != "" && f.Status != store.DeliveryPending && f.Status != store.DeliveryDelivered && f.Status != store.DeliveryFailed {
+		writeAPIError(w, http.StatusBadRequest, "invalid_status", "status must be pending, delivered or failed")
+		return
+	}
+
+	if _, err := st.GetWebhook(r.Context(), id); errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No webhook with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to load webhook %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	list, total, err := st.ListDeliveries(r.Context(), f, perPage, (page-1)*perPage)
+	if err != nil {
+		logErrorContext(r.Context(), "WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to list deliveries of webhook %d: %v", id, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	result := apiDeliveryList{Page: page, PerPage: perPage, Total: total, Deliveries: make([]apiDelivery, len(list))}
+	for i := range list {
+		result.Deliveries[i] = toAPIDelivery(&list[i])
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiRetryDeliveryHandler queues a failed delivery again:
+// POST /webhooks/{id}/deliveries/{delivery_id}/retry
+func apiRetryDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := strconv.ParseInt(mux.Vars(r)["delivery_id"], 10, 64)
+	if err != nil || deliveryID < 1 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "delivery_id must be a positive integer")
+		return
+	}
+
+	d, err := st.RetryDelivery(r.Context(), id, deliveryID, time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No failed delivery with this id")
+		return
+	} else if err != nil {
+		logErrorContext(r.Context(), "WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to retry delivery %d: %v", deliveryID, err))
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	hooks.wakeUp()
+	logErrorContext(r.Context(), "WEBHOOK_RETRY_SUCCESS", fmt.Sprintf("%s queued delivery %d of webhook %d again",
+		requestUser(r.Context()).Username, d.ID, d.WebhookID))
+	writeJSON(w, http.StatusOK, toAPIDelivery(d))
+}
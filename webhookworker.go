@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+const (
+	// webhookQueueSize bounds the events waiting to be stored as deliveries
+	webhookQueueSize = 1000
+	// webhookClaimBatch is how many due deliveries one round sends, and
+	// webhookConcurrency how many of them at once
+	webhookClaimBatch  = 50
+	webhookConcurrency = 8
+	// webhookLease is how long a claimed delivery is left alone by other
+	// instances; it must outlast a round of attempts
+	webhookLease = 5 * time.Minute
+	// webhookMaxBackoff caps the delay between attempts
+	webhookMaxBackoff = 6 * time.Hour
+	// webhookListTTL is how long the list of webhooks is reused before it
+	// is read again, which is how changes made on other instances arrive
+	webhookListTTL = time.Minute
+	// webhookPruneInterval is how often finished deliveries are pruned
+	webhookPruneInterval = time.Hour
+	// maxDeliveryErrorLength matches webhook_deliveries.last_error
+	maxDeliveryErrorLength = 500
+)
+
+// hooks delivers webhook events in the background; nil before the
+// database is open
+var hooks *webhookWorker
+
+// webhookWorker stores events as deliveries to each subscribed webhook and
+// sends the due ones. As with logWriter, events are queued on a bounded
+// channel so handlers never wait for the database or a receiver. Deliveries
+// live in the database, so retries survive restarts and any instance can
+// send them.
+type webhookWorker struct {
+	queue      chan *store.WebhookDelivery
+	wake       chan struct{}
+	client     *http.Client
+	maxTries   int
+	retryBase  time.Duration
+	poll       time.Duration
+	retention  time.Duration
+	delivering atomic.Bool
+
+	mu       sync.Mutex
+	list     []store.Webhook
+	loadedAt time.Time
+}
+
+// startWebhooks starts the worker configured by the WEBHOOK_* settings
+func startWebhooks(c config) *webhookWorker {
+	w := &webhookWorker{
+		queue: make(chan *store.WebhookDelivery, webhookQueueSize),
+		wake:  make(chan struct{}, 1),
+		client: &http.Client{
+			Timeout: c.WebhookTimeout,
+			// A redirect counts as a failed attempt rather than sending
+			// the event somewhere else
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		maxTries:  max(c.WebhookMaxAttempts, 1),
+		retryBase: c.WebhookRetryBase,
+		poll:      c.WebhookPollInterval,
+		retention: c.WebhookRetention,
+	}
+	if w.poll <= 0 {
+		w.poll = 5 * time.Second
+	}
+	go w.run()
+	return w
+}
+
+// publish queues an event without blocking, dropping it if the queue is
+// full
+func (w *webhookWorker) publish(d *store.WebhookDelivery) {
+	select {
+	case w.queue <- d:
+	default:
+		webhookEventsDropped.Inc()
+		logger.Warn("Dropped webhook event, the queue is full", "event", d.Event, "event_id", d.EventID)
+	}
+}
+
+// wakeUp makes the worker look for due deliveries now rather than at the
+// next poll
+func (w *webhookWorker) wakeUp() {
+	if w == nil {
+		return
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// reload makes the next event read the list of webhooks again, after one
+// was added or removed
+func (w *webhookWorker) reload() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.loadedAt = time.Time{}
+	w.mu.Unlock()
+}
+
+func (w *webhookWorker) run() {
+	ticker := time.NewTicker(w.poll)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		select {
+		case d := <-w.queue:
+			w.queueDeliveries(d)
+		case <-w.wake:
+			w.deliverDue()
+		case now := <-ticker.C:
+			w.deliverDue()
+			if w.retention > 0 && now.Sub(lastPrune) >= webhookPruneInterval {
+				lastPrune = now
+				w.prune(now)
+			}
+		}
+	}
+}
+
+// webhooks returns the registered webhooks, read again after
+// webhookListTTL or a reload
+func (w *webhookWorker) webhooks(ctx context.Context) ([]store.Webhook, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if time.Since(w.loadedAt) < webhookListTTL {
+		return w.list, nil
+	}
+	list, err := st.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w.list, w.loadedAt = list, time.Now()
+	return list, nil
+}
+
+// queueDeliveries turns an event into a pending delivery for each webhook subscribed
+// to its type
+func (w *webhookWorker) queueDeliveries(event *store.WebhookDelivery) {
+	ctx := context.Background()
+	list, err := w.webhooks(ctx)
+	if err != nil {
+		webhookEventsDropped.Inc()
+		logError("WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to load webhooks for %s event %s: %v", event.Event, event.EventID, err))
+		return
+	}
+	var deliveries []*store.WebhookDelivery
+	for i := range list {
+		if list[i].Wants(event.Event) {
+			d := *event
+			d.WebhookID = list[i].ID
+			d.NextAttemptAt = event.CreatedAt
+			deliveries = append(deliveries, &d)
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if err := st.QueueDeliveries(ctx, deliveries...); err != nil {
+		webhookEventsDropped.Inc()
+		logError("WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to queue %s event %s: %v", event.Event, event.EventID, err))
+		return
+	}
+	w.wakeUp()
+}
+
+// deliverDue claims the due deliveries and sends them in the background.
+// Only one round runs at a time; a round that fills its batch is followed
+// straight away by another.
+func (w *webhookWorker) deliverDue() {
+	if !w.delivering.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer w.delivering.Store(false)
+		for {
+			now := time.Now()
+			due, err := st.ClaimDeliveries(context.Background(), now, now.Add(webhookLease), webhookClaimBatch)
+			if err != nil {
+				logError("WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to claim webhook deliveries: %v", err))
+				return
+			}
+			if len(due) == 0 {
+				return
+			}
+			list, err := w.webhooks(context.Background())
+			if err != nil {
+				logError("WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to load webhooks: %v", err))
+				return
+			}
+			byID := make(map[int64]*store.Webhook, len(list))
+			for i := range list {
+				byID[list[i].ID] = &list[i]
+			}
+
+			var wg sync.WaitGroup
+			sem := make(chan struct{}, webhookConcurrency)
+			for i := range due {
+				h := byID[due[i].WebhookID]
+				if h == nil {
+					// Registered on another instance since the list was
+					// read; its lease runs out and the next round sends it
+					w.reload()
+					continue
+				}
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(d *store.WebhookDelivery) {
+					defer func() { <-sem; wg.Done() }()
+					w.attempt(h, d)
+				}(&due[i])
+			}
+			wg.Wait()
+			if len(due) < webhookClaimBatch {
+				return
+			}
+		}
+	}()
+}
+
+// attempt POSTs one delivery and stores the outcome: delivered on a 2xx
+// response, otherwise another attempt after an exponential backoff, or
+// failed once the attempts run out
+func (w *webhookWorker) attempt(h *store.Webhook, d *store.WebhookDelivery) {
+	code, err := w.send(h, d)
+	now := time.Now().UTC()
+	d.Attempts++
+	d.LastStatusCode = code
+	d.LastError = ""
+	switch {
+	case err == nil:
+		d.Status = store.DeliveryDelivered
+		d.DeliveredAt = now
+		webhookDeliveriesTotal.WithLabelValues("delivered").Inc()
+	case d.Attempts >= w.maxTries:
+		d.Status = store.DeliveryFailed
+		d.LastError = truncate(err.Error(), maxDeliveryErrorLength)
+		webhookDeliveriesTotal.WithLabelValues("failed").Inc()
+		logError("WEBHOOK_DELIVERY_FAILED", fmt.Sprintf("Gave up on delivery %d of %s to webhook %d after %d attempts: %v",
+			d.ID, d.Event, h.ID, d.Attempts, err))
+	default:
+		d.NextAttemptAt = now.Add(webhookBackoff(w.retryBase, d.Attempts))
+		d.LastError = truncate(err.Error(), maxDeliveryErrorLength)
+		webhookDeliveriesTotal.WithLabelValues("retry").Inc()
+	}
+	if err := st.UpdateDelivery(context.Background(), d); err != nil {
+		// The lease runs out and the delivery is sent again
+		logError("WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to record attempt %d of delivery %d: %v", d.Attempts, d.ID, err))
+	}
+}
+
+// webhookBackoff is the delay after the given number of failed attempts:
+// base, then doubling, up to webhookMaxBackoff
+func webhookBackoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxBackoff)
+}
+
+// send POSTs the payload, signed as described in webhookSignature, and
+// returns the response status code (0 when there was no response)
+func (w *webhookWorker) send(h *store.Webhook, d *store.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hogwarts_verify-webhooks")
+	req.Header.Set("X-Webhook-Id", d.EventID)
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", "sha256="+webhookSignature(h.Secret, ts, d.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookSignature is the hex HMAC-SHA256, keyed with the webhook's
+// secret, of the timestamp, a ".", and the body. Receivers recompute it and
+// reject old timestamps to stop replays.
+func webhookSignature(secret, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// prune deletes deliveries that finished more than WEBHOOK_RETENTION ago
+func (w *webhookWorker) prune(now time.Time) {
+	n, err := st.PruneDeliveries(context.Background(), now.Add(-w.retention))
+	if err != nil {
+		logError("WEBHOOK_DB_ERROR", fmt.Sprintf("Failed to prune webhook deliveries: %v", err))
+	} else if n > 0 {
+		logError("WEBHOOK_PRUNE_SUCCESS", fmt.Sprintf("Pruned %d finished webhook deliveries", n))
+	}
+}
+
+// truncate shortens s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
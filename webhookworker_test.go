@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	const body = `{"event":"person.revoked"}`
+	tests := []struct {
+		name, secret, timestamp, body, want string
+	}{
+		{"known value", "whsec_test", "1700000000", body, "fbe7824184ea9929ec2c243afa3f8b51ea693c48c84e30829e8149dd7586b564"},
+		{"other timestamp", "whsec_test", "1700000001", body, ""},
+		{"other secret", "whsec_other", "1700000000", body, ""},
+		{"other body", "whsec_test", "1700000000", body + " ", ""},
+	}
+	known := tests[0].want
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := webhookSignature(tt.secret, tt.timestamp, tt.body)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("webhookSignature = %s, want %s", got, tt.want)
+			}
+			if tt.want == "" && got == known {
+				t.Error("a changed input kept the same signature")
+			}
+		})
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	const payload = `{"event":"person.revoked","data":{}}`
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"delivered", http.StatusNoContent, false},
+		{"rejected", http.StatusBadRequest, true},
+		{"server error", http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			var gotBody string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				got, gotBody = r, string(b)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			w := &webhookWorker{client: srv.Client()}
+			h := &store.Webhook{ID: 1, URL: srv.URL, Secret: "whsec_test"}
+			d := &store.WebhookDelivery{ID: 2, EventID: "evt_1", Event: "person.revoked", Payload: payload}
+			code, err := w.send(h, d)
+			if code != tt.status || (err != nil) != tt.wantErr {
+				t.Fatalf("send = %d, %v; want %d, error %v", code, err, tt.status, tt.wantErr)
+			}
+			if gotBody != payload {
+				t.Errorf("body = %q, want %q", gotBody, payload)
+			}
+			ts := got.Header.Get("X-Webhook-Timestamp")
+			if unix, err := strconv.ParseInt(ts, 10, 64); err != nil || time.Since(time.Unix(unix, 0)) > time.Minute {
+				t.Errorf("X-Webhook-Timestamp = %q, want the current Unix time", ts)
+			}
+			if sig, want := got.Header.Get("X-Webhook-Signature"), "sha256="+webhookSignature(h.Secret, ts, payload); sig != want {
+				t.Errorf("X-Webhook-Signature = %q, want %q", sig, want)
+			}
+			if got.Header.Get("X-Webhook-Id") != d.EventID || got.Header.Get("X-Webhook-Event") != d.Event {
+				t.Errorf("X-Webhook-Id, X-Webhook-Event = %q, %q", got.Header.Get("X-Webhook-Id"), got.Header.Get("X-Webhook-Event"))
+			}
+		})
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, webhookMaxBackoff},
+		{100, webhookMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := webhookBackoff(time.Minute, tt.attempts); got != tt.want {
+			t.Errorf("webhookBackoff(1m, %d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sathimantha/getVerification/store"
+)
+
+// twilioWhatsAppHandler answers WhatsApp messages sent through Twilio.
+// The webhook has the same shape as SMS, but From/To carry a "whatsapp:"
+// prefix and replies can use WhatsApp formatting (*bold*, _italic_).
+func twilioWhatsAppHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorContext(r.Context(), "TWILIO_INVALID_FORM", fmt.Sprintf("Failed to parse form data: %v", err))
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	from := r.PostFormValue("From")
+	if !strings.HasPrefix(from, "whatsapp:") {
+		logErrorContext(r.Context(), "TWILIO_WHATSAPP_INVALID_FROM", fmt.Sprintf("Non-WhatsApp sender on WhatsApp webhook: %s", maskCaller(from)))
+		http.Error(w, "Expected a WhatsApp sender", http.StatusBadRequest)
+		return
+	}
+
+	input := normalizeSpokenID(stripSMSKeyword(r.PostFormValue("Body")))
+	p, reply, result := messagingLookup(r.Context(), "WHATSAPP", input)
+	matchedID := ""
+	if p != nil {
+		matchedID = p.NationalID
+	}
+	if result == callResultMatch {
+		check := recordMatch(r, auditChannelWhatsApp, input, p)
+		reply = whatsAppText(r.Context(), p, check)
+	} else {
+		recordAudit(r, auditChannelWhatsApp, input, result, matchedID)
+	}
+	recordCall(r, input, result, matchedID)
+	writeTwiML(w, twimlMessage{Body: reply})
+}
+
+// whatsAppText formats a verification result using WhatsApp markup, with a
+// link to the HTML view when WHATSAPP_INCLUDE_LINK and PUBLIC_BASE_URL are set
+// and the reference of check
+func whatsAppText(ctx context.Context, p *store.Person, check *store.AuditEntry) string {
+	var b strings.Builder
+	b.WriteString("*Verification result*\n\n")
+	fmt.Fprintf(&b, "*ID:* %s\n*Full name:* %s\n", p.NationalID, p.FullName)
+	state := credentialState(p)
+	if !p.ExpiryDate.IsZero() {
+		fmt.Fprintf(&b, "*Valid until:* %s\n", formatDate(p.ExpiryDate))
+	}
+	if c := categoryOf(ctx, p.Category); c.ShowCourses {
+		b.WriteString("*Courses completed:*\n")
+		for _, course := range p.Courses {
+			fmt.Fprintf(&b, "• %s\n", course)
+		}
+		b.WriteString("\n" + whatsAppStatus(state))
+	} else {
+		fmt.Fprintf(&b, "*Category:* %s\n*Remarks:* %s", c.Label, stripHTML(remarkText(remarksFor(p, auditChannelWhatsApp))))
+		if state != stateValid {
+			b.WriteString("\n" + whatsAppStatus(state))
+		}
+	}
+	fmt.Fprintf(&b, "\n\n*Reference:* %s\n_Checked %s_", check.Reference, check.CreatedAt.Format(checkedAtLayout))
+
+	if cfg.WhatsAppIncludeLink && cfg.PublicBaseURL != "" {
+		fmt.Fprintf(&b, "\n\nView online: %s", verifyURL(nil, p.NationalID))
+	}
+	return b.String()
+}
+
+// whatsAppStatus is the closing status line of WhatsApp replies
+func whatsAppStatus(state string) string {
+	switch state {
+	case stateRevoked:
+		return "*Status:* REVOKED ❌\n_" + revokedNotice + "_"
+	case stateExpired:
+		return "*Status:* EXPIRED ❌"
+	default:
+		return "*Approved and verified:* YES ✅"
+	}
+}